@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/gomcpgo/url_fetcher/pkg/crawler"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// crawl handles the crawl tool
+func (s *URLFetcherMCPServer) crawl(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+	spec := crawler.Spec{}
+
+	seeds, err := stringList(params["seeds"], "seeds")
+	if err != nil {
+		return nil, err
+	}
+	spec.Seeds = seeds
+	if spec.Include, err = stringList(params["include"], "include"); err != nil {
+		return nil, err
+	}
+	if spec.Exclude, err = stringList(params["exclude"], "exclude"); err != nil {
+		return nil, err
+	}
+	if depth, ok := params["depth"].(float64); ok {
+		spec.Depth = int(depth)
+	}
+	if maxPages, ok := params["max_pages"].(float64); ok {
+		spec.MaxPages = int(maxPages)
+	}
+	if format, ok := params["format"].(string); ok {
+		spec.Format = format
+	}
+	if engine, ok := params["engine"].(string); ok {
+		spec.Engine = engine
+	}
+	if output, ok := params["output"].(string); ok {
+		spec.Output = output
+	}
+	if maxBytes, ok := params["max_bytes"].(float64); ok {
+		spec.MaxBytes = int64(maxBytes)
+	}
+	if seconds, ok := params["max_duration_seconds"].(float64); ok {
+		spec.MaxDurationSeconds = int(seconds)
+	}
+
+	if dryRun, _ := params["dry_run"].(bool); dryRun {
+		return crawler.MakePlan(spec, s.fetcher.CheckURL)
+	}
+	if async, _ := params["async"].(bool); async {
+		return s.startJob(spec)
+	}
+	return s.runCrawl(ctx, spec)
+}
+
+// runPreset handles the run_preset tool
+func (s *URLFetcherMCPServer) runPreset(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+	name, ok := params["name"].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	spec, ok := s.presets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown preset %s (available: %v)", name, s.presetNames())
+	}
+
+	if dryRun, _ := params["dry_run"].(bool); dryRun {
+		return crawler.MakePlan(spec, s.fetcher.CheckURL)
+	}
+
+	var result map[string]interface{}
+	var err error
+	if async, _ := params["async"].(bool); async {
+		result, err = s.startJob(spec)
+	} else {
+		result, err = s.runCrawl(ctx, spec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result["preset"] = name
+	return result, nil
+}
+
+// runCrawl crawls spec with the server's fetcher and processor. With an
+// output artifact, pages are written to it as they arrive and the result
+// lists them without their content.
+func (s *URLFetcherMCPServer) runCrawl(ctx context.Context, spec crawler.Spec) (map[string]interface{}, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	output, err := s.createOutput(spec)
+	if err != nil {
+		return nil, err
+	}
+	var onPage func(crawler.Page)
+	if output != nil {
+		onPage = output.Write
+	}
+
+	result, err := crawler.Run(ctx, spec, s.crawlPage(spec), onPage)
+	if output != nil {
+		if closeErr := output.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if output != nil {
+		result.Output = spec.Output
+		result.Usage.DiskBytes = output.Bytes()
+		for i := range result.Pages {
+			result.Pages[i].Content = ""
+		}
+	}
+
+	return map[string]interface{}{
+		"pages":       result.Pages,
+		"fetched":     result.Fetched,
+		"failed":      result.Failed,
+		"filtered":    result.Filtered,
+		"bytes":       result.Bytes,
+		"duration_ms": result.DurationMs,
+		"stop_reason": result.StopReason,
+		"output":      result.Output,
+		"usage":       result.Usage,
+	}, nil
+}
+
+// startJob runs spec in the background and returns its job ID; the pages
+// are read with get_job_results as they arrive
+func (s *URLFetcherMCPServer) startJob(spec crawler.Spec) (map[string]interface{}, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	output, err := s.createOutput(spec)
+	if err != nil {
+		return nil, err
+	}
+	job, err := s.jobs.Start(spec, s.crawlPage(spec), output)
+	if err != nil {
+		if output != nil {
+			output.Close()
+		}
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"job_id": job.ID,
+		"status": crawler.JobRunning,
+	}, nil
+}
+
+// createOutput creates spec's output artifact; nil if it has none
+func (s *URLFetcherMCPServer) createOutput(spec crawler.Spec) (*crawler.Output, error) {
+	if spec.Output == "" {
+		return nil, nil
+	}
+	path, err := s.outputPath(spec.Output)
+	if err != nil {
+		return nil, err
+	}
+	return crawler.CreateOutput(path)
+}
+
+// getJobResults handles the get_job_results tool
+func (s *URLFetcherMCPServer) getJobResults(params map[string]interface{}) (interface{}, error) {
+	job, err := s.job(params)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, limit := 0, 0
+	if c, ok := params["cursor"].(float64); ok {
+		cursor = int(c)
+	}
+	if l, ok := params["limit"].(float64); ok {
+		limit = int(l)
+	}
+	return job.Results(cursor, limit), nil
+}
+
+// cancelJob handles the cancel_job tool
+func (s *URLFetcherMCPServer) cancelJob(params map[string]interface{}) (interface{}, error) {
+	job, err := s.job(params)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Cancel()
+	return map[string]interface{}{
+		"job_id":    job.ID,
+		"cancelled": true,
+	}, nil
+}
+
+// job looks up the job named by the job_id parameter
+func (s *URLFetcherMCPServer) job(params map[string]interface{}) (*crawler.Job, error) {
+	id, ok := params["job_id"].(string)
+	if !ok || id == "" {
+		return nil, fmt.Errorf("job_id is required")
+	}
+	job, ok := s.jobs.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("unknown job %s (finished jobs are kept for %s)", id, crawler.JobRetention)
+	}
+	return job, nil
+}
+
+// crawlPage returns the crawler's fetch function: each page is fetched,
+// its links read from the raw markup, then processed into spec's format
+// and cached like a fetch_url result
+func (s *URLFetcherMCPServer) crawlPage(spec crawler.Spec) crawler.FetchFunc {
+	return func(ctx context.Context, rawURL string) (crawler.Page, []types.Link) {
+		req := &types.FetchRequest{URL: rawURL, Engine: spec.Engine, Format: spec.Format}
+		s.fetcher.ApplyDefaults(req)
+
+		// Offline, pages come from the cache, as fetch_url would answer them
+		var response *types.FetchResponse
+		var err error
+		if s.config.Offline {
+			response, err = s.fetchRaw(ctx, req)
+		} else {
+			response, err = s.fetcher.Fetch(ctx, req)
+		}
+		if err != nil {
+			page := crawler.Page{Error: err.Error()}
+			if response != nil {
+				page.StatusCode = response.StatusCode
+				page.Bytes, page.RenderMs = response.BytesReceived, response.RenderMs
+			}
+			return page, nil
+		}
+
+		page := crawler.Page{
+			StatusCode: response.StatusCode,
+			Bytes:      response.BytesReceived,
+			RenderMs:   response.RenderMs,
+		}
+		if response.Skipped {
+			return page, nil
+		}
+
+		base := response.FinalURL
+		if base == "" {
+			base = rawURL
+		}
+		links := s.processor.ExtractLinks(response.Content, base)
+
+		if !s.config.Offline {
+			response = s.cacheRaw(req, response)
+		}
+		if err := s.process(ctx, req, response); err != nil {
+			page.Error = fmt.Sprintf("content processing error: %v", err)
+			return page, links
+		}
+
+		page.Title = response.Title
+		page.Content = response.Content
+		page.ContentLength = len(response.Content)
+		return page, links
+	}
+}
+
+// outputPath resolves a crawl artifact name inside FETCH_URL_OUTPUT_DIR,
+// refusing names that would escape it
+func (s *URLFetcherMCPServer) outputPath(name string) (string, error) {
+	if s.config.OutputDir == "" {
+		return "", fmt.Errorf("output requires FETCH_URL_OUTPUT_DIR to be set")
+	}
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("invalid output %s: must be a relative path inside the output directory", name)
+	}
+	return filepath.Join(s.config.OutputDir, name), nil
+}
+
+// presetNames returns the configured preset names in sorted order
+func (s *URLFetcherMCPServer) presetNames() []string {
+	names := make([]string, 0, len(s.presets))
+	for name := range s.presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// stringList reads a list-of-strings parameter, also accepting a single string
+func stringList(raw interface{}, name string) ([]string, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a list of strings", name)
+			}
+			list = append(list, str)
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("%s must be a list of strings", name)
+	}
+}
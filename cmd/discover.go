@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gomcpgo/url_fetcher/pkg/discovery"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// discoverEndpoints handles the discover_endpoints tool
+func (s *URLFetcherMCPServer) discoverEndpoints(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+	url, ok := params["url"].(string)
+	if !ok || url == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+
+	// Probes are raw HTTP fetches; a missing endpoint isn't worth retrying
+	fetch := func(ctx context.Context, rawURL string) (*types.FetchResponse, error) {
+		return s.fetcher.Fetch(ctx, &types.FetchRequest{
+			URL:              rawURL,
+			Engine:           types.EngineHTTP,
+			Format:           types.FormatHTML,
+			MaxContentLength: discovery.MaxSize,
+			Retry:            &types.RetryPolicy{MaxAttempts: 1},
+		})
+	}
+	return discovery.Discover(ctx, url, fetch)
+}
@@ -2,19 +2,30 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	_ "embed"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	neturl "net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gomcpgo/mcp/pkg/handler"
 	"github.com/gomcpgo/mcp/pkg/protocol"
 	"github.com/gomcpgo/mcp/pkg/server"
 	"github.com/gomcpgo/url_fetcher/pkg/cache"
 	"github.com/gomcpgo/url_fetcher/pkg/config"
+	"github.com/gomcpgo/url_fetcher/pkg/crawler"
 	"github.com/gomcpgo/url_fetcher/pkg/fetcher"
 	"github.com/gomcpgo/url_fetcher/pkg/processor"
+	"github.com/gomcpgo/url_fetcher/pkg/summarizer"
 	"github.com/gomcpgo/url_fetcher/pkg/types"
 )
 
@@ -29,10 +40,13 @@ var (
 
 // URLFetcherMCPServer implements the MCP server for URL fetching
 type URLFetcherMCPServer struct {
-	config    *config.Config
-	fetcher   *fetcher.Fetcher
-	processor *processor.Processor
-	cache     *cache.Cache
+	config     *config.Config
+	fetcher    *fetcher.Fetcher
+	processor  *processor.Processor
+	cache      *cache.Cache
+	summarizer summarizer.Summarizer
+	presets    map[string]crawler.Spec
+	jobs       *crawler.Jobs
 }
 
 // NewURLFetcherMCPServer creates a new URL Fetcher MCP server
@@ -43,11 +57,54 @@ func NewURLFetcherMCPServer() (*URLFetcherMCPServer, error) {
 		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
 
+	proc := processor.NewProcessor()
+	if cfg.ProfilesFile != "" {
+		profiles, err := processor.LoadProfiles(cfg.ProfilesFile)
+		if err != nil {
+			return nil, err
+		}
+		proc.SetProfiles(profiles)
+	}
+
+	responseCache := cache.NewCache(cfg.CacheTTL)
+	responseCache.SetLimits(cfg.CacheMaxEntries, cfg.CacheMaxBytes)
+	if err := responseCache.SetCompression(cfg.CacheCompression); err != nil {
+		return nil, err
+	}
+	if cfg.CacheRedisURL != "" {
+		store, err := cache.NewRedisStore(cfg.CacheRedisURL)
+		if err != nil {
+			return nil, err
+		}
+		responseCache.SetStore(store)
+	} else if cfg.CacheDir != "" {
+		if err := responseCache.SetDisk(cfg.CacheDir, cfg.CacheDirMaxBytes); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.CacheOriginTTL {
+		responseCache.SetOriginTTL(cfg.CacheMinTTL, cfg.CacheMaxTTL)
+	}
+	responseCache.SetServeStale(cfg.ServeStale)
+	responseCache.SetNegativeTTL(cfg.NegativeCacheTTL)
+	responseCache.SetTrackingParams(cfg.TrackingParams)
+
+	var presets map[string]crawler.Spec
+	if cfg.PresetsFile != "" {
+		presets, err = crawler.LoadPresets(cfg.PresetsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &URLFetcherMCPServer{
-		config:    cfg,
-		fetcher:   fetcher.NewFetcher(cfg),
-		processor: processor.NewProcessor(),
-		cache:     cache.NewCache(cfg.CacheTTL),
+		config:     cfg,
+		fetcher:    fetcher.NewFetcher(cfg),
+		processor:  proc,
+		cache:      responseCache,
+		summarizer: newSummarizer(cfg),
+		presets:    presets,
+		jobs:       crawler.NewJobs(),
 	}, nil
 }
 
@@ -62,14 +119,14 @@ func (s *URLFetcherMCPServer) ListTools(ctx context.Context) (*protocol.ListTool
 			},
 			"engine": map[string]interface{}{
 				"type":        "string",
-				"description": "Fetching engine: 'http' (default) or 'chrome'",
-				"enum":        []string{"http", "chrome"},
+				"description": "Fetching engine: 'http' (default), 'chrome', or the experimental 'http3' (QUIC, falls back to 'http' if the handshake fails)",
+				"enum":        []string{"http", "http3", "chrome"},
 				"default":     "http",
 			},
 			"format": map[string]interface{}{
 				"type":        "string",
-				"description": "Output format: 'text' (default, returns cleaned plain text — no HTML tags), 'html' (returns raw HTML — use this for HTML parsing), or 'markdown'",
-				"enum":        []string{"text", "html", "markdown"},
+				"description": "Output format: 'text' (default, returns cleaned plain text — no HTML tags), 'html' (returns raw HTML — use this for HTML parsing), 'markdown', or 'article' (main text plus structured metadata: byline, author, published date, excerpt, top image, word count)",
+				"enum":        []string{"text", "html", "markdown", "article"},
 				"default":     "text",
 			},
 			"max_content_length": map[string]interface{}{
@@ -77,142 +134,1941 @@ func (s *URLFetcherMCPServer) ListTools(ctx context.Context) (*protocol.ListTool
 				"description": "Maximum content length in bytes (default: 10MB)",
 				"default":     types.DefaultMaxContentLength,
 			},
+			"cookies": map[string]interface{}{
+				"type":        []string{"object", "array"},
+				"description": "Cookies to send with the request in either engine, as {\"name\": \"value\"} or [{\"name\": ..., \"value\": ..., \"domain\": ..., \"path\": ...}]. Without a domain a cookie goes to the requested host only; Chrome sets them before navigating",
+			},
+			"auth": map[string]interface{}{
+				"type":        "object",
+				"description": "Credentials sent as an Authorization header in either engine: {\"type\": \"basic\", \"username\": ..., \"password\": ...} or {\"type\": \"bearer\", \"token\": ...}",
+				"properties": map[string]interface{}{
+					"type":     map[string]interface{}{"type": "string", "enum": []string{"basic", "bearer"}},
+					"username": map[string]interface{}{"type": "string"},
+					"password": map[string]interface{}{"type": "string"},
+					"token":    map[string]interface{}{"type": "string"},
+				},
+				"required": []string{"type"},
+			},
+			"headers": map[string]interface{}{
+				"type":                 "object",
+				"description":          "Request headers to send in either engine, e.g. {\"Accept-Language\": \"de-DE\", \"Referer\": \"https://example.com/\"}, replacing the engine's own of the same name. Host, Connection, Content-Length, Transfer-Encoding, Upgrade, Accept-Encoding, Cookie and Range can't be set; use cookies and range_start/range_end instead",
+				"additionalProperties": map[string]interface{}{"type": "string"},
+			},
+			"xpath": map[string]interface{}{
+				"type":        "string",
+				"description": "XPath expression selecting the content to return instead of the readability extraction, e.g. //div[@id='content'] or //table//tr[td[contains(text(),'Total')]]. Element matches are converted to the requested format; text, attribute and scalar results are returned one value per line",
+			},
+			"proxy": map[string]interface{}{
+				"type":        "string",
+				"description": "Proxy for this request (http://, https:// or socks5://, optionally with user:password@), overriding the FETCH_URL_PROXY pool. The Chrome engine opens the page in a browser context of its own routed through it",
+			},
+			"transform": map[string]interface{}{
+				"type":        "string",
+				"description": "JMESPath expression applied to the response JSON before it is returned, e.g. '{title: title, hash: content_hash}' or 'article.{author: author, date: published_date}'. Accepted by every tool",
+			},
+			"schema": map[string]interface{}{
+				"type":        []string{"object", "string"},
+				"description": "JSON Schema the response (after transform) must satisfy; if it doesn't, the call fails with every violation and its JSON pointer location. Accepted by every tool",
+			},
+			"user_agent": map[string]interface{}{
+				"type":        "string",
+				"description": "User agent for both engines: a preset ('desktop-chrome', 'mobile-safari', 'googlebot', 'curl') or a full UA string. Request headers are adjusted to match, e.g. no browser headers for curl",
+			},
+			"force_http1": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Use HTTP/1.1 even if the server offers HTTP/2 (HTTP engine only), for servers that misbehave over h2",
+			},
+			"max_redirects": map[string]interface{}{
+				"type":        "integer",
+				"description": "Maximum number of redirects to follow (HTTP engines only), overriding FETCH_URL_MAX_REDIRECTS. 0 returns the redirect response itself with its target in 'location'",
+			},
+			"max_bandwidth": map[string]interface{}{
+				"type":        "integer",
+				"description": "Download rate limit for this request in bytes per second, on top of FETCH_URL_BANDWIDTH_LIMIT",
+			},
+			"range_start": map[string]interface{}{
+				"type":        "integer",
+				"description": "First byte of the body to fetch (HTTP engines only). Sends a Range request; if the server ignores it, the bytes before the range are read and discarded. The part returned is reported as 'content_range'",
+			},
+			"range_end": map[string]interface{}{
+				"type":        "integer",
+				"description": "Last byte of the body to fetch, inclusive (HTTP engines only); without it the range runs to the end. range_end 999 alone fetches the first 1000 bytes",
+			},
+			"wait_ms": map[string]interface{}{
+				"type":        "integer",
+				"description": fmt.Sprintf("Extra milliseconds to wait once the page has settled, before its HTML is captured (Chrome engine only, max %d), for content added by delayed timers the network-idle detection can't see", types.MaxWaitMs),
+			},
+			"scroll": map[string]interface{}{
+				"type":        []string{"boolean", "integer"},
+				"description": fmt.Sprintf("Scroll to the bottom of the page up to this many times before capturing it, stopping early once it stops growing (Chrome engine only; true = %d, max %d), so infinite-scroll feeds and lazy-loaded images are loaded", types.DefaultScrolls, types.MaxScrolls),
+			},
+			"actions": map[string]interface{}{
+				"type":        "array",
+				"description": fmt.Sprintf("Steps run in order once the page has loaded, before it is captured (Chrome engine only, max %d), e.g. to dismiss a cookie banner, switch tabs or click 'load more'", types.MaxActions),
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"type":     map[string]interface{}{"type": "string", "enum": []string{types.ActionClick, types.ActionType, types.ActionPress, types.ActionWait, types.ActionScroll}},
+						"selector": map[string]interface{}{"type": "string", "description": "CSS selector to click, type into, wait for (until visible) or scroll into view"},
+						"text":     map[string]interface{}{"type": "string", "description": "Text to type"},
+						"key":      map[string]interface{}{"type": "string", "description": "Key to press: a single character or Enter, Tab, Escape, Backspace, Delete, ArrowUp, ArrowDown, ArrowLeft, ArrowRight, PageUp, PageDown, Home, End"},
+						"ms":       map[string]interface{}{"type": "integer", "description": "Milliseconds to wait, for a wait without a selector"},
+					},
+					"required": []string{"type"},
+				},
+			},
+			"capture_network": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Record every request the page makes (Chrome engine only) and return them as 'network': URL, method, type, status, MIME type, bytes and timing, to find which API endpoint actually serves the data",
+			},
+			"return_cookies": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Return the cookies set for the page during the Chrome navigation as 'cookies' (Chrome engine only), with domain, path, expiry and flags, in a format import_cookies and the cookies parameter accept",
+			},
+			"device": map[string]interface{}{
+				"type":        "string",
+				"description": "Device to emulate (Chrome engine only): its viewport and, unless user_agent is given, its browser's user agent, since responsive sites serve different DOM per device",
+				"enum":        deviceNames(),
+			},
+			"viewport": map[string]interface{}{
+				"type":        "object",
+				"description": "Screen to emulate (Chrome engine only), overriding the device's",
+				"properties": map[string]interface{}{
+					"width":               map[string]interface{}{"type": "integer"},
+					"height":              map[string]interface{}{"type": "integer"},
+					"device_scale_factor": map[string]interface{}{"type": "number", "description": "Device pixels per CSS pixel (default 1)"},
+					"mobile":              map[string]interface{}{"type": "boolean", "description": "Emulate a mobile browser: mobile layout, meta viewport and touch events"},
+				},
+				"required": []string{"width", "height"},
+			},
+			"locale": map[string]interface{}{
+				"type":        "string",
+				"description": "Locale to fetch as, a BCP 47 tag such as de-DE or pt-BR: sets Accept-Language in either engine and, in Chrome, the page's navigator.language and Intl date and number formatting, for sites that localize content",
+			},
+			"timezone": map[string]interface{}{
+				"type":        "string",
+				"description": "IANA time zone the page sees, e.g. Europe/Berlin (Chrome engine only)",
+			},
+			"geolocation": map[string]interface{}{
+				"type":        "object",
+				"description": "Position the page sees through the Geolocation API, granted without a prompt (Chrome engine only), for sites that geo-gate content",
+				"properties": map[string]interface{}{
+					"latitude":  map[string]interface{}{"type": "number"},
+					"longitude": map[string]interface{}{"type": "number"},
+					"accuracy":  map[string]interface{}{"type": "number", "description": "Accuracy in meters (default 100)"},
+				},
+				"required": []string{"latitude", "longitude"},
+			},
+			"block": map[string]interface{}{
+				"type":        "array",
+				"description": "Resources Chrome doesn't load, overriding FETCH_URL_CHROME_BLOCK (Chrome engine only); [] loads everything. Blocking cuts render time and bandwidth when only the text is needed",
+				"items":       map[string]interface{}{"type": "string", "enum": types.BlockableResources},
+			},
+			"retry": map[string]interface{}{
+				"type":        "object",
+				"description": "Override the server's retry policy (FETCH_URL_RETRY) for this request; omitted fields keep the server's values. Delays double from base_delay_ms up to max_delay_ms, randomized by +/- jitter",
+				"properties": map[string]interface{}{
+					"max_attempts":   map[string]interface{}{"type": "integer", "description": fmt.Sprintf("Total attempts including the first, 1-%d (default %d); 1 disables retries", types.MaxRetryAttempts, types.DefaultRetryPolicy.MaxAttempts)},
+					"base_delay_ms":  map[string]interface{}{"type": "integer", "description": "Delay before the first retry (default 1000)"},
+					"max_delay_ms":   map[string]interface{}{"type": "integer", "description": "Longest delay between attempts (default 30000)"},
+					"jitter":         map[string]interface{}{"type": "number", "description": "Fraction each delay is randomized by, 0-1 (default 0.2)"},
+					"status_codes":   map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "integer"}, "description": "Response statuses to retry (default [500, 502, 503, 504])"},
+					"network_errors": map[string]interface{}{"type": "boolean", "description": "Retry timeouts and connection failures (default true)"},
+				},
+			},
+			"sign": map[string]interface{}{
+				"type":        "object",
+				"description": "Sign the URL with an HMAC computed server-side. 'secret' names the environment variable FETCH_URL_SECRET_<secret> holding the key, so the key itself is never sent",
+				"properties": map[string]interface{}{
+					"secret":        map[string]interface{}{"type": "string"},
+					"algorithm":     map[string]interface{}{"type": "string", "enum": []string{"hmac-sha256", "hmac-sha1", "hmac-sha512"}},
+					"param":         map[string]interface{}{"type": "string", "description": "Query parameter for the signature (default 'signature')"},
+					"expires_param": map[string]interface{}{"type": "string", "description": "Query parameter for a Unix expiry timestamp, added before signing"},
+					"ttl_seconds":   map[string]interface{}{"type": "integer", "description": "Signature lifetime when expires_param is set (default 300)"},
+					"encoding":      map[string]interface{}{"type": "string", "enum": []string{"hex", "base64", "base64url"}},
+				},
+				"required": []string{"secret"},
+			},
+			"session": map[string]interface{}{
+				"type":        "string",
+				"description": "Name of a session (letters, digits, '-', '_') whose cookie jar is sent with the request and updated from the response; created on first use",
+			},
+			"provenance": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Include a provenance record: server version, fetch time, SHA-256 of the content, a hash of the request options and, if the server has a key configured, an HMAC-SHA256 signature over them",
+			},
+			"summary_sentences": map[string]interface{}{
+				"type":        "integer",
+				"description": fmt.Sprintf("Also return a 'summary' of about this many sentences (max %d), written by the server's summarizer: extractive by default, or an external service if configured", types.MaxSummarySentences),
+			},
+			"preflight": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Send a HEAD request first (for hosts not fetched before) and skip the download if it is larger than max_content_length or not a processable type; only metadata is returned in that case",
+			},
+			"no_cache": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Skip the cached copy and fetch the page fresh; the result still replaces the cached entry",
+			},
+			"force_refresh": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Evict the cached copy, fetch the page fresh and cache the result; requests for the same entry meanwhile wait for the new copy",
+			},
+			"serve_stale": map[string]interface{}{
+				"type":        "boolean",
+				"description": "If the fetch times out or gets a 5xx, return the cached copy, even an expired one, with stale: true and a warning instead of the error (default: the server's FETCH_URL_SERVE_STALE)",
+			},
+			"cache_only": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Answer only from the cache, never the network; a page that isn't cached fails with status 'not_cached'",
+			},
+			"offset": map[string]interface{}{
+				"type":        "integer",
+				"description": "Character offset into the processed content to start returning from (for chunked retrieval)",
+				"default":     0,
+			},
+			"chunk_size": map[string]interface{}{
+				"type":        "integer",
+				"description": "Maximum number of characters to return starting at offset; the response reports total_length, has_more and next_offset. The full content is cached so subsequent chunks don't refetch",
+			},
+			"follow_pagination": map[string]interface{}{
+				"type":        []string{"boolean", "integer"},
+				"description": fmt.Sprintf("Follow rel=next / 'next page' links and append up to this many additional pages (true = %d, max %d)", types.DefaultPaginationPages, types.MaxPaginationPages),
+			},
+		},
+		"required": []string{"url"},
+	}
+
+	schemaBytes, err := json.Marshal(inputSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	emptySchema := []byte(`{"type":"object","properties":{}}`)
+
+	invalidateSchema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"url": map[string]interface{}{
+				"type":        "string",
+				"description": "Exact URL whose cached entries should be removed",
+			},
+			"pattern": map[string]interface{}{
+				"type":        "string",
+				"description": "Glob pattern ('*' matches anything, '?' one character) matched against cached URLs",
+			},
+		},
+	}
+
+	invalidateSchemaBytes, err := json.Marshal(invalidateSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionNameSchema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{
+				"type":        "string",
+				"description": "Session name",
+			},
+		},
+		"required": []string{"name"},
+	}
+
+	sessionNameSchemaBytes, err := json.Marshal(sessionNameSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	importCookiesSchema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"session": map[string]interface{}{
+				"type":        "string",
+				"description": "Session to import into; created if it doesn't exist",
+			},
+			"content": map[string]interface{}{
+				"type":        "string",
+				"description": "Contents of the cookie export: Netscape cookies.txt, a JSON array of cookies, or a {\"cookies\": [...]} storage state",
+			},
+		},
+		"required": []string{"session", "content"},
+	}
+
+	importCookiesSchemaBytes, err := json.Marshal(importCookiesSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	hasChangedSchema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"url": map[string]interface{}{
+				"type":        "string",
+				"description": "URL to check",
+			},
+			"engine": map[string]interface{}{
+				"type":        "string",
+				"description": "Fetching engine: 'http' (default), 'chrome', or the experimental 'http3' (QUIC, falls back to 'http' if the handshake fails)",
+				"enum":        []string{"http", "http3", "chrome"},
+			},
+			"format": map[string]interface{}{
+				"type":        "string",
+				"description": "Format the fingerprint is computed on (default: text)",
+				"enum":        []string{"text", "html", "markdown", "article"},
+			},
+		},
+		"required": []string{"url"},
+	}
+
+	hasChangedSchemaBytes, err := json.Marshal(hasChangedSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	domainStatsSchema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"domain": map[string]interface{}{
+				"type":        "string",
+				"description": "Only report this domain",
+			},
+			"sort_by": map[string]interface{}{
+				"type":        "string",
+				"description": "Sort order: 'fetches' (default), 'errors', 'error_rate' or 'latency'",
+				"enum":        []string{"fetches", "errors", "error_rate", "latency"},
+			},
+			"limit": map[string]interface{}{
+				"type":        "integer",
+				"description": "Maximum number of domains to return (default: all)",
+			},
+		},
+	}
+
+	domainStatsSchemaBytes, err := json.Marshal(domainStatsSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestSchema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"url": map[string]interface{}{
+				"type":        "string",
+				"description": "URL of a representative page from the site",
+			},
+			"engine": map[string]interface{}{
+				"type":        "string",
+				"description": "Fetching engine: 'http' (default), 'chrome', or the experimental 'http3' (QUIC, falls back to 'http' if the handshake fails)",
+				"enum":        []string{"http", "http3", "chrome"},
+			},
+		},
+		"required": []string{"url"},
+	}
+
+	suggestSchemaBytes, err := json.Marshal(suggestSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	discoverSchema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"url": map[string]interface{}{
+				"type":        "string",
+				"description": "Any URL on the site; its origin is probed",
+			},
 		},
 		"required": []string{"url"},
 	}
 
-	schemaBytes, err := json.Marshal(inputSchema)
+	discoverSchemaBytes, err := json.Marshal(discoverSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	crawlSchema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"seeds": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "URLs to start from; only links on the seeds' hosts are followed",
+			},
+			"depth": map[string]interface{}{
+				"type":        "integer",
+				"description": fmt.Sprintf("How many links deep to follow from the seeds, 0-%d (default: 0, fetch only the seeds)", crawler.MaxDepth),
+			},
+			"include": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Patterns a followed link (or, with depth 0, a seed) must match one of: a path glob or prefix ('/docs/**', '/docs'), a last-segment glob ('*.html'), a full-URL glob ('https://host/**'), 'query' or 'query:NAME' for query strings, or 're:EXPR' for a regular expression on the URL",
+			},
+			"exclude": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Patterns, in the same forms, a URL must match none of, e.g. ['/blog', '*.pdf', 'query:sort']; an exclude match always wins",
+			},
+			"format": map[string]interface{}{
+				"type":        "string",
+				"description": "Output format for each page (default: text)",
+				"enum":        []string{"text", "html", "markdown", "article"},
+			},
+			"engine": map[string]interface{}{
+				"type":        "string",
+				"description": "Fetching engine: 'http' (default), 'chrome', or the experimental 'http3'",
+				"enum":        []string{"http", "http3", "chrome"},
+			},
+			"max_pages": map[string]interface{}{
+				"type":        "integer",
+				"description": fmt.Sprintf("Maximum number of pages to fetch, 1-%d (default: %d)", crawler.MaxPages, crawler.DefaultMaxPages),
+			},
+			"max_bytes": map[string]interface{}{
+				"type":        "integer",
+				"description": fmt.Sprintf("Maximum bytes to download across all pages, up to %d (default: %d)", crawler.MaxBytes, crawler.DefaultMaxBytes),
+			},
+			"max_duration_seconds": map[string]interface{}{
+				"type":        "integer",
+				"description": fmt.Sprintf("Maximum crawl time in seconds, up to %d (default: %d)", int(crawler.MaxDuration.Seconds()), int(crawler.DefaultMaxDuration.Seconds())),
+			},
+			"output": map[string]interface{}{
+				"type":        "string",
+				"description": "Write pages as JSON Lines to this file under FETCH_URL_OUTPUT_DIR instead of returning their content",
+			},
+			"dry_run": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Return the fetch plan (the seeds that would be fetched after policy, filters and budgets, with the reason for each one skipped) without fetching anything",
+			},
+			"async": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Run the crawl in the background and return a job_id at once; read pages with get_job_results as they arrive",
+			},
+		},
+		"required": []string{"seeds"},
+	}
+
+	crawlSchemaBytes, err := json.Marshal(crawlSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	presetName := map[string]interface{}{
+		"type":        "string",
+		"description": "Name of a preset defined in FETCH_URL_PRESETS_FILE",
+	}
+	if len(s.presets) > 0 {
+		presetName["enum"] = s.presetNames()
+	}
+	runPresetSchema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name": presetName,
+			"dry_run": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Return the preset's fetch plan without fetching anything",
+			},
+			"async": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Run the preset in the background and return a job_id at once",
+			},
+		},
+		"required": []string{"name"},
+	}
+
+	runPresetSchemaBytes, err := json.Marshal(runPresetSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	jobID := map[string]interface{}{
+		"type":        "string",
+		"description": "Job ID returned by an async crawl or run_preset",
+	}
+	jobResultsSchema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"job_id": jobID,
+			"cursor": map[string]interface{}{
+				"type":        "integer",
+				"description": "Index of the first page to return: 0, then the next_cursor of the previous call (default: 0)",
+			},
+			"limit": map[string]interface{}{
+				"type":        "integer",
+				"description": fmt.Sprintf("Maximum pages to return, up to %d (default: %d)", crawler.MaxResultsLimit, crawler.DefaultResultsLimit),
+			},
+		},
+		"required": []string{"job_id"},
+	}
+
+	jobResultsSchemaBytes, err := json.Marshal(jobResultsSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	cancelJobSchema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"job_id": jobID,
+		},
+		"required": []string{"job_id"},
+	}
+
+	cancelJobSchemaBytes, err := json.Marshal(cancelJobSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	return &protocol.ListToolsResponse{
+		Tools: []protocol.Tool{
+			{
+				Name:        "fetch_url",
+				Description: "Fetch content from a URL. By default returns cleaned plain text (no HTML tags). Set format='html' to get raw HTML for parsing. Use engine='chrome' for JavaScript-heavy sites that need browser rendering.",
+				InputSchema: json.RawMessage(schemaBytes),
+			},
+			{
+				Name:        "cache_stats",
+				Description: "Report cache entry count, hit/miss counters and hit rate.",
+				InputSchema: json.RawMessage(emptySchema),
+			},
+			{
+				Name:        "clear_cache",
+				Description: "Remove all entries from the response cache.",
+				InputSchema: json.RawMessage(emptySchema),
+			},
+			{
+				Name:        "invalidate_url",
+				Description: "Evict cached responses for a URL (all engines and formats) or for every URL matching a glob pattern, e.g. 'https://example.com/docs/*'.",
+				InputSchema: json.RawMessage(invalidateSchemaBytes),
+			},
+			{
+				Name:        "capabilities",
+				Description: "List the engines, formats and optional features active in this deployment so plans can adapt to what the server can actually do.",
+				InputSchema: json.RawMessage(emptySchema),
+			},
+			{
+				Name:        "server_stats",
+				Description: "Report server uptime, fetch counts and errors per engine, average fetch time, Chrome pool utilization and cache size.",
+				InputSchema: json.RawMessage(emptySchema),
+			},
+			{
+				Name:        "has_changed",
+				Description: "Fetch a URL fresh and compare its content fingerprint against the cached copy, reporting whether the page changed. The fresh result replaces the cached one, so repeated calls detect successive changes.",
+				InputSchema: json.RawMessage(hasChangedSchemaBytes),
+			},
+			{
+				Name:        "domain_stats",
+				Description: "Report per-domain fetch counts, error rate, average latency, bytes and cache hit rate for this session (or across restarts when persistence is configured), to spot slow or failing sites.",
+				InputSchema: json.RawMessage(domainStatsSchemaBytes),
+			},
+			{
+				Name:        "list_sessions",
+				Description: "List named sessions with their cookie counts, domains and last use.",
+				InputSchema: json.RawMessage(emptySchema),
+			},
+			{
+				Name:        "import_cookies",
+				Description: "Import cookies exported from a browser (Netscape cookies.txt or a JSON export) into a named session, to reuse an existing logged-in browser session.",
+				InputSchema: json.RawMessage(importCookiesSchemaBytes),
+			},
+			{
+				Name:        "delete_session",
+				Description: "Delete a named session and its saved cookies.",
+				InputSchema: json.RawMessage(sessionNameSchemaBytes),
+			},
+			{
+				Name:        "login",
+				Description: "Run the configured login flow for a named session in Chrome, refreshing its cookies. Sessions with a flow also log in automatically on first use.",
+				InputSchema: json.RawMessage(sessionNameSchemaBytes),
+			},
+			{
+				Name:        "suggest_selectors",
+				Description: "Analyze a page and suggest CSS selectors for its main content, title, date and author, plus boilerplate to remove, as a starting point for a site extraction profile.",
+				InputSchema: json.RawMessage(suggestSchemaBytes),
+			},
+			{
+				Name:        "crawl",
+				Description: "Fetch a set of seed URLs and, with depth > 0, the pages they link to on the same hosts, breadth-first, optionally scoped by include/exclude globs. Returns each page's title and content, or writes them to an output file.",
+				InputSchema: json.RawMessage(crawlSchemaBytes),
+			},
+			{
+				Name:        "run_preset",
+				Description: "Run a named crawl preset from the server configuration, e.g. a recurring docs sync, with its seeds, depth, filters, format and output already set.",
+				InputSchema: json.RawMessage(runPresetSchemaBytes),
+			},
+			{
+				Name:        "discover_endpoints",
+				Description: "Probe a site's well-known machine-readable endpoints (robots.txt, sitemaps, security.txt, RSS/Atom feeds, web app manifest, OpenAPI spec, llms.txt) and report which exist, with metadata read from each, in one call.",
+				InputSchema: json.RawMessage(discoverSchemaBytes),
+			},
+			{
+				Name:        "get_job_results",
+				Description: "Read the pages of an async crawl or run_preset job, a batch at a time from a cursor, while it is still running. has_more stays true until the job is done and every page has been read.",
+				InputSchema: json.RawMessage(jobResultsSchemaBytes),
+			},
+			{
+				Name:        "cancel_job",
+				Description: "Stop an async crawl job; the pages fetched so far stay readable with get_job_results.",
+				InputSchema: json.RawMessage(cancelJobSchemaBytes),
+			},
+		},
+	}, nil
+}
+
+// CallTool executes a tool
+func (s *URLFetcherMCPServer) CallTool(ctx context.Context, req *protocol.CallToolRequest) (*protocol.CallToolResponse, error) {
+	var result interface{}
+
+	// Validate the transform and output schema before doing any work
+	transform, err := compileTransform(req.Arguments)
+	if err != nil {
+		return &protocol.CallToolResponse{
+			Content: []protocol.ToolContent{
+				{
+					Type: "text",
+					Text: fmt.Sprintf("Error: %v", err),
+				},
+			},
+			IsError: true,
+		}, nil
+	}
+	outputSchema, err := compileOutputSchema(req.Arguments)
+	if err != nil {
+		return &protocol.CallToolResponse{
+			Content: []protocol.ToolContent{
+				{
+					Type: "text",
+					Text: fmt.Sprintf("Error: %v", err),
+				},
+			},
+			IsError: true,
+		}, nil
+	}
+
+	switch req.Name {
+	case "fetch_url":
+		result, err = s.fetchURL(ctx, req.Arguments)
+	case "cache_stats":
+		result, err = s.cacheStats(req.Arguments)
+	case "clear_cache":
+		result, err = s.clearCache(req.Arguments)
+	case "invalidate_url":
+		result, err = s.invalidateURL(req.Arguments)
+	case "capabilities":
+		result, err = s.capabilities(req.Arguments)
+	case "server_stats":
+		result, err = s.serverStats(req.Arguments)
+	case "has_changed":
+		result, err = s.hasChanged(ctx, req.Arguments)
+	case "domain_stats":
+		result, err = s.domainStats(req.Arguments)
+	case "list_sessions":
+		result, err = s.listSessions(req.Arguments)
+	case "import_cookies":
+		result, err = s.importCookies(req.Arguments)
+	case "delete_session":
+		result, err = s.deleteSession(req.Arguments)
+	case "login":
+		result, err = s.login(ctx, req.Arguments)
+	case "suggest_selectors":
+		result, err = s.suggestSelectors(ctx, req.Arguments)
+	case "crawl":
+		result, err = s.crawl(ctx, req.Arguments)
+	case "run_preset":
+		result, err = s.runPreset(ctx, req.Arguments)
+	case "discover_endpoints":
+		result, err = s.discoverEndpoints(ctx, req.Arguments)
+	case "get_job_results":
+		result, err = s.getJobResults(req.Arguments)
+	case "cancel_job":
+		result, err = s.cancelJob(req.Arguments)
+	default:
+		return &protocol.CallToolResponse{
+			Content: []protocol.ToolContent{
+				{
+					Type: "text",
+					Text: fmt.Sprintf("Unknown tool: %s", req.Name),
+				},
+			},
+			IsError: true,
+		}, nil
+	}
+
+	if err == nil && transform != nil {
+		result, err = applyTransform(transform, result)
+	}
+	if err == nil && outputSchema != nil {
+		err = validateOutput(outputSchema, result)
+	}
+
+	if err != nil {
+		return &protocol.CallToolResponse{
+			Content: []protocol.ToolContent{
+				{
+					Type: "text",
+					Text: fmt.Sprintf("Error: %v", err),
+				},
+			},
+			IsError: true,
+		}, nil
+	}
+
+	// Convert result to JSON string
+	jsonBytes, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return &protocol.CallToolResponse{
+			Content: []protocol.ToolContent{
+				{
+					Type: "text",
+					Text: fmt.Sprintf("Error formatting response: %v", err),
+				},
+			},
+			IsError: true,
+		}, nil
+	}
+
+	return &protocol.CallToolResponse{
+		Content: []protocol.ToolContent{
+			{
+				Type: "text",
+				Text: string(jsonBytes),
+			},
+		},
+	}, nil
+}
+
+// fetchURL handles the fetch_url tool
+func (s *URLFetcherMCPServer) fetchURL(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+	// Parse request
+	req := &types.FetchRequest{}
+
+	// URL (required)
+	url, ok := params["url"].(string)
+	if !ok || url == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	req.URL = url
+
+	// Engine (optional)
+	if engine, ok := params["engine"].(string); ok {
+		req.Engine = engine
+	}
+
+	// Format (optional)
+	if format, ok := params["format"].(string); ok {
+		req.Format = format
+	}
+
+	// Max content length (optional)
+	if maxLen, ok := params["max_content_length"].(float64); ok {
+		req.MaxContentLength = int(maxLen)
+	}
+
+	// Follow pagination (optional): true for the default page limit or a number
+	switch pages := params["follow_pagination"].(type) {
+	case bool:
+		if pages {
+			req.FollowPagination = types.DefaultPaginationPages
+		}
+	case float64:
+		req.FollowPagination = int(pages)
+	}
+	if req.FollowPagination > types.MaxPaginationPages {
+		req.FollowPagination = types.MaxPaginationPages
+	}
+
+	// Cookies (optional)
+	cookies, err := parseCookies(params["cookies"])
+	if err != nil {
+		return nil, err
+	}
+	req.Cookies = cookies
+
+	// Auth (optional)
+	auth, err := parseAuth(params["auth"])
+	if err != nil {
+		return nil, err
+	}
+	req.Auth = auth
+
+	// Custom headers (optional)
+	headers, err := parseHeaders(params["headers"])
+	if err != nil {
+		return nil, err
+	}
+	req.Headers = headers
+
+	// XPath selection (optional)
+	if expr, ok := params["xpath"].(string); ok && expr != "" {
+		if err := processor.ValidateXPath(expr); err != nil {
+			return nil, err
+		}
+		req.XPath = expr
+	}
+
+	// Proxy override (optional)
+	if proxy, ok := params["proxy"].(string); ok {
+		req.Proxy = proxy
+	}
+
+	// User agent or preset name (optional)
+	if userAgent, ok := params["user_agent"].(string); ok {
+		req.UserAgent = userAgent
+	}
+
+	// Protocol override (optional)
+	if forceHTTP1, ok := params["force_http1"].(bool); ok {
+		req.ForceHTTP1 = forceHTTP1
+	}
+
+	// Redirect limit (optional)
+	if maxRedirects, ok := params["max_redirects"].(float64); ok {
+		if maxRedirects < 0 {
+			return nil, fmt.Errorf("max_redirects must be non-negative")
+		}
+		limit := int(maxRedirects)
+		req.MaxRedirects = &limit
+	}
+
+	// Bandwidth limit (optional)
+	if rate, ok := params["max_bandwidth"].(float64); ok {
+		if rate < 0 {
+			return nil, fmt.Errorf("max_bandwidth must be non-negative")
+		}
+		req.MaxBandwidth = int64(rate)
+	}
+
+	// Byte range (optional)
+	byteRange, err := parseRange(params)
+	if err != nil {
+		return nil, err
+	}
+	req.Range = byteRange
+
+	// Settle delay (optional)
+	if waitMs, ok := params["wait_ms"].(float64); ok {
+		if waitMs < 0 {
+			return nil, fmt.Errorf("wait_ms must be non-negative")
+		}
+		req.WaitMs = min(int(waitMs), types.MaxWaitMs)
+	}
+
+	// Auto-scroll (optional): true for the default number of scrolls or a number
+	switch scrolls := params["scroll"].(type) {
+	case bool:
+		if scrolls {
+			req.Scroll = types.DefaultScrolls
+		}
+	case float64:
+		req.Scroll = min(int(scrolls), types.MaxScrolls)
+	}
+
+	// Scripted interaction (optional)
+	actions, err := parseActions(params["actions"])
+	if err != nil {
+		return nil, err
+	}
+	req.Actions = actions
+
+	// Network capture (optional)
+	if captureNetwork, ok := params["capture_network"].(bool); ok {
+		req.CaptureNetwork = captureNetwork
+	}
+
+	// Cookie return (optional)
+	if returnCookies, ok := params["return_cookies"].(bool); ok {
+		req.ReturnCookies = returnCookies
+	}
+
+	// Device and viewport emulation (optional)
+	if device, ok := params["device"].(string); ok && device != "" {
+		if _, ok := types.DevicePresets[strings.ToLower(device)]; !ok {
+			return nil, fmt.Errorf("unknown device %s (available: %v)", device, deviceNames())
+		}
+		req.Device = device
+	}
+	viewport, err := parseViewport(params["viewport"])
+	if err != nil {
+		return nil, err
+	}
+	req.Viewport = viewport
+
+	// Locale, timezone and geolocation emulation (optional)
+	if locale, ok := params["locale"].(string); ok && locale != "" {
+		if err := types.ValidateLocale(locale); err != nil {
+			return nil, err
+		}
+		req.Locale = locale
+	}
+	if timezone, ok := params["timezone"].(string); ok {
+		req.Timezone = timezone
+	}
+	geolocation, err := parseGeolocation(params["geolocation"])
+	if err != nil {
+		return nil, err
+	}
+	req.Geolocation = geolocation
+
+	// Resource blocking (optional)
+	if params["block"] != nil {
+		block, err := stringList(params["block"], "block")
+		if err != nil {
+			return nil, err
+		}
+		for i, resource := range block {
+			block[i] = strings.ToLower(resource)
+			if !slices.Contains(types.BlockableResources, block[i]) {
+				return nil, fmt.Errorf("invalid block %s (must be one of %v)", resource, types.BlockableResources)
+			}
+		}
+		req.Block = block
+	}
+
+	// Retry policy override (optional)
+	retry, err := parseRetry(params["retry"])
+	if err != nil {
+		return nil, err
+	}
+	req.Retry = retry
+
+	// URL signing (optional)
+	sign, err := parseSign(params["sign"])
+	if err != nil {
+		return nil, err
+	}
+	req.Sign = sign
+
+	// Session (optional)
+	if session, ok := params["session"].(string); ok {
+		req.Session = session
+	}
+
+	// Provenance record (optional)
+	if provenance, ok := params["provenance"].(bool); ok {
+		req.Provenance = provenance
+	}
+
+	// Summary (optional)
+	if sentences, ok := params["summary_sentences"].(float64); ok {
+		req.SummarySentences = int(sentences)
+	}
+	if req.SummarySentences > types.MaxSummarySentences {
+		req.SummarySentences = types.MaxSummarySentences
+	}
+
+	// HEAD preflight (optional)
+	if preflight, ok := params["preflight"].(bool); ok {
+		req.Preflight = preflight
+	}
+
+	// Cache bypass (optional)
+	if noCache, ok := params["no_cache"].(bool); ok {
+		req.NoCache = noCache
+	}
+	if forceRefresh, ok := params["force_refresh"].(bool); ok {
+		req.ForceRefresh = forceRefresh
+	}
+	if serveStale, ok := params["serve_stale"].(bool); ok {
+		req.ServeStale = &serveStale
+	}
+	if cacheOnly, ok := params["cache_only"].(bool); ok {
+		req.CacheOnly = cacheOnly
+	}
+
+	// Chunking (optional): slice the processed content in characters
+	if offset, ok := params["offset"].(float64); ok {
+		req.Offset = int(offset)
+	}
+	if chunkSize, ok := params["chunk_size"].(float64); ok {
+		req.ChunkSize = int(chunkSize)
+	}
+	if req.Offset < 0 || req.ChunkSize < 0 {
+		return nil, fmt.Errorf("offset and chunk_size must be non-negative")
+	}
+
+	// Apply defaults
+	s.fetcher.ApplyDefaults(req)
+
+	// Refuse blocked URLs before the cache, so entries cached before a
+	// blocklist update aren't served
+	if err := s.fetcher.CheckPolicy(req.URL); err != nil {
+		result := s.formatErrorResponse(req.URL, err.Error())
+		result["status"] = "blocked"
+		return result, nil
+	}
+
+	// Fetch content, or reuse the cached raw body
+	response, err := s.fetchRaw(ctx, req)
+	if errors.Is(err, errNotCached) {
+		result := s.formatErrorResponse(req.URL, err.Error())
+		result["status"] = "not_cached"
+		return result, nil
+	}
+	if errors.Is(err, fetcher.ErrWarmingUp) {
+		result := s.formatErrorResponse(req.URL, err.Error())
+		result["status"] = "warming"
+		result["retryable"] = true
+		return result, nil
+	}
+	if err != nil {
+		// Return formatted error response
+		if response != nil {
+			return s.formatResponse(response), nil
+		}
+		return s.formatErrorResponse(req.URL, err.Error()), nil
+	}
+
+	// A skipped download has only metadata; there is nothing to process
+	if response.Skipped {
+		return s.formatResponse(response), nil
+	}
+
+	// Look for the next page before processing discards the markup
+	nextURL := ""
+	if req.FollowPagination > 0 {
+		nextURL = s.processor.FindNextPage(response.Content, req.URL)
+	}
+
+	// Process content
+	if err := s.process(ctx, req, response); err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		// Add warning but don't fail
+		response.Warnings = append(response.Warnings, fmt.Sprintf("Content processing error: %v", err))
+	}
+
+	if nextURL != "" {
+		s.stitchPages(ctx, req, response, nextURL)
+	}
+
+	// Never return a result the caller abandoned part way through
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result := s.formatChunk(response, req)
+	s.addSummary(ctx, req, response, result)
+	return result, nil
+}
+
+// fetchRaw returns req's response before processing: the cached raw body if
+// there is a fresh one and neither no_cache nor force_refresh is set, and
+// otherwise a fetch, revalidating an expired entry when it can. Fetched
+// bodies are cached as they came, so one fetch serves every format. The
+// response is a copy for the caller to process.
+func (s *URLFetcherMCPServer) fetchRaw(ctx context.Context, req *types.FetchRequest) (*types.FetchResponse, error) {
+	variant := cacheVariant(req)
+
+	if s.config.Offline || req.CacheOnly {
+		return s.fromCache(req, variant)
+	}
+
+	// force_refresh replaces the entry, holding off lookups of it meanwhile
+	if req.ForceRefresh {
+		response, err := s.cache.Refresh(req.URL, req.Engine, variant, func() (*types.FetchResponse, error) {
+			return s.fetcher.Fetch(ctx, req)
+		})
+		if err != nil || response.Skipped {
+			return response, err
+		}
+		return forRequest(response, req), nil
+	}
+
+	// With no_cache the cached copy is neither used nor revalidated
+	if !req.NoCache {
+		cached, found := s.cache.Get(req.URL, req.Engine, variant)
+		s.fetcher.RecordCacheLookup(req.URL, found)
+		if found {
+			return forRequest(cached, req), nil
+		}
+
+		// A dead URL fails again without a fetch while its failure is cached
+		if failure, ok := s.cache.Failure(req.URL, req.Engine, variant); ok {
+			return cachedFailure(failure, req)
+		}
+
+		// An expired entry with validators is revalidated rather than refetched
+		if stale, ok := s.cache.Stale(req.URL, req.Engine, variant); ok {
+			req.Revalidate = stale
+		}
+	}
+
+	response, err := s.fetcher.Fetch(ctx, req)
+	if err != nil {
+		if stale := s.staleFallback(req, err); stale != nil {
+			return stale, nil
+		}
+		if fetcher.IsDeadURL(err) {
+			s.cache.SetNegative(req.URL, req.Engine, variant, response, err)
+		}
+		return response, err
+	}
+	if response.Skipped {
+		return response, nil
+	}
+	return s.cacheRaw(req, response), nil
+}
+
+// errNotCached is returned for a cache-only request that finds nothing
+var errNotCached = errors.New("not in the cache")
+
+// fromCache answers req from the cache alone, in offline mode or for
+// cache_only: a fresh entry, a cached failure, or else an expired copy still
+// kept (marked stale). Nothing cached is errNotCached.
+func (s *URLFetcherMCPServer) fromCache(req *types.FetchRequest, variant string) (*types.FetchResponse, error) {
+	if req.NoCache || req.ForceRefresh {
+		return nil, fmt.Errorf("no_cache and force_refresh need a fetch, which cache-only requests don't make")
+	}
+
+	cached, found := s.cache.Get(req.URL, req.Engine, variant)
+	s.fetcher.RecordCacheLookup(req.URL, found)
+	if found {
+		return forRequest(cached, req), nil
+	}
+	if failure, ok := s.cache.Failure(req.URL, req.Engine, variant); ok {
+		return cachedFailure(failure, req)
+	}
+	if expired, ok := s.cache.Fallback(req.URL, req.Engine, variant); ok {
+		response := forRequest(expired, req)
+		response.Stale = true
+		response.Warnings = append(response.Warnings, fmt.Sprintf("Answering from the cache only; this copy, fetched at %s, has expired",
+			response.FetchedAt.UTC().Format(time.RFC3339)))
+		return response, nil
+	}
+	return nil, fmt.Errorf("%w: %s", errNotCached, req.URL)
+}
+
+// cachedFailure reproduces a negatively cached fetch failure: the response
+// the fetch returned, with the error it caused
+func cachedFailure(failure *types.FetchResponse, req *types.FetchRequest) (*types.FetchResponse, error) {
+	err := fmt.Errorf("%s (cached failure from %s)", failure.Error, failure.FetchedAt.UTC().Format(time.RFC3339))
+	response := forRequest(failure, req)
+	response.Error = ""
+	response.Warnings = append(response.Warnings, "Cached failure: "+err.Error())
+	return response, err
+}
+
+// staleFallback returns a copy of req's cached entry to serve in place of a
+// fetch that failed with err, or nil if serve_stale is off, the origin
+// didn't fail or nothing is cached
+func (s *URLFetcherMCPServer) staleFallback(req *types.FetchRequest, err error) *types.FetchResponse {
+	serveStale := s.config.ServeStale
+	if req.ServeStale != nil {
+		serveStale = *req.ServeStale
+	}
+	if !serveStale || !fetcher.IsUpstreamError(err) {
+		return nil
+	}
+	cached, ok := s.cache.Fallback(req.URL, req.Engine, cacheVariant(req))
+	if !ok {
+		return nil
+	}
+
+	response := forRequest(cached, req)
+	response.Stale = true
+	response.Warnings = append(response.Warnings, fmt.Sprintf("Fetch failed (%v); serving the cached copy fetched at %s",
+		err, response.FetchedAt.UTC().Format(time.RFC3339)))
+	return response
+}
+
+// cacheRaw caches a fetched response's raw body and returns a copy of it to
+// process, leaving the entry untouched. A 304 is cached as the stale copy it
+// confirmed, for a fresh TTL.
+func (s *URLFetcherMCPServer) cacheRaw(req *types.FetchRequest, response *types.FetchResponse) *types.FetchResponse {
+	s.cache.Set(req.URL, req.Engine, cacheVariant(req), response)
+	return forRequest(response, req)
+}
+
+// forRequest copies a raw response for processing into req's format
+func forRequest(raw *types.FetchResponse, req *types.FetchRequest) *types.FetchResponse {
+	response := *raw
+	response.Format = req.Format
+	response.Warnings = slices.Clone(raw.Warnings)
+	return &response
+}
+
+// formatChunk formats the response, slicing the content to the requested
+// offset/chunk_size window. The full content stays cached so later chunks
+// are served without refetching.
+func (s *URLFetcherMCPServer) formatChunk(resp *types.FetchResponse, req *types.FetchRequest) map[string]interface{} {
+	result := s.formatResponse(resp)
+	if req.Provenance {
+		result["provenance"] = s.provenanceRecord(req, resp)
+	}
+	if req.Offset == 0 && req.ChunkSize == 0 {
+		s.applyResponseBudget(resp, result)
+		return result
+	}
+
+	content := []rune(resp.Content)
+	total := len(content)
+
+	start := req.Offset
+	if start > total {
+		start = total
+	}
+	end := total
+	if req.ChunkSize > 0 && start+req.ChunkSize < total {
+		end = start + req.ChunkSize
+	}
+
+	result["content"] = string(content[start:end])
+	result["offset"] = start
+	result["total_length"] = total
+	result["has_more"] = end < total
+	if end < total {
+		result["next_offset"] = end
+	}
+
+	return result
+}
+
+// applyResponseBudget keeps content within the response budget. Markdown is
+// replaced with its outline; other formats, or an outline that is still too
+// long, are truncated at a paragraph boundary. The full content stays
+// cached, so the warning points the caller at offset/chunk_size to read it.
+func (s *URLFetcherMCPServer) applyResponseBudget(resp *types.FetchResponse, result map[string]interface{}) {
+	budget := s.config.ResponseBudget
+	total := utf8.RuneCountInString(resp.Content)
+	if budget <= 0 || total <= budget {
+		return
+	}
+
+	content := resp.Content
+	var warning string
+	if resp.Format == types.FormatMarkdown {
+		content = processor.Outline(content, 0)
+		result["format"] = types.FormatOutline
+		result["downgraded_from"] = types.FormatMarkdown
+		warning = fmt.Sprintf("markdown content is %d characters, over the response budget of %d; returned an outline instead", total, budget)
+	} else {
+		warning = fmt.Sprintf("content is %d characters, over the response budget of %d; returned the beginning", total, budget)
+	}
+	if utf8.RuneCountInString(content) > budget {
+		content = processor.Truncate(content, budget)
+		result["truncated"] = true
+	}
+
+	result["content"] = content
+	result["total_length"] = total
+	warnings := append([]string{}, resp.Warnings...)
+	result["warnings"] = append(warnings, warning+". Request it again with chunk_size (and offset) to read the full content from cache")
+}
+
+// stitchPages follows next-page links and appends each page's processed
+// content to response, up to req.FollowPagination additional pages
+func (s *URLFetcherMCPServer) stitchPages(ctx context.Context, req *types.FetchRequest, response *types.FetchResponse, nextURL string) {
+	visited := map[string]bool{req.URL: true}
+	response.Pages = []string{req.URL}
+
+	for len(response.Pages) <= req.FollowPagination && nextURL != "" && !visited[nextURL] {
+		visited[nextURL] = true
+
+		pageReq := *req
+		pageReq.URL = nextURL
+		pageReq.Revalidate = nil
+		page, err := s.fetchRaw(ctx, &pageReq)
+		if err != nil {
+			response.Warnings = append(response.Warnings,
+				fmt.Sprintf("Stopped pagination at %s: %v", nextURL, err))
+			return
+		}
+
+		following := s.processor.FindNextPage(page.Content, nextURL)
+		if err := s.process(ctx, req, page); err != nil {
+			response.Warnings = append(response.Warnings,
+				fmt.Sprintf("Content processing error on %s: %v", nextURL, err))
+		}
+
+		response.Content += "\n\n" + page.Content
+		if response.Article != nil && page.Article != nil {
+			response.Article.Text = response.Content
+			response.Article.WordCount += page.Article.WordCount
+		}
+		response.Pages = append(response.Pages, nextURL)
+		nextURL = following
+	}
+
+	if nextURL != "" && !visited[nextURL] {
+		response.Warnings = append(response.Warnings,
+			fmt.Sprintf("Pagination limit of %d pages reached; more pages may follow", req.FollowPagination))
+	}
+}
+
+// process converts fetched content to the requested format, narrowing it to
+// the request's XPath selection if one was given
+func (s *URLFetcherMCPServer) process(ctx context.Context, req *types.FetchRequest, response *types.FetchResponse) error {
+	if req.XPath != "" {
+		return s.processor.ProcessXPath(ctx, response, req.XPath)
+	}
+	return s.processor.Process(ctx, response)
+}
+
+// cacheVariant returns the cache key component describing how the raw body
+// was fetched, so options that change what the server sends don't share
+// entries. Format, XPath and pagination are applied to the raw body when it
+// is read, so they aren't part of it.
+func cacheVariant(req *types.FetchRequest) string {
+	variant := "raw"
+	if vary := varyHash(req); vary != "" {
+		variant += "+vary=" + vary
+	}
+	if req.MaxRedirects != nil {
+		variant += fmt.Sprintf("+redirects=%d", *req.MaxRedirects)
+	}
+	if req.Range != nil {
+		variant += fmt.Sprintf("+range=%d-%d", req.Range.Start, req.Range.End)
+	}
+	if req.WaitMs > 0 && req.Engine == types.EngineChrome {
+		variant += fmt.Sprintf("+wait=%d", req.WaitMs)
+	}
+	if req.Scroll > 0 && req.Engine == types.EngineChrome {
+		variant += fmt.Sprintf("+scroll=%d", req.Scroll)
+	}
+	if req.CaptureNetwork && req.Engine == types.EngineChrome {
+		variant += "+network"
+	}
+	if req.ReturnCookies && req.Engine == types.EngineChrome {
+		variant += "+cookies"
+	}
+	if req.Viewport != nil && req.Engine == types.EngineChrome {
+		v := req.Viewport
+		variant += fmt.Sprintf("+viewport=%dx%d@%g", v.Width, v.Height, v.DeviceScaleFactor)
+		if v.Mobile {
+			variant += "+mobile"
+		}
+	}
+	if req.Locale != "" {
+		variant += "+locale=" + strings.ToLower(req.Locale)
+	}
+	if req.Timezone != "" && req.Engine == types.EngineChrome {
+		variant += "+tz=" + req.Timezone
+	}
+	if g := req.Geolocation; g != nil && req.Engine == types.EngineChrome {
+		variant += fmt.Sprintf("+geo=%g,%g~%g", g.Latitude, g.Longitude, g.Accuracy)
+	}
+	if len(req.Actions) > 0 && req.Engine == types.EngineChrome {
+		// Text typed may be a search or a password, so it's hashed
+		data, _ := json.Marshal(req.Actions)
+		sum := sha256.Sum256(data)
+		variant += "+actions=" + hex.EncodeToString(sum[:])[:16]
+	}
+	return variant
+}
+
+// varyHash hashes what identifies the caller to the site: cookies,
+// credentials, custom headers, the signing secret, the session and a
+// non-default user agent. Responses that may be personalized by them are
+// then only served back to requests presenting the same, and the values
+// never appear in cache keys. It returns "" for a request with none of them.
+func varyHash(req *types.FetchRequest) string {
+	userAgent := req.UserAgent
+	if userAgent == types.DefaultUserAgent {
+		userAgent = ""
+	}
+	if len(req.Cookies) == 0 && req.Auth == nil && len(req.Headers) == 0 && req.Sign == nil && req.Session == "" && userAgent == "" {
+		return ""
+	}
+
+	// Cookie order doesn't change what the site sees
+	cookies := slices.Clone(req.Cookies)
+	slices.SortFunc(cookies, func(a, b types.Cookie) int {
+		if a.Name != b.Name {
+			return strings.Compare(a.Name, b.Name)
+		}
+		return strings.Compare(a.Value, b.Value)
+	})
+
+	h := sha256.New()
+	for _, c := range cookies {
+		fmt.Fprintf(h, "cookie:%q=%q@%q%q;", c.Name, c.Value, c.Domain, c.Path)
+	}
+	if req.Auth != nil {
+		fmt.Fprintf(h, "auth:%q:%q:%q:%q;", req.Auth.Type, req.Auth.Username, req.Auth.Password, req.Auth.Token)
+	}
+	// Header names are case-insensitive, so they're sorted once lowercased
+	headers := make([]string, 0, len(req.Headers))
+	for name, value := range req.Headers {
+		headers = append(headers, fmt.Sprintf("header:%q=%q;", strings.ToLower(name), value))
+	}
+	sort.Strings(headers)
+	for _, header := range headers {
+		h.Write([]byte(header))
+	}
+	if req.Sign != nil {
+		fmt.Fprintf(h, "sign:%q;", req.Sign.Secret)
+	}
+	fmt.Fprintf(h, "session:%q;ua:%q;", req.Session, userAgent)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// parseRange reads the range_start and range_end parameters into a byte
+// range, or nil if neither is given
+func parseRange(params map[string]interface{}) (*types.ByteRange, error) {
+	start, hasStart := params["range_start"].(float64)
+	end, hasEnd := params["range_end"].(float64)
+	if !hasStart && !hasEnd {
+		return nil, nil
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("range_start must be non-negative")
+	}
+	if !hasEnd {
+		return &types.ByteRange{Start: int64(start), End: -1}, nil
+	}
+	if end < start {
+		return nil, fmt.Errorf("range_end must not be less than range_start")
+	}
+	return &types.ByteRange{Start: int64(start), End: int64(end)}, nil
+}
+
+// parseSign reads the sign parameter into a signing spec
+func parseSign(raw interface{}) (*types.SignSpec, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sign: %w", err)
+	}
+	spec := &types.SignSpec{}
+	if err := json.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("invalid sign: %w", err)
+	}
+	if spec.Secret == "" {
+		return nil, fmt.Errorf("sign requires secret")
+	}
+	return spec, nil
+}
+
+// parseActions reads the actions parameter into validated steps
+func parseActions(raw interface{}) ([]types.Action, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	if _, ok := raw.([]interface{}); !ok {
+		return nil, fmt.Errorf("actions must be an array")
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	var actions []types.Action
+	if err := decoder.Decode(&actions); err != nil {
+		return nil, fmt.Errorf("invalid actions: %w", err)
+	}
+	if len(actions) > types.MaxActions {
+		return nil, fmt.Errorf("at most %d actions are allowed", types.MaxActions)
+	}
+	for i, action := range actions {
+		if err := action.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid action %d: %w", i+1, err)
+		}
+	}
+	return actions, nil
+}
+
+// parseViewport reads the viewport parameter
+func parseViewport(raw interface{}) (*types.Viewport, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	if _, ok := raw.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("viewport must be an object")
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	viewport := &types.Viewport{}
+	if err := decoder.Decode(viewport); err != nil {
+		return nil, fmt.Errorf("invalid viewport: %w", err)
+	}
+	if err := viewport.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid viewport: %w", err)
+	}
+	return viewport, nil
+}
+
+// parseGeolocation reads the geolocation parameter
+func parseGeolocation(raw interface{}) (*types.Geolocation, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	if _, ok := raw.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("geolocation must be an object")
+	}
+
+	data, err := json.Marshal(raw)
 	if err != nil {
 		return nil, err
 	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	geolocation := &types.Geolocation{}
+	if err := decoder.Decode(geolocation); err != nil {
+		return nil, fmt.Errorf("invalid geolocation: %w", err)
+	}
+	if err := geolocation.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid geolocation: %w", err)
+	}
+	return geolocation, nil
+}
 
-	return &protocol.ListToolsResponse{
-		Tools: []protocol.Tool{
-			{
-				Name:        "fetch_url",
-				Description: "Fetch content from a URL. By default returns cleaned plain text (no HTML tags). Set format='html' to get raw HTML for parsing. Use engine='chrome' for JavaScript-heavy sites that need browser rendering.",
-				InputSchema: json.RawMessage(schemaBytes),
-			},
-		},
-	}, nil
+// deviceNames returns the device presets' names in sorted order
+func deviceNames() []string {
+	names := make([]string, 0, len(types.DevicePresets))
+	for name := range types.DevicePresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
-// CallTool executes a tool
-func (s *URLFetcherMCPServer) CallTool(ctx context.Context, req *protocol.CallToolRequest) (*protocol.CallToolResponse, error) {
-	switch req.Name {
-	case "fetch_url":
-		result, err := s.fetchURL(req.Arguments)
-		if err != nil {
-			return &protocol.CallToolResponse{
-				Content: []protocol.ToolContent{
-					{
-						Type: "text",
-						Text: fmt.Sprintf("Error: %v", err),
-					},
-				},
-				IsError: true,
-			}, nil
+// parseRetry reads the retry parameter into a policy override
+func parseRetry(raw interface{}) (*types.RetryPolicy, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	if _, ok := raw.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("retry must be an object")
+	}
+
+	// Round-trip through JSON to reuse the policy's field names and types
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	policy := &types.RetryPolicy{}
+	if err := decoder.Decode(policy); err != nil {
+		return nil, fmt.Errorf("invalid retry: %w", err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid retry: %w", err)
+	}
+	return policy, nil
+}
+
+// parseHeaders reads the headers parameter: an object of header names to
+// string values
+func parseHeaders(raw interface{}) (map[string]string, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("headers must be an object")
+	}
+	headers := make(map[string]string, len(obj))
+	for name, value := range obj {
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("header %s must have a string value", name)
 		}
+		headers[name] = str
+	}
+	if err := types.ValidateHeaders(headers); err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
 
-		// Convert result to JSON string
-		jsonBytes, err := json.MarshalIndent(result, "", "  ")
-		if err != nil {
-			return &protocol.CallToolResponse{
-				Content: []protocol.ToolContent{
-					{
-						Type: "text",
-						Text: fmt.Sprintf("Error formatting response: %v", err),
-					},
-				},
-				IsError: true,
-			}, nil
+// parseAuth reads the auth parameter: {"type": "basic", "username", "password"}
+// or {"type": "bearer", "token"}
+func parseAuth(raw interface{}) (*types.Auth, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("auth must be an object")
+	}
+
+	auth := &types.Auth{}
+	auth.Type, _ = obj["type"].(string)
+	auth.Username, _ = obj["username"].(string)
+	auth.Password, _ = obj["password"].(string)
+	auth.Token, _ = obj["token"].(string)
+	auth.Type = strings.ToLower(auth.Type)
+
+	switch auth.Type {
+	case types.AuthBasic:
+		if auth.Username == "" {
+			return nil, fmt.Errorf("basic auth requires username")
 		}
+	case types.AuthBearer:
+		if auth.Token == "" {
+			return nil, fmt.Errorf("bearer auth requires token")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported auth type: %s", auth.Type)
+	}
 
-		return &protocol.CallToolResponse{
-			Content: []protocol.ToolContent{
-				{
-					Type: "text",
-					Text: string(jsonBytes),
-				},
-			},
-		}, nil
+	return auth, nil
+}
+
+// parseCookies accepts cookies as an object of name/value pairs or as an
+// array of {name, value} objects, returning them sorted by name
+func parseCookies(raw interface{}) ([]types.Cookie, error) {
+	var cookies []types.Cookie
 
+	switch v := raw.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		for name, value := range v {
+			str, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("cookie %s must have a string value", name)
+			}
+			cookies = append(cookies, types.Cookie{Name: name, Value: str})
+		}
+	case []interface{}:
+		for _, item := range v {
+			obj, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("cookies array entries must be objects with name and value")
+			}
+			name, _ := obj["name"].(string)
+			value, _ := obj["value"].(string)
+			if name == "" {
+				return nil, fmt.Errorf("cookie name is required")
+			}
+			domain, _ := obj["domain"].(string)
+			path, _ := obj["path"].(string)
+			cookies = append(cookies, types.Cookie{Name: name, Value: value, Domain: domain, Path: path})
+		}
 	default:
-		return &protocol.CallToolResponse{
-			Content: []protocol.ToolContent{
-				{
-					Type: "text",
-					Text: fmt.Sprintf("Unknown tool: %s", req.Name),
-				},
-			},
-			IsError: true,
-		}, nil
+		return nil, fmt.Errorf("cookies must be an object or an array")
 	}
+
+	sort.Slice(cookies, func(i, j int) bool { return cookies[i].Name < cookies[j].Name })
+	return cookies, nil
 }
 
-// fetchURL handles the fetch_url tool
-func (s *URLFetcherMCPServer) fetchURL(params map[string]interface{}) (interface{}, error) {
-	// Parse request
+// hasChanged handles the has_changed tool
+func (s *URLFetcherMCPServer) hasChanged(ctx context.Context, params map[string]interface{}) (interface{}, error) {
 	req := &types.FetchRequest{}
 
-	// URL (required)
 	url, ok := params["url"].(string)
 	if !ok || url == "" {
 		return nil, fmt.Errorf("url is required")
 	}
 	req.URL = url
 
-	// Engine (optional)
 	if engine, ok := params["engine"].(string); ok {
 		req.Engine = engine
 	}
-
-	// Format (optional)
 	if format, ok := params["format"].(string); ok {
 		req.Format = format
 	}
 
-	// Max content length (optional)
-	if maxLen, ok := params["max_content_length"].(float64); ok {
-		req.MaxContentLength = int(maxLen)
+	s.fetcher.ApplyDefaults(req)
+	variant := cacheVariant(req)
+
+	// With validators, the cached copy is revalidated instead of refetched.
+	// Its hash is that of its content in the requested format.
+	previousHash := ""
+	cached, found := s.cache.Get(req.URL, req.Engine, variant)
+	if !found {
+		cached, found = s.cache.Stale(req.URL, req.Engine, variant)
+	}
+	if found {
+		previous := forRequest(cached, req)
+		if err := s.processor.Process(ctx, previous); err == nil {
+			previousHash = previous.ContentHash
+		}
+		req.Revalidate = cached
 	}
 
-	// Apply defaults
-	if req.Engine == "" {
-		req.Engine = types.DefaultEngine
+	response, err := s.fetcher.Fetch(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	response = s.cacheRaw(req, response)
+	if err := s.processor.Process(ctx, response); err != nil {
+		return nil, fmt.Errorf("content processing error: %w", err)
+	}
+
+	result := map[string]interface{}{
+		"url":          req.URL,
+		"current_hash": response.ContentHash,
+		"status_code":  response.StatusCode,
+	}
+	if response.NotModified {
+		result["not_modified"] = true
 	}
-	if req.Format == "" {
-		req.Format = types.DefaultFormat
+	if previousHash == "" {
+		// Nothing to compare against; this fetch becomes the baseline
+		result["changed"] = nil
+		result["baseline"] = true
+	} else {
+		result["changed"] = previousHash != response.ContentHash
+		result["previous_hash"] = previousHash
 	}
-	if req.MaxContentLength == 0 {
-		req.MaxContentLength = types.DefaultMaxContentLength
+
+	return result, nil
+}
+
+// domainStats handles the domain_stats tool
+func (s *URLFetcherMCPServer) domainStats(params map[string]interface{}) (interface{}, error) {
+	stats := s.fetcher.DomainStats()
+
+	if domain, ok := params["domain"].(string); ok && domain != "" {
+		domain = strings.ToLower(domain)
+		filtered := stats[:0]
+		for _, d := range stats {
+			if d.Domain == domain {
+				filtered = append(filtered, d)
+			}
+		}
+		stats = filtered
+	}
+
+	sortBy, _ := params["sort_by"].(string)
+	switch sortBy {
+	case "", "fetches":
+		// Already sorted by fetch count
+	case "errors":
+		sort.SliceStable(stats, func(i, j int) bool { return stats[i].Errors > stats[j].Errors })
+	case "error_rate":
+		sort.SliceStable(stats, func(i, j int) bool { return stats[i].ErrorRate > stats[j].ErrorRate })
+	case "latency":
+		sort.SliceStable(stats, func(i, j int) bool { return stats[i].AvgLatencyMs > stats[j].AvgLatencyMs })
+	default:
+		return nil, fmt.Errorf("unsupported sort_by: %s", sortBy)
+	}
+
+	if limit, ok := params["limit"].(float64); ok && limit > 0 && int(limit) < len(stats) {
+		stats = stats[:int(limit)]
+	}
+
+	return map[string]interface{}{
+		"domains": stats,
+	}, nil
+}
+
+// suggestSelectors handles the suggest_selectors tool
+func (s *URLFetcherMCPServer) suggestSelectors(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+	req := &types.FetchRequest{}
+
+	url, ok := params["url"].(string)
+	if !ok || url == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	req.URL = url
+	if engine, ok := params["engine"].(string); ok {
+		req.Engine = engine
 	}
+	s.fetcher.ApplyDefaults(req)
 
-	// Check cache
-	if cached, found := s.cache.Get(req.URL, req.Engine, req.Format); found {
-		return s.formatResponse(cached), nil
+	// The engines return raw HTML; analyze it before any processing
+	response, err := s.fetcher.Fetch(ctx, req)
+	if err != nil {
+		return nil, err
 	}
 
-	// Fetch content
-	response, err := s.fetcher.Fetch(req)
+	suggestions, err := s.processor.SuggestSelectors(response.Content)
 	if err != nil {
-		// Return formatted error response
-		if response != nil {
-			return s.formatResponse(response), nil
+		return nil, err
+	}
+
+	// Pre-fill a profile with the top candidates for convenience
+	profile := processor.Profile{Remove: suggestions.Remove}
+	if host := hostname(req.URL); host != "" {
+		profile.Domains = []string{host}
+	}
+	if len(suggestions.Body) > 0 {
+		profile.Body = suggestions.Body[0].Selector
+	}
+	if len(suggestions.Title) > 0 {
+		profile.Title = suggestions.Title[0].Selector
+	}
+	if len(suggestions.Date) > 0 {
+		profile.Date = suggestions.Date[0].Selector
+	}
+	if len(suggestions.Author) > 0 {
+		profile.Author = suggestions.Author[0].Selector
+	}
+
+	return map[string]interface{}{
+		"url":               req.URL,
+		"suggestions":       suggestions,
+		"suggested_profile": profile,
+	}, nil
+}
+
+// hostname returns the lowercased host of a URL, or "" if it can't be parsed
+func hostname(rawURL string) string {
+	parsed, err := neturl.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsed.Hostname())
+}
+
+// cacheStats handles the cache_stats tool
+func (s *URLFetcherMCPServer) cacheStats(params map[string]interface{}) (interface{}, error) {
+	return s.cache.Stats(), nil
+}
+
+// clearCache handles the clear_cache tool
+func (s *URLFetcherMCPServer) clearCache(params map[string]interface{}) (interface{}, error) {
+	removed := s.cache.Clear()
+	return map[string]interface{}{
+		"removed": removed,
+	}, nil
+}
+
+// invalidateURL handles the invalidate_url tool
+func (s *URLFetcherMCPServer) invalidateURL(params map[string]interface{}) (interface{}, error) {
+	url, _ := params["url"].(string)
+	pattern, _ := params["pattern"].(string)
+
+	if url == "" && pattern == "" {
+		return nil, fmt.Errorf("either url or pattern is required")
+	}
+
+	removed := 0
+	if url != "" {
+		removed += s.cache.DeleteURL(url)
+	}
+	if pattern != "" {
+		n, err := s.cache.DeletePattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
 		}
-		return s.formatErrorResponse(req.URL, err.Error()), nil
+		removed += n
 	}
 
-	// Process content
-	if err := s.processor.Process(response); err != nil {
-		// Add warning but don't fail
-		response.Warnings = append(response.Warnings, fmt.Sprintf("Content processing error: %v", err))
+	return map[string]interface{}{
+		"removed": removed,
+	}, nil
+}
+
+// listSessions handles the list_sessions tool
+func (s *URLFetcherMCPServer) listSessions(params map[string]interface{}) (interface{}, error) {
+	loginFlows := []string{}
+	for _, flow := range s.config.LoginFlows {
+		loginFlows = append(loginFlows, flow.Session)
+	}
+	return map[string]interface{}{
+		"sessions":    s.fetcher.Sessions().List(),
+		"persistent":  s.config.SessionsDir != "",
+		"login_flows": loginFlows,
+	}, nil
+}
+
+// importCookies handles the import_cookies tool
+func (s *URLFetcherMCPServer) importCookies(params map[string]interface{}) (interface{}, error) {
+	name, ok := params["session"].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("session is required")
+	}
+	content, ok := params["content"].(string)
+	if !ok || content == "" {
+		return nil, fmt.Errorf("content is required")
+	}
+
+	imported, err := s.fetcher.Sessions().Import(name, []byte(content))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"session":  name,
+		"imported": imported,
+	}, nil
+}
+
+// deleteSession handles the delete_session tool
+func (s *URLFetcherMCPServer) deleteSession(params map[string]interface{}) (interface{}, error) {
+	name, ok := params["name"].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	deleted, err := s.fetcher.Sessions().Delete(name)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"deleted": deleted,
+	}, nil
+}
+
+// login handles the login tool
+func (s *URLFetcherMCPServer) login(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+	name, ok := params["name"].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	cookies, err := s.fetcher.Login(ctx, name)
+	if err != nil {
+		return nil, err
 	}
+	return map[string]interface{}{
+		"session": name,
+		"cookies": cookies,
+	}, nil
+}
+
+// capabilities handles the capabilities tool
+func (s *URLFetcherMCPServer) capabilities(params map[string]interface{}) (interface{}, error) {
+	return map[string]interface{}{
+		"version":             Version,
+		"status":              s.readiness(),
+		"engines":             []string{types.EngineHTTP, types.EngineHTTP3, types.EngineChrome},
+		"formats":             []string{types.FormatText, types.FormatHTML, types.FormatMarkdown, types.FormatArticle},
+		"chrome_available":    s.fetcher.ChromeAvailable(),
+		"block_local":         s.config.BlockLocal,
+		"cache_enabled":       s.config.CacheTTL > 0,
+		"proxy_configured":    len(s.config.Proxies) > 0,
+		"signed_provenance":   s.config.ProvenanceKey != "",
+		"compliance_mode":     len(s.config.Blocklists) > 0,
+		"external_summarizer": s.config.SummarizerURL != "",
+		"persistent_cache":    s.config.CacheDir != "" || s.config.CacheRedisURL != "",
+		"shared_cache":        s.config.CacheRedisURL != "",
+		"sessions":            true,
+		"screenshots":         false,
+		"robots_mode":         false,
+		"offline_mode":        s.config.Offline,
+	}, nil
+}
 
-	// Cache successful responses
-	s.cache.Set(req.URL, req.Engine, req.Format, response)
+// serverStats handles the server_stats tool
+func (s *URLFetcherMCPServer) serverStats(params map[string]interface{}) (interface{}, error) {
+	stats := map[string]interface{}{
+		"version":     Version,
+		"status":      s.readiness(),
+		"fetches":     s.fetcher.Metrics(),
+		"chrome_pool": s.fetcher.ChromePoolStats(),
+		"scheduler":   s.fetcher.SchedulerStats(),
+		"cache":       s.cache.Stats(),
+	}
+	if proxies := s.fetcher.ProxyStats(); proxies != nil {
+		stats["proxies"] = proxies
+	}
+	if blocklist := s.fetcher.BlocklistStatus(); blocklist != nil {
+		stats["blocklist"] = blocklist
+	}
+	return stats, nil
+}
 
-	return s.formatResponse(response), nil
+// readiness returns "ready" once warm-up has completed, "warming" before that
+func (s *URLFetcherMCPServer) readiness() string {
+	if s.fetcher.Ready() {
+		return "ready"
+	}
+	return "warming"
 }
 
 // formatResponse formats the response for MCP
@@ -228,14 +2084,109 @@ func (s *URLFetcherMCPServer) formatResponse(resp *types.FetchResponse) map[stri
 		"chrome_available": resp.ChromeAvailable,
 	}
 
+	if resp.FinalURL != "" {
+		result["final_url"] = resp.FinalURL
+	}
+
+	if len(resp.Redirects) > 0 {
+		result["redirects"] = resp.Redirects
+	}
+
+	if resp.Timing != nil {
+		result["timing"] = resp.Timing
+	}
+	if len(resp.Console) > 0 {
+		result["console"] = resp.Console
+	}
+	if len(resp.Network) > 0 {
+		result["network"] = resp.Network
+	}
+	if resp.Cookies != nil {
+		result["cookies"] = resp.Cookies
+	}
+
+	if resp.Attempts > 1 {
+		result["attempts"] = resp.Attempts
+	}
+
+	if resp.BytesReceived > 0 {
+		result["bytes_received"] = resp.BytesReceived
+	}
+
+	if resp.RenderMs > 0 {
+		result["render_ms"] = resp.RenderMs
+	}
+
+	if resp.Location != "" {
+		result["location"] = resp.Location
+	}
+
+	if resp.UserAgentProfile != "" {
+		result["user_agent_profile"] = resp.UserAgentProfile
+	}
+
+	if resp.UserAgent != "" {
+		result["user_agent"] = resp.UserAgent
+	}
+
 	if resp.Title != "" {
 		result["title"] = resp.Title
 	}
 
+	if resp.Language != "" {
+		result["language"] = resp.Language
+	}
+
+	if resp.ContentHash != "" {
+		result["content_hash"] = resp.ContentHash
+	}
+
+	if resp.Charset != "" {
+		result["charset"] = resp.Charset
+	}
+
+	if resp.Protocol != "" {
+		result["protocol"] = resp.Protocol
+	}
+
+	if resp.NotModified {
+		result["not_modified"] = true
+	}
+
+	if resp.Stale {
+		result["stale"] = true
+	}
+
+	if resp.BlockedBy != "" {
+		result["blocked_by"] = resp.BlockedBy
+	}
+
+	if resp.Skipped {
+		result["skipped"] = true
+		result["content_length"] = resp.ContentLength
+	}
+
+	if resp.ContentRange != "" {
+		result["content_range"] = resp.ContentRange
+	}
+
+	if resp.File != "" {
+		result["file"] = resp.File
+		result["content_length"] = resp.ContentLength
+	}
+
+	if resp.Article != nil {
+		result["article"] = resp.Article
+	}
+
 	if len(resp.Warnings) > 0 {
 		result["warnings"] = resp.Warnings
 	}
 
+	if len(resp.Pages) > 0 {
+		result["pages"] = resp.Pages
+	}
+
 	return result
 }
 
@@ -250,6 +2201,9 @@ func (s *URLFetcherMCPServer) formatErrorResponse(url, error string) map[string]
 
 // Close shuts down the server
 func (s *URLFetcherMCPServer) Close() {
+	if s.jobs != nil {
+		s.jobs.CancelAll()
+	}
 	if s.fetcher != nil {
 		s.fetcher.Close()
 	}
@@ -295,24 +2249,24 @@ func runTestMode() {
 		{
 			name: "Wikipedia article - Rich content",
 			params: map[string]interface{}{
-				"url":    "https://en.wikipedia.org/wiki/Go_(programming_language)",
-				"format": "markdown",
+				"url":                "https://en.wikipedia.org/wiki/Go_(programming_language)",
+				"format":             "markdown",
 				"max_content_length": 5000,
 			},
 		},
 		{
 			name: "GitHub repository - Code platform",
 			params: map[string]interface{}{
-				"url":    "https://github.com/golang/go",
-				"format": "text",
+				"url":                "https://github.com/golang/go",
+				"format":             "text",
 				"max_content_length": 3000,
 			},
 		},
 		{
 			name: "Hacker News - News aggregator",
 			params: map[string]interface{}{
-				"url":    "https://news.ycombinator.com",
-				"format": "text",
+				"url":                "https://news.ycombinator.com",
+				"format":             "text",
 				"max_content_length": 2000,
 			},
 		},
@@ -346,7 +2300,7 @@ func runTestMode() {
 		fmt.Printf("\nTest: %s\n", tc.name)
 		fmt.Println("-------------------")
 
-		result, err := server.fetchURL(tc.params)
+		result, err := server.fetchURL(context.Background(), tc.params)
 		if err != nil {
 			fmt.Printf("Error: %v\n", err)
 			continue
@@ -363,7 +2317,7 @@ func main() {
 	testMode := flag.Bool("test", false, "Run in test mode")
 	versionFlag := flag.Bool("version", false, "Show version information")
 	flag.Parse()
-	
+
 	if *versionFlag {
 		fmt.Printf("URL Fetcher MCP Server\n")
 		fmt.Printf("Version: %s\n", Version)
@@ -383,6 +2337,10 @@ func main() {
 	}
 	defer urlServer.Close()
 
+	if urlServer.config.MetricsAddr != "" {
+		go urlServer.servePrometheus(urlServer.config.MetricsAddr)
+	}
+
 	// Create handler registry
 	registry := handler.NewHandlerRegistry()
 
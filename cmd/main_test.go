@@ -0,0 +1,636 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gomcpgo/mcp/pkg/protocol"
+	"github.com/gomcpgo/url_fetcher/pkg/cache"
+	"github.com/gomcpgo/url_fetcher/pkg/config"
+	"github.com/gomcpgo/url_fetcher/pkg/fetcher"
+	"github.com/gomcpgo/url_fetcher/pkg/metrics"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// newTestServer returns a server on the default configuration that may
+// fetch local test servers
+func newTestServer(t *testing.T) *URLFetcherMCPServer {
+	t.Setenv("FETCH_URL_BLOCK_LOCAL", "false")
+	s, err := NewURLFetcherMCPServer()
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// callTool calls a tool and returns the text of its result, and whether it
+// is an error
+func callTool(t *testing.T, s *URLFetcherMCPServer, name string, arguments map[string]interface{}) (string, bool) {
+	t.Helper()
+	resp, err := s.CallTool(context.Background(), &protocol.CallToolRequest{Name: name, Arguments: arguments})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	return resp.Content[0].Text, resp.IsError
+}
+
+// TestParseAuth tests reading the auth parameter
+func TestParseAuth(t *testing.T) {
+	auth, err := parseAuth(map[string]interface{}{"type": "Basic", "username": "alice", "password": "s3cret"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if auth.Type != types.AuthBasic || auth.Username != "alice" || auth.Password != "s3cret" {
+		t.Errorf("Unexpected basic auth %+v", auth)
+	}
+
+	auth, err = parseAuth(map[string]interface{}{"type": "bearer", "token": "abc123"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if auth.Type != types.AuthBearer || auth.Token != "abc123" {
+		t.Errorf("Unexpected bearer auth %+v", auth)
+	}
+
+	if auth, err := parseAuth(nil); auth != nil || err != nil {
+		t.Errorf("Expected no auth for a missing parameter, got %+v, %v", auth, err)
+	}
+
+	invalid := []interface{}{
+		"Basic YWxpY2U6czNjcmV0",
+		map[string]interface{}{"type": "basic", "password": "s3cret"},
+		map[string]interface{}{"type": "bearer"},
+		map[string]interface{}{"type": "digest", "username": "alice"},
+		map[string]interface{}{"username": "alice"},
+	}
+	for _, raw := range invalid {
+		if _, err := parseAuth(raw); err == nil {
+			t.Errorf("Expected an error for auth %v", raw)
+		}
+	}
+}
+
+// TestCacheKeyHidesCredentials tests that credentials only reach the cache
+// key hashed
+func TestCacheKeyHidesCredentials(t *testing.T) {
+	requests := []*types.FetchRequest{
+		{URL: "https://example.com/", Auth: &types.Auth{Type: types.AuthBasic, Username: "alice", Password: "s3cret"}},
+		{URL: "https://example.com/", Auth: &types.Auth{Type: types.AuthBearer, Token: "tok3n"}},
+	}
+	for _, req := range requests {
+		variant := cacheVariant(req)
+		for _, secret := range []string{"alice", "s3cret", "tok3n", "YWxpY2U6czNjcmV0"} {
+			if strings.Contains(variant, secret) {
+				t.Errorf("Cache variant %q contains %q", variant, secret)
+			}
+		}
+	}
+	if cacheVariant(requests[0]) == cacheVariant(requests[1]) {
+		t.Error("Expected different credentials to give different cache variants")
+	}
+	if cacheVariant(requests[0]) == cacheVariant(&types.FetchRequest{URL: "https://example.com/"}) {
+		t.Error("Expected an authenticated request not to share the anonymous cache variant")
+	}
+}
+
+// TestVaryHash tests that what identifies the caller keys the cache
+// separately, however it is written, and only ever hashed
+func TestVaryHash(t *testing.T) {
+	base := types.FetchRequest{URL: "https://example.com/"}
+	if vary := varyHash(&base); vary != "" {
+		t.Errorf("Expected no vary hash for an anonymous request, got %q", vary)
+	}
+	defaultUA := base
+	defaultUA.UserAgent = types.DefaultUserAgent
+	if vary := varyHash(&defaultUA); vary != "" {
+		t.Errorf("Expected no vary hash for the default user agent, got %q", vary)
+	}
+
+	variants := []struct {
+		name string
+		req  types.FetchRequest
+	}{
+		{"cookie", types.FetchRequest{Cookies: []types.Cookie{{Name: "sid", Value: "cookie-secret"}}}},
+		{"other cookie", types.FetchRequest{Cookies: []types.Cookie{{Name: "sid", Value: "other-secret"}}}},
+		{"basic", types.FetchRequest{Auth: &types.Auth{Type: types.AuthBasic, Username: "alice", Password: "pass-secret"}}},
+		{"bearer", types.FetchRequest{Auth: &types.Auth{Type: types.AuthBearer, Token: "token-secret"}}},
+		{"header", types.FetchRequest{Headers: map[string]string{"X-Api-Key": "header-secret"}}},
+		{"other header", types.FetchRequest{Headers: map[string]string{"X-Api-Key": "other-header"}}},
+		{"sign", types.FetchRequest{Sign: &types.SignSpec{Secret: "sign-secret"}}},
+		{"session", types.FetchRequest{Session: "work"}},
+		{"user agent", types.FetchRequest{UserAgent: "MyBot/1.0"}},
+	}
+	seen := make(map[string]string)
+	for _, v := range variants {
+		req := v.req
+		req.URL = base.URL
+		vary := varyHash(&req)
+		if vary == "" {
+			t.Errorf("Expected a vary hash for %s", v.name)
+			continue
+		}
+		if other, ok := seen[vary]; ok {
+			t.Errorf("Expected %s and %s to give different keys", v.name, other)
+		}
+		seen[vary] = v.name
+
+		variant := cacheVariant(&req)
+		for _, secret := range []string{"cookie-secret", "other-secret", "alice", "pass-secret", "token-secret", "header-secret", "sign-secret", "X-Api-Key", "work", "MyBot"} {
+			if strings.Contains(variant, secret) {
+				t.Errorf("Cache variant %q for %s contains %q", variant, v.name, secret)
+			}
+		}
+	}
+
+	// Cookie order and header name case don't change what the site sees
+	a, b := base, base
+	a.Cookies = []types.Cookie{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}}
+	b.Cookies = []types.Cookie{{Name: "b", Value: "2"}, {Name: "a", Value: "1"}}
+	if varyHash(&a) != varyHash(&b) {
+		t.Error("Expected cookie order not to change the key")
+	}
+	a, b = base, base
+	a.Headers = map[string]string{"X-Api-Key": "k", "Accept-Language": "de"}
+	b.Headers = map[string]string{"x-api-key": "k", "ACCEPT-LANGUAGE": "de"}
+	if varyHash(&a) != varyHash(&b) {
+		t.Error("Expected header name case not to change the key")
+	}
+	b.Headers = map[string]string{"B": "1", "a": "2"}
+	a.Headers = map[string]string{"b": "1", "A": "2"}
+	if varyHash(&a) != varyHash(&b) {
+		t.Error("Expected header name case not to change the key whatever it sorts as")
+	}
+}
+
+// TestFormatChunk tests slicing content to the offset/chunk_size window,
+// in characters, at the edges of the content
+func TestFormatChunk(t *testing.T) {
+	s := &URLFetcherMCPServer{config: &config.Config{}}
+	resp := &types.FetchResponse{URL: "https://example.com/", Content: "ünïcödé text"} // 12 characters
+
+	tests := []struct {
+		offset, chunkSize int
+		content           string
+		start             int
+		hasMore           bool
+		nextOffset        interface{}
+	}{
+		{0, 5, "ünïcö", 0, true, 5},
+		{5, 5, "dé te", 5, true, 10},
+		{10, 5, "xt", 10, false, nil},
+		{7, 5, " text", 7, false, nil},
+		{4, 0, "ödé text", 4, false, nil},
+		{12, 5, "", 12, false, nil},
+		{50, 5, "", 12, false, nil},
+	}
+	for _, tt := range tests {
+		result := s.formatChunk(resp, &types.FetchRequest{Offset: tt.offset, ChunkSize: tt.chunkSize})
+		if result["content"] != tt.content || result["offset"] != tt.start || result["has_more"] != tt.hasMore || result["next_offset"] != tt.nextOffset {
+			t.Errorf("Chunk at %d of %d: got content %q, offset %v, has_more %v, next_offset %v",
+				tt.offset, tt.chunkSize, result["content"], result["offset"], result["has_more"], result["next_offset"])
+		}
+		if result["total_length"] != 12 {
+			t.Errorf("Expected a total length of 12 characters, got %v", result["total_length"])
+		}
+	}
+
+	// Without a window the content is returned whole
+	result := s.formatChunk(resp, &types.FetchRequest{})
+	if result["content"] != resp.Content || result["offset"] != nil {
+		t.Errorf("Expected the whole content without chunk fields, got %v", result)
+	}
+}
+
+// TestHasChanged tests comparing a fresh fetch with the cached fingerprint
+func TestHasChanged(t *testing.T) {
+	body := "<html><body><p>First version</p></body></html>"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	s := newTestServer(t)
+	ctx := context.Background()
+	check := func() map[string]interface{} {
+		t.Helper()
+		result, err := s.hasChanged(ctx, map[string]interface{}{"url": server.URL})
+		if err != nil {
+			t.Fatalf("has_changed failed: %v", err)
+		}
+		return result.(map[string]interface{})
+	}
+
+	first := check()
+	if first["baseline"] != true || first["changed"] != nil || first["current_hash"] == "" {
+		t.Errorf("Expected the first check to set a baseline, got %v", first)
+	}
+
+	second := check()
+	if second["changed"] != false || second["previous_hash"] != first["current_hash"] || second["current_hash"] != first["current_hash"] {
+		t.Errorf("Expected an unchanged page, got %v", second)
+	}
+
+	body = "<html><body><p>Second version</p></body></html>"
+	third := check()
+	if third["changed"] != true || third["previous_hash"] != first["current_hash"] || third["current_hash"] == first["current_hash"] {
+		t.Errorf("Expected a changed page, got %v", third)
+	}
+
+	// Only the text counts, so markup changes alone aren't a change
+	body = "<html><body><div>Second   version</div></body></html>"
+	if fourth := check(); fourth["changed"] != false {
+		t.Errorf("Expected a markup-only change to be ignored, got %v", fourth)
+	}
+
+	if _, err := s.hasChanged(ctx, map[string]interface{}{}); err == nil {
+		t.Error("Expected an error without a url")
+	}
+}
+
+// TestDomainStats tests the per-domain counters and the domain_stats tool's
+// filtering, sorting and limit
+func TestDomainStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("<html><body>ok</body></html>"))
+	}))
+	defer server.Close()
+
+	s := newTestServer(t)
+	ctx := context.Background()
+	// 127.0.0.1 and localhost count as different domains
+	local := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	for _, u := range []string{server.URL + "/a", server.URL + "/b", server.URL + "/a", local + "/missing", local + "/a"} {
+		s.fetchURL(ctx, map[string]interface{}{"url": u})
+	}
+
+	stats := func(params map[string]interface{}) []metrics.DomainStats {
+		t.Helper()
+		result, err := s.domainStats(params)
+		if err != nil {
+			t.Fatalf("domain_stats failed: %v", err)
+		}
+		return result.(map[string]interface{})["domains"].([]metrics.DomainStats)
+	}
+
+	all := stats(map[string]interface{}{})
+	if len(all) != 2 || all[0].Domain != "127.0.0.1" || all[1].Domain != "localhost" {
+		t.Fatalf("Expected both domains by fetch count, got %+v", all)
+	}
+	if d := all[0]; d.Fetches != 2 || d.Errors != 0 || d.CacheHits != 1 || d.CacheMisses != 2 || d.Bytes == 0 {
+		t.Errorf("Unexpected counters for 127.0.0.1: %+v", d)
+	}
+	if d := all[1]; d.Fetches != 2 || d.Errors != 1 || d.ErrorRate != 0.5 {
+		t.Errorf("Unexpected counters for localhost: %+v", d)
+	}
+
+	if byErrors := stats(map[string]interface{}{"sort_by": "error_rate"}); byErrors[0].Domain != "localhost" {
+		t.Errorf("Expected localhost first by error rate, got %+v", byErrors)
+	}
+	if filtered := stats(map[string]interface{}{"domain": "LOCALHOST"}); len(filtered) != 1 || filtered[0].Domain != "localhost" {
+		t.Errorf("Expected only localhost, got %+v", filtered)
+	}
+	if limited := stats(map[string]interface{}{"limit": float64(1)}); len(limited) != 1 || limited[0].Domain != "127.0.0.1" {
+		t.Errorf("Expected only the busiest domain, got %+v", limited)
+	}
+	if _, err := s.domainStats(map[string]interface{}{"sort_by": "name"}); err == nil {
+		t.Error("Expected an unsupported sort_by to be rejected")
+	}
+}
+
+// TestPerRequestCookies tests reading the cookies parameter in both forms,
+// sending the cookies and keeping the responses apart in the cache
+func TestPerRequestCookies(t *testing.T) {
+	cookies, err := parseCookies(map[string]interface{}{"theme": "dark", "consent": "yes"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := []types.Cookie{{Name: "consent", Value: "yes"}, {Name: "theme", Value: "dark"}}; !reflect.DeepEqual(cookies, want) {
+		t.Errorf("Expected %v, got %v", want, cookies)
+	}
+
+	cookies, err = parseCookies([]interface{}{
+		map[string]interface{}{"name": "sid", "value": "abc", "domain": ".example.com", "path": "/app"},
+		map[string]interface{}{"name": "empty"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := []types.Cookie{{Name: "empty"}, {Name: "sid", Value: "abc", Domain: ".example.com", Path: "/app"}}; !reflect.DeepEqual(cookies, want) {
+		t.Errorf("Expected %v, got %v", want, cookies)
+	}
+
+	invalid := []interface{}{
+		"sid=abc",
+		map[string]interface{}{"sid": 1.0},
+		[]interface{}{"sid=abc"},
+		[]interface{}{map[string]interface{}{"value": "abc"}},
+	}
+	for _, raw := range invalid {
+		if _, err := parseCookies(raw); err == nil {
+			t.Errorf("Expected an error for cookies %v", raw)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := "anonymous"
+		if c, err := r.Cookie("sid"); err == nil {
+			user = c.Value
+		}
+		w.Write([]byte("<html><body><p>Hello " + user + "</p></body></html>"))
+	}))
+	defer server.Close()
+
+	s := newTestServer(t)
+	fetch := func(params map[string]interface{}) string {
+		t.Helper()
+		result, err := s.fetchURL(context.Background(), params)
+		if err != nil {
+			t.Fatalf("fetch_url failed: %v", err)
+		}
+		content, _ := result.(map[string]interface{})["content"].(string)
+		return content
+	}
+	if content := fetch(map[string]interface{}{"url": server.URL, "cookies": map[string]interface{}{"sid": "alice"}}); !strings.Contains(content, "Hello alice") {
+		t.Errorf("Expected the cookie to be sent, got %q", content)
+	}
+	if content := fetch(map[string]interface{}{"url": server.URL}); !strings.Contains(content, "Hello anonymous") {
+		t.Errorf("Expected the response for the cookie not to be served without it, got %q", content)
+	}
+	if _, err := s.fetchURL(context.Background(), map[string]interface{}{"url": server.URL, "cookies": "sid=alice"}); err == nil {
+		t.Error("Expected invalid cookies to be rejected")
+	}
+}
+
+// TestTransform tests reshaping a tool result with a JMESPath expression,
+// and how invalid and failing expressions are reported
+func TestTransform(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><head><title>Shop</title></head><body><p>ok</p></body></html>"))
+	}))
+	defer server.Close()
+
+	s := newTestServer(t)
+	text, isError := callTool(t, s, "fetch_url", map[string]interface{}{"url": server.URL, "transform": "{status: status_code, url: url}"})
+	if isError {
+		t.Fatalf("Unexpected error: %s", text)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &got); err != nil {
+		t.Fatalf("Expected a JSON object, got %s", text)
+	}
+	if want := map[string]interface{}{"status": 200.0, "url": server.URL}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	if text, _ := callTool(t, s, "fetch_url", map[string]interface{}{"url": server.URL, "transform": "missing_field"}); text != "null" {
+		t.Errorf("Expected null for a field that isn't there, got %s", text)
+	}
+
+	text, isError = callTool(t, s, "fetch_url", map[string]interface{}{"url": server.URL, "transform": "{status: status_code"})
+	if !isError || !strings.Contains(text, "invalid transform") {
+		t.Errorf("Expected an invalid transform error, got %s", text)
+	}
+	text, isError = callTool(t, s, "fetch_url", map[string]interface{}{"url": server.URL, "transform": "abs(url)"})
+	if !isError || !strings.Contains(text, "transform failed") {
+		t.Errorf("Expected a transform failure, got %s", text)
+	}
+
+	// An invalid expression is rejected before the fetch
+	if _, isError := callTool(t, s, "fetch_url", map[string]interface{}{"url": "http://unreachable.invalid/", "transform": "["}); !isError {
+		t.Error("Expected an invalid transform to be rejected")
+	}
+	if stats := s.fetcher.DomainStats(); len(stats) != 1 {
+		t.Errorf("Expected no fetch for the invalid transform, got %+v", stats)
+	}
+}
+
+// TestOutputSchema tests validating tool results against a caller's JSON
+// Schema and reporting every violation with its location
+func TestOutputSchema(t *testing.T) {
+	schema, err := compileOutputSchema(map[string]interface{}{"schema": map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"name", "price"},
+		"properties": map[string]interface{}{
+			"name":  map[string]interface{}{"type": "string"},
+			"price": map[string]interface{}{"type": "number", "minimum": 0.0},
+			"tags":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := validateOutput(schema, map[string]interface{}{"name": "Lamp", "price": 12.5, "tags": []string{"home"}}); err != nil {
+		t.Errorf("Expected a matching result to pass, got %v", err)
+	}
+
+	err = validateOutput(schema, map[string]interface{}{"price": -1, "tags": []interface{}{"home", 3}})
+	var violations schemaViolations
+	if !errors.As(err, &violations) {
+		t.Fatalf("Expected schema violations, got %v", err)
+	}
+	if len(violations) != 3 ||
+		!strings.HasPrefix(violations[0], "/: ") || !strings.Contains(violations[0], "name") ||
+		!strings.HasPrefix(violations[1], "/price: ") ||
+		!strings.HasPrefix(violations[2], "/tags/1: ") {
+		t.Errorf("Expected the missing name, negative price and numeric tag, got %q", violations)
+	}
+	if !strings.HasPrefix(err.Error(), "result does not match schema:\n- ") {
+		t.Errorf("Unexpected message %q", err.Error())
+	}
+
+	if schema, err := compileOutputSchema(map[string]interface{}{}); schema != nil || err != nil {
+		t.Errorf("Expected no schema without the parameter, got %v, %v", schema, err)
+	}
+	invalid := []interface{}{
+		42.0,
+		"{not json",
+		map[string]interface{}{"type": "nonsense"},
+		map[string]interface{}{"$ref": "https://example.com/schema.json"},
+		map[string]interface{}{"$ref": "file:///etc/passwd"},
+	}
+	for _, raw := range invalid {
+		if _, err := compileOutputSchema(map[string]interface{}{"schema": raw}); err == nil {
+			t.Errorf("Expected schema %v to be rejected", raw)
+		}
+	}
+
+	// Through a tool call, violations are the tool's error
+	s := newTestServer(t)
+	text, isError := callTool(t, s, "cache_stats", map[string]interface{}{"schema": `{"required": ["entries", "products"]}`})
+	if !isError || !strings.Contains(text, "/: ") || !strings.Contains(text, "products") {
+		t.Errorf("Expected the missing property to be reported, got %s", text)
+	}
+	if text, isError := callTool(t, s, "cache_stats", map[string]interface{}{"schema": `{"required": ["entries"]}`}); isError {
+		t.Errorf("Expected a matching result, got %s", text)
+	}
+}
+
+// TestNoCache tests that no_cache skips the cached copy but still stores
+// the fresh result for later requests
+func TestNoCache(t *testing.T) {
+	version := "first"
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte("<html><body><p>The " + version + " version</p></body></html>"))
+	}))
+	defer server.Close()
+
+	s := newTestServer(t)
+	fetch := func(params map[string]interface{}) string {
+		t.Helper()
+		result, err := s.fetchURL(context.Background(), params)
+		if err != nil {
+			t.Fatalf("fetch_url failed: %v", err)
+		}
+		content, _ := result.(map[string]interface{})["content"].(string)
+		return content
+	}
+
+	fetch(map[string]interface{}{"url": server.URL})
+	version = "second"
+	if content := fetch(map[string]interface{}{"url": server.URL}); !strings.Contains(content, "first") || requests != 1 {
+		t.Errorf("Expected the cached copy, got %q after %d requests", content, requests)
+	}
+	if content := fetch(map[string]interface{}{"url": server.URL, "no_cache": true}); !strings.Contains(content, "second") || requests != 2 {
+		t.Errorf("Expected a fresh fetch, got %q after %d requests", content, requests)
+	}
+	if content := fetch(map[string]interface{}{"url": server.URL}); !strings.Contains(content, "second") || requests != 2 {
+		t.Errorf("Expected the no_cache result to have been cached, got %q after %d requests", content, requests)
+	}
+}
+
+// TestCapabilities tests that capabilities reports the features the
+// environment configured
+func TestCapabilities(t *testing.T) {
+	capabilities := func() map[string]interface{} {
+		t.Helper()
+		text, isError := callTool(t, newTestServer(t), "capabilities", map[string]interface{}{})
+		if isError {
+			t.Fatalf("capabilities failed: %s", text)
+		}
+		var result map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &result); err != nil {
+			t.Fatalf("Invalid capabilities %s: %v", text, err)
+		}
+		return result
+	}
+
+	for _, name := range []string{"FETCH_URL_CACHE_DIR", "FETCH_URL_CACHE_REDIS_URL", "FETCH_URL_PROXY", "FETCH_URL_OFFLINE"} {
+		t.Setenv(name, "")
+	}
+	result := capabilities()
+	for _, feature := range []string{"persistent_cache", "proxy_configured", "offline_mode"} {
+		if result[feature] != false {
+			t.Errorf("Expected %s off by default, got %v", feature, result[feature])
+		}
+	}
+	if result["cache_enabled"] != true || result["sessions"] != true {
+		t.Errorf("Expected the cache and sessions on, got %v", result)
+	}
+
+	t.Setenv("FETCH_URL_CACHE_DIR", t.TempDir())
+	t.Setenv("FETCH_URL_PROXY", "http://proxy.example:3128")
+	t.Setenv("FETCH_URL_OFFLINE", "true")
+	result = capabilities()
+	for _, feature := range []string{"persistent_cache", "proxy_configured", "offline_mode"} {
+		if result[feature] != true {
+			t.Errorf("Expected %s on, got %v", feature, result[feature])
+		}
+	}
+}
+
+// TestServerStats tests that server_stats counts fetches under the engine
+// that made them and reports the cache growing
+func TestServerStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><body>ok</body></html>"))
+	}))
+	defer server.Close()
+
+	s := newTestServer(t)
+	stats := func() (metrics.Snapshot, cache.Stats) {
+		t.Helper()
+		result, err := s.serverStats(nil)
+		if err != nil {
+			t.Fatalf("server_stats failed: %v", err)
+		}
+		fields := result.(map[string]interface{})
+		return fields["fetches"].(metrics.Snapshot), fields["cache"].(cache.Stats)
+	}
+
+	fetches, before := stats()
+	if fetches.TotalFetches != 0 {
+		t.Errorf("Expected no fetches yet, got %+v", fetches)
+	}
+
+	for _, path := range []string{"/a", "/b"} {
+		if _, err := s.fetchURL(context.Background(), map[string]interface{}{"url": server.URL + path, "engine": types.EngineHTTP}); err != nil {
+			t.Fatalf("fetch_url failed: %v", err)
+		}
+	}
+	fetches, after := stats()
+	if fetches.TotalFetches != 2 || fetches.Engines[types.EngineHTTP].Fetches != 2 || fetches.Engines[types.EngineHTTP].Errors != 0 {
+		t.Errorf("Expected 2 HTTP fetches, got %+v", fetches)
+	}
+	if fetches.Engines[types.EngineChrome].Fetches != 0 {
+		t.Errorf("Expected no Chrome fetches, got %+v", fetches.Engines[types.EngineChrome])
+	}
+	if after.Entries != before.Entries+2 || after.Bytes <= before.Bytes {
+		t.Errorf("Expected the cache to grow by 2 entries, went from %+v to %+v", before, after)
+	}
+}
+
+// TestWarmingUp tests that a Chrome fetch made before the browser pool is
+// ready is answered as retryable rather than failed
+func TestWarmingUp(t *testing.T) {
+	// A browser endpoint that accepts connections but never answers keeps
+	// the pool from ever becoming ready
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	t.Setenv("FETCH_URL_CHROME_WS_URL", "ws://"+listener.Addr().String()+"/devtools/browser")
+	t.Setenv("FETCH_URL_TIMEOUT", "1")
+
+	s := newTestServer(t)
+	if status := s.readiness(); status != "warming" {
+		t.Errorf("Expected the server to report warming, got %q", status)
+	}
+
+	result, err := s.fetchURL(context.Background(), map[string]interface{}{"url": "http://127.0.0.1/", "engine": types.EngineChrome})
+	if err != nil {
+		t.Fatalf("fetch_url failed: %v", err)
+	}
+	fields := result.(map[string]interface{})
+	if fields["status"] != "warming" || fields["retryable"] != true {
+		t.Errorf("Expected a retryable warming result, got %v", fields)
+	}
+	if !strings.Contains(fmt.Sprint(fields["error"]), fetcher.ErrWarmingUp.Error()) {
+		t.Errorf("Expected the warm-up error, got %v", fields["error"])
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// servePrometheus exposes server metrics in the Prometheus text format on
+// addr. It runs until the process exits; errors are logged, not fatal, since
+// metrics are auxiliary to serving MCP over stdio.
+func (s *URLFetcherMCPServer) servePrometheus(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		s.writePrometheus(w)
+	})
+
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("Metrics server error: %v", err)
+	}
+}
+
+// writePrometheus writes the current metrics in Prometheus exposition format
+func (s *URLFetcherMCPServer) writePrometheus(w io.Writer) {
+	snap := s.fetcher.Metrics()
+	pool := s.fetcher.ChromePoolStats()
+	cacheStats := s.cache.Stats()
+
+	fmt.Fprintf(w, "# TYPE url_fetcher_uptime_seconds gauge\n")
+	fmt.Fprintf(w, "url_fetcher_uptime_seconds %d\n", snap.UptimeSeconds)
+
+	engines := make([]string, 0, len(snap.Engines))
+	for engine := range snap.Engines {
+		engines = append(engines, engine)
+	}
+	sort.Strings(engines)
+
+	fmt.Fprintf(w, "# TYPE url_fetcher_fetches_total counter\n")
+	for _, engine := range engines {
+		fmt.Fprintf(w, "url_fetcher_fetches_total{engine=%q} %d\n", engine, snap.Engines[engine].Fetches)
+	}
+	fmt.Fprintf(w, "# TYPE url_fetcher_fetch_errors_total counter\n")
+	for _, engine := range engines {
+		fmt.Fprintf(w, "url_fetcher_fetch_errors_total{engine=%q} %d\n", engine, snap.Engines[engine].Errors)
+	}
+
+	fmt.Fprintf(w, "# TYPE url_fetcher_chrome_pool_size gauge\n")
+	fmt.Fprintf(w, "url_fetcher_chrome_pool_size %d\n", pool.Size)
+	fmt.Fprintf(w, "# TYPE url_fetcher_chrome_pool_busy gauge\n")
+	fmt.Fprintf(w, "url_fetcher_chrome_pool_busy %d\n", pool.Busy)
+	fmt.Fprintf(w, "# TYPE url_fetcher_chrome_pool_queue_depth gauge\n")
+	fmt.Fprintf(w, "url_fetcher_chrome_pool_queue_depth %d\n", pool.QueueDepth)
+
+	fmt.Fprintf(w, "# TYPE url_fetcher_chrome_renders_total counter\n")
+	for i, renders := range pool.RendersPerInstance {
+		fmt.Fprintf(w, "url_fetcher_chrome_renders_total{instance=\"%d\"} %d\n", i, renders)
+	}
+
+	fmt.Fprintf(w, "# TYPE url_fetcher_chrome_pool_wait_ms histogram\n")
+	for _, bucket := range pool.WaitHistogram {
+		le := "+Inf"
+		if bucket.LeMs >= 0 {
+			le = fmt.Sprintf("%d", bucket.LeMs)
+		}
+		fmt.Fprintf(w, "url_fetcher_chrome_pool_wait_ms_bucket{le=%q} %d\n", le, bucket.Count)
+	}
+	fmt.Fprintf(w, "url_fetcher_chrome_pool_wait_ms_sum %d\n", pool.WaitSumMs)
+	fmt.Fprintf(w, "url_fetcher_chrome_pool_wait_ms_count %d\n", pool.WaitCount)
+
+	fmt.Fprintf(w, "# TYPE url_fetcher_cache_entries gauge\n")
+	fmt.Fprintf(w, "url_fetcher_cache_entries %d\n", cacheStats.Entries)
+	fmt.Fprintf(w, "# TYPE url_fetcher_cache_hits_total counter\n")
+	fmt.Fprintf(w, "url_fetcher_cache_hits_total %d\n", cacheStats.Hits)
+	fmt.Fprintf(w, "# TYPE url_fetcher_cache_misses_total counter\n")
+	fmt.Fprintf(w, "url_fetcher_cache_misses_total %d\n", cacheStats.Misses)
+	fmt.Fprintf(w, "# TYPE url_fetcher_cache_bytes gauge\n")
+	fmt.Fprintf(w, "url_fetcher_cache_bytes %d\n", cacheStats.Bytes)
+	fmt.Fprintf(w, "# TYPE url_fetcher_cache_evictions_total counter\n")
+	fmt.Fprintf(w, "url_fetcher_cache_evictions_total %d\n", cacheStats.Evictions)
+}
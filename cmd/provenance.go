@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gomcpgo/url_fetcher/pkg/provenance"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// provenanceRecord describes how resp was produced for req, signed when
+// FETCH_URL_PROVENANCE_KEY is configured. The content hash covers the full
+// processed content, not just the chunk returned.
+func (s *URLFetcherMCPServer) provenanceRecord(req *types.FetchRequest, resp *types.FetchResponse) *provenance.Record {
+	record := provenance.New(Version, resp.URL, resp.FetchedAt, resp.Content, provenanceOptions(req))
+	if s.config.ProvenanceKey != "" {
+		record.Sign([]byte(s.config.ProvenanceKey))
+	}
+	return record
+}
+
+// provenanceOptions canonically describes the settings that shape a
+// response; credentials only enter it through cacheVariant's hash of them
+func provenanceOptions(req *types.FetchRequest) string {
+	options := fmt.Sprintf("engine=%s;format=%s;max_content_length=%d;offset=%d;chunk_size=%d;variant=%s",
+		req.Engine, req.Format, req.MaxContentLength, req.Offset, req.ChunkSize, cacheVariant(req))
+	if req.FollowPagination > 0 {
+		options += fmt.Sprintf(";pages=%d", req.FollowPagination)
+	}
+	if req.XPath != "" {
+		options += ";xpath=" + req.XPath
+	}
+	return options
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// schemaViolations lists where a tool result failed its output schema
+type schemaViolations []string
+
+func (v schemaViolations) Error() string {
+	return "result does not match schema:\n- " + strings.Join(v, "\n- ")
+}
+
+// compileOutputSchema parses the optional schema argument of a tool call,
+// given either as a JSON Schema object or as a JSON string
+func compileOutputSchema(params map[string]interface{}) (*jsonschema.Schema, error) {
+	raw, ok := params["schema"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+
+	var source string
+	switch schema := raw.(type) {
+	case string:
+		source = schema
+	case map[string]interface{}:
+		data, err := json.Marshal(schema)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema: %w", err)
+		}
+		source = string(data)
+	default:
+		return nil, fmt.Errorf("schema must be a JSON Schema object")
+	}
+
+	compiler := jsonschema.NewCompiler()
+	// Schemas come from callers; never let $ref read files or the network
+	compiler.LoadURL = func(s string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("external schema references are not allowed: %s", s)
+	}
+	if err := compiler.AddResource("mem:///output.json", strings.NewReader(source)); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	schema, err := compiler.Compile("mem:///output.json")
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return schema, nil
+}
+
+// validateOutput checks the tool result, as it would be serialized, against
+// the schema and returns every violation with its JSON pointer location
+func validateOutput(schema *jsonschema.Schema, result interface{}) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	err = schema.Validate(doc)
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return err
+	}
+
+	var violations schemaViolations
+	var collect func(*jsonschema.ValidationError)
+	collect = func(ve *jsonschema.ValidationError) {
+		if len(ve.Causes) == 0 {
+			location := ve.InstanceLocation
+			if location == "" {
+				location = "/"
+			}
+			violations = append(violations, fmt.Sprintf("%s: %s", location, ve.Message))
+			return
+		}
+		for _, cause := range ve.Causes {
+			collect(cause)
+		}
+	}
+	collect(validationErr)
+	sort.Strings(violations)
+	return violations
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gomcpgo/url_fetcher/pkg/config"
+	"github.com/gomcpgo/url_fetcher/pkg/summarizer"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// newSummarizer returns the external summarizer if FETCH_URL_SUMMARIZER_URL
+// is set, and the local extractive one otherwise
+func newSummarizer(cfg *config.Config) summarizer.Summarizer {
+	if cfg.SummarizerURL != "" {
+		return summarizer.NewHTTP(cfg.SummarizerURL, cfg.Timeout)
+	}
+	return summarizer.Extractive{}
+}
+
+// addSummary adds a summary of the full content to result when the request
+// asks for one. If an external summarizer fails, the local one is used and
+// a warning says so.
+func (s *URLFetcherMCPServer) addSummary(ctx context.Context, req *types.FetchRequest, resp *types.FetchResponse, result map[string]interface{}) {
+	if req.SummarySentences <= 0 || resp.Content == "" {
+		return
+	}
+
+	summary, err := s.summarizer.Summarize(ctx, resp.Content, req.SummarySentences)
+	if err != nil {
+		if _, local := s.summarizer.(summarizer.Extractive); local || ctx.Err() != nil {
+			return
+		}
+		summary, _ = summarizer.Extractive{}.Summarize(ctx, resp.Content, req.SummarySentences)
+		warnings, _ := result["warnings"].([]string)
+		result["warnings"] = append(append([]string{}, warnings...),
+			fmt.Sprintf("summarizer failed (%v); used the built-in extractive summary", err))
+	}
+	if summary != "" {
+		result["summary"] = summary
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jmespath/go-jmespath"
+)
+
+// compileTransform parses the optional transform argument of a tool call
+func compileTransform(params map[string]interface{}) (*jmespath.JMESPath, error) {
+	expr, ok := params["transform"].(string)
+	if !ok || expr == "" {
+		return nil, nil
+	}
+	compiled, err := jmespath.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transform: %w", err)
+	}
+	return compiled, nil
+}
+
+// applyTransform evaluates a JMESPath expression against the tool result as
+// it would be serialized, so expressions use the JSON field names
+func applyTransform(transform *jmespath.JMESPath, result interface{}) (interface{}, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	transformed, err := transform.Search(doc)
+	if err != nil {
+		return nil, fmt.Errorf("transform failed: %w", err)
+	}
+	return transformed, nil
+}
@@ -1,24 +1,97 @@
 package cache
 
 import (
+	"net/http"
+	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gomcpgo/url_fetcher/pkg/types"
 )
 
-// Cache provides in-memory caching with TTL support
+// StaleRetention is how long an expired entry with an ETag or Last-Modified
+// is kept past its expiry, so it can be revalidated instead of refetched (or,
+// with serve-stale, any entry, to serve when a fetch fails)
+const StaleRetention = 24 * time.Hour
+
+// Store holds cache entries by key. The in-memory LRU is the default;
+// a shared store such as Redis lets several instances use one cache. Stores
+// are best-effort: a failure is logged and treated as a miss.
+type Store interface {
+	// Get returns key's entry, expired or not
+	Get(key string) (*types.CacheEntry, bool)
+
+	// Set stores key's entry, replacing any previous one
+	Set(key string, entry *types.CacheEntry)
+
+	// Delete removes key's entry, if any
+	Delete(key string)
+
+	// DeleteMatching removes the entries whose keys match accepts,
+	// returning how many were removed
+	DeleteMatching(match func(key string) bool) int
+
+	// Stats fills in the entry count, size and limits the store tracks
+	Stats(stats *Stats)
+}
+
+// Cache provides caching with TTL support over a Store
 type Cache struct {
-	entries map[string]*types.CacheEntry
-	mu      sync.RWMutex
-	ttl     time.Duration
+	store  Store
+	memory *memoryStore
+	ttl    time.Duration
+	hits   atomic.Int64
+	misses atomic.Int64
+
+	// With originTTL, entries live as long as the origin's Cache-Control or
+	// Expires says, within minTTL and maxTTL (0 for no maximum)
+	originTTL bool
+	minTTL    time.Duration
+	maxTTL    time.Duration
+
+	// trackingParams are query parameters left out of cache keys, as
+	// path.Match patterns
+	trackingParams []string
+
+	// negativeTTL is how long a failed fetch of a dead URL is cached; 0
+	// doesn't cache failures
+	negativeTTL time.Duration
+
+	// serveStale keeps every expired entry for StaleRetention, not just
+	// those with validators, so it can be served when a fetch fails
+	serveStale bool
+
+	// refreshing holds a channel per key being refreshed, closed when the
+	// refresh is done
+	refreshMu  sync.Mutex
+	refreshing map[string]chan struct{}
+}
+
+// Stats is a point-in-time snapshot of cache usage
+type Stats struct {
+	Entries    int     `json:"entries"`
+	Bytes      int64   `json:"bytes"`
+	Hits       int64   `json:"hits"`
+	Misses     int64   `json:"misses"`
+	HitRate    float64 `json:"hit_rate"`
+	Evictions  int64   `json:"evictions"`
+	MaxEntries int     `json:"max_entries,omitempty"`
+	MaxBytes   int64   `json:"max_bytes,omitempty"`
+	DiskBytes  int64   `json:"disk_bytes,omitempty"`
+	TTL        string  `json:"ttl"`
 }
 
-// NewCache creates a new cache instance
+// NewCache creates a new cache instance, holding entries in memory until
+// SetStore replaces it
 func NewCache(ttl time.Duration) *Cache {
+	memory := newMemoryStore()
 	cache := &Cache{
-		entries: make(map[string]*types.CacheEntry),
-		ttl:     ttl,
+		store:      memory,
+		memory:     memory,
+		ttl:        ttl,
+		refreshing: make(map[string]chan struct{}),
 	}
 
 	// Start cleanup goroutine if TTL is set
@@ -29,95 +102,387 @@ func NewCache(ttl time.Duration) *Cache {
 	return cache
 }
 
-// generateKey creates a cache key from request parameters
-func (c *Cache) generateKey(url, engine, format string) string {
-	return url + "|" + engine + "|" + format
+// SetStore replaces the in-memory store, e.g. with a RedisStore shared
+// between instances. It must be called before the cache is used.
+func (c *Cache) SetStore(store Store) {
+	c.store = store
+}
+
+// SetLimits bounds the in-memory store to maxEntries entries and maxBytes
+// of content; 0 leaves either unlimited
+func (c *Cache) SetLimits(maxEntries int, maxBytes int64) {
+	m := c.memory
+	m.mu.Lock()
+	m.maxEntries = maxEntries
+	m.maxBytes = maxBytes
+	m.evict()
+	m.mu.Unlock()
+}
+
+// SetDisk persists the in-memory store's entries as files in dir, keeping at
+// most maxBytes of them (0 for no limit) by removing the least recently used.
+// Entries already there are loaded lazily, as they are looked up.
+func (c *Cache) SetDisk(dir string, maxBytes int64) error {
+	disk, err := newDiskStore(dir, maxBytes)
+	if err != nil {
+		return err
+	}
+	c.memory.mu.Lock()
+	c.memory.disk = disk
+	c.memory.mu.Unlock()
+	return nil
+}
+
+// SetCompression holds the content of in-memory entries compressed with
+// algorithm (CompressionGzip or CompressionZstd, or CompressionNone),
+// decompressing it on each hit. Entries already cached are left as they are.
+func (c *Cache) SetCompression(algorithm string) error {
+	codec, err := newCodec(algorithm)
+	if err != nil {
+		return err
+	}
+	c.memory.mu.Lock()
+	c.memory.codec = codec
+	c.memory.mu.Unlock()
+	return nil
+}
+
+// SetOriginTTL makes entries live as long as the origin's Cache-Control
+// max-age or Expires header says, bounded by minTTL and maxTTL (0 for no
+// maximum). Responses without either keep the global TTL.
+func (c *Cache) SetOriginTTL(minTTL, maxTTL time.Duration) {
+	c.originTTL = true
+	c.minTTL = minTTL
+	c.maxTTL = maxTTL
+}
+
+// SetServeStale keeps expired entries for StaleRetention whether or not they
+// can be revalidated, for Fallback to serve when a fetch fails
+func (c *Cache) SetServeStale(serveStale bool) {
+	c.serveStale = serveStale
+}
+
+// SetNegativeTTL caches failed fetches of dead URLs, for SetNegative, for
+// ttl; 0 doesn't cache them
+func (c *Cache) SetNegativeTTL(ttl time.Duration) {
+	c.negativeTTL = ttl
+}
+
+// SetTrackingParams leaves query parameters matching patterns, such as
+// "utm_*", out of cache keys, so links that differ only in them share an
+// entry
+func (c *Cache) SetTrackingParams(patterns []string) {
+	c.trackingParams = patterns
+}
+
+// generateKey creates a cache key from request parameters, with the URL
+// normalized. Chrome runs the page's scripts, so its entries keep the
+// fragment.
+func (c *Cache) generateKey(url, engine, variant string) string {
+	return normalizeURL(url, c.trackingParams, engine == types.EngineChrome) + "|" + engine + "|" + variant
 }
 
 // Get retrieves a cached response if it exists and hasn't expired
-func (c *Cache) Get(url, engine, format string) (*types.FetchResponse, bool) {
+func (c *Cache) Get(url, engine, variant string) (*types.FetchResponse, bool) {
 	if c.ttl == 0 {
 		return nil, false
 	}
 
-	key := c.generateKey(url, engine, format)
+	key := c.generateKey(url, engine, variant)
+	c.awaitRefresh(key)
+	entry, exists := c.store.Get(key)
+	if !exists || entry.Response.Error != "" {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	// Check if entry has expired
+	if now := time.Now(); now.After(entry.ExpiresAt) {
+		if removable(entry, now) {
+			c.store.Delete(key)
+		}
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	c.hits.Add(1)
+	return entry.Response, true
+}
 
-	c.mu.RLock()
-	entry, exists := c.entries[key]
-	c.mu.RUnlock()
+// Stale returns an expired entry that can still be revalidated with its ETag
+// or Last-Modified. Lookups here aren't counted: Get has already counted the
+// miss.
+func (c *Cache) Stale(url, engine, variant string) (*types.FetchResponse, bool) {
+	entry, ok := c.retained(url, engine, variant)
+	if !ok || !time.Now().After(entry.ExpiresAt) || !hasValidators(entry.Response) {
+		return nil, false
+	}
+	return entry.Response, true
+}
 
-	if !exists {
+// Fallback returns an entry, fresh or expired, that hasn't been dropped yet,
+// to serve in place of a failed fetch. With serve-stale set, expired entries
+// are kept for StaleRetention; otherwise only those with validators are.
+// Lookups here aren't counted.
+func (c *Cache) Fallback(url, engine, variant string) (*types.FetchResponse, bool) {
+	entry, ok := c.retained(url, engine, variant)
+	if !ok {
 		return nil, false
 	}
+	return entry.Response, true
+}
 
-	// Check if entry has expired
-	if time.Now().After(entry.ExpiresAt) {
-		c.Delete(url, engine, format)
+// retained looks up an entry that isn't yet of no further use
+func (c *Cache) retained(url, engine, variant string) (*types.CacheEntry, bool) {
+	if c.ttl == 0 {
+		return nil, false
+	}
+
+	key := c.generateKey(url, engine, variant)
+	c.awaitRefresh(key)
+	entry, exists := c.store.Get(key)
+	if !exists || entry.Response.Error != "" || removable(entry, time.Now()) {
+		return nil, false
+	}
+	return entry, true
+}
+
+// Failure returns the cached failure of a dead URL, if it hasn't expired:
+// the response the fetch returned, with Error set.
+// Lookups here aren't counted: Get has already counted the miss.
+func (c *Cache) Failure(url, engine, variant string) (*types.FetchResponse, bool) {
+	if c.ttl == 0 || c.negativeTTL == 0 {
 		return nil, false
 	}
 
+	key := c.generateKey(url, engine, variant)
+	c.awaitRefresh(key)
+	entry, exists := c.store.Get(key)
+	if !exists || entry.Response.Error == "" || time.Now().After(entry.ExpiresAt) {
+		return nil, false
+	}
 	return entry.Response, true
 }
 
+// SetNegative caches a failed fetch of a dead URL for the negative TTL, so
+// repeated requests for it fail without going to the network. response is
+// what the fetch returned, if anything; it replaces any entry for the page.
+func (c *Cache) SetNegative(url, engine, variant string, response *types.FetchResponse, err error) {
+	if c.ttl == 0 || c.negativeTTL == 0 {
+		return
+	}
+
+	now := time.Now()
+	failure := types.ErrorResponse(url, engine, err, 0)
+	if response != nil {
+		copied := *response
+		failure = &copied
+	}
+	failure.Error = err.Error()
+	if failure.FetchedAt.IsZero() {
+		failure.FetchedAt = now
+	}
+
+	expiresAt := now.Add(c.negativeTTL)
+	c.store.Set(c.generateKey(url, engine, variant), &types.CacheEntry{Response: failure, ExpiresAt: expiresAt, RetainUntil: expiresAt})
+}
+
+// Refresh evicts an entry and replaces it with what fetch returns. Lookups
+// of the entry wait until it's done, so they neither see the evicted copy
+// nor miss and fetch it themselves; concurrent refreshes of it take turns.
+// If fetch fails, the entry stays evicted.
+func (c *Cache) Refresh(url, engine, variant string, fetch func() (*types.FetchResponse, error)) (*types.FetchResponse, error) {
+	key := c.generateKey(url, engine, variant)
+
+	c.refreshMu.Lock()
+	for {
+		pending, busy := c.refreshing[key]
+		if !busy {
+			break
+		}
+		c.refreshMu.Unlock()
+		<-pending
+		c.refreshMu.Lock()
+	}
+	done := make(chan struct{})
+	c.refreshing[key] = done
+	c.refreshMu.Unlock()
+
+	defer func() {
+		c.refreshMu.Lock()
+		delete(c.refreshing, key)
+		c.refreshMu.Unlock()
+		close(done)
+	}()
+
+	c.store.Delete(key)
+	response, err := fetch()
+	if err == nil {
+		c.Set(url, engine, variant, response)
+	}
+	return response, err
+}
+
+// awaitRefresh waits for a refresh of key in progress, if any
+func (c *Cache) awaitRefresh(key string) {
+	c.refreshMu.Lock()
+	pending, busy := c.refreshing[key]
+	c.refreshMu.Unlock()
+	if busy {
+		<-pending
+	}
+}
+
+// removable reports whether entry is expired and of no further use: it is
+// past RetainUntil, which leaves entries with validators StaleRetention to be
+// revalidated, and with serve-stale, every entry that long to fall back on
+func removable(entry *types.CacheEntry, now time.Time) bool {
+	return now.After(entry.ExpiresAt) && now.After(entry.RetainUntil)
+}
+
+// hasValidators reports whether response can be revalidated
+func hasValidators(response *types.FetchResponse) bool {
+	return response.ETag != "" || response.LastModified != ""
+}
+
 // Set stores a response in the cache
-func (c *Cache) Set(url, engine, format string, response *types.FetchResponse) {
+func (c *Cache) Set(url, engine, variant string, response *types.FetchResponse) {
 	if c.ttl == 0 {
 		return
 	}
 
-	// Don't cache error responses
-	if response.StatusCode == 0 || response.StatusCode >= 400 {
+	// Don't cache error responses, a 304 with nothing to stand in for, an
+	// interstitial in place of the page, metadata from a skipped download, or
+	// a body streamed to a file that may be gone before the entry expires
+	if response.StatusCode == 0 || response.StatusCode >= 400 || response.StatusCode == http.StatusNotModified ||
+		response.BlockedBy != "" || response.Skipped || response.File != "" {
 		return
 	}
 
-	key := c.generateKey(url, engine, format)
+	now := time.Now()
+	ttl, cacheable := c.entryTTL(response, now)
+	if !cacheable || (ttl == 0 && !hasValidators(response)) {
+		return
+	}
+
+	// Entries are always stored without a BOM so repeated fetches compare equal
+	response.Content = strings.TrimPrefix(response.Content, "\uFEFF")
+
+	// A revalidated copy is stored as the page it stands for, so later hits
+	// don't claim a 304
+	if response.NotModified {
+		copied := *response
+		copied.NotModified = false
+		response = &copied
+	}
 
-	c.mu.Lock()
-	c.entries[key] = &types.CacheEntry{
-		Response:  response,
-		ExpiresAt: time.Now().Add(c.ttl),
+	entry := &types.CacheEntry{Response: response, ExpiresAt: now.Add(ttl)}
+	entry.RetainUntil = entry.ExpiresAt
+	if c.serveStale || hasValidators(response) {
+		entry.RetainUntil = entry.ExpiresAt.Add(StaleRetention)
 	}
-	c.mu.Unlock()
+	c.store.Set(c.generateKey(url, engine, variant), entry)
 }
 
 // Delete removes an entry from the cache
-func (c *Cache) Delete(url, engine, format string) {
-	key := c.generateKey(url, engine, format)
+func (c *Cache) Delete(url, engine, variant string) {
+	c.store.Delete(c.generateKey(url, engine, variant))
+}
+
+// DeleteURL removes every entry for a URL regardless of engine, variant and
+// fragment, returning the number of entries removed
+func (c *Cache) DeleteURL(url string) int {
+	page := normalizeURL(url, c.trackingParams, false)
+	return c.store.DeleteMatching(func(key string) bool {
+		keyURL, _, _ := strings.Cut(urlOfKey(key), "#")
+		return keyURL == page
+	})
+}
+
+// DeletePattern removes every entry whose URL matches a glob pattern, where
+// '*' matches any run of characters (including '/'). URLs are matched in the
+// normalized form they're keyed by. It returns the number of entries removed.
+func (c *Cache) DeletePattern(pattern string) (int, error) {
+	re, err := globToRegexp(pattern)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.store.DeleteMatching(func(key string) bool {
+		return re.MatchString(urlOfKey(key))
+	}), nil
+}
 
-	c.mu.Lock()
-	delete(c.entries, key)
-	c.mu.Unlock()
+// urlOfKey returns the URL part of a key made by generateKey. The URL may
+// itself contain '|', in its query or as a data: URL, but the engine and
+// variant after it don't.
+func urlOfKey(key string) string {
+	key = key[:strings.LastIndex(key, "|")]
+	return key[:strings.LastIndex(key, "|")]
 }
 
-// Clear removes all entries from the cache
-func (c *Cache) Clear() {
-	c.mu.Lock()
-	c.entries = make(map[string]*types.CacheEntry)
-	c.mu.Unlock()
+// Clear removes all entries from the cache, returning how many were removed
+func (c *Cache) Clear() int {
+	return c.store.DeleteMatching(func(string) bool { return true })
 }
 
 // Size returns the number of entries in the cache
 func (c *Cache) Size() int {
-	c.mu.RLock()
-	size := len(c.entries)
-	c.mu.RUnlock()
-	return size
+	var stats Stats
+	c.store.Stats(&stats)
+	return stats.Entries
+}
+
+// Stats returns the store's entry count and size, hit/miss counters and
+// evictions
+func (c *Cache) Stats() Stats {
+	hits := c.hits.Load()
+	misses := c.misses.Load()
+
+	var hitRate float64
+	if total := hits + misses; total > 0 {
+		hitRate = float64(hits) / float64(total)
+	}
+
+	stats := Stats{
+		Hits:    hits,
+		Misses:  misses,
+		HitRate: hitRate,
+		TTL:     c.ttl.String(),
+	}
+	c.store.Stats(&stats)
+	return stats
 }
 
-// cleanupExpired periodically removes expired entries
+// globToRegexp compiles a '*' / '?' glob into an anchored regular expression
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// cleanupExpired periodically removes expired entries that can't be
+// revalidated from the in-memory store. Shared stores expire entries
+// themselves.
 func (c *Cache) cleanupExpired() {
 	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
 
 	for range ticker.C {
 		now := time.Now()
-
-		c.mu.Lock()
-		for key, entry := range c.entries {
-			if now.After(entry.ExpiresAt) {
-				delete(c.entries, key)
-			}
-		}
-		c.mu.Unlock()
+		c.memory.prune(func(entry *types.CacheEntry) bool {
+			return removable(entry, now)
+		})
 	}
 }
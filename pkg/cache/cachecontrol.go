@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// cacheDirectives parses a Cache-Control header into its directives, keyed
+// by lowercased name, with quotes removed from values
+func cacheDirectives(header string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+	}
+	return directives
+}
+
+// originTTL returns how long the origin said response stays fresh: its
+// Cache-Control max-age, or else the time until its Expires date. ok is
+// false when the origin said neither.
+func originTTL(response *types.FetchResponse, directives map[string]string, now time.Time) (time.Duration, bool) {
+	if value, ok := directives["max-age"]; ok {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seconds < 0 {
+			return 0, true
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if response.Expires == "" {
+		return 0, false
+	}
+	expires, err := http.ParseTime(response.Expires)
+	if err != nil {
+		// An invalid date, typically "0", means already expired
+		return 0, true
+	}
+	fetched := response.FetchedAt
+	if fetched.IsZero() {
+		fetched = now
+	}
+	return max(expires.Sub(fetched), 0), true
+}
+
+// entryTTL returns how long to cache response, and false if it mustn't be
+// cached at all. no-store is always honored; with origin TTLs enabled, the
+// origin's freshness lifetime replaces the global TTL, bounded by the
+// configured minimum and maximum. no-cache entries expire at once, so they
+// are revalidated on every use.
+func (c *Cache) entryTTL(response *types.FetchResponse, now time.Time) (time.Duration, bool) {
+	directives := cacheDirectives(response.CacheControl)
+	if _, noStore := directives["no-store"]; noStore {
+		return 0, false
+	}
+	if !c.originTTL {
+		return c.ttl, true
+	}
+	if _, noCache := directives["no-cache"]; noCache {
+		return 0, true
+	}
+
+	ttl, ok := originTTL(response, directives, now)
+	if !ok {
+		return c.ttl, true
+	}
+	ttl = max(ttl, c.minTTL)
+	if c.maxTTL > 0 {
+		ttl = min(ttl, c.maxTTL)
+	}
+	return ttl, true
+}
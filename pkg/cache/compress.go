@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression algorithms for content held in memory
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// compressMinSize is the smallest content worth compressing; below it the
+// saving doesn't pay for the work
+const compressMinSize = 1024
+
+// codec compresses content held in memory
+type codec interface {
+	compress(data []byte) []byte
+	decompress(data []byte) ([]byte, error)
+}
+
+// newCodec returns the codec for an algorithm, or nil for none
+func newCodec(algorithm string) (codec, error) {
+	switch algorithm {
+	case "", CompressionNone:
+		return nil, nil
+	case CompressionGzip:
+		return gzipCodec{}, nil
+	case CompressionZstd:
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+		if err != nil {
+			return nil, err
+		}
+		decoder, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		return zstdCodec{encoder: encoder, decoder: decoder}, nil
+	default:
+		return nil, fmt.Errorf("unsupported cache compression %q", algorithm)
+	}
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) compress(data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func (gzipCodec) decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// zstdCodec shares one encoder and decoder, whose EncodeAll and DecodeAll
+// are safe for concurrent use
+type zstdCodec struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func (c zstdCodec) compress(data []byte) []byte {
+	return c.encoder.EncodeAll(data, nil)
+}
+
+func (c zstdCodec) decompress(data []byte) ([]byte, error) {
+	return c.decoder.DecodeAll(data, nil)
+}
@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+const (
+	// diskFileSuffix marks the cache's files, so nothing else in the
+	// directory is read or removed
+	diskFileSuffix = ".json"
+
+	// diskTempPrefix marks files being written, renamed into place once
+	// complete. One left behind by a crash is removed once it is older than
+	// diskTempMaxAge, so another server's write in progress is left alone.
+	diskTempPrefix = "tmp-"
+	diskTempMaxAge = time.Minute
+)
+
+// diskStore persists entries as one JSON file per key, so the cache
+// survives restarts. Files are named by a hash of their key and hold the key
+// itself, for the pattern deletes that have to scan them.
+type diskStore struct {
+	dir      string
+	maxBytes int64 // 0 is unlimited
+
+	mu    sync.Mutex
+	bytes int64
+}
+
+// diskEntry is the serialized form of a stored entry, on disk and in Redis
+type diskEntry struct {
+	Key         string               `json:"key"`
+	ExpiresAt   time.Time            `json:"expires_at"`
+	RetainUntil time.Time            `json:"retain_until"`
+	Response    *types.FetchResponse `json:"response"`
+}
+
+func newDiskStore(dir string, maxBytes int64) (*diskStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	d := &diskStore{dir: dir, maxBytes: maxBytes}
+	d.removeTemp()
+	for _, file := range d.files() {
+		d.bytes += file.size
+	}
+	return d, nil
+}
+
+// removeTemp removes the partial files of writes that never finished
+func (d *diskStore) removeTemp() {
+	entries, _ := os.ReadDir(d.dir)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), diskTempPrefix) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > diskTempMaxAge {
+			os.Remove(filepath.Join(d.dir, entry.Name()))
+		}
+	}
+}
+
+// path returns the file key is stored in
+func (d *diskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+diskFileSuffix)
+}
+
+// load reads key's entry, marking it used so the size cap evicts it last.
+// An entry past its retention is removed instead.
+func (d *diskStore) load(key string) (*types.CacheEntry, bool) {
+	path := d.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var stored diskEntry
+	if err := json.Unmarshal(data, &stored); err != nil || stored.Key != key || stored.Response == nil {
+		return nil, false
+	}
+	entry := &types.CacheEntry{Response: stored.Response, ExpiresAt: stored.ExpiresAt, RetainUntil: stored.RetainUntil}
+	now := time.Now()
+	if removable(entry, now) {
+		d.remove(path)
+		return nil, false
+	}
+	os.Chtimes(path, now, now)
+	return entry, true
+}
+
+// save writes key's entry, replacing the file atomically, then evicts the
+// least recently used files past the size cap
+func (d *diskStore) save(key string, entry *types.CacheEntry) error {
+	data, err := json.Marshal(diskEntry{Key: key, ExpiresAt: entry.ExpiresAt, RetainUntil: entry.RetainUntil, Response: entry.Response})
+	if err != nil {
+		return err
+	}
+	if d.maxBytes > 0 && int64(len(data)) > d.maxBytes {
+		return nil
+	}
+
+	path := d.path(key)
+	tmp, err := os.CreateTemp(d.dir, diskTempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var previous int64
+	if info, err := os.Stat(path); err == nil {
+		previous = info.Size()
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	d.bytes += int64(len(data)) - previous
+	d.evict()
+	return nil
+}
+
+// evict removes the least recently used files until the store is within
+// its size cap; d.mu must be held
+func (d *diskStore) evict() {
+	if d.maxBytes <= 0 || d.bytes <= d.maxBytes {
+		return
+	}
+	files := d.files()
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, file := range files {
+		if d.bytes <= d.maxBytes {
+			break
+		}
+		if os.Remove(file.path) == nil {
+			d.bytes -= file.size
+		}
+	}
+}
+
+// delete removes key's file, if any
+func (d *diskStore) delete(key string) {
+	d.remove(d.path(key))
+}
+
+// deleteMatching removes every file whose key match accepts, returning how
+// many were removed
+func (d *diskStore) deleteMatching(match func(key string) bool) int {
+	removed := 0
+	for _, file := range d.files() {
+		data, err := os.ReadFile(file.path)
+		if err != nil {
+			continue
+		}
+		var stored struct {
+			Key string `json:"key"`
+		}
+		if json.Unmarshal(data, &stored) == nil && match(stored.Key) {
+			d.remove(file.path)
+			removed++
+		}
+	}
+	return removed
+}
+
+// clear removes every file
+func (d *diskStore) clear() {
+	for _, file := range d.files() {
+		d.remove(file.path)
+	}
+}
+
+func (d *diskStore) remove(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if info, err := os.Stat(path); err == nil && os.Remove(path) == nil {
+		d.bytes -= info.Size()
+	}
+}
+
+// size returns the bytes stored
+func (d *diskStore) size() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.bytes
+}
+
+// diskFile is one of the store's files
+type diskFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// files lists the store's files
+func (d *diskStore) files() []diskFile {
+	entries, _ := os.ReadDir(d.dir)
+	files := make([]diskFile, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), diskFileSuffix) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			files = append(files, diskFile{path: filepath.Join(d.dir, entry.Name()), size: info.Size(), modTime: info.ModTime()})
+		}
+	}
+	return files
+}
@@ -0,0 +1,239 @@
+package cache
+
+import (
+	"container/list"
+	"log"
+	"sync"
+
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// entryOverhead approximates what an entry takes besides its strings:
+// the response's other fields, the map and list bookkeeping
+const entryOverhead = 512
+
+// memoryStore is the default store: an in-process LRU. With limits set, it
+// holds at most maxEntries entries and maxBytes of them, evicting the least
+// recently used first. With a disk store it persists entries across
+// restarts, loading them into memory as they are looked up.
+type memoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]*list.Element // of *item
+	lru       *list.List               // most recently used first
+	bytes     int64
+	evictions int64
+
+	// maxEntries and maxBytes bound the store; 0 is unlimited
+	maxEntries int
+	maxBytes   int64
+
+	disk *diskStore
+
+	// codec, if set, compresses the content of entries held in memory
+	codec codec
+}
+
+// item is an entry in the LRU list. With compression, the entry's content is
+// held compressed in content instead.
+type item struct {
+	key     string
+	entry   *types.CacheEntry
+	content []byte
+	size    int64
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Get finds key's entry in memory or, failing that, loads it from disk
+func (m *memoryStore) Get(key string) (*types.CacheEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if element, exists := m.entries[key]; exists {
+		stored := element.Value.(*item)
+		entry, err := m.expand(stored)
+		if err != nil {
+			log.Printf("Warning: failed to decompress cache entry: %v", err)
+			m.remove(element)
+			return nil, false
+		}
+		m.lru.MoveToFront(element)
+		return entry, true
+	}
+	if m.disk == nil {
+		return nil, false
+	}
+	entry, found := m.disk.load(key)
+	if !found {
+		return nil, false
+	}
+	m.insert(m.newItem(key, entry))
+	return entry, true
+}
+
+// Set stores key's entry in memory and on disk
+func (m *memoryStore) Set(key string, entry *types.CacheEntry) {
+	stored := m.newItem(key, entry)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if element, exists := m.entries[key]; exists {
+		m.remove(element)
+	}
+	if m.disk != nil {
+		if err := m.disk.save(key, entry); err != nil {
+			log.Printf("Warning: failed to persist cache entry: %v", err)
+		}
+	}
+	m.insert(stored)
+}
+
+// newItem makes key's entry into an LRU item, compressing its content if
+// compression is on and the content is large enough to gain from it
+func (m *memoryStore) newItem(key string, entry *types.CacheEntry) *item {
+	stored := &item{key: key, entry: entry}
+	if m.codec != nil && len(entry.Response.Content) >= compressMinSize {
+		response := *entry.Response
+		stored.content = m.codec.compress([]byte(response.Content))
+		response.Content = ""
+		stored.entry = &types.CacheEntry{Response: &response, ExpiresAt: entry.ExpiresAt, RetainUntil: entry.RetainUntil}
+	}
+	stored.size = entrySize(key, stored.entry.Response) + int64(len(stored.content))
+	return stored
+}
+
+// expand returns an item's entry with its content decompressed
+func (m *memoryStore) expand(stored *item) (*types.CacheEntry, error) {
+	if stored.content == nil {
+		return stored.entry, nil
+	}
+	content, err := m.codec.decompress(stored.content)
+	if err != nil {
+		return nil, err
+	}
+	response := *stored.entry.Response
+	response.Content = string(content)
+	return &types.CacheEntry{Response: &response, ExpiresAt: stored.entry.ExpiresAt, RetainUntil: stored.entry.RetainUntil}, nil
+}
+
+// insert adds an entry to memory as the most recently used, evicting others
+// to make room. An entry that would displace the whole store isn't kept.
+// m.mu must be held.
+func (m *memoryStore) insert(stored *item) {
+	element := m.lru.PushFront(stored)
+	m.entries[stored.key] = element
+	m.bytes += stored.size
+	if m.maxBytes > 0 && stored.size > m.maxBytes {
+		m.remove(element)
+		return
+	}
+	m.evict()
+}
+
+// entrySize estimates the memory an entry holds
+func entrySize(key string, response *types.FetchResponse) int64 {
+	size := len(key) + len(response.Content) + len(response.URL) + len(response.FinalURL) +
+		len(response.Title) + len(response.ContentType) + len(response.ETag) + len(response.LastModified)
+	for _, warning := range response.Warnings {
+		size += len(warning)
+	}
+	for _, page := range response.Pages {
+		size += len(page)
+	}
+	return int64(size + entryOverhead)
+}
+
+// evict removes least recently used entries until the store is within its
+// limits; m.mu must be held
+func (m *memoryStore) evict() {
+	for m.lru.Len() > 0 && ((m.maxEntries > 0 && m.lru.Len() > m.maxEntries) || (m.maxBytes > 0 && m.bytes > m.maxBytes)) {
+		m.remove(m.lru.Back())
+		m.evictions++
+	}
+}
+
+// remove drops an entry from memory; m.mu must be held
+func (m *memoryStore) remove(element *list.Element) {
+	stored := element.Value.(*item)
+	if m.entries[stored.key] != element {
+		return
+	}
+	m.lru.Remove(element)
+	delete(m.entries, stored.key)
+	m.bytes -= stored.size
+}
+
+// Delete removes key's entry from memory and disk
+func (m *memoryStore) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if element, exists := m.entries[key]; exists {
+		m.remove(element)
+	}
+	if m.disk != nil {
+		m.disk.delete(key)
+	}
+}
+
+// DeleteMatching removes the entries, in memory and on disk, whose keys
+// match accepts, returning how many were removed
+func (m *memoryStore) DeleteMatching(match func(key string) bool) int {
+	removed := make(map[string]bool)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, element := range m.entries {
+		if match(key) {
+			m.remove(element)
+			removed[key] = true
+		}
+	}
+	if m.disk != nil {
+		m.disk.deleteMatching(func(key string) bool {
+			if !match(key) {
+				return false
+			}
+			removed[key] = true
+			return true
+		})
+	}
+
+	return len(removed)
+}
+
+// prune removes the entries, in memory and on disk, that expired says are
+// of no further use. Entries only on disk are left for their next lookup
+// or the size cap.
+func (m *memoryStore) prune(expired func(*types.CacheEntry) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, element := range m.entries {
+		if expired(element.Value.(*item).entry) {
+			m.remove(element)
+			if m.disk != nil {
+				m.disk.delete(key)
+			}
+		}
+	}
+}
+
+// Stats reports the entries and bytes held, evictions and limits
+func (m *memoryStore) Stats(stats *Stats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats.Entries = len(m.entries)
+	stats.Bytes = m.bytes
+	stats.Evictions = m.evictions
+	stats.MaxEntries = m.maxEntries
+	stats.MaxBytes = m.maxBytes
+	if m.disk != nil {
+		stats.DiskBytes = m.disk.size()
+	}
+}
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// defaultPorts are the ports a URL's scheme implies
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// normalizeURL reduces a URL to the form it is cached under, so trivially
+// different spellings of a page share an entry: the scheme and host are
+// lowercased, the scheme's default port is dropped, an empty path becomes
+// "/", and query parameters are sorted by name, without those matching
+// trackingParams (path.Match patterns such as "utm_*"). The fragment is
+// dropped unless keepFragment is set, for a browser, where a hash-routed
+// app renders a different page for each. Parameter values keep their
+// encoding. A URL that doesn't parse is returned as is.
+func normalizeURL(rawURL string, trackingParams []string, keepFragment bool) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if port := u.Port(); port != "" && port == defaultPorts[u.Scheme] {
+		u.Host = strings.TrimSuffix(u.Host, ":"+port)
+	}
+	if !keepFragment {
+		u.Fragment, u.RawFragment = "", ""
+	}
+	if u.Path == "" && u.RawPath == "" {
+		u.Path = "/"
+	}
+
+	if u.RawQuery != "" {
+		var params []string
+		for _, param := range strings.Split(u.RawQuery, "&") {
+			if param != "" && !trackingParam(queryName(param), trackingParams) {
+				params = append(params, param)
+			}
+		}
+		sort.SliceStable(params, func(i, j int) bool { return queryName(params[i]) < queryName(params[j]) })
+		u.RawQuery = strings.Join(params, "&")
+	}
+	u.ForceQuery = false
+
+	return u.String()
+}
+
+// queryName returns the decoded name of a raw query parameter
+func queryName(param string) string {
+	name, _, _ := strings.Cut(param, "=")
+	if decoded, err := url.QueryUnescape(name); err == nil {
+		return decoded
+	}
+	return name
+}
+
+// trackingParam reports whether a query parameter name matches one of the
+// tracking parameter patterns
+func trackingParam(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,339 @@
+package cache
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+const (
+	// redisKeyPrefix namespaces the cache's keys, so deletes and stats only
+	// touch ours on a shared server
+	redisKeyPrefix = "url_fetcher:cache:"
+
+	// redisTimeout bounds each command, so a stalled server slows fetches
+	// down rather than hanging them
+	redisTimeout = 5 * time.Second
+
+	// redisScanCount is the batch size hinted to SCAN
+	redisScanCount = "500"
+)
+
+// RedisStore keeps entries in Redis or Valkey, so several instances share
+// one cache. Entries are stored as JSON and expire on the server once they
+// are of no further use, at their RetainUntil.
+type RedisStore struct {
+	address  string
+	username string
+	password string
+	db       int
+	tls      *tls.Config
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisStore connects to the server at rawURL, of the form
+// redis://[[user]:password@]host[:port][/db] (rediss:// for TLS)
+func NewRedisStore(rawURL string) (*RedisStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+
+	s := &RedisStore{}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		s.tls = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, fmt.Errorf("invalid Redis URL: unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid Redis URL: missing host")
+	}
+	port := u.Port()
+	if port == "" {
+		port = "6379"
+	}
+	s.address = net.JoinHostPort(u.Hostname(), port)
+	if u.User != nil {
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if s.db, err = strconv.Atoi(db); err != nil || s.db < 0 {
+			return nil, fmt.Errorf("invalid Redis URL: bad database %q", db)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns key's entry, or a miss if it's absent or the server fails
+func (s *RedisStore) Get(key string) (*types.CacheEntry, bool) {
+	reply, err := s.do("GET", redisKeyPrefix+key)
+	if err != nil {
+		log.Printf("Warning: failed to read cache entry from Redis: %v", err)
+		return nil, false
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, false
+	}
+	var stored diskEntry
+	if err := json.Unmarshal(data, &stored); err != nil || stored.Key != key || stored.Response == nil {
+		return nil, false
+	}
+	return &types.CacheEntry{Response: stored.Response, ExpiresAt: stored.ExpiresAt, RetainUntil: stored.RetainUntil}, true
+}
+
+// Set stores key's entry until it's of no further use
+func (s *RedisStore) Set(key string, entry *types.CacheEntry) {
+	data, err := json.Marshal(diskEntry{Key: key, ExpiresAt: entry.ExpiresAt, RetainUntil: entry.RetainUntil, Response: entry.Response})
+	if err != nil {
+		log.Printf("Warning: failed to store cache entry in Redis: %v", err)
+		return
+	}
+
+	ttl := max(time.Until(entry.RetainUntil).Milliseconds(), time.Until(entry.ExpiresAt).Milliseconds(), 1)
+
+	if _, err := s.do("SET", redisKeyPrefix+key, string(data), "PX", strconv.FormatInt(ttl, 10)); err != nil {
+		log.Printf("Warning: failed to store cache entry in Redis: %v", err)
+	}
+}
+
+// Delete removes key's entry
+func (s *RedisStore) Delete(key string) {
+	if _, err := s.do("DEL", redisKeyPrefix+key); err != nil {
+		log.Printf("Warning: failed to delete cache entry from Redis: %v", err)
+	}
+}
+
+// DeleteMatching removes the entries whose keys match accepts, returning
+// how many were removed. It scans every cache key on the server.
+func (s *RedisStore) DeleteMatching(match func(key string) bool) int {
+	removed := 0
+	err := s.scan(func(key string) error {
+		if !match(key) {
+			return nil
+		}
+		reply, err := s.do("DEL", redisKeyPrefix+key)
+		if n, ok := reply.(int64); ok {
+			removed += int(n)
+		}
+		return err
+	})
+	if err != nil {
+		log.Printf("Warning: failed to delete cache entries from Redis: %v", err)
+	}
+	return removed
+}
+
+// Stats counts the cache's keys on the server; their size isn't tracked
+func (s *RedisStore) Stats(stats *Stats) {
+	err := s.scan(func(string) error {
+		stats.Entries++
+		return nil
+	})
+	if err != nil {
+		log.Printf("Warning: failed to count cache entries in Redis: %v", err)
+	}
+}
+
+// scan calls fn with every cache key on the server, without its prefix
+func (s *RedisStore) scan(fn func(key string) error) error {
+	cursor := "0"
+	for {
+		reply, err := s.do("SCAN", cursor, "MATCH", redisKeyPrefix+"*", "COUNT", redisScanCount)
+		if err != nil {
+			return err
+		}
+		page, ok := reply.([]interface{})
+		if !ok || len(page) != 2 {
+			return fmt.Errorf("unexpected SCAN reply")
+		}
+		next, _ := page[0].([]byte)
+		keys, _ := page[1].([]interface{})
+		for _, key := range keys {
+			if name, ok := key.([]byte); ok {
+				if err := fn(strings.TrimPrefix(string(name), redisKeyPrefix)); err != nil {
+					return err
+				}
+			}
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+// Close closes the connection to the server
+func (s *RedisStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// do sends a command and returns its reply: a string, int64, []byte, nil
+// or []interface{} of those. A command that fails on a connection the
+// server has since closed, e.g. by restarting, is retried once on a new one.
+func (s *RedisStore) do(args ...string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reused := s.conn != nil
+	for {
+		if s.conn == nil {
+			if err := s.connect(); err != nil {
+				return nil, err
+			}
+		}
+		reply, err := s.roundTrip(args)
+		var replyErr redisError
+		if err == nil || errors.As(err, &replyErr) {
+			return reply, err
+		}
+		s.conn.Close()
+		s.conn = nil
+		if !reused {
+			return nil, err
+		}
+		reused = false
+	}
+}
+
+// connect dials the server, authenticates and selects the database; s.mu
+// must be held
+func (s *RedisStore) connect() error {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	var err error
+	if s.tls != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.address, s.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", s.address)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	s.conn = conn
+	s.reader = bufio.NewReader(conn)
+
+	var setup [][]string
+	if s.password != "" {
+		if s.username != "" {
+			setup = append(setup, []string{"AUTH", s.username, s.password})
+		} else {
+			setup = append(setup, []string{"AUTH", s.password})
+		}
+	}
+	if s.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.db)})
+	}
+	for _, args := range setup {
+		if _, err := s.roundTrip(args); err != nil {
+			conn.Close()
+			s.conn = nil
+			return fmt.Errorf("failed to set up Redis connection: %w", err)
+		}
+	}
+	return nil
+}
+
+// roundTrip writes a command and reads its reply; s.mu must be held
+func (s *RedisStore) roundTrip(args []string) (interface{}, error) {
+	s.conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(s.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return readReply(s.reader)
+}
+
+// readReply parses one RESP reply
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad bulk length %q", line[1:])
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad array length %q", line[1:])
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
@@ -0,0 +1,237 @@
+package compliance
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxListSize bounds how much of a remote blocklist is read
+const maxListSize = 64 * 1024 * 1024
+
+// Entry is one blocked domain or URL prefix
+type Entry struct {
+	Pattern      string `json:"pattern"`
+	Jurisdiction string `json:"jurisdiction,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+	Source       string `json:"source"`
+}
+
+// Status describes a loaded blocklist
+type Status struct {
+	Sources       []string  `json:"sources"`
+	Domains       int       `json:"domains"`
+	URLs          int       `json:"urls"`
+	Loaded        bool      `json:"loaded"`
+	LastRefreshed time.Time `json:"last_refreshed,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+// Blocklist rejects URLs whose host or prefix appears in one of its sources.
+// Sources are files or http(s) URLs in hosts or CSV format:
+//
+//	0.0.0.0 blocked.example        # hosts file
+//	blocked.example,DE,court order # CSV: domain or URL, jurisdiction, reason
+//
+// CSV rows with a jurisdiction apply only when that jurisdiction is enforced;
+// rows without one always apply.
+type Blocklist struct {
+	sources       []string
+	jurisdictions map[string]bool
+	client        *http.Client
+
+	mu            sync.RWMutex
+	domains       map[string]Entry
+	prefixes      []Entry
+	loaded        bool
+	lastRefreshed time.Time
+	lastError     error
+}
+
+// New creates a blocklist over sources, enforcing the given jurisdictions
+// (case-insensitive). Call Refresh to load it.
+func New(sources, jurisdictions []string, timeout time.Duration) *Blocklist {
+	enforced := make(map[string]bool, len(jurisdictions))
+	for _, j := range jurisdictions {
+		enforced[strings.ToUpper(strings.TrimSpace(j))] = true
+	}
+	return &Blocklist{
+		sources:       sources,
+		jurisdictions: enforced,
+		client:        &http.Client{Timeout: timeout},
+		domains:       make(map[string]Entry),
+	}
+}
+
+// Refresh reloads every source. If any source fails, the previous list is
+// kept so a flaky mirror can't silently lift blocks.
+func (b *Blocklist) Refresh() error {
+	domains := make(map[string]Entry)
+	var prefixes []Entry
+
+	for _, source := range b.sources {
+		data, err := b.read(source)
+		if err == nil {
+			err = b.parse(source, data, domains, &prefixes)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to load blocklist %s: %w", source, err)
+			b.mu.Lock()
+			b.lastError = err
+			b.mu.Unlock()
+			return err
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.domains = domains
+	b.prefixes = prefixes
+	b.loaded = true
+	b.lastRefreshed = time.Now()
+	b.lastError = nil
+	return nil
+}
+
+// Check returns the entry blocking rawURL, if any. ok is false when the list
+// has never loaded, since nothing can be vouched for then.
+func (b *Blocklist) Check(rawURL string) (entry Entry, blocked, ok bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if !b.loaded {
+		return Entry{}, false, false
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return Entry{}, false, true
+	}
+
+	// Walk up the labels so a blocked domain covers its subdomains
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+	for host != "" {
+		if entry, found := b.domains[host]; found {
+			return entry, true, true
+		}
+		_, parent, found := strings.Cut(host, ".")
+		if !found {
+			break
+		}
+		host = parent
+	}
+
+	normalized := strings.ToLower(parsed.Scheme + "://" + parsed.Host + parsed.EscapedPath())
+	for _, entry := range b.prefixes {
+		if strings.HasPrefix(normalized, entry.Pattern) {
+			return entry, true, true
+		}
+	}
+	return Entry{}, false, true
+}
+
+// Status reports what the blocklist currently holds
+func (b *Blocklist) Status() Status {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	status := Status{
+		Sources:       b.sources,
+		Domains:       len(b.domains),
+		URLs:          len(b.prefixes),
+		Loaded:        b.loaded,
+		LastRefreshed: b.lastRefreshed,
+	}
+	if b.lastError != nil {
+		status.LastError = b.lastError.Error()
+	}
+	return status
+}
+
+// read returns the contents of a file or remote source
+func (b *Blocklist) read(source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.ReadFile(source)
+	}
+
+	resp, err := b.client.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxListSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxListSize {
+		return nil, errors.New("list is too large")
+	}
+	return data, nil
+}
+
+// parse adds a source's entries. Lines with commas are CSV records; other
+// lines are hosts-file entries or bare domain names.
+func (b *Blocklist) parse(source string, data []byte, domains map[string]Entry, prefixes *[]Entry) error {
+	add := func(pattern, jurisdiction, reason string) {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		jurisdiction = strings.ToUpper(strings.TrimSpace(jurisdiction))
+		if pattern == "" || (jurisdiction != "" && !b.jurisdictions[jurisdiction]) {
+			return
+		}
+		entry := Entry{Pattern: pattern, Jurisdiction: jurisdiction, Reason: strings.TrimSpace(reason), Source: source}
+		if strings.Contains(pattern, "://") {
+			*prefixes = append(*prefixes, entry)
+			return
+		}
+		domains[strings.TrimSuffix(strings.TrimPrefix(pattern, "*."), ".")] = entry
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.Contains(line, ",") {
+			record, err := csv.NewReader(strings.NewReader(line)).Read()
+			if err != nil {
+				return fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			switch strings.ToLower(strings.TrimSpace(record[0])) {
+			case "domain", "url", "pattern":
+				continue // header row
+			}
+			record = append(record, "", "")
+			add(record[0], record[1], record[2])
+			continue
+		}
+
+		line, _, _ = strings.Cut(line, "#")
+		fields := strings.Fields(line)
+		// hosts files map an address to names; plain lists are one name per line
+		if len(fields) > 0 && net.ParseIP(fields[0]) != nil {
+			fields = fields[1:]
+		}
+		for _, name := range fields {
+			if name != "localhost" {
+				add(name, "", "")
+			}
+		}
+	}
+	return scanner.Err()
+}
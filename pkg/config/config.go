@@ -1,10 +1,20 @@
 package config
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/gomcpgo/url_fetcher/pkg/processor"
+	"github.com/gomcpgo/url_fetcher/pkg/proxy"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
 )
 
 // Config holds the configuration for the URL Fetcher MCP server
@@ -15,20 +25,285 @@ type Config struct {
 	// ChromePoolSize is the number of Chrome instances to keep in the pool
 	ChromePoolSize int
 	
+	// ChromeBlock lists resources Chrome doesn't load unless a request says
+	// otherwise, from types.BlockableResources
+	ChromeBlock []string
+
+	// ChromePath is the browser executable to launch; empty looks for
+	// Chrome, Chromium, Edge or Brave in the usual places
+	ChromePath string
+
+	// ChromeFlags are extra command line flags for the browser, such as
+	// --lang=de-DE, added after and overriding the built-in ones
+	ChromeFlags []string
+
+	// ChromeWSURL is a remote browser's DevTools endpoint to use instead of
+	// launching Chrome; ChromePath and ChromeFlags then don't apply
+	ChromeWSURL string
+	
 	// CacheTTL is the time-to-live for cached responses in seconds
 	CacheTTL time.Duration
 	
+	// CacheOriginTTL derives each entry's TTL from the origin's Cache-Control
+	// max-age or Expires header, within CacheMinTTL and CacheMaxTTL
+	CacheOriginTTL bool
+	CacheMinTTL    time.Duration
+	CacheMaxTTL    time.Duration
+	
+	// CacheMaxEntries and CacheMaxBytes bound the cache, which evicts the
+	// least recently used entries past them; 0 is unlimited
+	CacheMaxEntries int
+	CacheMaxBytes   int64
+	
+	// CacheCompression compresses the content of entries held in memory:
+	// none, gzip or zstd
+	CacheCompression string
+	
+	// CacheDir, if set, persists cached responses across restarts, keeping
+	// at most CacheDirMaxBytes of them (0 for no limit)
+	CacheDir         string
+	CacheDirMaxBytes int64
+	
+	// CacheRedisURL, if set, keeps the cache in Redis or Valkey instead of
+	// memory, shared by every instance pointed at it
+	CacheRedisURL string
+	
+	// TrackingParams are query parameters left out of cache keys, as glob
+	// patterns, so links differing only in them share an entry
+	TrackingParams []string
+	
+	// NegativeCacheTTL caches 404s, 410s and unresolvable hosts for this
+	// long, so repeated requests for dead URLs fail without a fetch; 0
+	// doesn't cache them
+	NegativeCacheTTL time.Duration
+	
+	// Offline answers fetch_url only from the cache and refuses every
+	// network fetch, for air-gapped demos and deterministic test runs
+	Offline bool
+	
+	// ServeStale returns a cached copy, even an expired one, in place of a
+	// fetch that timed out or got a 5xx; requests can override it
+	ServeStale bool
+	
 	// Timeout is the request timeout in seconds
 	Timeout time.Duration
+	
+	// ConnectTimeout bounds TCP connection establishment, separately from Timeout
+	ConnectTimeout time.Duration
+	
+	// DualStackFallbackDelay is how long to wait on the preferred address family
+	// before racing a connection on the other one (happy eyeballs). Zero means
+	// the standard library's default of 300ms; a negative value disables the
+	// race, so only the preferred family is tried.
+	DualStackFallbackDelay time.Duration
+	
+	// Defaults are request options applied when a caller omits them
+	Defaults RequestDefaults
+	
+	// DomainStatsFile, if set, persists per-domain statistics across restarts
+	DomainStatsFile string
+	
+	// HeadPreflight issues a HEAD before the first GET to each host and skips
+	// downloads that are too large or of an unprocessable type
+	HeadPreflight bool
+	
+	// ProfilesFile, if set, is a JSON file of per-site extraction profiles
+	ProfilesFile string
+	
+	// PresetsFile, if set, is a JSON file of named crawl presets
+	PresetsFile string
+	
+	// OutputDir is where crawl artifacts are written; empty disables them
+	OutputDir string
+	
+	// StreamThreshold is the body size past which the HTTP engines stream a
+	// response to a file in DownloadDir instead of holding it in memory; 0
+	// disables streaming
+	StreamThreshold int64
+	
+	// DownloadDir is where streamed bodies are written (default: the system
+	// temp directory)
+	DownloadDir string
+	
+	// MaxDownloadSize caps a streamed body; 0 is unlimited
+	MaxDownloadSize int64
+	
+	// BandwidthLimit caps the download rate, in bytes per second, across all
+	// fetches; 0 is unlimited
+	BandwidthLimit int64
+	
+	// MetricsAddr, if set, serves Prometheus metrics over HTTP at this address
+	MetricsAddr string
+	
+	// SessionsDir, if set, persists named session cookie jars across restarts
+	SessionsDir string
+	
+	// CookieFiles maps session names to browser cookie exports (Netscape
+	// cookies.txt or JSON) imported into them at startup
+	CookieFiles map[string]string
+	
+	// Proxies, if set, routes outbound requests from both engines through
+	// http://, https:// or socks5:// proxies
+	Proxies []string
+	
+	// ProxyRotation chooses among Proxies: "round-robin" or "sticky" (per domain)
+	ProxyRotation string
+	
+	// OAuth2 lists client-credentials grants used to authenticate requests to
+	// protected APIs, matched by domain
+	OAuth2 []OAuth2Client
+	
+	// LoginFlows are scripted browser logins that establish named sessions
+	LoginFlows []LoginFlow
+	
+	// MaxRedirects caps how many redirects the HTTP engines follow. Zero means
+	// types.DefaultMaxRedirects; a negative value disables following, so the
+	// redirect response itself is returned.
+	MaxRedirects int
+	
+	// MaxConcurrency caps fetches in flight across all tool calls and crawls;
+	// 0 means unlimited
+	MaxConcurrency int
+	
+	// MaxPerHost caps fetches in flight to any one host; 0 means unlimited
+	MaxPerHost int
+	
+	// HostRate caps requests per second to any one host, after an initial
+	// burst of HostBurst; 0 means unlimited
+	HostRate  float64
+	HostBurst int
+	
+	// Retry overrides fields of types.DefaultRetryPolicy for every fetch
+	Retry types.RetryPolicy
+	
+	// UAFallback lists user agent presets to retry with, in order, when the
+	// HTTP engine is refused with a 403
+	UAFallback []string
+	
+	// UARotation replaces the default user agent with one from UAPool:
+	// "request" cycles through the pool fetch by fetch, "domain" gives each
+	// domain its own; "" turns rotation off
+	UARotation string
+	
+	// UAPool is the user agents rotation picks from (default:
+	// types.UserAgentPool)
+	UAPool []string
+	
+	// EscalateChallenges retries with Chrome when the HTTP engines are
+	// served a bot challenge page
+	EscalateChallenges bool
+	
+	// SummarizerURL, if set, is an external service that writes summaries in
+	// place of the built-in extractive summarizer
+	SummarizerURL string
+	
+	// ResponseBudget is the most content characters a response returns before
+	// markdown is downgraded to an outline; 0 disables the check
+	ResponseBudget int
+	
+	// ProvenanceKey, if set, is the HMAC key used to sign provenance records
+	ProvenanceKey string
+	
+	// Blocklists are files or URLs of domains and URL prefixes that must never
+	// be fetched, in hosts or CSV format
+	Blocklists []string
+	
+	// BlocklistRefresh is how often Blocklists are reloaded; zero disables it
+	BlocklistRefresh time.Duration
+	
+	// Jurisdictions selects which jurisdiction-tagged blocklist rows apply
+	Jurisdictions []string
+	
+	// Schemes are the URL schemes accepted: http, https and data. Empty means
+	// http and https.
+	Schemes []string
+	
+	// AllowDomains, if set, restricts fetching to hosts matching one of these
+	// patterns: a domain (matching it and its subdomains) or a glob such as
+	// *.corp.com
+	AllowDomains []string
+	
+	// DenyDomains are host patterns, in the same form, that are never fetched
+	DenyDomains []string
+}
+
+// RequestDefaults holds deployment-wide defaults for fetch_url parameters.
+// Zero values fall back to the built-in defaults in pkg/types. Boolean
+// options have none, since a request's false can't be told from unset.
+type RequestDefaults struct {
+	Engine           string `json:"engine,omitempty"`
+	Format           string `json:"format,omitempty"`
+	MaxContentLength int    `json:"max_content_length,omitempty"`
+	XPath            string `json:"xpath,omitempty"`
+	UserAgent        string `json:"user_agent,omitempty"` // a preset name or a UA string
+	Device           string `json:"device,omitempty"`
+	SummarySentences int    `json:"summary_sentences,omitempty"`
+	ChunkSize        int    `json:"chunk_size,omitempty"`
+
+	// ResponseBudget stands in for FETCH_URL_RESPONSE_BUDGET when that isn't set
+	ResponseBudget int `json:"response_budget,omitempty"`
+}
+
+// OAuth2Client is a client-credentials grant for a set of domains. The client
+// ID and secret are read from the named environment variables.
+type OAuth2Client struct {
+	Domains         []string `json:"domains"`
+	TokenURL        string   `json:"token_url"`
+	ClientIDEnv     string   `json:"client_id_env"`
+	ClientSecretEnv string   `json:"client_secret_env"`
+	Scopes          []string `json:"scopes,omitempty"`
+	
+	// Resolved from the environment at load time
+	ClientID     string `json:"-"`
+	ClientSecret string `json:"-"`
+}
+
+// LoginFlow is a declarative form login run by the Chrome engine to fill a
+// session's cookie jar. Credentials are read from the named environment
+// variables, never from the conversation.
+type LoginFlow struct {
+	Session          string `json:"session"`
+	URL              string `json:"url"`
+	UsernameSelector string `json:"username_selector"`
+	UsernameEnv      string `json:"username_env"`
+	PasswordSelector string `json:"password_selector"`
+	PasswordEnv      string `json:"password_env"`
+	SubmitSelector   string `json:"submit_selector"`
+	WaitSelector     string `json:"wait_selector,omitempty"` // shown once logged in
+	AutoRelogin      bool   `json:"auto_relogin,omitempty"`  // rerun the flow when a fetch hits the login page
+	
+	// Resolved from the environment at load time
+	Username string `json:"-"`
+	Password string `json:"-"`
 }
 
 // LoadConfig loads configuration from environment variables with defaults
 func LoadConfig() (*Config, error) {
 	cfg := &Config{
-		BlockLocal:     true,
-		ChromePoolSize: 3,
-		CacheTTL:       time.Hour,
-		Timeout:        30 * time.Second,
+		BlockLocal:             true,
+		ChromePoolSize:         3,
+		CacheTTL:               time.Hour,
+		CacheMinTTL:            time.Minute,
+		CacheMaxTTL:            24 * time.Hour,
+		CacheMaxEntries:        10000,
+		CacheMaxBytes:          256 * 1024 * 1024,
+		CacheCompression:       "none",
+		CacheDirMaxBytes:       1024 * 1024 * 1024,
+		TrackingParams:         []string{"utm_*", "fbclid", "gclid"},
+		Timeout:                30 * time.Second,
+		ConnectTimeout:         10 * time.Second,
+		DualStackFallbackDelay: 300 * time.Millisecond,
+		ProxyRotation:          proxy.RotationRoundRobin,
+		BlocklistRefresh:       time.Hour,
+		ResponseBudget:         types.DefaultResponseBudget,
+		Schemes:                []string{"http", "https"},
+		MaxConcurrency:         16,
+		MaxPerHost:             4,
+		HostRate:               2,
+		HostBurst:              5,
+		EscalateChallenges:     true,
+		StreamThreshold:        5 * 1024 * 1024,
+		MaxDownloadSize:        1024 * 1024 * 1024,
 	}
 	
 	// FETCH_URL_BLOCK_LOCAL
@@ -52,6 +327,85 @@ func LoadConfig() (*Config, error) {
 		cfg.ChromePoolSize = poolSize
 	}
 	
+	// FETCH_URL_CHROME_BLOCK, e.g. image,font,media,analytics
+	if val := os.Getenv("FETCH_URL_CHROME_BLOCK"); val != "" {
+		for _, resource := range splitList(val) {
+			resource = strings.ToLower(resource)
+			if !slices.Contains(types.BlockableResources, resource) {
+				return nil, fmt.Errorf("invalid FETCH_URL_CHROME_BLOCK value: %s", resource)
+			}
+			cfg.ChromeBlock = append(cfg.ChromeBlock, resource)
+		}
+	}
+	
+	// FETCH_URL_CHROME_PATH, e.g. /opt/brave.com/brave/brave
+	if val := os.Getenv("FETCH_URL_CHROME_PATH"); val != "" {
+		cfg.ChromePath = val
+	}
+
+	// FETCH_URL_CHROME_FLAGS, e.g. --lang=de-DE --disable-extensions
+	if val := os.Getenv("FETCH_URL_CHROME_FLAGS"); val != "" {
+		for _, flag := range strings.Fields(val) {
+			if !strings.HasPrefix(flag, "--") || len(flag) == 2 || flag[2] == '=' {
+				return nil, fmt.Errorf("invalid FETCH_URL_CHROME_FLAGS value: %s", flag)
+			}
+			cfg.ChromeFlags = append(cfg.ChromeFlags, flag)
+		}
+	}
+
+	// FETCH_URL_CHROME_WS_URL, e.g. ws://chrome:3000 or http://127.0.0.1:9222
+	if val := os.Getenv("FETCH_URL_CHROME_WS_URL"); val != "" {
+		u, err := url.Parse(val)
+		if err != nil || u.Host == "" || !slices.Contains([]string{"ws", "wss", "http", "https"}, u.Scheme) {
+			return nil, fmt.Errorf("invalid FETCH_URL_CHROME_WS_URL value: %s", val)
+		}
+		cfg.ChromeWSURL = val
+	}
+
+	// FETCH_URL_MAX_CONCURRENCY / FETCH_URL_MAX_PER_HOST
+	for _, env := range []string{"FETCH_URL_MAX_CONCURRENCY", "FETCH_URL_MAX_PER_HOST"} {
+		val := os.Getenv(env)
+		if val == "" {
+			continue
+		}
+		limit, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value: %s", env, val)
+		}
+		if limit < 0 {
+			return nil, fmt.Errorf("%s must be non-negative", env)
+		}
+		if env == "FETCH_URL_MAX_CONCURRENCY" {
+			cfg.MaxConcurrency = limit
+		} else {
+			cfg.MaxPerHost = limit
+		}
+	}
+	
+	// FETCH_URL_HOST_RATE
+	if val := os.Getenv("FETCH_URL_HOST_RATE"); val != "" {
+		rate, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_HOST_RATE value: %s", val)
+		}
+		if rate < 0 {
+			return nil, fmt.Errorf("FETCH_URL_HOST_RATE must be non-negative")
+		}
+		cfg.HostRate = rate
+	}
+	
+	// FETCH_URL_HOST_BURST
+	if val := os.Getenv("FETCH_URL_HOST_BURST"); val != "" {
+		burst, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_HOST_BURST value: %s", val)
+		}
+		if burst < 1 {
+			return nil, fmt.Errorf("FETCH_URL_HOST_BURST must be at least 1")
+		}
+		cfg.HostBurst = burst
+	}
+	
 	// FETCH_URL_CACHE_TTL
 	if val := os.Getenv("FETCH_URL_CACHE_TTL"); val != "" {
 		ttlSeconds, err := strconv.Atoi(val)
@@ -64,6 +418,131 @@ func LoadConfig() (*Config, error) {
 		cfg.CacheTTL = time.Duration(ttlSeconds) * time.Second
 	}
 	
+	// FETCH_URL_CACHE_MAX_ENTRIES
+	if val := os.Getenv("FETCH_URL_CACHE_MAX_ENTRIES"); val != "" {
+		maxEntries, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_CACHE_MAX_ENTRIES value: %s", val)
+		}
+		if maxEntries < 0 {
+			return nil, fmt.Errorf("FETCH_URL_CACHE_MAX_ENTRIES must be non-negative")
+		}
+		cfg.CacheMaxEntries = maxEntries
+	}
+	
+	// FETCH_URL_CACHE_MAX_BYTES
+	if val := os.Getenv("FETCH_URL_CACHE_MAX_BYTES"); val != "" {
+		maxBytes, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_CACHE_MAX_BYTES value: %s", val)
+		}
+		if maxBytes < 0 {
+			return nil, fmt.Errorf("FETCH_URL_CACHE_MAX_BYTES must be non-negative")
+		}
+		cfg.CacheMaxBytes = maxBytes
+	}
+	
+	// FETCH_URL_CACHE_DIR
+	cfg.CacheDir = os.Getenv("FETCH_URL_CACHE_DIR")
+	
+	// FETCH_URL_CACHE_DIR_MAX_BYTES
+	if val := os.Getenv("FETCH_URL_CACHE_DIR_MAX_BYTES"); val != "" {
+		maxBytes, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_CACHE_DIR_MAX_BYTES value: %s", val)
+		}
+		if maxBytes < 0 {
+			return nil, fmt.Errorf("FETCH_URL_CACHE_DIR_MAX_BYTES must be non-negative")
+		}
+		cfg.CacheDirMaxBytes = maxBytes
+	}
+	
+	// FETCH_URL_CACHE_COMPRESSION
+	if val := os.Getenv("FETCH_URL_CACHE_COMPRESSION"); val != "" {
+		switch val {
+		case "none", "gzip", "zstd":
+			cfg.CacheCompression = val
+		default:
+			return nil, fmt.Errorf("invalid FETCH_URL_CACHE_COMPRESSION value: %s", val)
+		}
+	}
+	
+	// FETCH_URL_CACHE_REDIS_URL
+	cfg.CacheRedisURL = os.Getenv("FETCH_URL_CACHE_REDIS_URL")
+	
+	// FETCH_URL_TRACKING_PARAMS, e.g. utm_*,fbclid,gclid; set but empty keeps
+	// every parameter
+	if val, ok := os.LookupEnv("FETCH_URL_TRACKING_PARAMS"); ok {
+		cfg.TrackingParams = splitList(val)
+		for _, pattern := range cfg.TrackingParams {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid FETCH_URL_TRACKING_PARAMS value: %s", pattern)
+			}
+		}
+	}
+	
+	// FETCH_URL_NEGATIVE_CACHE_TTL, in seconds
+	if val := os.Getenv("FETCH_URL_NEGATIVE_CACHE_TTL"); val != "" {
+		seconds, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_NEGATIVE_CACHE_TTL value: %s", val)
+		}
+		if seconds < 0 {
+			return nil, fmt.Errorf("FETCH_URL_NEGATIVE_CACHE_TTL must be non-negative")
+		}
+		cfg.NegativeCacheTTL = time.Duration(seconds) * time.Second
+	}
+	
+	// FETCH_URL_OFFLINE
+	if val := os.Getenv("FETCH_URL_OFFLINE"); val != "" {
+		offline, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_OFFLINE value: %s", val)
+		}
+		cfg.Offline = offline
+	}
+	
+	// FETCH_URL_SERVE_STALE
+	if val := os.Getenv("FETCH_URL_SERVE_STALE"); val != "" {
+		serveStale, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_SERVE_STALE value: %s", val)
+		}
+		cfg.ServeStale = serveStale
+	}
+	
+	// FETCH_URL_CACHE_ORIGIN_TTL
+	if val := os.Getenv("FETCH_URL_CACHE_ORIGIN_TTL"); val != "" {
+		originTTL, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_CACHE_ORIGIN_TTL value: %s", val)
+		}
+		cfg.CacheOriginTTL = originTTL
+	}
+	
+	// FETCH_URL_CACHE_MIN_TTL / FETCH_URL_CACHE_MAX_TTL, in seconds
+	for _, env := range []string{"FETCH_URL_CACHE_MIN_TTL", "FETCH_URL_CACHE_MAX_TTL"} {
+		val := os.Getenv(env)
+		if val == "" {
+			continue
+		}
+		seconds, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value: %s", env, val)
+		}
+		if seconds < 0 {
+			return nil, fmt.Errorf("%s must be non-negative", env)
+		}
+		if env == "FETCH_URL_CACHE_MIN_TTL" {
+			cfg.CacheMinTTL = time.Duration(seconds) * time.Second
+		} else {
+			cfg.CacheMaxTTL = time.Duration(seconds) * time.Second
+		}
+	}
+	if cfg.CacheMaxTTL > 0 && cfg.CacheMinTTL > cfg.CacheMaxTTL {
+		return nil, fmt.Errorf("FETCH_URL_CACHE_MIN_TTL must not exceed FETCH_URL_CACHE_MAX_TTL")
+	}
+	
 	// FETCH_URL_TIMEOUT
 	if val := os.Getenv("FETCH_URL_TIMEOUT"); val != "" {
 		timeoutSeconds, err := strconv.Atoi(val)
@@ -76,5 +555,429 @@ func LoadConfig() (*Config, error) {
 		cfg.Timeout = time.Duration(timeoutSeconds) * time.Second
 	}
 	
+	// FETCH_URL_CONNECT_TIMEOUT
+	if val := os.Getenv("FETCH_URL_CONNECT_TIMEOUT"); val != "" {
+		connectSeconds, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_CONNECT_TIMEOUT value: %s", val)
+		}
+		if connectSeconds < 1 || connectSeconds > 300 {
+			return nil, fmt.Errorf("FETCH_URL_CONNECT_TIMEOUT must be between 1 and 300 seconds")
+		}
+		cfg.ConnectTimeout = time.Duration(connectSeconds) * time.Second
+	}
+	
+	// FETCH_URL_DUAL_STACK_FALLBACK_MS
+	if val := os.Getenv("FETCH_URL_DUAL_STACK_FALLBACK_MS"); val != "" {
+		fallbackMs, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_DUAL_STACK_FALLBACK_MS value: %s", val)
+		}
+		cfg.DualStackFallbackDelay = time.Duration(fallbackMs) * time.Millisecond
+	}
+	
+	// FETCH_URL_MAX_REDIRECTS; 0 returns redirects without following them
+	if val := os.Getenv("FETCH_URL_MAX_REDIRECTS"); val != "" {
+		maxRedirects, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_MAX_REDIRECTS value: %s", val)
+		}
+		if maxRedirects < 0 || maxRedirects > 50 {
+			return nil, fmt.Errorf("FETCH_URL_MAX_REDIRECTS must be between 0 and 50")
+		}
+		cfg.MaxRedirects = maxRedirects
+		if maxRedirects == 0 {
+			cfg.MaxRedirects = -1
+		}
+	}
+	
+	// FETCH_URL_UA_FALLBACK, e.g. curl,googlebot
+	for _, profile := range splitList(os.Getenv("FETCH_URL_UA_FALLBACK")) {
+		profile = strings.ToLower(profile)
+		if _, ok := types.UserAgentPresets[profile]; !ok {
+			return nil, fmt.Errorf("invalid FETCH_URL_UA_FALLBACK value: unknown user agent preset %s", profile)
+		}
+		cfg.UAFallback = append(cfg.UAFallback, profile)
+	}
+	
+	// FETCH_URL_UA_ROTATION
+	switch val := strings.ToLower(os.Getenv("FETCH_URL_UA_ROTATION")); val {
+	case "", "off":
+	case "request", "domain":
+		cfg.UARotation = val
+	default:
+		return nil, fmt.Errorf("invalid FETCH_URL_UA_ROTATION value: %s (use request, domain or off)", val)
+	}
+	
+	// FETCH_URL_UA_POOL_FILE, one user agent per line
+	if path := os.Getenv("FETCH_URL_UA_POOL_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read FETCH_URL_UA_POOL_FILE: %w", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				cfg.UAPool = append(cfg.UAPool, line)
+			}
+		}
+		if len(cfg.UAPool) == 0 {
+			return nil, fmt.Errorf("FETCH_URL_UA_POOL_FILE lists no user agents")
+		}
+	}
+	
+	// FETCH_URL_ESCALATE_CHALLENGES
+	if val := os.Getenv("FETCH_URL_ESCALATE_CHALLENGES"); val != "" {
+		escalate, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_ESCALATE_CHALLENGES value: %s", val)
+		}
+		cfg.EscalateChallenges = escalate
+	}
+	
+	// FETCH_URL_SUMMARIZER_URL, e.g. http://127.0.0.1:8081/summarize
+	cfg.SummarizerURL = os.Getenv("FETCH_URL_SUMMARIZER_URL")
+	
+	// FETCH_URL_RESPONSE_BUDGET
+	if val := os.Getenv("FETCH_URL_RESPONSE_BUDGET"); val != "" {
+		budget, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_RESPONSE_BUDGET value: %s", val)
+		}
+		if budget < 0 {
+			return nil, fmt.Errorf("FETCH_URL_RESPONSE_BUDGET must be non-negative")
+		}
+		cfg.ResponseBudget = budget
+	}
+	
+	// FETCH_URL_DEFAULTS, e.g. {"format":"markdown","max_content_length":500000}
+	if val := os.Getenv("FETCH_URL_DEFAULTS"); val != "" {
+		defaults, err := parseRequestDefaults(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_DEFAULTS value: %w", err)
+		}
+		cfg.Defaults = defaults
+		if defaults.ResponseBudget > 0 && os.Getenv("FETCH_URL_RESPONSE_BUDGET") == "" {
+			cfg.ResponseBudget = defaults.ResponseBudget
+		}
+	}
+	
+	// FETCH_URL_RETRY, e.g. {"max_attempts":5,"base_delay_ms":500,"status_codes":[429,503]}
+	if val := os.Getenv("FETCH_URL_RETRY"); val != "" {
+		decoder := json.NewDecoder(strings.NewReader(val))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&cfg.Retry); err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_RETRY value: %w", err)
+		}
+		if err := cfg.Retry.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_RETRY value: %w", err)
+		}
+	}
+	
+	// FETCH_URL_DOMAIN_STATS_FILE
+	cfg.DomainStatsFile = os.Getenv("FETCH_URL_DOMAIN_STATS_FILE")
+	
+	// FETCH_URL_HEAD_PREFLIGHT
+	if val := os.Getenv("FETCH_URL_HEAD_PREFLIGHT"); val != "" {
+		preflight, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_HEAD_PREFLIGHT value: %s", val)
+		}
+		cfg.HeadPreflight = preflight
+	}
+	
+	// FETCH_URL_PROFILES_FILE
+	cfg.ProfilesFile = os.Getenv("FETCH_URL_PROFILES_FILE")
+	
+	// FETCH_URL_PRESETS_FILE
+	cfg.PresetsFile = os.Getenv("FETCH_URL_PRESETS_FILE")
+	
+	// FETCH_URL_OUTPUT_DIR
+	cfg.OutputDir = os.Getenv("FETCH_URL_OUTPUT_DIR")
+	
+	// FETCH_URL_STREAM_THRESHOLD / FETCH_URL_MAX_DOWNLOAD_SIZE, in bytes
+	for _, env := range []string{"FETCH_URL_STREAM_THRESHOLD", "FETCH_URL_MAX_DOWNLOAD_SIZE"} {
+		val := os.Getenv(env)
+		if val == "" {
+			continue
+		}
+		size, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value: %s", env, val)
+		}
+		if size < 0 {
+			return nil, fmt.Errorf("%s must be non-negative", env)
+		}
+		if env == "FETCH_URL_STREAM_THRESHOLD" {
+			cfg.StreamThreshold = size
+		} else {
+			cfg.MaxDownloadSize = size
+		}
+	}
+	
+	// FETCH_URL_BANDWIDTH_LIMIT, in bytes per second
+	if val := os.Getenv("FETCH_URL_BANDWIDTH_LIMIT"); val != "" {
+		limit, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_BANDWIDTH_LIMIT value: %s", val)
+		}
+		if limit < 0 {
+			return nil, fmt.Errorf("FETCH_URL_BANDWIDTH_LIMIT must be non-negative")
+		}
+		cfg.BandwidthLimit = limit
+	}
+	
+	// FETCH_URL_DOWNLOAD_DIR
+	cfg.DownloadDir = os.Getenv("FETCH_URL_DOWNLOAD_DIR")
+	
+	// FETCH_URL_METRICS_ADDR, e.g. 127.0.0.1:9464
+	cfg.MetricsAddr = os.Getenv("FETCH_URL_METRICS_ADDR")
+	
+	// FETCH_URL_SESSIONS_DIR
+	cfg.SessionsDir = os.Getenv("FETCH_URL_SESSIONS_DIR")
+	
+	// FETCH_URL_COOKIE_FILES, e.g. jira=/secrets/jira-cookies.txt,wiki=/secrets/wiki.json
+	if val := os.Getenv("FETCH_URL_COOKIE_FILES"); val != "" {
+		cfg.CookieFiles = make(map[string]string)
+		for _, entry := range strings.Split(val, ",") {
+			name, path, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok || name == "" || path == "" {
+				return nil, fmt.Errorf("invalid FETCH_URL_COOKIE_FILES value: %s", entry)
+			}
+			cfg.CookieFiles[name] = path
+		}
+	}
+	
+	// FETCH_URL_PROXY, e.g. http://proxy.corp:3128 or a comma-separated pool
+	if val := os.Getenv("FETCH_URL_PROXY"); val != "" {
+		for _, raw := range strings.Split(val, ",") {
+			raw = strings.TrimSpace(raw)
+			if raw == "" {
+				continue
+			}
+			if _, err := proxy.ParseURL(raw); err != nil {
+				return nil, fmt.Errorf("invalid FETCH_URL_PROXY value: %s", raw)
+			}
+			cfg.Proxies = append(cfg.Proxies, raw)
+		}
+	}
+	
+	// FETCH_URL_OAUTH2, e.g. [{"domains":["api.corp.com"],"token_url":"https://auth.corp.com/token",
+	// "client_id_env":"CORP_CLIENT_ID","client_secret_env":"CORP_CLIENT_SECRET"}]
+	if val := os.Getenv("FETCH_URL_OAUTH2"); val != "" {
+		clients, err := parseOAuth2Clients(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_OAUTH2 value: %w", err)
+		}
+		cfg.OAuth2 = clients
+	}
+	
+	// FETCH_URL_LOGIN_FLOWS, e.g. [{"session":"wiki","url":"https://wiki.corp.com/login",
+	// "username_selector":"#user","username_env":"WIKI_USER","password_selector":"#pass",
+	// "password_env":"WIKI_PASS","submit_selector":"button[type=submit]","wait_selector":"#dashboard"}]
+	if val := os.Getenv("FETCH_URL_LOGIN_FLOWS"); val != "" {
+		flows, err := parseLoginFlows(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_LOGIN_FLOWS value: %w", err)
+		}
+		cfg.LoginFlows = flows
+	}
+	
+	// FETCH_URL_PROVENANCE_KEY
+	cfg.ProvenanceKey = os.Getenv("FETCH_URL_PROVENANCE_KEY")
+	
+	// FETCH_URL_BLOCKLISTS, e.g. /etc/url_fetcher/blocked.hosts,https://compliance.corp.com/blocked.csv
+	cfg.Blocklists = splitList(os.Getenv("FETCH_URL_BLOCKLISTS"))
+	
+	// FETCH_URL_BLOCKLIST_REFRESH
+	if val := os.Getenv("FETCH_URL_BLOCKLIST_REFRESH"); val != "" {
+		refreshSeconds, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCH_URL_BLOCKLIST_REFRESH value: %s", val)
+		}
+		if refreshSeconds < 0 {
+			return nil, fmt.Errorf("FETCH_URL_BLOCKLIST_REFRESH must be non-negative")
+		}
+		cfg.BlocklistRefresh = time.Duration(refreshSeconds) * time.Second
+	}
+	
+	// FETCH_URL_JURISDICTIONS, e.g. DE,EU
+	cfg.Jurisdictions = splitList(os.Getenv("FETCH_URL_JURISDICTIONS"))
+	
+	// FETCH_URL_SCHEMES, e.g. https for https-only, or http,https,data
+	if val := os.Getenv("FETCH_URL_SCHEMES"); val != "" {
+		cfg.Schemes = nil
+		for _, scheme := range splitList(val) {
+			scheme = strings.ToLower(scheme)
+			if scheme != "http" && scheme != "https" && scheme != "data" {
+				return nil, fmt.Errorf("invalid FETCH_URL_SCHEMES value: unsupported scheme %s", scheme)
+			}
+			cfg.Schemes = append(cfg.Schemes, scheme)
+		}
+		if len(cfg.Schemes) == 0 {
+			return nil, fmt.Errorf("FETCH_URL_SCHEMES must list at least one scheme")
+		}
+	}
+	
+	// FETCH_URL_ALLOW_DOMAINS / FETCH_URL_DENY_DOMAINS, e.g. docs.corp.com,*.wikipedia.org
+	for _, env := range []string{"FETCH_URL_ALLOW_DOMAINS", "FETCH_URL_DENY_DOMAINS"} {
+		patterns, err := parseDomainPatterns(os.Getenv(env))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value: %w", env, err)
+		}
+		if env == "FETCH_URL_ALLOW_DOMAINS" {
+			cfg.AllowDomains = patterns
+		} else {
+			cfg.DenyDomains = patterns
+		}
+	}
+	
+	// FETCH_URL_PROXY_ROTATION
+	if val := os.Getenv("FETCH_URL_PROXY_ROTATION"); val != "" {
+		if val != proxy.RotationRoundRobin && val != proxy.RotationSticky {
+			return nil, fmt.Errorf("invalid FETCH_URL_PROXY_ROTATION value: %s", val)
+		}
+		cfg.ProxyRotation = val
+	}
+	
+	// The connect phase can never outlast the whole request
+	if cfg.ConnectTimeout > cfg.Timeout {
+		cfg.ConnectTimeout = cfg.Timeout
+	}
+	
 	return cfg, nil
-}
\ No newline at end of file
+}
+
+// splitList splits a comma-separated value, dropping empty items
+func splitList(val string) []string {
+	var items []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseDomainPatterns splits a list of domain patterns, normalizing them to
+// lowercase without leading or trailing dots and checking glob syntax
+func parseDomainPatterns(val string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range splitList(val) {
+		pattern = strings.Trim(strings.ToLower(pattern), ".")
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%s: %w", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// parseRequestDefaults decodes and validates the FETCH_URL_DEFAULTS JSON object
+func parseRequestDefaults(val string) (RequestDefaults, error) {
+	var defaults RequestDefaults
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(val)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&defaults); err != nil {
+		return defaults, err
+	}
+
+	switch defaults.Engine {
+	case "", types.EngineHTTP, types.EngineHTTP3, types.EngineChrome:
+	default:
+		return defaults, fmt.Errorf("unsupported engine: %s", defaults.Engine)
+	}
+
+	switch defaults.Format {
+	case "", types.FormatText, types.FormatHTML, types.FormatMarkdown, types.FormatArticle:
+	default:
+		return defaults, fmt.Errorf("unsupported format: %s", defaults.Format)
+	}
+
+	if defaults.MaxContentLength < 0 {
+		return defaults, fmt.Errorf("max_content_length must be non-negative")
+	}
+
+	if defaults.XPath != "" {
+		if err := processor.ValidateXPath(defaults.XPath); err != nil {
+			return defaults, err
+		}
+	}
+
+	if _, ok := types.DevicePresets[strings.ToLower(defaults.Device)]; defaults.Device != "" && !ok {
+		return defaults, fmt.Errorf("unknown device: %s", defaults.Device)
+	}
+
+	if defaults.SummarySentences < 0 || defaults.SummarySentences > types.MaxSummarySentences {
+		return defaults, fmt.Errorf("summary_sentences must be between 0 and %d", types.MaxSummarySentences)
+	}
+
+	if defaults.ChunkSize < 0 || defaults.ResponseBudget < 0 {
+		return defaults, fmt.Errorf("chunk_size and response_budget must be non-negative")
+	}
+
+	return defaults, nil
+}
+
+// parseOAuth2Clients decodes the FETCH_URL_OAUTH2 JSON array and resolves
+// each client's credentials from the environment
+func parseOAuth2Clients(val string) ([]OAuth2Client, error) {
+	var clients []OAuth2Client
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(val)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&clients); err != nil {
+		return nil, err
+	}
+
+	for i := range clients {
+		c := &clients[i]
+		if len(c.Domains) == 0 {
+			return nil, fmt.Errorf("client %d has no domains", i)
+		}
+		if c.TokenURL == "" {
+			return nil, fmt.Errorf("client %d has no token_url", i)
+		}
+		c.ClientID = os.Getenv(c.ClientIDEnv)
+		c.ClientSecret = os.Getenv(c.ClientSecretEnv)
+		if c.ClientID == "" || c.ClientSecret == "" {
+			return nil, fmt.Errorf("client %d: %s and %s must be set", i, c.ClientIDEnv, c.ClientSecretEnv)
+		}
+	}
+
+	return clients, nil
+}
+
+// parseLoginFlows decodes the FETCH_URL_LOGIN_FLOWS JSON array and resolves
+// each flow's credentials from the environment
+func parseLoginFlows(val string) ([]LoginFlow, error) {
+	var flows []LoginFlow
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(val)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&flows); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for i := range flows {
+		flow := &flows[i]
+		if flow.Session == "" || flow.URL == "" {
+			return nil, fmt.Errorf("flow %d needs a session and url", i)
+		}
+		if seen[flow.Session] {
+			return nil, fmt.Errorf("duplicate flow for session %s", flow.Session)
+		}
+		seen[flow.Session] = true
+		if flow.UsernameSelector == "" || flow.PasswordSelector == "" || flow.SubmitSelector == "" {
+			return nil, fmt.Errorf("flow %s needs username_selector, password_selector and submit_selector", flow.Session)
+		}
+		flow.Username = os.Getenv(flow.UsernameEnv)
+		flow.Password = os.Getenv(flow.PasswordEnv)
+		if flow.Username == "" || flow.Password == "" {
+			return nil, fmt.Errorf("flow %s: %s and %s must be set", flow.Session, flow.UsernameEnv, flow.PasswordEnv)
+		}
+	}
+
+	return flows, nil
+}
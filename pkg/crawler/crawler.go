@@ -0,0 +1,295 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// Crawl limits. Budgets default to the Default values and can't be raised
+// past the Max ones, so every crawl ends.
+const (
+	DefaultMaxPages    = 50
+	MaxPages           = 1000
+	MaxDepth           = 5
+	DefaultMaxBytes    = 50 * 1024 * 1024
+	MaxBytes           = 1024 * 1024 * 1024
+	DefaultMaxDuration = 10 * time.Minute
+	MaxDuration        = time.Hour
+)
+
+// Reasons a crawl stopped
+const (
+	StopCompleted   = "completed"
+	StopMaxPages    = "max_pages"
+	StopMaxBytes    = "max_bytes"
+	StopMaxDuration = "max_duration"
+	StopCancelled   = "cancelled"
+)
+
+// Spec describes a crawl: where it starts, how far it follows links and
+// which pages it keeps. With Depth 0 only the seeds are fetched, which makes
+// it a batch fetch.
+type Spec struct {
+	Seeds    []string `json:"seeds"`
+	Depth    int      `json:"depth,omitempty"`
+	Include  []string `json:"include,omitempty"` // filters a URL must match one of
+	Exclude  []string `json:"exclude,omitempty"` // filters a URL must match none of
+	Format   string   `json:"format,omitempty"`
+	Engine   string   `json:"engine,omitempty"`
+	MaxPages int      `json:"max_pages,omitempty"`
+	Output   string   `json:"output,omitempty"` // JSON Lines artifact, relative to the output directory
+
+	// MaxBytes caps the bytes downloaded across all pages
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+
+	// MaxDurationSeconds caps the crawl's wall-clock time; a fetch still in
+	// flight when it runs out is abandoned
+	MaxDurationSeconds int `json:"max_duration_seconds,omitempty"`
+}
+
+// Page is the outcome of fetching one URL. Parent and AnchorText record the
+// link it was first discovered through; seeds have neither.
+type Page struct {
+	URL           string `json:"url"`
+	Depth         int    `json:"depth"`
+	Parent        string `json:"parent,omitempty"`
+	AnchorText    string `json:"anchor_text,omitempty"`
+	StatusCode    int    `json:"status_code,omitempty"`
+	Title         string `json:"title,omitempty"`
+	ContentLength int    `json:"content_length"`
+	Bytes         int64  `json:"bytes"`               // received over the network, before decompression
+	RenderMs      int64  `json:"render_ms,omitempty"` // Chrome render time
+	Content       string `json:"content,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Result summarizes a crawl
+type Result struct {
+	Pages      []Page `json:"pages"`
+	Fetched    int    `json:"fetched"`
+	Failed     int    `json:"failed"`
+	Filtered   int    `json:"filtered"` // URLs left out by the include/exclude filters
+	Bytes      int64  `json:"bytes"`
+	DurationMs int64  `json:"duration_ms"`
+	StopReason string `json:"stop_reason"`
+	Output     string `json:"output,omitempty"`
+	Usage      Usage  `json:"usage"`
+}
+
+// Usage is what a crawl consumed, for enforcing quotas and attributing
+// costs: bytes received over the network (including failed pages), bytes
+// written to its output artifact and seconds of Chrome rendering
+type Usage struct {
+	NetworkBytes  int64   `json:"network_bytes"`
+	DiskBytes     int64   `json:"disk_bytes"`
+	RenderSeconds float64 `json:"render_seconds"`
+}
+
+// add counts a page's usage
+func (u *Usage) add(page Page) {
+	u.NetworkBytes += page.Bytes
+	u.RenderSeconds += float64(page.RenderMs) / 1000
+}
+
+// FetchFunc fetches and processes one URL, returning the page and the
+// absolute links found on it
+type FetchFunc func(ctx context.Context, rawURL string) (Page, []types.Link)
+
+// Validate checks a spec and fills in its defaults
+func (s *Spec) Validate() error {
+	if len(s.Seeds) == 0 {
+		return fmt.Errorf("at least one seed URL is required")
+	}
+	for _, seed := range s.Seeds {
+		if u, err := url.Parse(seed); err != nil || u.Host == "" {
+			return fmt.Errorf("invalid seed URL: %s", seed)
+		}
+	}
+	if s.Depth < 0 || s.Depth > MaxDepth {
+		return fmt.Errorf("depth must be between 0 and %d", MaxDepth)
+	}
+	if s.MaxPages == 0 {
+		s.MaxPages = DefaultMaxPages
+	}
+	if s.MaxPages < 0 || s.MaxPages > MaxPages {
+		return fmt.Errorf("max_pages must be between 1 and %d", MaxPages)
+	}
+	if s.MaxBytes == 0 {
+		s.MaxBytes = DefaultMaxBytes
+	}
+	if s.MaxBytes < 0 || s.MaxBytes > MaxBytes {
+		return fmt.Errorf("max_bytes must be between 1 and %d", MaxBytes)
+	}
+	if s.MaxDurationSeconds == 0 {
+		s.MaxDurationSeconds = int(DefaultMaxDuration.Seconds())
+	}
+	if s.MaxDurationSeconds < 0 || s.MaxDurationSeconds > int(MaxDuration.Seconds()) {
+		return fmt.Errorf("max_duration_seconds must be between 1 and %d", int(MaxDuration.Seconds()))
+	}
+	if _, err := parseFilters(s.Include); err != nil {
+		return err
+	}
+	if _, err := parseFilters(s.Exclude); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Run crawls breadth-first from the seeds, staying on the seeds' hosts,
+// until every reachable page within Depth is fetched or a budget runs out.
+// The include and exclude filters scope which links are followed; in a batch
+// (Depth 0) they apply to the seeds instead. onPage, if non-nil, receives
+// each page as soon as it is fetched.
+func Run(ctx context.Context, spec Spec, fetch FetchFunc, onPage func(Page)) (*Result, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	include, _ := parseFilters(spec.Include)
+	exclude, _ := parseFilters(spec.Exclude)
+	result := &Result{StopReason: StopCompleted}
+
+	start := time.Now()
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(spec.MaxDurationSeconds)*time.Second)
+	defer cancel()
+	defer func() { result.DurationMs = time.Since(start).Milliseconds() }()
+
+	hosts := make(map[string]bool)
+	seen := make(map[string]bool)
+	type queued struct {
+		url    string
+		depth  int
+		parent string
+		text   string
+	}
+	var queue []queued
+	for _, seed := range spec.Seeds {
+		u, _ := url.Parse(seed)
+		hosts[strings.ToLower(u.Hostname())] = true
+		key := normalize(seed)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if spec.Depth == 0 && !allowed(u, include, exclude) {
+			result.Filtered++
+			continue
+		}
+		queue = append(queue, queued{url: seed})
+	}
+
+	for len(queue) > 0 {
+		if reason := stopReason(parent, ctx); reason != "" {
+			result.StopReason = reason
+			break
+		}
+		if len(result.Pages) >= spec.MaxPages {
+			result.StopReason = StopMaxPages
+			break
+		}
+		if result.Bytes >= spec.MaxBytes {
+			result.StopReason = StopMaxBytes
+			break
+		}
+
+		next := queue[0]
+		queue = queue[1:]
+
+		page, links := fetch(ctx, next.url)
+		if reason := stopReason(parent, ctx); reason != "" {
+			// The fetch was cut short, so its page is incomplete
+			result.StopReason = reason
+			break
+		}
+		page.URL = next.url
+		page.Depth = next.depth
+		page.Parent = next.parent
+		page.AnchorText = next.text
+		if page.Error != "" {
+			result.Failed++
+		} else {
+			result.Fetched++
+		}
+		result.Bytes += page.Bytes
+		result.Usage.add(page)
+		result.Pages = append(result.Pages, page)
+		if onPage != nil {
+			onPage(page)
+		}
+
+		if next.depth >= spec.Depth {
+			continue
+		}
+		for _, link := range links {
+			u, err := url.Parse(link.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || !hosts[strings.ToLower(u.Hostname())] {
+				continue
+			}
+			key := normalize(link.URL)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if !allowed(u, include, exclude) {
+				result.Filtered++
+				continue
+			}
+			queue = append(queue, queued{url: link.URL, depth: next.depth + 1, parent: next.url, text: link.Text})
+		}
+	}
+
+	return result, nil
+}
+
+// stopReason reports why the crawl context ended, if it has: the caller
+// cancelled it or the duration budget ran out
+func stopReason(parent, ctx context.Context) string {
+	switch {
+	case parent.Err() != nil:
+		return StopCancelled
+	case ctx.Err() != nil:
+		return StopMaxDuration
+	default:
+		return ""
+	}
+}
+
+// normalize returns the key a URL is deduplicated by: without its fragment
+func normalize(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u.String()
+}
+
+// LoadPresets reads named crawl specs from a JSON file containing an object
+// of preset names to Spec objects
+func LoadPresets(filePath string) (map[string]Spec, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read presets: %w", err)
+	}
+
+	var presets map[string]Spec
+	if err := json.Unmarshal(data, &presets); err != nil {
+		return nil, fmt.Errorf("failed to parse presets: %w", err)
+	}
+
+	for name, spec := range presets {
+		if err := spec.Validate(); err != nil {
+			return nil, fmt.Errorf("preset %s: %w", name, err)
+		}
+	}
+
+	return presets, nil
+}
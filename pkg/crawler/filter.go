@@ -0,0 +1,137 @@
+package crawler
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// filter is one include or exclude pattern. Patterns take these forms:
+//
+//	re:EXPR           regular expression searched for in the full URL
+//	query             any URL with a query string
+//	query:NAME        URLs with the query parameter NAME
+//	https://host/**   glob matched against the full URL
+//	/docs/**          glob matched against the path; without wildcards, a
+//	                  path prefix: /blog matches /blog and /blog/post
+//	*.pdf             glob matched against the last path segment
+//
+// In globs, * and ? stay within one path segment and ** crosses segments.
+type filter struct {
+	re     *regexp.Regexp
+	target string // "url", "path", "segment" or "prefix"
+	param  string // for query filters: "" for any query, else the parameter
+	query  bool
+	prefix string
+}
+
+// parseFilters parses include or exclude patterns
+func parseFilters(patterns []string) ([]filter, error) {
+	filters := make([]filter, 0, len(patterns))
+	for _, pattern := range patterns {
+		f, err := parseFilter(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+func parseFilter(pattern string) (filter, error) {
+	switch {
+	case pattern == "":
+		return filter{}, fmt.Errorf("empty pattern")
+	case strings.HasPrefix(pattern, "re:"):
+		re, err := regexp.Compile(pattern[3:])
+		if err != nil {
+			return filter{}, err
+		}
+		return filter{re: re, target: "url"}, nil
+	case pattern == "query":
+		return filter{query: true}, nil
+	case strings.HasPrefix(pattern, "query:"):
+		if pattern == "query:" {
+			return filter{}, fmt.Errorf("missing query parameter name")
+		}
+		return filter{query: true, param: pattern[6:]}, nil
+	case strings.Contains(pattern, "://"):
+		return filter{re: globToRegexp(pattern), target: "url"}, nil
+	case strings.HasPrefix(pattern, "/"):
+		if !strings.ContainsAny(pattern, "*?") {
+			return filter{target: "prefix", prefix: strings.TrimSuffix(pattern, "/")}, nil
+		}
+		return filter{re: globToRegexp(pattern), target: "path"}, nil
+	default:
+		return filter{re: globToRegexp(pattern), target: "segment"}, nil
+	}
+}
+
+// match reports whether the filter matches u
+func (f filter) match(u *url.URL) bool {
+	if f.query {
+		if f.param == "" {
+			return u.RawQuery != ""
+		}
+		return u.Query().Has(f.param)
+	}
+
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	switch f.target {
+	case "url":
+		return f.re.MatchString(u.String())
+	case "prefix":
+		return p == f.prefix || strings.HasPrefix(p, f.prefix+"/")
+	case "segment":
+		return f.re.MatchString(path.Base(p))
+	default:
+		return f.re.MatchString(p)
+	}
+}
+
+// allowed reports whether a URL passes the include and exclude filters; an
+// exclude match always wins
+func allowed(u *url.URL, include, exclude []filter) bool {
+	for _, f := range exclude {
+		if f.match(u) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, f := range include {
+		if f.match(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// globToRegexp converts a glob to an anchored regular expression: ** matches
+// anything, * anything but a slash and ? a single character other than a
+// slash
+func globToRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			b.WriteString("[^/]*")
+		case glob[i] == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
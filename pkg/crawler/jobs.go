@@ -0,0 +1,220 @@
+package crawler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Job limits
+const (
+	MaxRunningJobs      = 10
+	JobRetention        = time.Hour // how long a finished job's results are kept
+	DefaultResultsLimit = 20
+	MaxResultsLimit     = 200
+)
+
+// Job statuses
+const (
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is a crawl running in the background. Its pages become readable as
+// soon as each one is fetched.
+type Job struct {
+	ID      string
+	Spec    Spec
+	Started time.Time
+
+	cancel context.CancelFunc
+	output *Output
+
+	mu       sync.Mutex
+	usage    Usage
+	pages    []Page
+	result   *Result
+	err      error
+	finished time.Time
+}
+
+// JobResults is one page of a job's results, starting at a cursor
+type JobResults struct {
+	JobID      string  `json:"job_id"`
+	Status     string  `json:"status"`
+	Pages      []Page  `json:"pages"`
+	Cursor     int     `json:"cursor"`
+	NextCursor int     `json:"next_cursor"`
+	HasMore    bool    `json:"has_more"`         // more pages are available now or may arrive
+	Total      int     `json:"total"`            // pages fetched so far
+	Usage      Usage   `json:"usage"`            // consumed so far
+	Result     *Result `json:"result,omitempty"` // the summary, once the job is done
+	Error      string  `json:"error,omitempty"`
+}
+
+// Jobs tracks background crawls
+type Jobs struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewJobs creates an empty job registry
+func NewJobs() *Jobs {
+	return &Jobs{jobs: make(map[string]*Job)}
+}
+
+// Start runs spec in the background. With an output, which the job closes
+// when it ends, each page is written to it and stored without its content; a
+// write error fails the job.
+func (j *Jobs) Start(spec Spec, fetch FetchFunc, output *Output) (*Job, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.prune()
+	running := 0
+	for _, job := range j.jobs {
+		if job.Status() == JobRunning {
+			running++
+		}
+	}
+	if running >= MaxRunningJobs {
+		return nil, fmt.Errorf("too many running jobs (max %d); wait for one to finish or cancel one", MaxRunningJobs)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{ID: hex.EncodeToString(id), Spec: spec, Started: time.Now(), cancel: cancel, output: output}
+	j.jobs[job.ID] = job
+
+	go func() {
+		defer cancel()
+		result, err := Run(ctx, spec, fetch, func(page Page) {
+			if output != nil {
+				output.Write(page)
+				page.Content = ""
+			}
+			job.mu.Lock()
+			job.pages = append(job.pages, page)
+			job.usage.add(page)
+			job.mu.Unlock()
+		})
+		if output != nil {
+			if closeErr := output.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if result != nil {
+			result.Pages = nil // readable through Results
+			result.Output = spec.Output
+			if output != nil {
+				result.Usage.DiskBytes = output.Bytes()
+			}
+		}
+
+		job.mu.Lock()
+		job.result, job.err, job.finished = result, err, time.Now()
+		job.mu.Unlock()
+	}()
+
+	return job, nil
+}
+
+// Get returns a job by ID
+func (j *Jobs) Get(id string) (*Job, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.prune()
+	job, ok := j.jobs[id]
+	return job, ok
+}
+
+// CancelAll stops every running job, e.g. on shutdown
+func (j *Jobs) CancelAll() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, job := range j.jobs {
+		job.cancel()
+	}
+}
+
+// prune forgets jobs that finished more than JobRetention ago; j.mu must be
+// held
+func (j *Jobs) prune() {
+	for id, job := range j.jobs {
+		job.mu.Lock()
+		expired := !job.finished.IsZero() && time.Since(job.finished) > JobRetention
+		job.mu.Unlock()
+		if expired {
+			delete(j.jobs, id)
+		}
+	}
+}
+
+// Cancel stops the job; the pages fetched so far stay readable
+func (job *Job) Cancel() {
+	job.cancel()
+}
+
+// Status returns JobRunning, JobDone or JobFailed
+func (job *Job) Status() string {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	switch {
+	case job.finished.IsZero():
+		return JobRunning
+	case job.err != nil:
+		return JobFailed
+	default:
+		return JobDone
+	}
+}
+
+// Results returns up to limit pages starting at cursor, the index of the
+// first page to return
+func (job *Job) Results(cursor, limit int) JobResults {
+	if limit <= 0 {
+		limit = DefaultResultsLimit
+	}
+	limit = min(limit, MaxResultsLimit)
+
+	status := job.Status()
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	total := len(job.pages)
+	cursor = max(0, min(cursor, total))
+	end := min(cursor+limit, total)
+
+	results := JobResults{
+		JobID:      job.ID,
+		Status:     status,
+		Pages:      append([]Page{}, job.pages[cursor:end]...),
+		Cursor:     cursor,
+		NextCursor: end,
+		HasMore:    end < total || status == JobRunning,
+		Total:      total,
+		Usage:      job.usage,
+	}
+	if job.output != nil {
+		results.Usage.DiskBytes = job.output.Bytes()
+	}
+	if status != JobRunning {
+		results.Result = job.result
+	}
+	if job.err != nil {
+		results.Error = job.err.Error()
+	}
+	return results
+}
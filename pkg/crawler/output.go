@@ -0,0 +1,89 @@
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Output is a crawl's JSON Lines artifact: one Page per line, written as each
+// page is fetched. It counts the bytes written, for disk usage accounting.
+type Output struct {
+	file    *os.File
+	encoder *json.Encoder
+
+	mu    sync.Mutex
+	bytes int64
+	err   error
+}
+
+// CreateOutput creates the artifact at path, along with its directory
+func CreateOutput(path string) (*Output, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output: %w", err)
+	}
+
+	o := &Output{file: file}
+	o.encoder = json.NewEncoder(writerFunc(o.write))
+	return o, nil
+}
+
+// Write appends a page. After a failed write, later pages are dropped and
+// Close reports the error.
+func (o *Output) Write(page Page) {
+	if o.Err() == nil {
+		o.encoder.Encode(page)
+	}
+}
+
+// write is the encoder's destination; it records the bytes and any error
+func (o *Output) write(p []byte) (int, error) {
+	n, err := o.file.Write(p)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.bytes += int64(n)
+	if err != nil && o.err == nil {
+		o.err = err
+	}
+	return n, err
+}
+
+// Bytes returns how many bytes have been written so far
+func (o *Output) Bytes() int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.bytes
+}
+
+// Err returns the first write error
+func (o *Output) Err() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err
+}
+
+// Close closes the file, returning the first write or close error
+func (o *Output) Close() error {
+	err := o.file.Close()
+	if writeErr := o.Err(); writeErr != nil {
+		err = writeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
+
+// writerFunc adapts a function to io.Writer
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
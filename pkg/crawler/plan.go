@@ -0,0 +1,96 @@
+package crawler
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Reasons a URL is left out of a plan
+const (
+	SkipDuplicate = "duplicate"
+	SkipFiltered  = "filtered"
+	SkipBlocked   = "blocked"
+	SkipMaxPages  = "max_pages"
+)
+
+// SkippedURL is a URL a crawl would not fetch, and why
+type SkippedURL struct {
+	URL    string `json:"url"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Plan is what a crawl would do, worked out without fetching anything. For
+// a batch it is the exact URL list; a crawl with Depth > 0 also fetches the
+// links it discovers, which can't be known in advance, so Hosts and the
+// budgets describe where it may go.
+type Plan struct {
+	Fetch              []string     `json:"fetch"`
+	Skipped            []SkippedURL `json:"skipped,omitempty"`
+	Depth              int          `json:"depth"`
+	Hosts              []string     `json:"hosts,omitempty"` // hosts links are followed on
+	Include            []string     `json:"include,omitempty"`
+	Exclude            []string     `json:"exclude,omitempty"`
+	MaxPages           int          `json:"max_pages"`
+	MaxBytes           int64        `json:"max_bytes"`
+	MaxDurationSeconds int          `json:"max_duration_seconds"`
+}
+
+// MakePlan applies the same deduplication, filters and page budget to the
+// seeds as Run, plus check, which returns an error for URLs that policy
+// would refuse (allowlists, blocklists, schemes)
+func MakePlan(spec Spec, check func(rawURL string) error) (*Plan, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	include, _ := parseFilters(spec.Include)
+	exclude, _ := parseFilters(spec.Exclude)
+
+	plan := &Plan{
+		Fetch:              []string{},
+		Depth:              spec.Depth,
+		Include:            spec.Include,
+		Exclude:            spec.Exclude,
+		MaxPages:           spec.MaxPages,
+		MaxBytes:           spec.MaxBytes,
+		MaxDurationSeconds: spec.MaxDurationSeconds,
+	}
+
+	hosts := make(map[string]bool)
+	seen := make(map[string]bool)
+	for _, seed := range spec.Seeds {
+		u, _ := url.Parse(seed)
+		key := normalize(seed)
+		if seen[key] {
+			plan.Skipped = append(plan.Skipped, SkippedURL{URL: seed, Reason: SkipDuplicate})
+			continue
+		}
+		seen[key] = true
+		if spec.Depth == 0 && !allowed(u, include, exclude) {
+			plan.Skipped = append(plan.Skipped, SkippedURL{URL: seed, Reason: SkipFiltered})
+			continue
+		}
+
+		if check != nil {
+			if err := check(seed); err != nil {
+				plan.Skipped = append(plan.Skipped, SkippedURL{URL: seed, Reason: SkipBlocked, Detail: err.Error()})
+				continue
+			}
+		}
+		if len(plan.Fetch) >= spec.MaxPages {
+			plan.Skipped = append(plan.Skipped, SkippedURL{URL: seed, Reason: SkipMaxPages})
+			continue
+		}
+		plan.Fetch = append(plan.Fetch, seed)
+		hosts[strings.ToLower(u.Hostname())] = true
+	}
+
+	if spec.Depth > 0 {
+		for host := range hosts {
+			plan.Hosts = append(plan.Hosts, host)
+		}
+		sort.Strings(plan.Hosts)
+	}
+	return plan, nil
+}
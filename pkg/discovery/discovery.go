@@ -0,0 +1,458 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// MaxSize is the most of each endpoint that is downloaded
+const MaxSize = 2 * 1024 * 1024
+
+// Kinds of endpoint
+const (
+	KindRobots   = "robots"
+	KindSitemap  = "sitemap"
+	KindSecurity = "security"
+	KindFeed     = "feed"
+	KindManifest = "manifest"
+	KindOpenAPI  = "openapi"
+	KindLLMs     = "llms"
+)
+
+// probes are the conventional locations of each kind, in the order they are
+// reported
+var probes = []struct {
+	kind  string
+	paths []string
+}{
+	{KindRobots, []string{"/robots.txt"}},
+	{KindSitemap, []string{"/sitemap.xml", "/sitemap_index.xml"}},
+	{KindSecurity, []string{"/.well-known/security.txt", "/security.txt"}},
+	{KindFeed, []string{"/feed", "/rss.xml", "/atom.xml", "/feed.xml", "/index.xml"}},
+	{KindManifest, []string{"/manifest.json", "/site.webmanifest"}},
+	{KindOpenAPI, []string{"/openapi.json", "/swagger.json", "/api/openapi.json"}},
+	{KindLLMs, []string{"/llms.txt", "/llms-full.txt"}},
+}
+
+// maxRobotsSitemaps caps how many sitemaps named in robots.txt are probed
+const maxRobotsSitemaps = 3
+
+// Endpoint is a machine-readable resource found on a site, with metadata
+// read from it
+type Endpoint struct {
+	Kind        string                 `json:"kind"`
+	URL         string                 `json:"url"`
+	StatusCode  int                    `json:"status_code"`
+	ContentType string                 `json:"content_type,omitempty"`
+	Size        int                    `json:"size"`
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// Result maps a site's machine-readable surfaces
+type Result struct {
+	Origin    string     `json:"origin"`
+	Endpoints []Endpoint `json:"endpoints"`
+	Missing   []string   `json:"missing"` // probed URLs that don't exist or aren't what they claim to be
+}
+
+// FetchFunc fetches a URL's raw content
+type FetchFunc func(ctx context.Context, rawURL string) (*types.FetchResponse, error)
+
+// probe is one URL to try as an endpoint of a kind
+type probe struct {
+	kind string
+	url  string
+}
+
+// Discover probes the well-known endpoints of rawURL's origin, and those its
+// home page and robots.txt point to. A URL counts as found only if its
+// content parses as the kind it should be, so a site that answers every
+// path with an HTML page doesn't appear to have them all.
+func Discover(ctx context.Context, rawURL string, fetch FetchFunc) (*Result, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid URL: %s", rawURL)
+	}
+	origin := u.Scheme + "://" + u.Host
+
+	// The home page and robots.txt name endpoints at unconventional paths
+	var home, robots *types.FetchResponse
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		home, _ = fetch(ctx, origin+"/")
+	}()
+	go func() {
+		defer wg.Done()
+		robots, _ = fetch(ctx, origin+"/robots.txt")
+	}()
+	wg.Wait()
+
+	linked := homeLinks(home, origin)
+	var list []probe
+	seen := make(map[string]bool)
+	add := func(kind, target string) {
+		if !seen[target] {
+			seen[target] = true
+			list = append(list, probe{kind, target})
+		}
+	}
+	for _, p := range probes {
+		for _, target := range linked[p.kind] {
+			add(p.kind, target)
+		}
+		if p.kind == KindSitemap && robots != nil {
+			for i, sitemap := range robotsSitemaps(robots.Content) {
+				if ref, err := url.Parse(sitemap); err == nil && i < maxRobotsSitemaps {
+					add(p.kind, u.ResolveReference(ref).String())
+				}
+			}
+		}
+		for _, path := range p.paths {
+			add(p.kind, origin+path)
+		}
+	}
+
+	// robots.txt is already fetched; probe the rest concurrently, leaving
+	// the fetcher's limits to keep it polite
+	responses := make([]*types.FetchResponse, len(list))
+	for i, p := range list {
+		if p.url == origin+"/robots.txt" {
+			responses[i] = robots
+			continue
+		}
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			if resp, err := fetch(ctx, target); err == nil {
+				responses[i] = resp
+			}
+		}(i, p.url)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &Result{Origin: origin, Endpoints: []Endpoint{}, Missing: []string{}}
+	found := make(map[string]bool) // final URLs, since several paths may redirect to one
+	for i, p := range list {
+		resp := responses[i]
+		if resp == nil || resp.StatusCode != 200 {
+			result.Missing = append(result.Missing, p.url)
+			continue
+		}
+		metadata, ok := inspect(p.kind, resp)
+		if !ok {
+			result.Missing = append(result.Missing, p.url)
+			continue
+		}
+		final := resp.FinalURL
+		if final == "" {
+			final = p.url
+		}
+		if found[final] {
+			continue
+		}
+		found[final] = true
+		result.Endpoints = append(result.Endpoints, Endpoint{
+			Kind:        p.kind,
+			URL:         final,
+			StatusCode:  resp.StatusCode,
+			ContentType: resp.ContentType,
+			Size:        len(resp.Content),
+			Metadata:    metadata,
+		})
+	}
+
+	return result, nil
+}
+
+// homeLinks returns the feeds and manifest a home page links to
+func homeLinks(home *types.FetchResponse, origin string) map[string][]string {
+	links := make(map[string][]string)
+	if home == nil || home.StatusCode != 200 {
+		return links
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(home.Content))
+	if err != nil {
+		return links
+	}
+
+	base, _ := url.Parse(origin + "/")
+	if home.FinalURL != "" {
+		if final, err := url.Parse(home.FinalURL); err == nil {
+			base = final
+		}
+	}
+	resolve := func(href string) string {
+		ref, err := url.Parse(strings.TrimSpace(href))
+		if err != nil || href == "" {
+			return ""
+		}
+		return base.ResolveReference(ref).String()
+	}
+
+	doc.Find("link[href]").Each(func(_ int, link *goquery.Selection) {
+		rel := strings.ToLower(link.AttrOr("rel", ""))
+		linkType := strings.ToLower(link.AttrOr("type", ""))
+		target := resolve(link.AttrOr("href", ""))
+		switch {
+		case target == "":
+		case strings.Contains(rel, "alternate") && (strings.Contains(linkType, "rss") || strings.Contains(linkType, "atom")):
+			links[KindFeed] = append(links[KindFeed], target)
+		case strings.Contains(rel, "manifest"):
+			links[KindManifest] = append(links[KindManifest], target)
+		}
+	})
+	return links
+}
+
+// robotsSitemaps returns the sitemaps robots.txt lists
+func robotsSitemaps(content string) []string {
+	var sitemaps []string
+	for _, line := range strings.Split(content, "\n") {
+		field, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(field), "sitemap") {
+			if value = strings.TrimSpace(value); value != "" {
+				sitemaps = append(sitemaps, value)
+			}
+		}
+	}
+	return sitemaps
+}
+
+// inspect checks that a response is an endpoint of kind and reads its
+// metadata
+func inspect(kind string, resp *types.FetchResponse) (map[string]interface{}, bool) {
+	content := resp.Content
+	switch kind {
+	case KindRobots:
+		return inspectRobots(content)
+	case KindSitemap:
+		return inspectXML(content, map[string]string{"urlset": "url", "sitemapindex": "sitemap"})
+	case KindFeed:
+		return inspectXML(content, map[string]string{"rss": "item", "rdf": "item", "feed": "entry"})
+	case KindSecurity:
+		return inspectSecurity(content)
+	case KindManifest:
+		return inspectManifest(content)
+	case KindOpenAPI:
+		return inspectOpenAPI(content)
+	case KindLLMs:
+		return inspectLLMs(content)
+	}
+	return nil, false
+}
+
+// looksLikeHTML reports whether text content is really an HTML page, as
+// sites serving a catch-all page for unknown paths return
+func looksLikeHTML(content string) bool {
+	start := strings.ToLower(strings.TrimSpace(content))
+	return strings.HasPrefix(start, "<!doctype html") || strings.HasPrefix(start, "<html")
+}
+
+func inspectRobots(content string) (map[string]interface{}, bool) {
+	if looksLikeHTML(content) {
+		return nil, false
+	}
+	agents, rules := 0, 0
+	for _, line := range strings.Split(content, "\n") {
+		field, _, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(field)) {
+		case "user-agent":
+			agents++
+		case "allow", "disallow":
+			rules++
+		}
+	}
+	sitemaps := robotsSitemaps(content)
+	if agents == 0 && len(sitemaps) == 0 && strings.TrimSpace(content) != "" {
+		return nil, false
+	}
+	metadata := map[string]interface{}{"user_agents": agents, "rules": rules}
+	if len(sitemaps) > 0 {
+		metadata["sitemaps"] = sitemaps
+	}
+	return metadata, true
+}
+
+// inspectXML checks an XML document's root element is one of roots, which
+// maps each accepted root to the element counted as its entries
+func inspectXML(content string, roots map[string]string) (map[string]interface{}, bool) {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	decoder.Strict = false
+
+	var root, entry, title string
+	entries := 0
+	inTitle := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if root == "" {
+				return nil, false
+			}
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			if root == "" {
+				var ok bool
+				if entry, ok = roots[name]; !ok {
+					return nil, false
+				}
+				root = name
+				continue
+			}
+			if name == entry {
+				entries++
+			}
+			// The feed's own title, not an entry's
+			inTitle = name == "title" && title == "" && entries == 0
+		case xml.CharData:
+			if inTitle {
+				title += string(t)
+			}
+		case xml.EndElement:
+			inTitle = false
+		}
+	}
+	if root == "" {
+		return nil, false
+	}
+
+	metadata := map[string]interface{}{"format": root, "entries": entries}
+	if root == "rdf" {
+		metadata["format"] = "rss"
+	}
+	if title = strings.TrimSpace(title); title != "" {
+		metadata["title"] = title
+	}
+	return metadata, true
+}
+
+func inspectSecurity(content string) (map[string]interface{}, bool) {
+	if looksLikeHTML(content) {
+		return nil, false
+	}
+	var contacts []string
+	metadata := map[string]interface{}{}
+	for _, line := range strings.Split(content, "\n") {
+		field, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(field)) {
+		case "contact":
+			contacts = append(contacts, value)
+		case "expires":
+			metadata["expires"] = value
+		case "policy":
+			metadata["policy"] = value
+		}
+	}
+	// Contact is the one required field (RFC 9116)
+	if len(contacts) == 0 {
+		return nil, false
+	}
+	metadata["contact"] = contacts
+	return metadata, true
+}
+
+func inspectManifest(content string) (map[string]interface{}, bool) {
+	var manifest struct {
+		Name      string        `json:"name"`
+		ShortName string        `json:"short_name"`
+		StartURL  string        `json:"start_url"`
+		Icons     []interface{} `json:"icons"`
+	}
+	if err := json.Unmarshal([]byte(content), &manifest); err != nil {
+		return nil, false
+	}
+	if manifest.Name == "" && manifest.ShortName == "" && manifest.StartURL == "" && len(manifest.Icons) == 0 {
+		return nil, false
+	}
+	metadata := map[string]interface{}{"icons": len(manifest.Icons)}
+	for key, value := range map[string]string{"name": manifest.Name, "short_name": manifest.ShortName, "start_url": manifest.StartURL} {
+		if value != "" {
+			metadata[key] = value
+		}
+	}
+	return metadata, true
+}
+
+func inspectOpenAPI(content string) (map[string]interface{}, bool) {
+	var spec struct {
+		OpenAPI string `json:"openapi"`
+		Swagger string `json:"swagger"`
+		Info    struct {
+			Title   string `json:"title"`
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal([]byte(content), &spec); err != nil {
+		return nil, false
+	}
+	specVersion := spec.OpenAPI
+	if specVersion == "" {
+		specVersion = spec.Swagger
+	}
+	if specVersion == "" {
+		return nil, false
+	}
+	metadata := map[string]interface{}{"spec_version": specVersion, "paths": len(spec.Paths)}
+	if spec.Info.Title != "" {
+		metadata["title"] = spec.Info.Title
+	}
+	if spec.Info.Version != "" {
+		metadata["version"] = spec.Info.Version
+	}
+	return metadata, true
+}
+
+// markdownLink matches a Markdown link's target
+var markdownLink = regexp.MustCompile(`\]\(([^)\s]+)`)
+
+func inspectLLMs(content string) (map[string]interface{}, bool) {
+	if looksLikeHTML(content) || strings.ContainsRune(content, 0) {
+		return nil, false
+	}
+	// llms.txt opens with an H1 naming the site
+	var title string
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		if heading, ok := strings.CutPrefix(line, "# "); ok {
+			title = strings.TrimSpace(heading)
+		}
+		break
+	}
+	if title == "" {
+		return nil, false
+	}
+	return map[string]interface{}{
+		"title": title,
+		"links": len(markdownLink.FindAllString(content, -1)),
+	}, true
+}
@@ -0,0 +1,110 @@
+package fetcher
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gomcpgo/url_fetcher/pkg/config"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// bandwidthChunk caps each read of a throttled body, so a large read buffer
+// doesn't reserve a long stretch of bandwidth at once
+const bandwidthChunk = 16 * 1024
+
+// bandwidthLimiter is a token bucket over bytes: bodies read through it are
+// held to rate bytes per second between them, with at most a second's worth
+// read at once
+type bandwidthLimiter struct {
+	rate float64
+
+	mu     sync.Mutex
+	tokens float64 // negative while reads are waiting for bytes
+	last   time.Time
+}
+
+// newBandwidthLimiter returns a limiter for rate bytes per second, or nil if
+// rate is unlimited
+func newBandwidthLimiter(rate int64) *bandwidthLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// take accounts for n bytes read, blocking until they fit in the rate
+func (l *bandwidthLimiter) take(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	return waitRetry(ctx, delay)
+}
+
+// throttledReader reads from r no faster than each of its limiters allows
+type throttledReader struct {
+	ctx      context.Context
+	r        io.Reader
+	chunk    int
+	limiters []*bandwidthLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > t.chunk {
+		p = p[:t.chunk]
+	}
+	n, err := t.r.Read(p)
+	for _, l := range t.limiters {
+		if waitErr := l.take(t.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// throttle limits reading body to the server's bandwidth limit, shared with
+// every other download, and the request's own max_bandwidth
+func (e *HTTPEngine) throttle(ctx context.Context, body io.Reader, fetchReq *types.FetchRequest) io.Reader {
+	var limiters []*bandwidthLimiter
+	if e.bandwidth != nil {
+		limiters = append(limiters, e.bandwidth)
+	}
+	if own := newBandwidthLimiter(fetchReq.MaxBandwidth); own != nil {
+		limiters = append(limiters, own)
+	}
+	if len(limiters) == 0 {
+		return body
+	}
+
+	chunk := bandwidthChunk
+	for _, l := range limiters {
+		chunk = min(chunk, max(int(l.rate), 1))
+	}
+	return &throttledReader{ctx: ctx, r: body, chunk: chunk, limiters: limiters}
+}
+
+// bandwidthRate is the download rate a request is held to, in bytes per
+// second: the lower of the server's limit and the request's, 0 if neither
+// is set
+func bandwidthRate(cfg *config.Config, fetchReq *types.FetchRequest) int64 {
+	switch {
+	case cfg.BandwidthLimit <= 0:
+		return max(fetchReq.MaxBandwidth, 0)
+	case fetchReq.MaxBandwidth <= 0:
+		return cfg.BandwidthLimit
+	default:
+		return min(cfg.BandwidthLimit, fetchReq.MaxBandwidth)
+	}
+}
@@ -0,0 +1,100 @@
+package fetcher
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// What a page that isn't the requested content is blocked by
+const (
+	BlockedCloudflare = "cloudflare"
+	BlockedAkamai     = "akamai"
+	BlockedCaptcha    = "captcha"
+	BlockedLoginWall  = "login_wall"
+)
+
+// interstitialMaxSize is the largest page judged on weak signals alone: a
+// captcha widget or a password field on a big page is more likely part of
+// the real content than a wall in front of it
+const interstitialMaxSize = 32 * 1024
+
+// captchaWidgets are the markup of captcha widgets and hosted challenges
+var captchaWidgets = []string{
+	"g-recaptcha", "recaptcha/api.js", "h-captcha", "hcaptcha.com/1/api.js", "cf-turnstile",
+	"px-captcha", "captcha-delivery.com", "funcaptcha", "arkoselabs.com",
+}
+
+// captchaPhrases are what interstitials tell the visitor
+var captchaPhrases = []string{
+	"verify you are human", "verify that you are human", "are you a robot", "not a robot",
+	"unusual traffic from your computer", "press & hold", "complete the security check",
+}
+
+// loginTitle matches the titles of login pages
+var loginTitle = regexp.MustCompile(`(?i)<title[^>]*>[^<]*\b(log ?in|sign ?in|log on|sign on|authenticat)`)
+
+// passwordInput matches a password field
+var passwordInput = regexp.MustCompile(`(?i)<input[^>]+type=["']?password`)
+
+// detectBlock reports what keeps a response from being the requested page:
+// a bot challenge, a captcha or a login wall. It returns "" for a real page.
+func detectBlock(req *types.FetchRequest, response *types.FetchResponse, err error) string {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.Challenge != "" {
+		switch statusErr.Challenge {
+		case "cloudflare":
+			return BlockedCloudflare
+		case "akamai":
+			return BlockedAkamai
+		default:
+			return BlockedCaptcha
+		}
+	}
+	if err != nil || response == nil || response.Skipped || response.NotModified {
+		return ""
+	}
+
+	content := strings.ToLower(response.Content)
+	for _, marker := range cloudflareMarkers {
+		if strings.Contains(content, marker) {
+			return BlockedCloudflare
+		}
+	}
+
+	small := len(content) <= interstitialMaxSize
+	widget := containsAny(content, captchaWidgets)
+	if widget && (small || containsAny(content, captchaPhrases)) {
+		return BlockedCaptcha
+	}
+
+	if redirectedToLogin(req, response) ||
+		(small && passwordInput.MatchString(content) && loginTitle.MatchString(content)) {
+		return BlockedLoginWall
+	}
+	return ""
+}
+
+// blockedWarning explains a blocked_by value
+func blockedWarning(blockedBy string) string {
+	switch blockedBy {
+	case BlockedLoginWall:
+		return "the page is a login wall, not the requested content; use a session to fetch it"
+	case BlockedCaptcha:
+		return "the page is a captcha, not the requested content"
+	default:
+		return fmt.Sprintf("the page is a %s bot challenge, not the requested content", blockedBy)
+	}
+}
+
+func containsAny(s string, substrings []string) bool {
+	for _, sub := range substrings {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
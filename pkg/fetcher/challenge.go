@@ -0,0 +1,113 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// challengeSniffSize is how much of a 403 or 503 body is read to look for a
+// bot challenge; the markers sit near the top of the page
+const challengeSniffSize = 64 * 1024
+
+// cloudflareMarkers identify Cloudflare's challenge pages, whatever their
+// status
+var cloudflareMarkers = []string{
+	"cf-chl", "cf_chl_opt", "challenge-platform", "<title>just a moment...</title>", "attention required! | cloudflare",
+}
+
+// challengeVendors are bot-protection services and the markers of their
+// challenge pages, matched case-insensitively against the start of the body
+var challengeVendors = []struct {
+	name    string
+	markers []string
+}{
+	{"cloudflare", cloudflareMarkers},
+	{"akamai", []string{"errors.edgesuite.net", "reference&#32;&#35;"}},
+	{"perimeterx", []string{"px-captcha", "_pxappid", "captcha.px-cdn.net", "perimeterx"}},
+	{"datadome", []string{"captcha-delivery.com", "geo.captcha-delivery.com"}},
+}
+
+// detectChallenge names the bot-protection service whose challenge a 403 or
+// 503 response is, or returns "" if it doesn't look like one. Headers the
+// services set decide it where they can; otherwise the body's markers do.
+func detectChallenge(resp *http.Response, body []byte) string {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusServiceUnavailable {
+		return ""
+	}
+
+	header := resp.Header
+	server := strings.ToLower(header.Get("Server"))
+	switch {
+	case header.Get("Cf-Mitigated") == "challenge":
+		return "cloudflare"
+	case header.Get("X-Datadome") != "" || server == "datadome":
+		return "datadome"
+	}
+
+	content := strings.ToLower(string(body))
+	for _, vendor := range challengeVendors {
+		for _, marker := range vendor.markers {
+			if strings.Contains(content, marker) {
+				return vendor.name
+			}
+		}
+	}
+
+	// Akamai's bare "Access Denied" page only identifies itself by server
+	if strings.Contains(server, "akamaighost") && strings.Contains(content, "access denied") {
+		return "akamai"
+	}
+	return ""
+}
+
+// sniffChallenge reads the start of a 403 or 503 body, undoing any content
+// coding, and checks it for a bot challenge
+func sniffChallenge(resp *http.Response) string {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusServiceUnavailable {
+		return ""
+	}
+	reader, cleanup, err := decodeContent(resp.Body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return detectChallenge(resp, nil)
+	}
+	defer cleanup()
+	body, _ := io.ReadAll(io.LimitReader(reader, challengeSniffSize))
+	return detectChallenge(resp, body)
+}
+
+// escalateChallenge retries a request the HTTP engines were served a bot
+// challenge for with Chrome, which can often pass the JavaScript checks
+// behind it, rather than hand the challenge page back as content
+func (f *Fetcher) escalateChallenge(ctx context.Context, req *types.FetchRequest, response *types.FetchResponse, err error, chromeAvailable bool) (*types.FetchResponse, error) {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Challenge == "" || !f.config.EscalateChallenges || ctx.Err() != nil {
+		return response, err
+	}
+
+	challenge := fmt.Sprintf("%s bot challenge (status %d)", statusErr.Challenge, statusErr.StatusCode)
+	if !chromeAvailable || !f.chromeEngine.WaitReady(ctx, f.config.Timeout) {
+		if response != nil {
+			response.Warnings = append(response.Warnings,
+				fmt.Sprintf("%s; Chrome isn't available to get past it", challenge))
+		}
+		return response, err
+	}
+
+	chromeResponse, chromeErr := f.chromeEngine.Fetch(ctx, req)
+	if chromeErr != nil {
+		if response != nil {
+			response.Warnings = append(response.Warnings,
+				fmt.Sprintf("%s; retrying with chrome also failed: %v", challenge, chromeErr))
+		}
+		return response, err
+	}
+	chromeResponse.Warnings = append(chromeResponse.Warnings,
+		fmt.Sprintf("%s from the %s engine; fetched with chrome instead", challenge, req.Engine))
+	return chromeResponse, nil
+}
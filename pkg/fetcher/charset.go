@@ -0,0 +1,60 @@
+package fetcher
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// utf8BOM is the byte order mark some servers prepend to UTF-8 bodies
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// normalizeCharset converts a text response body to UTF-8 without a BOM and
+// reports the charset it was originally encoded in. Detection follows the
+// HTML spec: BOM first, then the Content-Type header, then <meta> prescan.
+// Other bodies are returned as they are, with no charset: detection falls
+// back to windows-1252, which would mangle their bytes.
+func normalizeCharset(body []byte, contentType string) (string, string, error) {
+	if !textType(contentType, body) {
+		return string(body), "", nil
+	}
+	enc, name, _ := charset.DetermineEncoding(body, contentType)
+
+	decoded := body
+	if name != "utf-8" {
+		var err error
+		decoded, err = enc.NewDecoder().Bytes(body)
+		if err != nil {
+			return "", name, fmt.Errorf("failed to decode %s content: %w", name, err)
+		}
+	}
+
+	decoded = bytes.TrimPrefix(decoded, utf8BOM)
+
+	return string(decoded), name, nil
+}
+
+// textType reports whether contentType is text, and so has a charset. A
+// missing type is sniffed from body.
+func textType(contentType string, body []byte) bool {
+	if strings.TrimSpace(contentType) == "" {
+		contentType = http.DetectContentType(body)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, _, _ = strings.Cut(strings.ToLower(contentType), ";")
+		mediaType = strings.TrimSpace(mediaType)
+	}
+
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-javascript", "application/ecmascript":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		strings.HasSuffix(mediaType, "+json")
+}
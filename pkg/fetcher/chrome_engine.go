@@ -3,13 +3,30 @@ package fetcher
 import (
 	"context"
 	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/fetch"
 	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/target"
 	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/kb"
 	"github.com/gomcpgo/url_fetcher/pkg/config"
+	"github.com/gomcpgo/url_fetcher/pkg/proxy"
+	"github.com/gomcpgo/url_fetcher/pkg/session"
 	"github.com/gomcpgo/url_fetcher/pkg/types"
 )
 
@@ -19,6 +36,9 @@ type ChromeEngine struct {
 	pool         *BrowserPool
 	isAvailable  bool
 	availability sync.Once
+
+	// sessions supplies cookie jars for requests that name a session
+	sessions *session.Manager
 }
 
 // BrowserPool manages a pool of Chrome browser instances
@@ -26,7 +46,54 @@ type BrowserPool struct {
 	contexts    []context.Context
 	cancelFuncs []context.CancelFunc
 	available   chan int
+	ready       chan struct{}
 	mu          sync.Mutex
+
+	// proxies holds each instance's proxy, nil for a direct connection;
+	// their credentials are answered when the proxy asks for them
+	proxies []*url.URL
+
+	// A remote pool's instances are browser contexts in a browser the
+	// server didn't launch. failed is closed, and err set, if it can't be
+	// reached; closed stops a connection still in progress.
+	remote bool
+	failed chan struct{}
+	err    error
+	closed bool
+
+	// Usage metrics, guarded by statsMu except waiting
+	waiting     atomic.Int64
+	statsMu     sync.Mutex
+	renders     []int64
+	waitBuckets []int64
+	waitCount   int64
+	waitTotal   time.Duration
+	waitMax     time.Duration
+}
+
+// poolWaitBucketsMs are the upper bounds of the queue wait histogram; a
+// final implicit bucket counts everything slower
+var poolWaitBucketsMs = []int64{10, 50, 100, 500, 1000, 5000}
+
+// PoolStats reports Chrome pool utilization
+type PoolStats struct {
+	Size               int              `json:"size"`
+	Busy               int              `json:"busy"`
+	Utilization        float64          `json:"utilization"`
+	QueueDepth         int64            `json:"queue_depth"`
+	RendersPerInstance []int64          `json:"renders_per_instance,omitempty"`
+	WaitHistogram      []WaitTimeBucket `json:"wait_histogram,omitempty"`
+	WaitCount          int64            `json:"wait_count"`
+	WaitSumMs          int64            `json:"wait_sum_ms"`
+	AvgWaitMs          float64          `json:"avg_wait_ms"`
+	MaxWaitMs          int64            `json:"max_wait_ms"`
+}
+
+// WaitTimeBucket is one cumulative bucket of the queue wait histogram.
+// LeMs of -1 stands for +Inf.
+type WaitTimeBucket struct {
+	LeMs  int64 `json:"le_ms"`
+	Count int64 `json:"count"`
 }
 
 // NewChromeEngine creates a new Chrome engine
@@ -35,13 +102,20 @@ func NewChromeEngine(cfg *config.Config) *ChromeEngine {
 		config: cfg,
 	}
 
-	// Check Chrome availability once
+	// Find the browser once; a remote one is assumed to be there, and
+	// fetches fail with the reason if it can't be reached
+	var execPath string
 	engine.availability.Do(func() {
-		engine.isAvailable = checkChromeAvailable()
+		if cfg.ChromeWSURL != "" {
+			engine.isAvailable = true
+			return
+		}
+		execPath = findChrome(cfg.ChromePath)
+		engine.isAvailable = execPath != ""
 	})
 
 	if engine.isAvailable {
-		engine.pool = newBrowserPool(cfg.ChromePoolSize)
+		engine.pool = newBrowserPool(cfg, execPath)
 	}
 
 	return engine
@@ -52,86 +126,468 @@ func (e *ChromeEngine) IsAvailable() bool {
 	return e.isAvailable
 }
 
-// Fetch retrieves content from a URL using Chrome
-func (e *ChromeEngine) Fetch(fetchURL string, maxContentLength int) (*types.FetchResponse, error) {
+// IsReady reports whether the browser pool has finished pre-warming. An
+// engine without Chrome is always ready since there is nothing to warm up.
+func (e *ChromeEngine) IsReady() bool {
+	if e.pool == nil {
+		return true
+	}
+	select {
+	case <-e.pool.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitReady blocks until the browser pool is warm, the timeout elapses or ctx
+// is done, returning whether the pool is ready
+func (e *ChromeEngine) WaitReady(ctx context.Context, timeout time.Duration) bool {
+	if e.pool == nil {
+		return true
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-e.pool.ready:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Fetch retrieves content from a URL using Chrome, retrying as the retry
+// policy allows. The tab is closed as soon as ctx is done, aborting the
+// navigation.
+func (e *ChromeEngine) Fetch(ctx context.Context, fetchReq *types.FetchRequest) (*types.FetchResponse, error) {
+	startTime := time.Now()
+	policy := retryPolicy(e.config, fetchReq)
+
+	// Every attempt's bandwidth and render time counts towards usage
+	var received, renderMs int64
+	for attempt := 1; ; attempt++ {
+		response, err := e.fetchOnce(ctx, fetchReq)
+		if response != nil {
+			received += response.BytesReceived
+			renderMs += response.RenderMs
+		}
+		retry := attempt < policy.MaxAttempts && ctx.Err() == nil
+		if err != nil {
+			retry = retry && retryableError(policy, err)
+		} else {
+			retry = retry && retryableStatus(policy, response.StatusCode)
+		}
+		if !retry {
+			if response != nil {
+				response.Attempts = attempt
+				response.FetchTimeMs = time.Since(startTime).Milliseconds()
+				response.BytesReceived, response.RenderMs = received, renderMs
+			}
+			return response, err
+		}
+
+		if err := waitRetry(ctx, retryDelay(policy, attempt)); err != nil {
+			response := types.ErrorResponse(fetchReq.URL, types.EngineChrome, err, time.Since(startTime))
+			response.BytesReceived, response.RenderMs = received, renderMs
+			return response, err
+		}
+	}
+}
+
+// fetchOnce makes a single attempt at a Chrome fetch
+func (e *ChromeEngine) fetchOnce(ctx context.Context, fetchReq *types.FetchRequest) (*types.FetchResponse, error) {
 	startTime := time.Now()
+	fetchURL := fetchReq.URL
+	maxContentLength := fetchReq.MaxContentLength
 
 	if !e.isAvailable {
-		return nil, fmt.Errorf("Chrome is not available on this system")
+		return nil, ErrChromeUnavailable
+	}
+
+	// A caller's proxy gets a browser context of its own on the instance
+	var ownProxy *url.URL
+	if fetchReq.Proxy != "" {
+		var err error
+		if ownProxy, err = requestProxy(e.config, fetchReq.Proxy); err != nil {
+			return types.ErrorResponse(fetchURL, types.EngineChrome, err, time.Since(startTime)), err
+		}
 	}
 
-	// Get a browser instance from the pool
-	instanceID := <-e.pool.available
+	// Get a browser instance from the pool, recording how long we queued
+	waitStart := time.Now()
+	instanceID, err := e.pool.acquire(ctx)
+	if err != nil {
+		err = classifyError(err)
+		return types.ErrorResponse(fetchURL, types.EngineChrome, err, time.Since(startTime)), err
+	}
+	queued := time.Since(waitStart)
+	e.pool.recordAcquire(instanceID, queued)
+	renderStart := time.Now()
 	defer func() {
 		e.pool.available <- instanceID
 	}()
 
-	ctx := e.pool.contexts[instanceID]
+	var jar *session.Session
+	if fetchReq.Session != "" {
+		var err error
+		if jar, err = e.sessions.Get(fetchReq.Session); err != nil {
+			return types.ErrorResponse(fetchURL, types.EngineChrome, err, time.Since(startTime)), err
+		}
+	}
+
+	proxyURL := e.pool.proxies[instanceID]
+	if ownProxy != nil {
+		proxyURL = ownProxy
+	}
 
 	// Create a new tab context with timeout
-	tabCtx, cancel := chromedp.NewContext(ctx)
+	timeoutCtx, cancel := e.newTab(ctx, instanceID, ownProxy)
 	defer cancel()
 
-	timeoutCtx, timeoutCancel := context.WithTimeout(tabCtx, e.config.Timeout)
-	defer timeoutCancel()
-
 	var htmlContent string
+	var finalURL string
 	var statusCode int64
+	var protocol string
 	contentType := "text/html"
 
+	// The main navigation keeps one request ID across its redirects; the
+	// mutex also guards its response and the timing the listener fills in
+	var redirectsMu sync.Mutex
+	var navigationID network.RequestID
+	var redirects []types.Redirect
+	timing := &types.Timing{QueueMs: queued.Milliseconds()}
+	var navigationStart, navigationDone time.Time
+	var received int64 // encoded bytes of every resource the page loaded
+	var pageCookies []types.PageCookie
+	var navigationBlocked error // a redirect of the page to a forbidden target
+	authorization := authorizationHeader(fetchReq.Auth)
+	targets := newTargetCache(e.config)
+
+	// Collect what the page logs, to help explain a page that rendered nothing
+	console := &consoleLog{}
+	chromedp.ListenTarget(timeoutCtx, console.listen)
+	var requests *networkLog
+	if fetchReq.CaptureNetwork {
+		requests = newNetworkLog()
+		chromedp.ListenTarget(timeoutCtx, requests.listen)
+	}
+
 	// Set up network monitoring
 	chromedp.ListenTarget(timeoutCtx, func(ev interface{}) {
 		switch ev := ev.(type) {
+		case *network.EventRequestWillBeSent:
+			if ev.Type != network.ResourceTypeDocument {
+				return
+			}
+			redirectsMu.Lock()
+			defer redirectsMu.Unlock()
+			if navigationID == "" {
+				navigationID = ev.RequestID
+			}
+			if ev.RequestID == navigationID && ev.RedirectResponse != nil {
+				redirects = append(redirects, types.Redirect{
+					URL:        ev.RedirectResponse.URL,
+					StatusCode: int(ev.RedirectResponse.Status),
+					Location:   ev.Request.URL,
+				})
+			}
 		case *network.EventResponseReceived:
 			if ev.Type == network.ResourceTypeDocument {
+				// Frames load documents too; only the page's own counts
+				redirectsMu.Lock()
+				defer redirectsMu.Unlock()
+				if ev.RequestID != navigationID {
+					return
+				}
+				resourceTiming(timing, ev.Response.Timing)
 				statusCode = ev.Response.Status
+				protocol = ev.Response.Protocol
 				if ct, ok := ev.Response.Headers["content-type"].(string); ok {
 					contentType = ct
 				}
 			}
+		case *network.EventLoadingFinished:
+			redirectsMu.Lock()
+			received += int64(ev.EncodedDataLength)
+			redirectsMu.Unlock()
+		case *fetch.EventRequestPaused:
+			// Requests to metadata services, local hosts under BlockLocal
+			// and blocked resources are failed, the rest let through.
+			// Commands can't be sent from the listener itself, which would
+			// deadlock.
+			go func() {
+				c := chromedp.FromContext(timeoutCtx)
+				executor := cdp.WithExecutor(timeoutCtx, c.Target)
+				if err := targets.check(ev.Request.URL); err != nil {
+					if ev.ResourceType == network.ResourceTypeDocument && string(ev.FrameID) == string(c.Target.TargetID) {
+						redirectsMu.Lock()
+						navigationBlocked = fmt.Errorf("%w: %s: %w", ErrUnsafeRedirect, hostOf(ev.Request.URL), err)
+						redirectsMu.Unlock()
+					}
+					fetch.FailRequest(ev.RequestID, network.ErrorReasonAccessDenied).Do(executor)
+					return
+				}
+				if blockedRequest(fetchReq.Block, ev.ResourceType, ev.Request.URL) {
+					fetch.FailRequest(ev.RequestID, network.ErrorReasonBlockedByClient).Do(executor)
+					return
+				}
+				// Credentials go only to the site they are for, not to
+				// its subresources on other hosts or a redirect elsewhere
+				if authorization != "" && sameOrigin(ev.Request.URL, fetchURL) {
+					headers := withAuthorization(ev.Request.Headers, authorization)
+					fetch.ContinueRequest(ev.RequestID).WithHeaders(headers).Do(executor)
+					return
+				}
+				fetch.ContinueRequest(ev.RequestID).Do(executor)
+			}()
+		case *fetch.EventAuthRequired:
+			// Sign in to the proxy; a site's own challenge gets the
+			// browser's default, which headless cancels
+			go func() {
+				c := chromedp.FromContext(timeoutCtx)
+				response := &fetch.AuthChallengeResponse{Response: fetch.AuthChallengeResponseResponseDefault}
+				if ev.AuthChallenge.Source == fetch.AuthChallengeSourceProxy {
+					response = proxyCredentials(proxyURL)
+				}
+				fetch.ContinueWithAuth(ev.RequestID, response).
+					Do(cdp.WithExecutor(timeoutCtx, c.Target))
+			}()
 		}
 	})
 
 	// Navigate and wait with smart strategy
-	err := chromedp.Run(timeoutCtx,
+	err = chromedp.Run(timeoutCtx,
 		// Enable network events
 		network.Enable(),
 
-		// Set up request interception to block resources
+		// Use SetCacheDisabled to improve performance
+		network.SetCacheDisabled(true),
+
+		// Intercept every request, redirects and subresources included, so
+		// the listener can check where it goes; auth challenges are only
+		// reported for intercepted requests too
+		fetch.Enable().
+			WithPatterns([]*fetch.RequestPattern{{URLPattern: "*"}}).
+			WithHandleAuthRequests(proxyURL != nil && proxyURL.User != nil),
+
+		// The browser instance is shared: start from an empty cookie store,
+		// so no earlier fetch's cookies are sent, then install the session's
+		// and the request's before navigating
+		network.ClearBrowserCookies(),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			cookies := browserCookies(jar, fetchReq.Cookies, fetchURL)
+			if len(cookies) == 0 {
+				return nil
+			}
+			if err := network.SetCookies(cookies).Do(ctx); err != nil {
+				return fmt.Errorf("failed to set cookies: %w", err)
+			}
+			return nil
+		}),
+
+		// Override the UA the browser was launched with, and its languages.
+		// A remote browser's own UA is unknown, so it's always replaced.
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			userAgent := fetchReq.UserAgent
+			if userAgent == "" {
+				userAgent = types.DefaultUserAgent
+			}
+			if userAgent == types.DefaultUserAgent && fetchReq.Locale == "" && !e.pool.remote {
+				return nil
+			}
+			languages := "en-US,en;q=0.9"
+			if fetchReq.Locale != "" {
+				languages = acceptLanguage(fetchReq.Locale)
+			}
+			return emulation.SetUserAgentOverride(userAgent).
+				WithPlatform(uaPlatform(userAgent)).
+				WithAcceptLanguage(languages).
+				Do(ctx)
+		}),
+
+		// Emulate the requested region: the locale Intl formats dates and
+		// numbers in, the time zone and the position
 		chromedp.ActionFunc(func(ctx context.Context) error {
-			// Enable fetch domain
-			if err := network.Enable().Do(ctx); err != nil {
+			if fetchReq.Locale != "" {
+				// Chrome takes ICU locales, with underscores
+				locale := strings.ReplaceAll(fetchReq.Locale, "-", "_")
+				if err := emulation.SetLocaleOverride().WithLocale(locale).Do(ctx); err != nil {
+					return fmt.Errorf("failed to set locale: %w", err)
+				}
+			}
+			if fetchReq.Timezone != "" {
+				if err := emulation.SetTimezoneOverride(fetchReq.Timezone).Do(ctx); err != nil {
+					return fmt.Errorf("failed to set timezone %s: %w", fetchReq.Timezone, err)
+				}
+			}
+			if geo := fetchReq.Geolocation; geo != nil {
+				accuracy := geo.Accuracy
+				if accuracy == 0 {
+					accuracy = 100
+				}
+				err := browser.GrantPermissions([]browser.PermissionType{browser.PermissionTypeGeolocation}).Do(ctx)
+				if err != nil {
+					return fmt.Errorf("failed to grant geolocation: %w", err)
+				}
+				err = emulation.SetGeolocationOverride().
+					WithLatitude(geo.Latitude).
+					WithLongitude(geo.Longitude).
+					WithAccuracy(accuracy).
+					Do(ctx)
+				if err != nil {
+					return fmt.Errorf("failed to set geolocation: %w", err)
+				}
+			}
+			return nil
+		}),
+
+		// Emulate the requested screen
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			viewport := fetchReq.Viewport
+			if viewport == nil {
+				return nil
+			}
+			scale := viewport.DeviceScaleFactor
+			if scale == 0 {
+				scale = 1
+			}
+			err := emulation.SetDeviceMetricsOverride(int64(viewport.Width), int64(viewport.Height), scale, viewport.Mobile).
+				WithScreenWidth(int64(viewport.Width)).
+				WithScreenHeight(int64(viewport.Height)).
+				Do(ctx)
+			if err != nil || !viewport.Mobile {
 				return err
 			}
+			return emulation.SetTouchEmulationEnabled(true).WithMaxTouchPoints(5).Do(ctx)
+		}),
 
-			// Note: Request interception patterns were removed as they're not available
-			// in the current chromedp version. Resource blocking is handled by
-			// browser flags instead.
+		// Hold the page's downloads to the bandwidth limit
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			rate := bandwidthRate(e.config, fetchReq)
+			if rate == 0 {
+				return nil
+			}
+			return network.EmulateNetworkConditions(false, 0, float64(rate), -1).Do(ctx)
+		}),
 
-			// Use SetCacheDisabled to improve performance
-			return network.SetCacheDisabled(true).Do(ctx)
+		// Send the request's own headers with the navigation; credentials
+		// are added by the listener, to same-origin requests only
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			headers := network.Headers{}
+			for _, field := range customHeaders(fetchReq.Headers) {
+				headers[field.name] = field.value
+			}
+			if len(headers) == 0 {
+				return nil
+			}
+			return network.SetExtraHTTPHeaders(headers).Do(ctx)
 		}),
 
 		// Navigate to URL
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			navigationStart = time.Now()
+			return nil
+		}),
 		chromedp.Navigate(fetchURL),
 
 		// Smart wait strategy
 		chromedp.ActionFunc(func(ctx context.Context) error {
+			navigationDone = time.Now()
+
 			// Wait for initial page load
 			if err := chromedp.WaitReady("body", chromedp.ByQuery).Do(ctx); err != nil {
 				return err
 			}
 
 			// Smart wait: monitor network and DOM changes
-			return waitForPageStability(ctx, 15*time.Second)
+			err := waitForPageStability(ctx, 15*time.Second)
+			timing.StabilityMs = time.Since(navigationDone).Milliseconds()
+			return err
+		}),
+
+		// Run the caller's interaction steps
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			return runActions(ctx, fetchReq.Actions)
+		}),
+
+		// Load what infinite scrolling and lazy loading hold back
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			return autoScroll(ctx, fetchReq.Scroll)
 		}),
 
-		// Get the HTML content
+		// Give delayed timers the caller knows about time to run
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			if fetchReq.WaitMs <= 0 {
+				return nil
+			}
+			return chromedp.Sleep(time.Duration(fetchReq.WaitMs) * time.Millisecond).Do(ctx)
+		}),
+
+		// Get the HTML content and where navigation ended up
 		chromedp.OuterHTML("html", &htmlContent),
+		chromedp.Location(&finalURL),
+
+		// Hand the cookies the page set to the caller
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			if !fetchReq.ReturnCookies {
+				return nil
+			}
+			urls := []string{fetchURL}
+			if finalURL != "" && finalURL != fetchURL {
+				urls = append(urls, finalURL)
+			}
+			cookies, err := network.GetCookies().WithUrls(urls).Do(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to read page cookies: %w", err)
+			}
+			pageCookies = exportCookies(cookies)
+			if jar != nil {
+				// Cleared once the session has its copy
+				return nil
+			}
+			return network.ClearBrowserCookies().Do(ctx)
+		}),
+
+		// Carry cookies the page set back into the session
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			if jar == nil {
+				return nil
+			}
+			cookies, err := network.GetCookies().WithUrls([]string{fetchURL}).Do(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to read session cookies: %w", err)
+			}
+			storeSessionCookies(jar, fetchURL, cookies)
+
+			// The browser instance is shared; don't leak this session into the next fetch
+			return network.ClearBrowserCookies().Do(ctx)
+		}),
 	)
 
 	if err != nil {
-		return types.ErrorResponse(fetchURL, types.EngineChrome, err, time.Since(startTime)), err
+		redirectsMu.Lock()
+		if navigationBlocked != nil {
+			err = navigationBlocked
+		}
+		redirectsMu.Unlock()
+		if ctx.Err() != nil {
+			// The tab was closed because the caller gave up
+			err = ctx.Err()
+		}
+		err = classifyError(err)
+		response := types.ErrorResponse(fetchURL, types.EngineChrome, err, time.Since(startTime))
+		redirectsMu.Lock()
+		response.BytesReceived = received
+		redirectsMu.Unlock()
+		response.RenderMs = time.Since(renderStart).Milliseconds()
+		console.apply(response)
+		if requests != nil {
+			requests.apply(response)
+		}
+		return response, err
 	}
 
 	// Truncate content if needed
@@ -139,20 +595,111 @@ func (e *ChromeEngine) Fetch(fetchURL string, maxContentLength int) (*types.Fetc
 		htmlContent = htmlContent[:maxContentLength]
 	}
 
+	redirectsMu.Lock()
 	response := &types.FetchResponse{
 		URL:             fetchURL,
+		FinalURL:        finalURL,
 		Engine:          types.EngineChrome,
 		StatusCode:      int(statusCode),
 		ContentType:     contentType,
+		Charset:         "utf-8", // The DOM is always serialized as UTF-8
+		Protocol:        protocol,
 		Content:         htmlContent,
 		Format:          types.FormatHTML, // Will be processed later
 		FetchTimeMs:     time.Since(startTime).Milliseconds(),
 		ChromeAvailable: true,
 	}
+	timing.NavigationMs = between(navigationStart, navigationDone)
+	response.Redirects = redirects
+	response.Timing = timing
+	response.BytesReceived = received
+	redirectsMu.Unlock()
+	response.RenderMs = time.Since(renderStart).Milliseconds()
+	response.Cookies = pageCookies
+	console.apply(response)
+	if requests != nil {
+		requests.apply(response)
+	}
+	if fetchReq.ForceHTTP1 {
+		response.Warnings = append(response.Warnings,
+			"force_http1 is not supported by the chrome engine; the browser negotiated its own protocol")
+	}
+	if fetchReq.MaxRedirects != nil {
+		response.Warnings = append(response.Warnings,
+			"max_redirects is not supported by the chrome engine; the browser followed redirects itself")
+	}
+	if fetchReq.Range != nil {
+		response.Warnings = append(response.Warnings,
+			"range_start/range_end are not supported by the chrome engine; the whole page was fetched")
+	}
 
 	return response, nil
 }
 
+// newTab opens a tab on a pooled browser. The tab is closed when ctx is done
+// or the configured timeout elapses, whichever comes first. With a proxyURL
+// the tab opens in a new browser context routed through it, as a browser's
+// own proxy is fixed at launch; the context is disposed with the tab.
+func (e *ChromeEngine) newTab(ctx context.Context, instanceID int, proxyURL *url.URL) (context.Context, context.CancelFunc) {
+	var opts []chromedp.ContextOption
+	if proxyURL != nil {
+		opts = append(opts, chromedp.WithNewBrowserContext(func(params *target.CreateBrowserContextParams) *target.CreateBrowserContextParams {
+			return params.WithProxyServer(proxyServer(proxyURL))
+		}))
+	}
+	tabCtx, cancel := chromedp.NewContext(e.pool.contexts[instanceID], opts...)
+	stop := context.AfterFunc(ctx, cancel)
+	timeoutCtx, timeoutCancel := context.WithTimeout(tabCtx, e.config.Timeout)
+
+	return timeoutCtx, func() {
+		timeoutCancel()
+		stop()
+		cancel()
+	}
+}
+
+// PoolStats returns current pool utilization; a zero value if Chrome is unavailable
+func (e *ChromeEngine) PoolStats() PoolStats {
+	if e.pool == nil {
+		return PoolStats{}
+	}
+
+	p := e.pool
+	size := len(p.contexts)
+	busy := size - len(p.available)
+
+	stats := PoolStats{
+		Size:        size,
+		Busy:        busy,
+		Utilization: float64(busy) / float64(size),
+		QueueDepth:  p.waiting.Load(),
+	}
+
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+
+	stats.RendersPerInstance = append([]int64(nil), p.renders...)
+
+	// Report the histogram cumulatively, Prometheus-style
+	var cumulative int64
+	for i, count := range p.waitBuckets {
+		cumulative += count
+		le := int64(-1)
+		if i < len(poolWaitBucketsMs) {
+			le = poolWaitBucketsMs[i]
+		}
+		stats.WaitHistogram = append(stats.WaitHistogram, WaitTimeBucket{LeMs: le, Count: cumulative})
+	}
+	stats.WaitCount = p.waitCount
+	stats.WaitSumMs = p.waitTotal.Milliseconds()
+	if p.waitCount > 0 {
+		stats.AvgWaitMs = float64(p.waitTotal.Milliseconds()) / float64(p.waitCount)
+	}
+	stats.MaxWaitMs = p.waitMax.Milliseconds()
+
+	return stats
+}
+
 // Close shuts down the browser pool
 func (e *ChromeEngine) Close() {
 	if e.pool != nil {
@@ -160,14 +707,28 @@ func (e *ChromeEngine) Close() {
 	}
 }
 
-// newBrowserPool creates a new browser pool
-func newBrowserPool(size int) *BrowserPool {
+// newBrowserPool creates a new browser pool of execPath instances
+func newBrowserPool(cfg *config.Config, execPath string) *BrowserPool {
+	size := cfg.ChromePoolSize
 	pool := &BrowserPool{
 		contexts:    make([]context.Context, size),
 		cancelFuncs: make([]context.CancelFunc, size),
 		available:   make(chan int, size),
+		ready:       make(chan struct{}),
+		renders:     make([]int64, size),
+		proxies:     make([]*url.URL, size),
+		waitBuckets: make([]int64, len(poolWaitBucketsMs)+1),
 	}
 
+	if cfg.ChromeWSURL != "" {
+		pool.remote = true
+		pool.failed = make(chan struct{})
+		go pool.connectRemote(cfg)
+		return pool
+	}
+
+	var warming sync.WaitGroup
+
 	// Initialize browser instances
 	for i := 0; i < size; i++ {
 		opts := append(chromedp.DefaultExecAllocatorOptions[:],
@@ -180,7 +741,20 @@ func newBrowserPool(size int) *BrowserPool {
 			chromedp.Flag("disable-features", "IsolateOrigins,site-per-process"),
 			chromedp.Flag("disable-blink-features", "AutomationControlled"),
 			chromedp.UserAgent(types.DefaultUserAgent),
+			chromedp.ExecPath(execPath),
 		)
+		for _, flag := range cfg.ChromeFlags {
+			opts = append(opts, chromeFlag(flag))
+		}
+		// Spread the proxy pool across instances, since a browser's proxy is
+		// fixed at launch. Chrome won't take credentials on the command
+		// line, so they're kept to answer the proxy's challenges.
+		if len(cfg.Proxies) > 0 {
+			if proxyURL, err := proxy.ParseURL(cfg.Proxies[i%len(cfg.Proxies)]); err == nil {
+				pool.proxies[i] = proxyURL
+				opts = append(opts, chromedp.ProxyServer(proxyServer(proxyURL)))
+			}
+		}
 
 		allocCtx, allocCancel := chromedp.NewExecAllocator(context.Background(), opts...)
 		browserCtx, browserCancel := chromedp.NewContext(allocCtx)
@@ -193,19 +767,140 @@ func newBrowserPool(size int) *BrowserPool {
 		pool.available <- i
 
 		// Pre-warm the browser instance
+		warming.Add(1)
 		go func(ctx context.Context) {
+			defer warming.Done()
 			chromedp.Run(ctx)
 		}(browserCtx)
 	}
 
+	// Signal readiness once every instance has launched
+	go func() {
+		warming.Wait()
+		close(pool.ready)
+	}()
+
 	return pool
 }
 
+// connectRemote attaches the pool to the browser at cfg.ChromeWSURL. Each
+// instance gets its own browser context there, so fetches keep apart
+// cookies and cache as separately launched browsers would, and its proxy
+// from the pool. Instances become available once all are connected.
+func (p *BrowserPool) connectRemote(cfg *config.Config) {
+	defer close(p.ready)
+	wsURL := cfg.ChromeWSURL
+
+	// A ws:// URL is used as given, since services such as browserless
+	// serve the protocol at their own paths; an http:// one is the
+	// browser's debugging port, asked for its websocket URL
+	var opts []chromedp.RemoteAllocatorOption
+	if strings.HasPrefix(wsURL, "ws://") || strings.HasPrefix(wsURL, "wss://") {
+		opts = append(opts, chromedp.NoModifyURL)
+	}
+	allocCtx, allocCancel := chromedp.NewRemoteAllocator(context.Background(), wsURL, opts...)
+	browserCtx, browserCancel := chromedp.NewContext(allocCtx)
+	cancelFuncs := []context.CancelFunc{browserCancel, allocCancel}
+	contexts := make([]context.Context, len(p.contexts))
+
+	err := chromedp.Run(browserCtx)
+	for i := range contexts {
+		if err != nil {
+			break
+		}
+		var options []chromedp.CreateBrowserContextOption
+		if len(cfg.Proxies) > 0 {
+			if proxyURL, err := proxy.ParseURL(cfg.Proxies[i%len(cfg.Proxies)]); err == nil {
+				p.proxies[i] = proxyURL
+				options = append(options, func(params *target.CreateBrowserContextParams) *target.CreateBrowserContextParams {
+					return params.WithProxyServer(proxyServer(proxyURL))
+				})
+			}
+		}
+		ctx, cancel := chromedp.NewContext(browserCtx, chromedp.WithNewBrowserContext(options...))
+		contexts[i] = ctx
+		cancelFuncs = append([]context.CancelFunc{cancel}, cancelFuncs...)
+		err = chromedp.Run(ctx)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil || p.closed {
+		for _, cancel := range cancelFuncs {
+			cancel()
+		}
+		if err != nil {
+			// The dial error quotes the URL, token and all
+			redacted := redactedURL(wsURL)
+			reason := strings.ReplaceAll(err.Error(), wsURL, redacted)
+			p.err = fmt.Errorf("%w: can't connect to %s: %s", ErrChromeUnavailable, redacted, reason)
+			log.Printf("Warning: %v", p.err)
+			close(p.failed)
+		}
+		return
+	}
+	copy(p.contexts, contexts)
+	p.cancelFuncs = cancelFuncs
+	for i := range contexts {
+		p.available <- i
+	}
+}
+
+// redactedURL returns rawURL without the credentials or tokens a remote
+// browser service may carry in its userinfo or query
+func redactedURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "the remote browser"
+	}
+	return u.Scheme + "://" + u.Host + u.Path
+}
+
+// acquire waits for a free browser instance, giving up if ctx is done first
+// or a remote browser can't be reached. The caller must send the instance
+// back to available when finished.
+func (p *BrowserPool) acquire(ctx context.Context) (int, error) {
+	p.waiting.Add(1)
+	defer p.waiting.Add(-1)
+
+	select {
+	case instanceID := <-p.available:
+		return instanceID, nil
+	case <-p.failed:
+		return 0, p.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// recordAcquire records a render on an instance and how long it was queued for
+func (p *BrowserPool) recordAcquire(instanceID int, wait time.Duration) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+
+	p.renders[instanceID]++
+	p.waitCount++
+	p.waitTotal += wait
+	if wait > p.waitMax {
+		p.waitMax = wait
+	}
+
+	bucket := len(poolWaitBucketsMs)
+	for i, le := range poolWaitBucketsMs {
+		if wait.Milliseconds() <= le {
+			bucket = i
+			break
+		}
+	}
+	p.waitBuckets[bucket]++
+}
+
 // Close shuts down all browser instances in the pool
 func (p *BrowserPool) Close() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	p.closed = true
 	close(p.available)
 	for _, cancel := range p.cancelFuncs {
 		if cancel != nil {
@@ -214,39 +909,76 @@ func (p *BrowserPool) Close() {
 	}
 }
 
-// checkChromeAvailable checks if Chrome/Chromium is available on the system
-func checkChromeAvailable() bool {
-	// Check common Chrome/Chromium paths
-	chromePaths := []string{
+// findChrome returns the browser executable to launch: path if given and
+// it exists, otherwise the first Chrome, Chromium, Edge or Brave found on
+// PATH or in its usual install location. It returns "" if there is none.
+func findChrome(path string) string {
+	if path != "" {
+		if found, err := exec.LookPath(path); err == nil {
+			return found
+		}
+		return ""
+	}
+	for _, candidate := range chromeCandidates() {
+		if found, err := exec.LookPath(candidate); err == nil {
+			return found
+		}
+	}
+	return ""
+}
+
+// chromeCandidates lists the executables findChrome looks for, in order of
+// preference: Chrome, then Chromium, Edge and Brave
+func chromeCandidates() []string {
+	candidates := []string{
 		"google-chrome",
+		"google-chrome-stable",
 		"chromium",
 		"chromium-browser",
 		"chrome",
+		"microsoft-edge",
+		"microsoft-edge-stable",
+		"brave-browser",
+		"brave",
 		"/usr/bin/google-chrome",
 		"/usr/bin/chromium",
 		"/usr/bin/chromium-browser",
+		"/snap/bin/chromium",
+		"/opt/google/chrome/chrome",
+		"/opt/microsoft/msedge/msedge",
+		"/opt/brave.com/brave/brave",
 		"/Applications/Google Chrome.app/Contents/MacOS/Google Chrome",
 		"/Applications/Chromium.app/Contents/MacOS/Chromium",
+		"/Applications/Microsoft Edge.app/Contents/MacOS/Microsoft Edge",
+		"/Applications/Brave Browser.app/Contents/MacOS/Brave Browser",
 	}
 
-	for _, path := range chromePaths {
-		if _, err := exec.LookPath(path); err == nil {
-			return true
+	// Windows installs per machine or per user, outside PATH
+	if runtime.GOOS == "windows" {
+		for _, env := range []string{"ProgramFiles", "ProgramFiles(x86)", "LocalAppData"} {
+			dir := os.Getenv(env)
+			if dir == "" {
+				continue
+			}
+			candidates = append(candidates,
+				filepath.Join(dir, `Google\Chrome\Application\chrome.exe`),
+				filepath.Join(dir, `Chromium\Application\chrome.exe`),
+				filepath.Join(dir, `Microsoft\Edge\Application\msedge.exe`),
+				filepath.Join(dir, `BraveSoftware\Brave-Browser\Application\brave.exe`),
+			)
 		}
 	}
+	return candidates
+}
 
-	// Try to execute chrome with version flag
-	cmd := exec.Command("google-chrome", "--version")
-	if err := cmd.Run(); err == nil {
-		return true
-	}
-
-	cmd = exec.Command("chromium", "--version")
-	if err := cmd.Run(); err == nil {
-		return true
+// chromeFlag turns a --name or --name=value command line flag into an
+// allocator option, which replaces a built-in flag of the same name
+func chromeFlag(flag string) chromedp.ExecAllocatorOption {
+	name, value, found := strings.Cut(strings.TrimPrefix(flag, "--"), "=")
+	if !found {
+		return chromedp.Flag(name, true)
 	}
-
-	return false
+	return chromedp.Flag(name, value)
 }
 
 // waitForPageStability implements smart wait strategy
@@ -306,3 +1038,301 @@ func waitForPageStability(ctx context.Context, maxWait time.Duration) error {
 		}
 	}
 }
+
+// analyticsPatterns match the requests of common third-party analytics,
+// tag managers and ad trackers
+var analyticsPatterns = []string{
+	"*://*.google-analytics.com/*",
+	"*://*.googletagmanager.com/*",
+	"*://*.googlesyndication.com/*",
+	"*://*.doubleclick.net/*",
+	"*://connect.facebook.net/*",
+	"*://*.hotjar.com/*",
+	"*://cdn.segment.com/*",
+	"*://*.mixpanel.com/*",
+	"*://*.amplitude.com/*",
+	"*://*.clarity.ms/*",
+	"*://*.newrelic.com/*",
+	"*://*.nr-data.net/*",
+	"*://*.scorecardresearch.com/*",
+	"*://*.quantserve.com/*",
+}
+
+// proxyServer returns the --proxy-server value for proxyURL: its scheme,
+// host and port, without credentials. Chrome always resolves names through
+// a SOCKS5 proxy, so socks5h is plain socks5 to it.
+func proxyServer(proxyURL *url.URL) string {
+	scheme := proxyURL.Scheme
+	if scheme == "socks5h" {
+		scheme = "socks5"
+	}
+	return scheme + "://" + proxyURL.Host
+}
+
+// proxyCredentials answers a proxy's auth challenge with proxyURL's
+// credentials, or cancels it if there are none
+func proxyCredentials(proxyURL *url.URL) *fetch.AuthChallengeResponse {
+	if proxyURL == nil || proxyURL.User == nil {
+		return &fetch.AuthChallengeResponse{Response: fetch.AuthChallengeResponseResponseCancelAuth}
+	}
+	password, _ := proxyURL.User.Password()
+	return &fetch.AuthChallengeResponse{
+		Response: fetch.AuthChallengeResponseResponseProvideCredentials,
+		Username: proxyURL.User.Username(),
+		Password: password,
+	}
+}
+
+// sameOrigin reports whether rawURL has the scheme, host and port of target
+func sameOrigin(rawURL, target string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	t, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == t.Scheme && strings.EqualFold(u.Hostname(), t.Hostname()) && originPort(u) == originPort(t)
+}
+
+// originPort returns u's port, or its scheme's default
+func originPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	switch u.Scheme {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	}
+	return ""
+}
+
+// withAuthorization returns an intercepted request's headers with an
+// Authorization header, replacing any the page set itself
+func withAuthorization(headers network.Headers, authorization string) []*fetch.HeaderEntry {
+	entries := make([]*fetch.HeaderEntry, 0, len(headers)+1)
+	for name, value := range headers {
+		if strings.EqualFold(name, "Authorization") {
+			continue
+		}
+		entries = append(entries, &fetch.HeaderEntry{Name: name, Value: fmt.Sprint(value)})
+	}
+	return append(entries, &fetch.HeaderEntry{Name: "Authorization", Value: authorization})
+}
+
+// blockedRequest reports whether a request of resourceType for rawURL is
+// for a blocked resource
+func blockedRequest(block []string, resourceType network.ResourceType, rawURL string) bool {
+	for _, resource := range block {
+		switch resource {
+		case types.BlockImage:
+			if resourceType == network.ResourceTypeImage {
+				return true
+			}
+		case types.BlockFont:
+			if resourceType == network.ResourceTypeFont {
+				return true
+			}
+		case types.BlockMedia:
+			if resourceType == network.ResourceTypeMedia {
+				return true
+			}
+		case types.BlockAnalytics:
+			if analyticsPattern.MatchString(rawURL) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// analyticsPattern is analyticsPatterns as one regular expression, for
+// requests intercepted by a catch-all pattern
+var analyticsPattern = func() *regexp.Regexp {
+	alternatives := make([]string, len(analyticsPatterns))
+	for i, pattern := range analyticsPatterns {
+		alternatives[i] = strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	}
+	return regexp.MustCompile("^(?:" + strings.Join(alternatives, "|") + ")$")
+}()
+
+// actionKeys maps the named keys of press actions to their key codes
+var actionKeys = map[string]string{
+	"Enter":      kb.Enter,
+	"Tab":        kb.Tab,
+	"Escape":     kb.Escape,
+	"Backspace":  kb.Backspace,
+	"Delete":     kb.Delete,
+	"ArrowUp":    kb.ArrowUp,
+	"ArrowDown":  kb.ArrowDown,
+	"ArrowLeft":  kb.ArrowLeft,
+	"ArrowRight": kb.ArrowRight,
+	"PageUp":     kb.PageUp,
+	"PageDown":   kb.PageDown,
+	"Home":       kb.Home,
+	"End":        kb.End,
+}
+
+// runActions runs scripted interaction steps in order. Steps that may set
+// off requests let the page settle before the next one.
+func runActions(ctx context.Context, actions []types.Action) error {
+	for i, action := range actions {
+		if err := runAction(ctx, action); err != nil {
+			return fmt.Errorf("action %d (%s) failed: %w", i+1, action.Type, err)
+		}
+	}
+	return nil
+}
+
+// runAction runs one interaction step
+func runAction(ctx context.Context, action types.Action) error {
+	switch action.Type {
+	case types.ActionClick:
+		if err := chromedp.Click(action.Selector, chromedp.ByQuery, chromedp.NodeVisible).Do(ctx); err != nil {
+			return err
+		}
+	case types.ActionType:
+		return chromedp.SendKeys(action.Selector, action.Text, chromedp.ByQuery, chromedp.NodeVisible).Do(ctx)
+	case types.ActionPress:
+		key, ok := actionKeys[action.Key]
+		if !ok {
+			key = action.Key
+		}
+		if err := chromedp.KeyEvent(key).Do(ctx); err != nil {
+			return err
+		}
+	case types.ActionWait:
+		if action.Selector != "" {
+			return chromedp.WaitVisible(action.Selector, chromedp.ByQuery).Do(ctx)
+		}
+		return chromedp.Sleep(time.Duration(action.Ms) * time.Millisecond).Do(ctx)
+	case types.ActionScroll:
+		var err error
+		if action.Selector != "" {
+			err = chromedp.ScrollIntoView(action.Selector, chromedp.ByQuery).Do(ctx)
+		} else {
+			err = chromedp.Evaluate(`window.scrollTo(0, document.documentElement.scrollHeight)`, nil).Do(ctx)
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown action type %q", action.Type)
+	}
+	return waitForPageStability(ctx, 5*time.Second)
+}
+
+// autoScroll scrolls to the bottom of the page up to times times, letting
+// it settle after each, and stops once the page no longer grows
+func autoScroll(ctx context.Context, times int) error {
+	const script = `window.scrollTo(0, document.documentElement.scrollHeight); document.documentElement.scrollHeight`
+
+	var height int64
+	for i := 0; i < times; i++ {
+		var grown int64
+		if err := chromedp.Evaluate(script, &grown).Do(ctx); err != nil {
+			return fmt.Errorf("failed to scroll: %w", err)
+		}
+		if err := waitForPageStability(ctx, 5*time.Second); err != nil {
+			return err
+		}
+		if grown <= height {
+			return nil
+		}
+		height = grown
+	}
+	return nil
+}
+
+// browserCookies returns the cookies to install before navigating to
+// rawURL: every cookie in the session, for whichever site set it, so
+// redirects through other hosts carry theirs too, then the request's.
+// A request cookie without a domain is set for rawURL's host only.
+func browserCookies(jar *session.Session, cookies []types.Cookie, rawURL string) []*network.CookieParam {
+	var params []*network.CookieParam
+	if jar != nil {
+		for origin, stored := range jar.AllCookies() {
+			for _, c := range stored {
+				param := &network.CookieParam{
+					Name:     c.Name,
+					Value:    c.Value,
+					URL:      origin,
+					Domain:   c.Domain,
+					Path:     c.Path,
+					Secure:   c.Secure,
+					HTTPOnly: c.HttpOnly,
+				}
+				if !c.Expires.IsZero() {
+					expires := cdp.TimeSinceEpoch(c.Expires)
+					param.Expires = &expires
+				}
+				params = append(params, param)
+			}
+		}
+	}
+	for _, c := range cookies {
+		param := &network.CookieParam{Name: c.Name, Value: c.Value, URL: rawURL, Path: c.Path}
+		if c.Domain != "" {
+			// The domain may not be rawURL's, e.g. to reach a redirect target
+			param.URL, param.Domain = "", c.Domain
+			if param.Path == "" {
+				param.Path = "/"
+			}
+		}
+		params = append(params, param)
+	}
+	return params
+}
+
+// exportCookies converts cookies read from the browser for the caller
+func exportCookies(cookies []*network.Cookie) []types.PageCookie {
+	exported := make([]types.PageCookie, 0, len(cookies))
+	for _, c := range cookies {
+		expires := c.Expires
+		if c.Session {
+			expires = -1
+		}
+		exported = append(exported, types.PageCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Expires:  expires,
+			HTTPOnly: c.HTTPOnly,
+			Secure:   c.Secure,
+			SameSite: string(c.SameSite),
+		})
+	}
+	return exported
+}
+
+// storeSessionCookies saves cookies read from the browser into the session
+func storeSessionCookies(jar *session.Session, rawURL string, cookies []*network.Cookie) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+
+	converted := make([]*http.Cookie, 0, len(cookies))
+	for _, c := range cookies {
+		cookie := &http.Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HttpOnly: c.HTTPOnly,
+		}
+		// Host-only cookies come back without a leading dot and must stay host-only
+		if strings.HasPrefix(c.Domain, ".") {
+			cookie.Domain = c.Domain
+		}
+		if !c.Session && c.Expires > 0 {
+			cookie.Expires = time.Unix(int64(c.Expires), 0)
+		}
+		converted = append(converted, cookie)
+	}
+	jar.SetCookies(u, converted)
+}
@@ -0,0 +1,112 @@
+package fetcher
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gomcpgo/url_fetcher/pkg/compliance"
+	"github.com/gomcpgo/url_fetcher/pkg/config"
+)
+
+// CheckPolicy returns ErrDomainNotAllowed if rawURL's host is excluded by
+// the domain allowlist or denylist, and ErrBlockedByPolicy if rawURL is on a
+// configured blocklist. While no blocklist has loaded, every URL is refused
+// rather than fetched unchecked.
+func (f *Fetcher) CheckPolicy(rawURL string) error {
+	if err := checkDomain(f.config, hostOf(rawURL)); err != nil {
+		return err
+	}
+
+	if f.blocklist == nil {
+		return nil
+	}
+
+	entry, blocked, ok := f.blocklist.Check(rawURL)
+	if !ok {
+		return fmt.Errorf("%w: the blocklist could not be loaded", ErrBlockedByPolicy)
+	}
+	if !blocked {
+		return nil
+	}
+
+	detail := entry.Source
+	if entry.Jurisdiction != "" {
+		detail += ", " + entry.Jurisdiction
+	}
+	if entry.Reason != "" {
+		detail += ": " + entry.Reason
+	}
+	return fmt.Errorf("%w: %s matches %s (%s)", ErrBlockedByPolicy, rawURL, entry.Pattern, detail)
+}
+
+// CheckURL applies the checks Fetch makes before any network access, the
+// policy and the scheme policy, so fetches can be planned without being made
+func (f *Fetcher) CheckURL(rawURL string) error {
+	if err := f.CheckPolicy(rawURL); err != nil {
+		return err
+	}
+	return checkScheme(f.config, rawURL)
+}
+
+// checkDomain returns ErrDomainNotAllowed if host matches a denied pattern,
+// or an allowlist is configured and host matches none of it
+func checkDomain(cfg *config.Config, host string) error {
+	// URLs without a host, such as data: URLs, have no domain to check
+	if host == "" {
+		return nil
+	}
+	for _, pattern := range cfg.DenyDomains {
+		if matchDomain(pattern, host) {
+			return fmt.Errorf("%w: %s is denied by %s", ErrDomainNotAllowed, host, pattern)
+		}
+	}
+	if len(cfg.AllowDomains) == 0 {
+		return nil
+	}
+	for _, pattern := range cfg.AllowDomains {
+		if matchDomain(pattern, host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is not on the allowlist", ErrDomainNotAllowed, host)
+}
+
+// matchDomain reports whether host matches pattern: a glob if it contains
+// *, ? or [, and otherwise the domain itself or any subdomain of it
+func matchDomain(pattern, host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	if strings.ContainsAny(pattern, "*?[") {
+		matched, _ := path.Match(pattern, host)
+		return matched
+	}
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+// BlocklistStatus reports the loaded blocklist, or nil if none is configured
+func (f *Fetcher) BlocklistStatus() *compliance.Status {
+	if f.blocklist == nil {
+		return nil
+	}
+	status := f.blocklist.Status()
+	return &status
+}
+
+// refreshBlocklist periodically reloads the blocklist until the fetcher closes
+func (f *Fetcher) refreshBlocklist(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.blocklist.Refresh(); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		case <-f.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package fetcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	cdplog "github.com/chromedp/cdproto/log"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+const (
+	// maxConsoleMessages bounds how many messages a page adds to its
+	// response; later ones are counted but dropped
+	maxConsoleMessages = 50
+
+	// maxConsoleText bounds each message, since exceptions carry stack traces
+	maxConsoleText = 500
+)
+
+// consoleLog collects what a page logs while Chrome renders it
+type consoleLog struct {
+	mu       sync.Mutex
+	messages []types.ConsoleMessage
+	dropped  int
+}
+
+// listen is a chromedp target listener feeding the log
+func (c *consoleLog) listen(ev interface{}) {
+	switch ev := ev.(type) {
+	case *runtime.EventConsoleAPICalled:
+		texts := make([]string, 0, len(ev.Args))
+		for _, arg := range ev.Args {
+			texts = append(texts, remoteObjectText(arg))
+		}
+		source := ""
+		if ev.StackTrace != nil && len(ev.StackTrace.CallFrames) > 0 {
+			frame := ev.StackTrace.CallFrames[0]
+			source = sourceLocation(frame.URL, frame.LineNumber)
+		}
+		c.add(string(ev.Type), strings.Join(texts, " "), source)
+	case *runtime.EventExceptionThrown:
+		details := ev.ExceptionDetails
+		text := details.Text
+		if details.Exception != nil && details.Exception.Description != "" {
+			text = details.Exception.Description
+		}
+		c.add("exception", text, sourceLocation(details.URL, details.LineNumber))
+	case *cdplog.EventEntryAdded:
+		entry := ev.Entry
+		if entry.Level != cdplog.LevelWarning && entry.Level != cdplog.LevelError {
+			return
+		}
+		// Resources blocked on request fail by design
+		if strings.Contains(entry.Text, "ERR_BLOCKED_BY_CLIENT") {
+			return
+		}
+		c.add(string(entry.Level), entry.Text, sourceLocation(entry.URL, entry.LineNumber))
+	}
+}
+
+// add records a message, truncating its text at a rune boundary
+func (c *consoleLog) add(level, text, source string) {
+	if len(text) > maxConsoleText {
+		cut := maxConsoleText
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut] + "..."
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.messages) >= maxConsoleMessages {
+		c.dropped++
+		return
+	}
+	c.messages = append(c.messages, types.ConsoleMessage{Level: level, Text: text, Source: source})
+}
+
+// apply adds the collected messages to a response, with a warning if some
+// were dropped
+func (c *consoleLog) apply(response *types.FetchResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	response.Console = c.messages
+	if c.dropped > 0 {
+		response.Warnings = append(response.Warnings,
+			fmt.Sprintf("%d more console messages were dropped", c.dropped))
+	}
+}
+
+// remoteObjectText renders a console call argument the way DevTools prints it
+func remoteObjectText(obj *runtime.RemoteObject) string {
+	if len(obj.Value) > 0 {
+		var s string
+		if json.Unmarshal(obj.Value, &s) == nil {
+			return s
+		}
+		return string(obj.Value)
+	}
+	if obj.UnserializableValue != "" {
+		return string(obj.UnserializableValue)
+	}
+	if obj.Description != "" {
+		return obj.Description
+	}
+	return string(obj.Type)
+}
+
+// sourceLocation formats a URL and 0-based line number; "" without a URL
+func sourceLocation(url string, line int64) string {
+	if url == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", url, line+1)
+}
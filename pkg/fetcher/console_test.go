@@ -0,0 +1,92 @@
+package fetcher
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	cdplog "github.com/chromedp/cdproto/log"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// TestConsoleLog tests which of a page's console calls, exceptions and log
+// entries are collected, and how
+func TestConsoleLog(t *testing.T) {
+	console := &consoleLog{}
+	console.listen(&runtime.EventConsoleAPICalled{
+		Type: runtime.APITypeLog,
+		Args: []*runtime.RemoteObject{
+			{Type: runtime.TypeString, Value: []byte(`"loaded"`)},
+			{Type: runtime.TypeNumber, Value: []byte(`42`)},
+			{Type: runtime.TypeNumber, UnserializableValue: "NaN"},
+			{Type: runtime.TypeObject, Description: "Object"},
+		},
+		StackTrace: &runtime.StackTrace{CallFrames: []*runtime.CallFrame{{URL: "https://example.com/app.js", LineNumber: 9}}},
+	})
+	console.listen(&runtime.EventExceptionThrown{ExceptionDetails: &runtime.ExceptionDetails{
+		Text:       "Uncaught",
+		URL:        "https://example.com/app.js",
+		LineNumber: 19,
+		Exception:  &runtime.RemoteObject{Type: runtime.TypeObject, Description: "TypeError: x is undefined"},
+	}})
+	console.listen(&runtime.EventExceptionThrown{ExceptionDetails: &runtime.ExceptionDetails{Text: "Script error."}})
+
+	// Only warnings and errors are logged, less the requests blocked on purpose
+	for _, entry := range []*cdplog.Entry{
+		{Level: cdplog.LevelInfo, Text: "informational"},
+		{Level: cdplog.LevelVerbose, Text: "chatty"},
+		{Level: cdplog.LevelWarning, Text: "deprecated API", URL: "https://example.com/", LineNumber: 2},
+		{Level: cdplog.LevelError, Text: "Failed to load resource: net::ERR_BLOCKED_BY_CLIENT", URL: "https://ads.example/pixel"},
+		{Level: cdplog.LevelError, Text: "Failed to load resource: the server responded with a status of 404"},
+	} {
+		console.listen(&cdplog.EventEntryAdded{Entry: entry})
+	}
+
+	want := []types.ConsoleMessage{
+		{Level: "log", Text: "loaded 42 NaN Object", Source: "https://example.com/app.js:10"},
+		{Level: "exception", Text: "TypeError: x is undefined", Source: "https://example.com/app.js:20"},
+		{Level: "exception", Text: "Script error."},
+		{Level: "warning", Text: "deprecated API", Source: "https://example.com/:3"},
+		{Level: "error", Text: "Failed to load resource: the server responded with a status of 404"},
+	}
+	response := &types.FetchResponse{}
+	console.apply(response)
+	if !reflect.DeepEqual(response.Console, want) {
+		t.Errorf("Expected %+v, got %+v", want, response.Console)
+	}
+	if len(response.Warnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", response.Warnings)
+	}
+}
+
+// TestConsoleLogLimits tests truncating long messages on a rune boundary and
+// dropping messages over the limit with a warning
+func TestConsoleLogLimits(t *testing.T) {
+	console := &consoleLog{}
+
+	// The limit falls inside the two bytes of "é"
+	long := strings.Repeat("a", maxConsoleText-1) + strings.Repeat("é", 10)
+	console.add("error", long, "")
+	text := console.messages[0].Text
+	if !utf8.ValidString(text) || text != strings.Repeat("a", maxConsoleText-1)+"..." {
+		t.Errorf("Expected the text cut before the split rune, got %q", text[maxConsoleText-10:])
+	}
+
+	for i := 1; i < maxConsoleMessages+7; i++ {
+		console.add("log", fmt.Sprintf("message %d", i), "")
+	}
+	response := &types.FetchResponse{}
+	console.apply(response)
+	if len(response.Console) != maxConsoleMessages {
+		t.Errorf("Expected %d messages, got %d", maxConsoleMessages, len(response.Console))
+	}
+	if last := response.Console[len(response.Console)-1].Text; last != fmt.Sprintf("message %d", maxConsoleMessages-1) {
+		t.Errorf("Expected the earliest messages to be kept, last is %q", last)
+	}
+	if want := []string{"7 more console messages were dropped"}; !reflect.DeepEqual(response.Warnings, want) {
+		t.Errorf("Expected warnings %v, got %v", want, response.Warnings)
+	}
+}
@@ -0,0 +1,116 @@
+package fetcher
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+const (
+	// previewSize is how much of a streamed body is returned as content
+	previewSize = 64 * 1024
+
+	// downloadRetention is how long a streamed body is kept; older files
+	// are removed when the next one is written
+	downloadRetention = time.Hour
+
+	// downloadPattern names streamed bodies, so pruning only touches ours
+	downloadPattern = "url_fetcher-*.download"
+)
+
+// download is a response body streamed to disk
+type download struct {
+	path string
+	size int64
+}
+
+// streams reports whether a body larger than the stream threshold is
+// written to disk for this request. A max_content_length below the
+// threshold keeps its meaning as a hard limit.
+func (e *HTTPEngine) streams(fetchReq *types.FetchRequest) bool {
+	threshold := e.config.StreamThreshold
+	return threshold > 0 && int64(fetchReq.MaxContentLength) >= threshold
+}
+
+// maxBodySize is the largest body the request accepts: the maximum download
+// size when large bodies are streamed to disk (0 for no limit), and
+// max_content_length otherwise
+func (e *HTTPEngine) maxBodySize(fetchReq *types.FetchRequest) int64 {
+	if e.streams(fetchReq) {
+		return e.config.MaxDownloadSize
+	}
+	return int64(fetchReq.MaxContentLength)
+}
+
+// streamToFile writes a body that outgrew the in-memory threshold to a file
+// in the download directory: head is what has already been read and rest
+// the remainder. A body over the maximum download size is removed and
+// reported as too large.
+func (e *HTTPEngine) streamToFile(head []byte, rest io.Reader) (*download, error) {
+	dir := e.config.DownloadDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	pruneDownloads(dir)
+
+	file, err := os.CreateTemp(dir, downloadPattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create download file: %w", err)
+	}
+
+	var reader io.Reader = io.MultiReader(bytes.NewReader(head), rest)
+	maxSize := e.config.MaxDownloadSize
+	if maxSize > 0 {
+		reader = io.LimitReader(reader, maxSize+1)
+	}
+	size, err := io.Copy(file, reader)
+	if err != nil {
+		err = fmt.Errorf("failed to read response: %w", classifyError(err))
+	} else if maxSize > 0 && size > maxSize {
+		err = fmt.Errorf("%w of %d bytes", ErrContentTooLarge, maxSize)
+	}
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write download file: %w", closeErr)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	return &download{path: file.Name(), size: size}, nil
+}
+
+// pruneDownloads removes streamed bodies older than downloadRetention
+func pruneDownloads(dir string) {
+	paths, _ := filepath.Glob(filepath.Join(dir, downloadPattern))
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > downloadRetention {
+			os.Remove(path)
+		}
+	}
+}
+
+// downloadPreview returns the start of a streamed body to process in its
+// place, or nil for a type with no text to preview
+func downloadPreview(head []byte, contentType string) []byte {
+	if !processableType(contentType) {
+		return nil
+	}
+	preview := head[:min(len(head), previewSize)]
+	// Back up to a rune boundary so the preview doesn't end partway through one
+	for i := len(preview) - 1; i >= 0 && i >= len(preview)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(preview[i]) {
+			if !utf8.FullRune(preview[i:]) {
+				preview = preview[:i]
+			}
+			break
+		}
+	}
+	return preview
+}
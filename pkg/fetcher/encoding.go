@@ -0,0 +1,89 @@
+package fetcher
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// acceptEncoding advertises exactly the content codings decodeContent
+// handles; advertising more gets us bodies we can only return as junk
+const acceptEncoding = "gzip, deflate, br, zstd"
+
+// zstdMaxWindow caps the memory a zstd stream may make the decoder allocate,
+// whatever window size the server's frame header asks for
+const zstdMaxWindow = 8 << 20
+
+// decodeContent wraps body with decoders for a Content-Encoding header value.
+// Codings are listed in the order they were applied, so they are undone in
+// reverse. The caller bounds the decompressed size by limiting how much it
+// reads, and must call the returned cleanup when done.
+func decodeContent(body io.Reader, contentEncoding string) (io.Reader, func(), error) {
+	codings := strings.Split(contentEncoding, ",")
+
+	var closers []func()
+	cleanup := func() {
+		for _, closeFn := range closers {
+			closeFn()
+		}
+	}
+
+	reader := body
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		switch coding {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			gzipReader, err := gzip.NewReader(reader)
+			if err != nil {
+				cleanup()
+				return nil, nil, fmt.Errorf("failed to create gzip reader: %w", err)
+			}
+			reader = gzipReader
+		case "deflate":
+			reader = newDeflateReader(reader)
+		case "br":
+			reader = brotli.NewReader(reader)
+		case "zstd":
+			zstdReader, err := zstd.NewReader(reader,
+				zstd.WithDecoderConcurrency(1),
+				zstd.WithDecoderMaxWindow(zstdMaxWindow))
+			if err != nil {
+				cleanup()
+				return nil, nil, fmt.Errorf("failed to create zstd reader: %w", err)
+			}
+			closers = append(closers, zstdReader.Close)
+			reader = zstdReader
+		default:
+			cleanup()
+			return nil, nil, fmt.Errorf("unsupported content encoding: %s", coding)
+		}
+	}
+	return reader, cleanup, nil
+}
+
+// newDeflateReader decodes "deflate", which should be zlib-wrapped (RFC 9110)
+// but which some servers send as a raw deflate stream
+func newDeflateReader(r io.Reader) io.Reader {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(2)
+	if err == nil && isZlibHeader(header) {
+		if zlibReader, err := zlib.NewReader(buffered); err == nil {
+			return zlibReader
+		}
+	}
+	return flate.NewReader(buffered)
+}
+
+// isZlibHeader checks the CMF/FLG bytes of a zlib stream: deflate method and
+// a header checksum that is a multiple of 31
+func isZlibHeader(header []byte) bool {
+	return header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
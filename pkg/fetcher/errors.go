@@ -0,0 +1,137 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Sentinel errors returned (possibly wrapped) by the fetcher and its engines.
+// Use errors.Is to test for them.
+var (
+	// ErrInvalidURL is returned when the URL cannot be parsed
+	ErrInvalidURL = errors.New("invalid URL")
+
+	// ErrUnsupportedScheme is returned for URL schemes the scheme policy
+	// doesn't allow or the engine can't fetch
+	ErrUnsupportedScheme = errors.New("unsupported scheme")
+
+	// ErrBlockedLocal is returned when BlockLocal rejects a local/private target
+	ErrBlockedLocal = errors.New("access to local/private IP addresses is blocked")
+
+	// ErrBlockedMetadata is returned for cloud metadata endpoints, which are
+	// refused whether or not BlockLocal is set
+	ErrBlockedMetadata = errors.New("access to cloud metadata endpoints is blocked")
+
+	// ErrTooManyRedirects is returned when the redirect limit is exceeded
+	ErrTooManyRedirects = errors.New("too many redirects")
+
+	// ErrUnsafeRedirect is returned when a redirect leads somewhere the
+	// original URL couldn't go, such as a local address; it also wraps the
+	// reason (e.g. ErrBlockedLocal)
+	ErrUnsafeRedirect = errors.New("redirect target rejected")
+
+	// ErrContentTooLarge is returned when the body exceeds max_content_length
+	ErrContentTooLarge = errors.New("content exceeds maximum length")
+
+	// ErrChromeUnavailable is returned when the Chrome engine is used without Chrome
+	ErrChromeUnavailable = errors.New("Chrome is not available on this system")
+
+	// ErrTimeout is returned when a fetch exceeds its deadline
+	ErrTimeout = errors.New("request timed out")
+
+	// ErrUnsupportedEngine is returned for unknown engine names
+	ErrUnsupportedEngine = errors.New("unsupported engine")
+
+	// ErrInvalidProxy is returned when a per-request proxy URL is unusable
+	ErrInvalidProxy = errors.New("invalid proxy")
+
+	// ErrSigning is returned when a signed URL can't be produced
+	ErrSigning = errors.New("cannot sign URL")
+
+	// ErrNoLoginFlow is returned when a login is requested for a session that
+	// has no configured flow
+	ErrNoLoginFlow = errors.New("no login flow configured for session")
+
+	// ErrBlockedByPolicy is returned when a URL matches a compliance blocklist,
+	// or no blocklist could be loaded to check it against
+	ErrBlockedByPolicy = errors.New("blocked by compliance policy")
+
+	// ErrDomainNotAllowed is returned for hosts excluded by the configured
+	// domain allowlist or denylist
+	ErrDomainNotAllowed = errors.New("domain not allowed")
+
+	// ErrOffline is returned for any fetch while the server is in offline
+	// mode, which answers only from its cache
+	ErrOffline = errors.New("offline mode: network fetches are disabled")
+
+	// ErrWarmingUp is returned when a Chrome fetch could not start because the
+	// browser pool was still warming up; callers may retry shortly
+	ErrWarmingUp = errors.New("chrome pool is still warming up, retry shortly")
+)
+
+// StatusError reports a non-success HTTP status from the origin server.
+// Challenge names the bot-protection service, if the response was one of
+// its challenge pages.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Challenge  string
+}
+
+func (e *StatusError) Error() string {
+	var msg string
+	if e.StatusCode >= 500 {
+		msg = fmt.Sprintf("server returned status %d", e.StatusCode)
+	} else {
+		msg = fmt.Sprintf("client error: %s", e.Status)
+	}
+	if e.Challenge != "" {
+		msg += fmt.Sprintf(" (%s bot challenge)", e.Challenge)
+	}
+	return msg
+}
+
+// classifyError wraps timeouts in ErrTimeout so callers don't need to know
+// whether the deadline came from net/http, a dialer or a context
+func classifyError(err error) error {
+	if err == nil || errors.Is(err, ErrTimeout) {
+		return err
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
+	}
+
+	return err
+}
+
+// IsUpstreamError reports whether err means the origin couldn't serve the
+// page just then: a timeout, a connection failure or a 5xx status. Other
+// errors, such as a 404 or a blocked URL, are about the request itself.
+func IsUpstreamError(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	return transientError(err)
+}
+
+// IsDeadURL reports whether err says the URL doesn't exist, rather than that
+// fetching it failed this time: a 404 or 410, or a host name that doesn't
+// resolve
+func IsDeadURL(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusGone
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsNotFound
+	}
+	return strings.Contains(err.Error(), "net::ERR_NAME_NOT_RESOLVED") // Chrome's DNS failure
+}
@@ -1,93 +1,479 @@
 package fetcher
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net/url"
 	"strings"
+	"time"
 
+	"github.com/gomcpgo/url_fetcher/pkg/compliance"
 	"github.com/gomcpgo/url_fetcher/pkg/config"
+	"github.com/gomcpgo/url_fetcher/pkg/metrics"
+	"github.com/gomcpgo/url_fetcher/pkg/proxy"
+	"github.com/gomcpgo/url_fetcher/pkg/session"
 	"github.com/gomcpgo/url_fetcher/pkg/types"
 )
 
 // Engine interface defines methods for fetching URLs
 type Engine interface {
-	Fetch(url string, maxContentLength int) (*types.FetchResponse, error)
+	Fetch(ctx context.Context, req *types.FetchRequest) (*types.FetchResponse, error)
 }
 
 // Fetcher manages URL fetching with multiple engines
 type Fetcher struct {
 	config       *config.Config
 	httpEngine   *HTTPEngine
+	http3Engine  *HTTPEngine
 	chromeEngine *ChromeEngine
+	metrics      *metrics.Collector
+	sessions     *session.Manager
+	oauth2       []*oauth2Provider
+	blocklist    *compliance.Blocklist
+	scheduler    *scheduler
+	limiter      *hostLimiter
+	uaRotator    *uaRotator
+	done         chan struct{}
 }
 
+// domainStatsSaveInterval is how often persisted domain stats are flushed
+const domainStatsSaveInterval = time.Minute
+
 // NewFetcher creates a new fetcher instance
 func NewFetcher(cfg *config.Config) *Fetcher {
-	return &Fetcher{
+	f := &Fetcher{
 		config:       cfg,
 		httpEngine:   NewHTTPEngine(cfg),
+		http3Engine:  NewHTTP3Engine(cfg),
 		chromeEngine: NewChromeEngine(cfg),
+		metrics:      metrics.NewCollector(),
+		oauth2:       newOAuth2Providers(cfg),
+		scheduler:    newScheduler(cfg.MaxConcurrency, cfg.MaxPerHost),
+		limiter:      newHostLimiter(cfg.HostRate, cfg.HostBurst),
+		uaRotator:    newUARotator(cfg),
+		done:         make(chan struct{}),
 	}
-}
 
-// Fetch retrieves content from a URL using the specified engine
-func (f *Fetcher) Fetch(req *types.FetchRequest) (*types.FetchResponse, error) {
-	// Set defaults
-	if req.Engine == "" {
-		req.Engine = types.DefaultEngine
+	sessions, err := session.NewManager(cfg.SessionsDir)
+	if err != nil {
+		log.Printf("Warning: %v", err)
 	}
-	if req.Format == "" {
-		req.Format = types.DefaultFormat
+	f.sessions = sessions
+	for name, path := range cfg.CookieFiles {
+		if _, err := sessions.ImportFile(name, path); err != nil {
+			log.Printf("Warning: failed to import cookies into session %s: %v", name, err)
+		}
 	}
-	if req.MaxContentLength == 0 {
-		req.MaxContentLength = types.DefaultMaxContentLength
+	f.httpEngine.sessions = sessions
+	f.http3Engine.sessions = sessions
+	f.chromeEngine.sessions = sessions
+
+	bandwidth := newBandwidthLimiter(cfg.BandwidthLimit)
+	f.httpEngine.bandwidth = bandwidth
+	f.http3Engine.bandwidth = bandwidth
+
+	if len(cfg.Blocklists) > 0 {
+		f.blocklist = compliance.New(cfg.Blocklists, cfg.Jurisdictions, cfg.Timeout)
+		if err := f.blocklist.Refresh(); err != nil {
+			log.Printf("Warning: %v; all fetches are refused until it loads", err)
+		}
+		if cfg.BlocklistRefresh > 0 {
+			go f.refreshBlocklist(cfg.BlocklistRefresh)
+		}
 	}
 
-	// Normalize engine name
-	req.Engine = strings.ToLower(req.Engine)
+	if cfg.DomainStatsFile != "" {
+		if err := f.metrics.LoadDomainStats(cfg.DomainStatsFile); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		go f.persistDomainStats()
+	}
+
+	return f
+}
+
+// Fetch retrieves content from a URL using the specified engine. Cancelling
+// ctx, or its deadline passing, aborts the request in flight.
+func (f *Fetcher) Fetch(ctx context.Context, req *types.FetchRequest) (*types.FetchResponse, error) {
+	f.ApplyDefaults(req)
 
 	var response *types.FetchResponse
 	var err error
 
+	if f.config.Offline {
+		return nil, ErrOffline
+	}
+	if err := f.CheckPolicy(req.URL); err != nil {
+		return nil, err
+	}
+	if err := checkScheme(f.config, req.URL); err != nil {
+		return nil, err
+	}
+	// Whichever engine fetches it
+	if err := checkTarget(f.config, req.URL); err != nil {
+		return nil, err
+	}
+
+	// Resolve the session up front so a bad name fails before any network I/O
+	if req.Session != "" {
+		if _, err := f.sessions.Get(req.Session); err != nil {
+			return nil, err
+		}
+		if err := f.ensureLoggedIn(ctx, req.Session); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err := f.sessions.Save(req.Session); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		}()
+	}
+
+	startTime := time.Now()
+
+	// Sign a copy of the request so the signature (and any expiry) is
+	// recomputed on every fetch and never becomes part of the reported URL
+	requestedURL := req.URL
+	if req.Sign != nil {
+		signedURL, err := signURL(req.URL, req.Sign, startTime)
+		if err != nil {
+			return nil, err
+		}
+		signed := *req
+		signed.URL = signedURL
+		req = &signed
+	}
+
+	// Rotate the default user agent on a copy, so the caller's request (and
+	// its cache key) keeps the one it asked for
+	rotatedUA := f.uaRotator.pick(req, hostOf(req.URL))
+	if rotatedUA != "" {
+		rotated := *req
+		rotated.UserAgent = rotatedUA
+		req = &rotated
+	}
+
+	// Authenticate to configured OAuth2-protected APIs
+	auth, err := f.oauth2Auth(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if auth != nil {
+		authed := *req
+		authed.Auth = auth
+		req = &authed
+	}
+
 	// Check Chrome availability
 	chromeAvailable := f.chromeEngine.IsAvailable()
 
-	// Select engine and fetch
+	// Select engine and fetch; inline data needs neither
+	if isDataURL(req.URL) {
+		response, err = fetchData(req)
+	} else {
+		// Wait for the host's rate limit, then for a slot under the global
+		// and per-host concurrency limits, so a rate-limited fetch doesn't
+		// hold a slot while it waits. A loopback server is the caller's own,
+		// such as one under development, so it isn't held to the limits
+		// that protect sites.
+		queueStart := time.Now()
+		if f.config.BlockLocal || !isLoopbackHost(hostOf(req.URL)) {
+			if waitErr := f.limiter.wait(ctx, hostOf(req.URL)); waitErr != nil {
+				return nil, waitErr
+			}
+			release, acquireErr := f.scheduler.acquire(ctx, hostOf(req.URL))
+			if acquireErr != nil {
+				return nil, acquireErr
+			}
+			defer release()
+		}
+		queued := time.Since(queueStart)
+
+		response, err = f.fetchWithEngine(ctx, req, chromeAvailable)
+		if response != nil && response.Timing != nil {
+			response.Timing.QueueMs += queued.Milliseconds()
+		}
+	}
+	if response == nil && (errors.Is(err, ErrWarmingUp) || errors.Is(err, ErrUnsupportedEngine)) {
+		return nil, err
+	}
+
+	// Chrome follows redirects itself, so check where it ended up
+	if err == nil && response.FinalURL != "" {
+		if domainErr := checkDomain(f.config, hostOf(response.FinalURL)); domainErr != nil {
+			response, err = nil, domainErr
+		}
+	}
+
+	// A session bounced to its login page has lost its login
+	sessionExpired := err == nil && req.Session != "" && f.atLoginWall(req, response)
+	if sessionExpired {
+		response, err = f.reauthenticate(ctx, req, response, chromeAvailable)
+	}
+
+	// Tell the caller when the page is an interstitial, not the content;
+	// reauthenticate has already explained an expired session's login page
+	if response != nil {
+		response.BlockedBy = detectBlock(req, response, err)
+		if response.BlockedBy != "" && err == nil && !(sessionExpired && response.BlockedBy == BlockedLoginWall) {
+			response.Warnings = append(response.Warnings, blockedWarning(response.BlockedBy))
+		}
+	}
+
+	engineUsed := req.Engine
+	if response != nil && response.Engine != "" {
+		engineUsed = response.Engine
+	}
+	f.metrics.RecordFetch(engineUsed, time.Since(startTime), err != nil)
+
+	contentBytes := 0
+	if response != nil && err == nil {
+		contentBytes = len(response.Content)
+	}
+	f.metrics.RecordDomainFetch(hostOf(req.URL), time.Since(startTime), contentBytes, err != nil)
+
+	if response != nil {
+		response.URL = requestedURL
+		// Report the URL as requested, not signed, when it wasn't redirected
+		if response.FinalURL == req.URL {
+			response.FinalURL = requestedURL
+		}
+		if len(response.Redirects) > 0 && response.Redirects[0].URL == req.URL {
+			response.Redirects[0].URL = requestedURL
+		}
+	}
+
+	if err != nil {
+		return response, err
+	}
+
+	// Set Chrome availability in response
+	response.ChromeAvailable = chromeAvailable
+	if rotatedUA != "" && response.UserAgentProfile == "" {
+		response.UserAgent = rotatedUA
+	}
+	response.FetchedAt = startTime
+
+	// Set the requested format (processing will be done by the processor)
+	response.Format = req.Format
+
+	return response, nil
+}
+
+// fetchWithEngine fetches with the requested engine, falling back to the HTTP
+// engine when HTTP/3 or Chrome can't serve the request
+func (f *Fetcher) fetchWithEngine(ctx context.Context, req *types.FetchRequest, chromeAvailable bool) (*types.FetchResponse, error) {
+	var response *types.FetchResponse
+	var err error
+
 	switch req.Engine {
 	case types.EngineHTTP:
-		response, err = f.httpEngine.Fetch(req.URL, req.MaxContentLength)
+		response, err = f.httpEngine.Fetch(ctx, req)
+
+	case types.EngineHTTP3:
+		if reason := f.http3Unsupported(req); reason != "" {
+			response, err = f.httpEngine.Fetch(ctx, req)
+			if response != nil {
+				response.Warnings = append(response.Warnings,
+					fmt.Sprintf("%s; used the HTTP engine instead", reason))
+			}
+			break
+		}
+
+		response, err = f.http3Engine.Fetch(ctx, req)
+		if err != nil && ctx.Err() == nil && shouldFallBackFromHTTP3(err) {
+			// Most servers don't speak QUIC; retry over TCP rather than fail
+			h3Err := err
+			response, err = f.httpEngine.Fetch(ctx, req)
+			if response != nil {
+				response.Warnings = append(response.Warnings,
+					fmt.Sprintf("HTTP/3 failed (%v), fell back to the HTTP engine", h3Err))
+			}
+		}
 
 	case types.EngineChrome:
 		if !chromeAvailable {
 			// Fall back to HTTP with warning
-			response, err = f.httpEngine.Fetch(req.URL, req.MaxContentLength)
+			response, err = f.httpEngine.Fetch(ctx, req)
 			if response != nil {
 				response.Engine = types.EngineHTTP
 				response.Warnings = append(response.Warnings,
 					"Chrome not available, falling back to HTTP engine")
 			}
+		} else if !f.chromeEngine.WaitReady(ctx, f.config.Timeout) {
+			if err := ctx.Err(); err != nil {
+				return nil, classifyError(err)
+			}
+			// Queue behind pre-warm for up to one timeout, then let the caller retry
+			return nil, ErrWarmingUp
 		} else {
-			response, err = f.chromeEngine.Fetch(req.URL, req.MaxContentLength)
+			response, err = f.chromeEngine.Fetch(ctx, req)
 		}
 
 	default:
-		return nil, fmt.Errorf("unsupported engine: %s", req.Engine)
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEngine, req.Engine)
+	}
+
+	if response == nil || response.Engine != types.EngineChrome {
+		response, err = f.retryForbidden(ctx, req, response, err)
+		response, err = f.escalateChallenge(ctx, req, response, err, chromeAvailable)
 	}
 
+	return response, err
+}
+
+// ApplyDefaults fills in options the caller omitted, preferring the
+// operator-configured defaults over the built-in ones
+func (f *Fetcher) ApplyDefaults(req *types.FetchRequest) {
+	defaults := f.config.Defaults
+
+	if req.Engine == "" {
+		req.Engine = defaults.Engine
+	}
+	if req.Engine == "" {
+		req.Engine = types.DefaultEngine
+	}
+	if req.Format == "" {
+		req.Format = defaults.Format
+	}
+	if req.Format == "" {
+		req.Format = types.DefaultFormat
+	}
+	if req.MaxContentLength == 0 {
+		req.MaxContentLength = defaults.MaxContentLength
+	}
+	if req.MaxContentLength == 0 {
+		req.MaxContentLength = types.DefaultMaxContentLength
+	}
+	if req.XPath == "" {
+		req.XPath = defaults.XPath
+	}
+	if req.SummarySentences == 0 {
+		req.SummarySentences = defaults.SummarySentences
+	}
+	if req.ChunkSize == 0 {
+		req.ChunkSize = defaults.ChunkSize
+	}
+	if req.Device == "" {
+		req.Device = defaults.Device
+	}
+
+	if req.Block == nil {
+		req.Block = f.config.ChromeBlock
+	}
+
+	// A device preset supplies what the request doesn't set itself
+	if device, ok := types.DevicePresets[strings.ToLower(req.Device)]; ok {
+		if req.Viewport == nil {
+			viewport := device.Viewport
+			req.Viewport = &viewport
+		}
+		if req.UserAgent == "" {
+			req.UserAgent = device.UserAgent
+		}
+	}
+
+	// A request's device picks its UA before the deployment's default does
+	if req.UserAgent == "" {
+		req.UserAgent = defaults.UserAgent
+	}
+
+	// Resolve user agent presets so both engines see the same UA string
+	if preset, ok := types.UserAgentPresets[strings.ToLower(req.UserAgent)]; ok {
+		req.UserAgent = preset
+	}
+	if req.UserAgent == "" {
+		req.UserAgent = types.DefaultUserAgent
+	}
+
+	// Normalize engine name
+	req.Engine = strings.ToLower(req.Engine)
+}
+
+// Ready reports whether the fetcher has finished warming up
+func (f *Fetcher) Ready() bool {
+	return f.chromeEngine.IsReady()
+}
+
+// ChromeAvailable reports whether the Chrome engine can be used
+func (f *Fetcher) ChromeAvailable() bool {
+	return f.chromeEngine.IsAvailable()
+}
+
+// Metrics returns a snapshot of fetch counters
+func (f *Fetcher) Metrics() metrics.Snapshot {
+	return f.metrics.Snapshot()
+}
+
+// DomainStats returns per-domain statistics
+func (f *Fetcher) DomainStats() []metrics.DomainStats {
+	return f.metrics.DomainStats()
+}
+
+// RecordCacheLookup records a cache hit or miss for the URL's domain
+func (f *Fetcher) RecordCacheLookup(rawURL string, hit bool) {
+	f.metrics.RecordCacheLookup(hostOf(rawURL), hit)
+}
+
+// persistDomainStats periodically saves domain stats until the fetcher closes
+func (f *Fetcher) persistDomainStats() {
+	ticker := time.NewTicker(domainStatsSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.metrics.SaveDomainStats(f.config.DomainStatsFile); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		case <-f.done:
+			return
+		}
+	}
+}
+
+// hostOf returns the lowercased hostname of a URL, or "" if it can't be parsed
+func hostOf(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
 	if err != nil {
-		return response, err
+		return ""
 	}
+	return strings.ToLower(parsed.Hostname())
+}
 
-	// Set Chrome availability in response
-	response.ChromeAvailable = chromeAvailable
+// ProxyStats returns the health of the configured proxy pool, or nil
+func (f *Fetcher) ProxyStats() []proxy.Status {
+	return f.httpEngine.ProxyStats()
+}
 
-	// Set the requested format (processing will be done by the processor)
-	response.Format = req.Format
+// Sessions returns the named session manager
+func (f *Fetcher) Sessions() *session.Manager {
+	return f.sessions
+}
 
-	return response, nil
+// SchedulerStats returns how many fetches are running and waiting for a slot
+func (f *Fetcher) SchedulerStats() SchedulerStats {
+	stats := f.scheduler.stats()
+	f.limiter.stats(&stats)
+	return stats
+}
+
+// ChromePoolStats returns current Chrome pool utilization
+func (f *Fetcher) ChromePoolStats() PoolStats {
+	return f.chromeEngine.PoolStats()
 }
 
 // Close shuts down the fetcher and its engines
 func (f *Fetcher) Close() {
+	close(f.done)
+	if f.config.DomainStatsFile != "" {
+		if err := f.metrics.SaveDomainStats(f.config.DomainStatsFile); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	closeHTTP3(f.http3Engine)
 	if f.chromeEngine != nil {
 		f.chromeEngine.Close()
 	}
@@ -0,0 +1,170 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dialFunc is the signature of net.Dialer.DialContext
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// maxHeaderBlock bounds how much of a request orderedConn holds back while
+// looking for the end of its headers
+const maxHeaderBlock = 64 << 10
+
+// orderedConn rewrites the header block of each HTTP/1.1 request written to
+// it into the order the request's browser sends them. net/http always
+// writes headers sorted by name, which anti-bot systems key on.
+//
+// Bodies pass through untouched. A body sent in chunks has no length up
+// front, so once one is seen the rest of the connection passes through too.
+type orderedConn struct {
+	net.Conn
+	pending     []byte
+	body        int64
+	passthrough bool
+}
+
+// Write buffers a request until its header block is complete, then writes
+// the block reordered, followed by the body
+func (c *orderedConn) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if c.passthrough {
+			if _, err := c.Conn.Write(p); err != nil {
+				return 0, err
+			}
+			return n, nil
+		}
+		if c.body > 0 {
+			k := min(int64(len(p)), c.body)
+			if _, err := c.Conn.Write(p[:k]); err != nil {
+				return 0, err
+			}
+			c.body -= k
+			p = p[k:]
+			continue
+		}
+
+		c.pending = append(c.pending, p...)
+		p = nil
+		end := bytes.Index(c.pending, []byte("\r\n\r\n"))
+		if end < 0 {
+			if len(c.pending) > maxHeaderBlock {
+				c.passthrough = true
+				p, c.pending = c.pending, nil
+			}
+			continue
+		}
+
+		block, rest := c.pending[:end+4], c.pending[end+4:]
+		c.pending = nil
+		ordered, body, ok := orderHeaderBlock(block)
+		if _, err := c.Conn.Write(ordered); err != nil {
+			return 0, err
+		}
+		c.body = body
+		c.passthrough = !ok
+		p = rest
+	}
+	return n, nil
+}
+
+// orderHeaderBlock reorders the header lines of a request: Host first, as
+// browsers send it, then the headers requestHeaders lists for the request's
+// User-Agent in that order, then any others as net/http wrote them. It also
+// returns the length of the body that follows, and false if that isn't known.
+func orderHeaderBlock(block []byte) ([]byte, int64, bool) {
+	lines := strings.Split(string(block[:len(block)-4]), "\r\n")
+	requestLine, fields := lines[0], lines[1:]
+
+	userAgent := ""
+	var body int64
+	for _, line := range fields {
+		name, value, _ := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(name) {
+		case "user-agent":
+			userAgent = value
+		case "content-length":
+			body, _ = strconv.ParseInt(value, 10, 64)
+		case "transfer-encoding":
+			return block, 0, false
+		}
+	}
+
+	rank := map[string]int{"host": -1}
+	for i, field := range requestHeaders(userAgent) {
+		rank[strings.ToLower(field.name)] = i
+	}
+	position := func(line string) int {
+		name, _, _ := strings.Cut(line, ":")
+		if i, ok := rank[strings.ToLower(name)]; ok {
+			return i
+		}
+		return len(rank)
+	}
+	slices.SortStableFunc(fields, func(a, b string) int { return position(a) - position(b) })
+
+	return []byte(requestLine + "\r\n" + strings.Join(fields, "\r\n") + "\r\n\r\n"), body, true
+}
+
+// orderedDial wraps the connections dial makes for plain HTTP in orderedConn
+func orderedDial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &orderedConn{Conn: conn}, nil
+	}
+}
+
+// orderedTLSDial returns a TLS dialer for http.Transport whose HTTP/1.1
+// connections are wrapped in orderedConn. HTTP/2 connections are returned
+// as they are: net/http's HTTP/2 client needs the *tls.Conn, and encodes
+// headers itself.
+//
+// A custom TLS dialer takes the handshake out of the transport's hands, so
+// it reports the handshake to the request's trace itself.
+func orderedTLSDial(dial dialFunc, config *tls.Config, timeout time.Duration) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		raw, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg := config.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		conn := tls.Client(raw, cfg)
+
+		handshakeCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		trace := httptrace.ContextClientTrace(ctx)
+		if trace != nil && trace.TLSHandshakeStart != nil {
+			trace.TLSHandshakeStart()
+		}
+		err = conn.HandshakeContext(handshakeCtx)
+		if trace != nil && trace.TLSHandshakeDone != nil {
+			trace.TLSHandshakeDone(conn.ConnectionState(), err)
+		}
+		if err != nil {
+			raw.Close()
+			return nil, err
+		}
+
+		if conn.ConnectionState().NegotiatedProtocol == "h2" {
+			return conn, nil
+		}
+		return &orderedConn{Conn: conn}, nil
+	}
+}
@@ -0,0 +1,231 @@
+package fetcher
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// headerField is a single request header; slices of these keep the order a
+// real browser sends them in, which orderedConn puts them on the wire in
+type headerField struct {
+	name  string
+	value string
+}
+
+var (
+	chromeVersionPattern = regexp.MustCompile(`Chrome/(\d+)`)
+	edgeVersionPattern   = regexp.MustCompile(`Edg/(\d+)`)
+)
+
+// browserHeaders returns the headers userAgent's browser sends for a
+// top-level navigation, in its order. Chromium gets Sec-CH-UA client hints
+// derived from userAgent so the hints never contradict the UA string;
+// Firefox and Safari send none.
+func browserHeaders(userAgent string) []headerField {
+	switch {
+	case strings.Contains(userAgent, "Firefox/"):
+		return []headerField{
+			{"User-Agent", userAgent},
+			{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"},
+			{"Accept-Language", "en-US,en;q=0.5"},
+			{"Accept-Encoding", acceptEncoding},
+			{"Upgrade-Insecure-Requests", "1"},
+			{"Sec-Fetch-Dest", "document"},
+			{"Sec-Fetch-Mode", "navigate"},
+			{"Sec-Fetch-Site", "none"},
+			{"Sec-Fetch-User", "?1"},
+		}
+	case strings.Contains(userAgent, "Version/") && !chromeVersionPattern.MatchString(userAgent):
+		return []headerField{
+			{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+			{"Sec-Fetch-Site", "none"},
+			{"Accept-Encoding", acceptEncoding},
+			{"Sec-Fetch-Mode", "navigate"},
+			{"User-Agent", userAgent},
+			{"Accept-Language", "en-US,en;q=0.9"},
+			{"Sec-Fetch-Dest", "document"},
+		}
+	}
+
+	var fields []headerField
+	fields = append(fields, headerField{"Cache-Control", "max-age=0"})
+	fields = append(fields, clientHints(userAgent)...)
+	fields = append(fields,
+		headerField{"Upgrade-Insecure-Requests", "1"},
+		headerField{"User-Agent", userAgent},
+		headerField{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7"},
+		headerField{"Sec-Fetch-Site", "none"},
+		headerField{"Sec-Fetch-Mode", "navigate"},
+		headerField{"Sec-Fetch-User", "?1"},
+		headerField{"Sec-Fetch-Dest", "document"},
+		headerField{"Accept-Encoding", acceptEncoding},
+		headerField{"Accept-Language", "en-US,en;q=0.9"},
+	)
+	return fields
+}
+
+// requestHeaders returns the headers to send for userAgent: a full browser
+// navigation set for browser UAs, and the minimal set a crawler or command
+// line client sends otherwise, so the headers never give the UA away
+func requestHeaders(userAgent string) []headerField {
+	if isBrowserUA(userAgent) {
+		return browserHeaders(userAgent)
+	}
+	return []headerField{
+		{"User-Agent", userAgent},
+		{"Accept", "*/*"},
+		{"Accept-Encoding", "gzip"},
+	}
+}
+
+// isBrowserUA reports whether userAgent belongs to an interactive browser
+// rather than a bot or tool that merely starts with "Mozilla/5.0"
+func isBrowserUA(userAgent string) bool {
+	lower := strings.ToLower(userAgent)
+	if strings.Contains(lower, "bot") || strings.Contains(lower, "spider") || strings.Contains(lower, "crawler") {
+		return false
+	}
+	return strings.HasPrefix(userAgent, "Mozilla/") &&
+		(strings.Contains(userAgent, "AppleWebKit") || strings.Contains(userAgent, "Gecko/"))
+}
+
+// clientHints builds the low-entropy Sec-CH-UA headers for a Chromium UA
+func clientHints(userAgent string) []headerField {
+	match := chromeVersionPattern.FindStringSubmatch(userAgent)
+	if match == nil {
+		return nil
+	}
+	version := match[1]
+
+	brand := fmt.Sprintf(`"Google Chrome";v="%s"`, version)
+	if edge := edgeVersionPattern.FindStringSubmatch(userAgent); edge != nil {
+		brand = fmt.Sprintf(`"Microsoft Edge";v="%s"`, edge[1])
+	}
+
+	mobile := "?0"
+	if strings.Contains(userAgent, "Mobile") {
+		mobile = "?1"
+	}
+
+	return []headerField{
+		{"Sec-CH-UA", fmt.Sprintf(`"Not_A Brand";v="8", "Chromium";v="%s", %s`, version, brand)},
+		{"Sec-CH-UA-Mobile", mobile},
+		{"Sec-CH-UA-Platform", fmt.Sprintf(`"%s"`, uaPlatform(userAgent))},
+	}
+}
+
+// uaPlatform maps a UA string to the Sec-CH-UA-Platform value Chrome reports
+func uaPlatform(userAgent string) string {
+	switch {
+	case strings.Contains(userAgent, "Android"):
+		return "Android"
+	case strings.Contains(userAgent, "CrOS"):
+		return "Chrome OS"
+	case strings.Contains(userAgent, "Windows"):
+		return "Windows"
+	case strings.Contains(userAgent, "Macintosh"), strings.Contains(userAgent, "Mac OS X"):
+		return "macOS"
+	case strings.Contains(userAgent, "Linux"):
+		return "Linux"
+	default:
+		return "Unknown"
+	}
+}
+
+// applyHeaders sets the fields on req, later fields replacing earlier ones
+// of the same name. net/http sorts them by name when it writes the request;
+// orderedConn restores the browser's order on HTTP/1.1 connections.
+func applyHeaders(req *http.Request, fields []headerField) {
+	for _, field := range fields {
+		req.Header.Set(field.name, field.value)
+	}
+}
+
+// cookieHeader serializes the request cookies that apply to u into a Cookie
+// header value
+func cookieHeader(cookies []types.Cookie, u *url.URL) string {
+	parts := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		if cookieApplies(c, u) {
+			parts = append(parts, (&http.Cookie{Name: c.Name, Value: c.Value}).String())
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// cookieApplies reports whether a request cookie's domain and path, if it
+// has them, cover u
+func cookieApplies(c types.Cookie, u *url.URL) bool {
+	if domain := strings.ToLower(strings.TrimPrefix(c.Domain, ".")); domain != "" {
+		host := strings.ToLower(u.Hostname())
+		if host != domain && !strings.HasSuffix(host, "."+domain) {
+			return false
+		}
+	}
+	if c.Path != "" {
+		path := u.Path
+		if path == "" {
+			path = "/"
+		}
+		if !strings.HasPrefix(path, c.Path) {
+			return false
+		}
+	}
+	return true
+}
+
+// acceptLanguage returns the Accept-Language a browser set to locale sends:
+// the locale, then its bare language, e.g. "de-DE,de;q=0.9"
+func acceptLanguage(locale string) string {
+	language, _, found := strings.Cut(locale, "-")
+	if !found {
+		return locale
+	}
+	return locale + "," + language + ";q=0.9"
+}
+
+// withHeader sets name to value in headers, in place if it is already
+// there so the browser's order is kept, or at the end
+func withHeader(headers []headerField, name, value string) []headerField {
+	for i, field := range headers {
+		if field.name == name {
+			headers[i].value = value
+			return headers
+		}
+	}
+	return append(headers, headerField{name, value})
+}
+
+// customHeaders returns a request's own headers in name order. Added after
+// the engine's defaults, they replace any of the same name.
+func customHeaders(headers map[string]string) []headerField {
+	fields := make([]headerField, 0, len(headers))
+	for name, value := range headers {
+		fields = append(fields, headerField{http.CanonicalHeaderKey(name), value})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].name < fields[j].name })
+	return fields
+}
+
+// authorizationHeader builds the Authorization header value for auth, or ""
+func authorizationHeader(auth *types.Auth) string {
+	if auth == nil {
+		return ""
+	}
+	switch auth.Type {
+	case types.AuthBasic:
+		credentials := auth.Username + ":" + auth.Password
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	case types.AuthBearer:
+		return "Bearer " + auth.Token
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,99 @@
+package fetcher
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// TestAuthorizationHeader tests encoding credentials as an Authorization header
+func TestAuthorizationHeader(t *testing.T) {
+	tests := []struct {
+		auth *types.Auth
+		want string
+	}{
+		{nil, ""},
+		{&types.Auth{Type: types.AuthBasic, Username: "alice", Password: "s3cret"}, "Basic YWxpY2U6czNjcmV0"},
+		{&types.Auth{Type: types.AuthBasic, Username: "alice"}, "Basic YWxpY2U6"},
+		{&types.Auth{Type: types.AuthBasic, Username: "al:ice", Password: "pä ss"}, "Basic YWw6aWNlOnDDpCBzcw=="},
+		{&types.Auth{Type: types.AuthBearer, Token: "abc.def"}, "Bearer abc.def"},
+		{&types.Auth{Type: "digest", Username: "alice"}, ""},
+	}
+	for _, tt := range tests {
+		if got := authorizationHeader(tt.auth); got != tt.want {
+			t.Errorf("authorizationHeader(%+v) = %q, want %q", tt.auth, got, tt.want)
+		}
+	}
+}
+
+// TestSameOrigin tests which of a page's requests Chrome sends credentials with
+func TestSameOrigin(t *testing.T) {
+	const target = "https://example.com/account"
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://example.com/style.css", true},
+		{"https://EXAMPLE.com:443/api?x=1", true},
+		{"http://example.com/account", false},
+		{"https://example.com:8443/account", false},
+		{"https://cdn.example.com/app.js", false},
+		{"https://tracker.example/pixel", false},
+		{"::not a url", false},
+	}
+	for _, tt := range tests {
+		if got := sameOrigin(tt.url, target); got != tt.want {
+			t.Errorf("sameOrigin(%q, %q) = %v, want %v", tt.url, target, got, tt.want)
+		}
+	}
+
+	entries := withAuthorization(network.Headers{"Accept": "*/*", "authorization": "Bearer page"}, "Bearer ours")
+	found := map[string]string{}
+	for _, entry := range entries {
+		found[entry.Name] = entry.Value
+	}
+	if len(found) != 2 || found["Accept"] != "*/*" || found["Authorization"] != "Bearer ours" {
+		t.Errorf("Unexpected headers %v", found)
+	}
+}
+
+// bufferConn is a net.Conn that records what is written to it
+type bufferConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (c *bufferConn) Write(p []byte) (int, error) { return c.written.Write(p) }
+
+// TestOrderedConn tests that orderedConn reorders each request's headers
+// however the writes are split, and leaves bodies alone
+func TestOrderedConn(t *testing.T) {
+	const firefox = "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"
+	raw := &bufferConn{}
+	conn := &orderedConn{Conn: raw}
+	requests := "POST /form HTTP/1.1\r\nHost: example.com\r\nAccept: */*\r\nContent-Length: 11\r\nUser-Agent: " + firefox + "\r\n\r\nhello\r\n\r\n!" +
+		"GET / HTTP/1.1\r\nHost: example.com\r\nAccept-Language: en\r\nUser-Agent: curl/8.4.0\r\n\r\n"
+	for i := 0; i < len(requests); i += 7 {
+		if _, err := conn.Write([]byte(requests[i:min(i+7, len(requests))])); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	want := "POST /form HTTP/1.1\r\nHost: example.com\r\nUser-Agent: " + firefox + "\r\nAccept: */*\r\nContent-Length: 11\r\n\r\nhello\r\n\r\n!" +
+		"GET / HTTP/1.1\r\nHost: example.com\r\nUser-Agent: curl/8.4.0\r\nAccept-Language: en\r\n\r\n"
+	if raw.written.String() != want {
+		t.Errorf("Expected %q, got %q", want, raw.written.String())
+	}
+
+	// Where a chunked body ends isn't tracked, so the connection stops reordering
+	raw = &bufferConn{}
+	conn = &orderedConn{Conn: raw}
+	chunked := "POST / HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\nUser-Agent: curl/8.4.0\r\n\r\n0\r\n\r\n" +
+		"GET / HTTP/1.1\r\nUser-Agent: curl/8.4.0\r\nHost: example.com\r\n\r\n"
+	conn.Write([]byte(chunked))
+	if raw.written.String() != chunked {
+		t.Errorf("Expected %q unchanged, got %q", chunked, raw.written.String())
+	}
+}
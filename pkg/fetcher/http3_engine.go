@@ -0,0 +1,101 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/gomcpgo/url_fetcher/pkg/config"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// http3HandshakeTimeout bounds how long a QUIC handshake may stall before we
+// give up and fall back to TCP; servers without HTTP/3 simply never answer
+const http3HandshakeTimeout = 3 * time.Second
+
+// NewHTTP3Engine creates an experimental HTTP engine that speaks HTTP/3 over
+// QUIC. It shares the HTTP engine's request handling but not its proxy
+// support, since proxies here are TCP-only.
+func NewHTTP3Engine(cfg *config.Config) *HTTPEngine {
+	engine := NewHTTPEngine(cfg)
+	engine.name = types.EngineHTTP3
+	engine.proxies = nil
+
+	client := *engine.client
+	client.Transport = &http3.RoundTripper{
+		QuicConfig: &quic.Config{
+			HandshakeIdleTimeout: http3HandshakeTimeout,
+		},
+		Dial: dialQUIC(dialGuard(cfg.BlockLocal)),
+	}
+	engine.client = &client
+
+	return engine
+}
+
+// dialQUIC returns a QUIC dial function that resolves the address itself,
+// so guard sees the address that is dialed, as the TCP dialer's Control
+// hook does
+func dialQUIC(guard func(network, address string, conn syscall.RawConn) error) func(context.Context, string, *tls.Config, *quic.Config) (quic.EarlyConnection, error) {
+	return func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("no addresses for %s", host)
+		}
+		resolved := net.JoinHostPort(ips[0].IP.String(), port)
+		if err := guard("udp", resolved, nil); err != nil {
+			return nil, &net.OpError{Op: "dial", Net: "udp", Err: err}
+		}
+		return quic.DialAddrEarly(ctx, resolved, tlsCfg, cfg)
+	}
+}
+
+// closeHTTP3 releases the QUIC connections held by an HTTP/3 engine
+func closeHTTP3(engine *HTTPEngine) {
+	if rt, ok := engine.client.Transport.(*http3.RoundTripper); ok {
+		rt.Close()
+	}
+}
+
+// shouldFallBackFromHTTP3 reports whether an HTTP/3 failure was at the
+// transport level, so the same request over TCP may well succeed. Responses
+// from the server and requests we refused ourselves are final.
+func shouldFallBackFromHTTP3(err error) bool {
+	var statusErr *StatusError
+	switch {
+	case errors.As(err, &statusErr),
+		errors.Is(err, ErrInvalidURL),
+		errors.Is(err, ErrUnsupportedScheme),
+		errors.Is(err, ErrBlockedLocal),
+		errors.Is(err, ErrBlockedMetadata),
+		errors.Is(err, ErrContentTooLarge),
+		errors.Is(err, ErrTooManyRedirects),
+		errors.Is(err, ErrUnsafeRedirect):
+		return false
+	}
+	return true
+}
+
+// http3Unsupported reports why a request can't use the HTTP/3 engine, or ""
+func (f *Fetcher) http3Unsupported(req *types.FetchRequest) string {
+	switch {
+	case req.Proxy != "" || len(f.config.Proxies) > 0:
+		return "HTTP/3 can't be used through a proxy"
+	case req.ForceHTTP1:
+		return "force_http1 was requested"
+	}
+	return ""
+}
@@ -1,15 +1,23 @@
 package fetcher
 
 import (
-	"compress/gzip"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gomcpgo/url_fetcher/pkg/config"
+	"github.com/gomcpgo/url_fetcher/pkg/proxy"
+	"github.com/gomcpgo/url_fetcher/pkg/session"
 	"github.com/gomcpgo/url_fetcher/pkg/types"
 )
 
@@ -17,199 +25,639 @@ import (
 type HTTPEngine struct {
 	client *http.Client
 	config *config.Config
+
+	// name is the engine reported in responses: http, or http3 for the QUIC variant
+	name string
+
+	// knownHosts records hosts fetched successfully, which skip HEAD preflight
+	knownHosts sync.Map
+
+	// sessions supplies cookie jars for requests that name a session
+	sessions *session.Manager
+
+	// proxies rotates requests across the configured egress proxies; nil if none
+	proxies *proxy.Pool
+
+	// bandwidth holds every download to FETCH_URL_BANDWIDTH_LIMIT; nil if unlimited
+	bandwidth *bandwidthLimiter
+
+	// clients caches a client per proxy and protocol combination so their
+	// connections are reused
+	clients sync.Map
+
+	// directDial connects to targets, held to BlockLocal
+	directDial func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// proxyDial connects to proxies, which may be local even with BlockLocal
+	proxyDial func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// newDialer returns a dual-stack dialer: if the preferred address family
+// (usually IPv6) doesn't connect within the fallback delay, the other family
+// is raced in parallel so a broken AAAA record costs milliseconds rather
+// than the whole timeout. The delay is passed through as configured, zero
+// and negative values included.
+func newDialer(cfg *config.Config) *net.Dialer {
+	return &net.Dialer{
+		Timeout:       cfg.ConnectTimeout,
+		FallbackDelay: cfg.DualStackFallbackDelay,
+		KeepAlive:     30 * time.Second,
+	}
 }
 
+// redirectLimitKey carries a request's redirect limit to CheckRedirect,
+// which is shared by every request made with the client
+type redirectLimitKey struct{}
+
 // NewHTTPEngine creates a new HTTP engine
 func NewHTTPEngine(cfg *config.Config) *HTTPEngine {
+	dialer := newDialer(cfg)
+	// Proxies are checked before they are used, and resolve the target
+	// themselves, so only direct connections are held to BlockLocal
+	proxyDialer := *dialer
+	proxyDialer.Control = dialGuard(false)
+	dialer.Control = dialGuard(cfg.BlockLocal)
+
+	// A custom dialer disables HTTP/2 unless it is requested explicitly.
+	// HTTP/1.1 requests go out with their headers in browser order.
 	transport := &http.Transport{
+		DialContext:           orderedDial(dialer.DialContext),
+		ForceAttemptHTTP2:     true,
 		DisableCompression:    false,
 		MaxIdleConns:          10,
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 	}
+	transport.DialTLSContext = orderedTLSDial(dialer.DialContext, &tls.Config{NextProtos: []string{"h2", "http/1.1"}}, transport.TLSHandshakeTimeout)
 
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   cfg.Timeout,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			if len(via) >= 5 {
-				return fmt.Errorf("too many redirects")
-			}
-			return nil
-		},
+	engine := &HTTPEngine{
+		config:     cfg,
+		name:       types.EngineHTTP,
+		directDial: dialer.DialContext,
+		proxyDial:  proxyDialer.DialContext,
+	}
+	engine.client = &http.Client{
+		Transport:     transport,
+		Timeout:       cfg.Timeout,
+		CheckRedirect: engine.checkRedirect,
 	}
 
-	return &HTTPEngine{
-		client: client,
-		config: cfg,
+	// Route through the configured egress proxies; LoadConfig already validated them
+	if len(cfg.Proxies) > 0 {
+		pool, err := proxy.NewPool(cfg.Proxies, cfg.ProxyRotation)
+		if err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		engine.proxies = pool
 	}
+
+	return engine
 }
 
-// Fetch retrieves content from a URL using HTTP
-func (e *HTTPEngine) Fetch(fetchURL string, maxContentLength int) (*types.FetchResponse, error) {
+// Fetch retrieves content from a URL using HTTP. Cancelling ctx aborts the
+// request, including any wait between retries.
+func (e *HTTPEngine) Fetch(ctx context.Context, fetchReq *types.FetchRequest) (*types.FetchResponse, error) {
 	startTime := time.Now()
+	fetchURL := fetchReq.URL
 
 	// Validate URL
 	if err := e.validateURL(fetchURL); err != nil {
-		return types.ErrorResponse(fetchURL, types.EngineHTTP, err, time.Since(startTime)), err
+		return types.ErrorResponse(fetchURL, e.name, err, time.Since(startTime)), err
 	}
 
+	ctx = context.WithValue(ctx, redirectLimitKey{}, e.redirectLimit(fetchReq))
+
 	// Create request
-	req, err := http.NewRequest("GET", fetchURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fetchURL, nil)
 	if err != nil {
-		return types.ErrorResponse(fetchURL, types.EngineHTTP, err, time.Since(startTime)), err
-	}
-
-	// Set browser-like headers
-	req.Header.Set("User-Agent", types.DefaultUserAgent)
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.9")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
-	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
-	req.Header.Set("DNT", "1")
-	req.Header.Set("Connection", "keep-alive")
-	req.Header.Set("Upgrade-Insecure-Requests", "1")
-	req.Header.Set("Sec-Fetch-Dest", "document")
-	req.Header.Set("Sec-Fetch-Mode", "navigate")
-	req.Header.Set("Sec-Fetch-Site", "none")
-	req.Header.Set("Sec-Fetch-User", "?1")
-	req.Header.Set("Cache-Control", "max-age=0")
-
-	// Execute request with retry logic for server errors
-	var resp *http.Response
-	maxRetries := 2
+		return types.ErrorResponse(fetchURL, e.name, err, time.Since(startTime)), err
+	}
 
-	for attempt := 0; attempt <= maxRetries; attempt++ {
-		// Add small delay between retries (except first attempt)
-		if attempt > 0 {
-			time.Sleep(time.Duration(attempt) * time.Second)
+	// Set headers consistent with the requested user agent
+	userAgent := fetchReq.UserAgent
+	if userAgent == "" {
+		userAgent = types.DefaultUserAgent
+	}
+	headers := requestHeaders(userAgent)
+	if fetchReq.Locale != "" {
+		headers = withHeader(headers, "Accept-Language", acceptLanguage(fetchReq.Locale))
+	}
+	if cookie := cookieHeader(fetchReq.Cookies, req.URL); cookie != "" {
+		headers = append(headers, headerField{"Cookie", cookie})
+	}
+	if authorization := authorizationHeader(fetchReq.Auth); authorization != "" {
+		headers = append(headers, headerField{"Authorization", authorization})
+	}
+	headers = append(headers, customHeaders(fetchReq.Headers)...)
+	if fetchReq.Range != nil {
+		headers = withRange(headers, fetchReq.Range)
+	}
+	if cached := fetchReq.Revalidate; cached != nil {
+		if cached.ETag != "" {
+			headers = append(headers, headerField{"If-None-Match", cached.ETag})
+		}
+		if cached.LastModified != "" {
+			headers = append(headers, headerField{"If-Modified-Since", cached.LastModified})
 		}
+	}
+	applyHeaders(req, headers)
 
-		// Create new request for each attempt (in case body was consumed)
-		if attempt > 0 {
-			req, err = http.NewRequest("GET", fetchURL, nil)
+	client, pooled, err := e.clientFor(fetchReq)
+	if err != nil {
+		return types.ErrorResponse(fetchURL, e.name, err, time.Since(startTime)), err
+	}
+
+	// Optionally check size and type with a HEAD before committing to a GET
+	if e.config.HeadPreflight || fetchReq.Preflight {
+		if response, skip := e.preflight(ctx, client, fetchReq, headers, startTime); skip {
+			return response, nil
+		}
+	}
+
+	// Execute the request, retrying as the retry policy allows
+	policy := retryPolicy(e.config, fetchReq)
+	var resp *http.Response
+	var timer *requestTimer
+	var requestStart, headersReceived time.Time
+	var retryAfter *time.Duration // the server's requested wait, if it asked for one
+	var challenge string          // the bot-protection service whose challenge was served
+	var warnings []string
+	attempt := 1
+
+	for ; ; attempt++ {
+		if attempt > 1 {
+			delay := retryDelay(policy, attempt-1)
+			if retryAfter != nil {
+				delay, retryAfter = *retryAfter, nil
+			}
+			if err := waitRetry(ctx, delay); err != nil {
+				return types.ErrorResponse(fetchURL, e.name, err, time.Since(startTime)), err
+			}
+
+			// Create new request for each attempt (in case body was consumed)
+			req, err = http.NewRequestWithContext(ctx, "GET", fetchURL, nil)
 			if err != nil {
-				return types.ErrorResponse(fetchURL, types.EngineHTTP, err, time.Since(startTime)), err
+				return types.ErrorResponse(fetchURL, e.name, err, time.Since(startTime)), err
 			}
 
 			// Re-set headers for retry attempts
-			req.Header.Set("User-Agent", types.DefaultUserAgent)
-			req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.9")
-			req.Header.Set("Accept-Language", "en-US,en;q=0.9")
-			req.Header.Set("Accept-Encoding", "gzip, deflate, br")
-			req.Header.Set("DNT", "1")
-			req.Header.Set("Connection", "keep-alive")
-			req.Header.Set("Upgrade-Insecure-Requests", "1")
-			req.Header.Set("Sec-Fetch-Dest", "document")
-			req.Header.Set("Sec-Fetch-Mode", "navigate")
-			req.Header.Set("Sec-Fetch-Site", "none")
-			req.Header.Set("Sec-Fetch-User", "?1")
-			req.Header.Set("Cache-Control", "max-age=0")
-		}
-
-		resp, err = e.client.Do(req)
+			applyHeaders(req, headers)
+
+			// Give the proxy pool a chance to route around a failing proxy
+			if pooled != nil {
+				if client, pooled, err = e.clientFor(fetchReq); err != nil {
+					return types.ErrorResponse(fetchURL, e.name, err, time.Since(startTime)), err
+				}
+			}
+		}
+
+		timer = &requestTimer{}
+		requestStart = time.Now()
+		resp, err = client.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), timer.trace())))
+		headersReceived = time.Now()
+		if pooled != nil {
+			if err != nil {
+				e.proxies.ReportFailure(pooled)
+			} else {
+				e.proxies.ReportSuccess(pooled)
+			}
+		}
 		if err != nil {
-			if attempt == maxRetries {
-				return types.ErrorResponse(fetchURL, types.EngineHTTP, err, time.Since(startTime)), err
+			err = classifyError(err)
+			// A failed QUIC handshake won't succeed on retry; let the fetcher fall back
+			if attempt >= policy.MaxAttempts || e.name == types.EngineHTTP3 ||
+				ctx.Err() != nil || !retryableError(policy, err) {
+				response := types.ErrorResponse(fetchURL, e.name, err, time.Since(startTime))
+				response.Attempts = attempt
+				response.Warnings = append(response.Warnings, warnings...)
+				return response, err
 			}
 			continue
 		}
 
-		// If we get a server error (5xx), retry
-		if resp.StatusCode >= 500 && attempt < maxRetries {
+		// A bot challenge won't clear on retry, though Chrome may get past it
+		if challenge = sniffChallenge(resp); challenge != "" {
+			break
+		}
+
+		// A throttled request is retried when the server says it may be,
+		// as long as the wait fits in what is left of the request timeout
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				remaining := e.config.Timeout - time.Since(startTime)
+				if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < remaining {
+					remaining = time.Until(deadline)
+				}
+				if attempt < policy.MaxAttempts && delay < remaining {
+					warnings = append(warnings, fmt.Sprintf("throttled (status %d); retried after the %s the server asked for",
+						resp.StatusCode, delay.Round(time.Millisecond)))
+					retryAfter = &delay
+					resp.Body.Close()
+					continue
+				}
+				warnings = append(warnings, fmt.Sprintf("throttled (status %d); the server asked to wait %s, which doesn't fit in the request",
+					resp.StatusCode, delay.Round(time.Millisecond)))
+				break
+			}
+		}
+
+		if attempt < policy.MaxAttempts && retryableStatus(policy, resp.StatusCode) {
 			resp.Body.Close()
 			continue
 		}
 
-		// Success or non-retryable error, break out of retry loop
+		// Success or non-retryable status, break out of retry loop
 		break
 	}
 	defer resp.Body.Close()
 
+	// Not modified: there is no body to read, and the cached copy stands in
+	// for one
+	if resp.StatusCode == http.StatusNotModified {
+		response := notModified(fetchReq.Revalidate, resp)
+		response.URL = fetchURL
+		response.Engine = e.name
+		response.FetchTimeMs = time.Since(startTime).Milliseconds()
+		response.Timing = timer.timing(requestStart, headersReceived, 0)
+		response.Attempts = attempt
+		response.Warnings = warnings
+		return response, nil
+	}
+
 	// Check for server errors and provide helpful messages
 	if resp.StatusCode >= 500 {
-		return types.ErrorResponse(fetchURL, types.EngineHTTP,
-			fmt.Errorf("server error (status %d) after %d retries. try using engine='chrome'", resp.StatusCode, maxRetries),
-			time.Since(startTime)), fmt.Errorf("server returned status %d", resp.StatusCode)
+		response := types.ErrorResponse(fetchURL, e.name,
+			fmt.Errorf("server error (status %d) after %d attempts. try using engine='chrome'", resp.StatusCode, attempt),
+			time.Since(startTime))
+		response.Attempts = attempt
+		response.Warnings = append(response.Warnings, warnings...)
+		return response, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Challenge: challenge}
 	}
 
 	if resp.StatusCode >= 400 {
-		return types.ErrorResponse(fetchURL, types.EngineHTTP,
+		response := types.ErrorResponse(fetchURL, e.name,
 			fmt.Errorf("client error (status %d): %s", resp.StatusCode, resp.Status),
-			time.Since(startTime)), fmt.Errorf("client error: %s", resp.Status)
+			time.Since(startTime))
+		response.Attempts = attempt
+		response.Warnings = append(response.Warnings, warnings...)
+		return response, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Challenge: challenge}
 	}
 
 	// Read response body
-	body, err := e.readResponseBody(resp, maxContentLength)
+	downloadStart := time.Now()
+	body, dl, received, err := e.readResponseBody(resp, fetchReq)
+	download := time.Since(downloadStart)
+	if err != nil {
+		return types.ErrorResponse(fetchURL, e.name, err, time.Since(startTime)), err
+	}
+
+	e.knownHosts.Store(hostOf(fetchURL), true)
+
+	var contentRange string
+	if fetchReq.Range != nil {
+		size := int64(len(body))
+		if dl != nil {
+			size = dl.size
+		}
+		contentRange = servedRange(resp, fetchReq.Range, size)
+		if resp.StatusCode == http.StatusOK {
+			warnings = append(warnings, "The server doesn't support range requests; the range was read from the start of the full response")
+		}
+	}
+
+	// A streamed body is processed from a preview of its start
+	contentType := resp.Header.Get("Content-Type")
+	if dl != nil {
+		body = downloadPreview(body, contentType)
+		warnings = append(warnings, fmt.Sprintf("Body of %d bytes streamed to %s; content is a preview of its first %d bytes", dl.size, dl.path, len(body)))
+	}
+
+	// Normalize to UTF-8 so cached entries don't vary with the origin's encoding
+	content, originalCharset, err := normalizeCharset(body, contentType)
 	if err != nil {
-		return types.ErrorResponse(fetchURL, types.EngineHTTP, err, time.Since(startTime)), err
+		return types.ErrorResponse(fetchURL, e.name, err, time.Since(startTime)), err
 	}
 
 	// Create response
 	response := &types.FetchResponse{
 		URL:             fetchURL,
-		Engine:          types.EngineHTTP,
+		FinalURL:        resp.Request.URL.String(),
+		Redirects:       redirectChain(resp),
+		Engine:          e.name,
 		StatusCode:      resp.StatusCode,
-		ContentType:     resp.Header.Get("Content-Type"),
-		Content:         string(body),
+		ContentType:     contentType,
+		ContentRange:    contentRange,
+		Charset:         originalCharset,
+		Protocol:        protocolName(resp),
+		ETag:            resp.Header.Get("ETag"),
+		LastModified:    resp.Header.Get("Last-Modified"),
+		CacheControl:    resp.Header.Get("Cache-Control"),
+		Expires:         resp.Header.Get("Expires"),
+		Content:         content,
 		Format:          types.FormatHTML, // Will be processed later
 		FetchTimeMs:     time.Since(startTime).Milliseconds(),
+		Timing:          timer.timing(requestStart, headersReceived, download),
+		Attempts:        attempt,
+		BytesReceived:   received,
+		Warnings:        warnings,
 		ChromeAvailable: false, // Will be set by main fetcher
 	}
 
+	if dl != nil {
+		response.File = dl.path
+		response.ContentLength = dl.size
+	}
+
+	// Only reached for 3xx when redirects aren't being followed
+	if location, err := resp.Location(); err == nil && resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		response.Location = location.String()
+	}
+
 	return response, nil
 }
 
+// notModified builds the response to a 304. With a cached copy, that copy
+// is returned with its own status, since it is the representation the 304
+// vouches for, and the validators and freshness headers the 304 updates.
+// Without one, e.g. when the conditional headers came from elsewhere, the
+// 304 is reported as is, with no content.
+func notModified(cached *types.FetchResponse, resp *http.Response) *types.FetchResponse {
+	response := &types.FetchResponse{
+		StatusCode:   resp.StatusCode,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Format:       types.FormatHTML,
+	}
+	if cached != nil {
+		copied := *cached
+		copied.Warnings = nil
+		copied.Timing = nil
+		copied.BytesReceived, copied.RenderMs = 0, 0
+		if response.ETag == "" {
+			response.ETag = copied.ETag
+		}
+		if response.LastModified == "" {
+			response.LastModified = copied.LastModified
+		}
+		copied.ETag, copied.LastModified = response.ETag, response.LastModified
+		// A 304 may also renew the freshness headers
+		if cacheControl := resp.Header.Get("Cache-Control"); cacheControl != "" {
+			copied.CacheControl = cacheControl
+		}
+		if expires := resp.Header.Get("Expires"); expires != "" {
+			copied.Expires = expires
+		}
+		response = &copied
+	}
+	response.FinalURL = resp.Request.URL.String()
+	response.Redirects = redirectChain(resp)
+	response.Protocol = protocolName(resp)
+	response.NotModified = true
+	return response
+}
+
+// checkRedirect enforces the request's redirect limit and applies the same
+// URL validation and domain lists to each hop as to the original URL, so a
+// public host can't redirect to a local or private address
+func (e *HTTPEngine) checkRedirect(req *http.Request, via []*http.Request) error {
+	limit, ok := req.Context().Value(redirectLimitKey{}).(int)
+	if !ok {
+		limit = types.DefaultMaxRedirects
+	}
+	if limit == 0 {
+		// Hand back the redirect itself so its Location can be reported
+		return http.ErrUseLastResponse
+	}
+	if len(via) > limit {
+		return ErrTooManyRedirects
+	}
+	if err := e.validateURL(req.URL.String()); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrUnsafeRedirect, req.URL.Redacted(), err)
+	}
+	// The domain allowlist and denylist apply wherever a redirect leads
+	if err := checkDomain(e.config, req.URL.Hostname()); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrUnsafeRedirect, req.URL.Redacted(), err)
+	}
+	return nil
+}
+
+// redirectLimit returns how many redirects a request may follow; 0 means
+// the redirect response is returned as is
+func (e *HTTPEngine) redirectLimit(fetchReq *types.FetchRequest) int {
+	switch {
+	case fetchReq.MaxRedirects != nil:
+		return *fetchReq.MaxRedirects
+	case e.config.MaxRedirects < 0:
+		return 0
+	case e.config.MaxRedirects > 0:
+		return e.config.MaxRedirects
+	}
+	return types.DefaultMaxRedirects
+}
+
+// requestProxy parses a caller-chosen proxy, which must not become a way to
+// reach internal hosts
+func requestProxy(cfg *config.Config, rawProxy string) (*url.URL, error) {
+	proxyURL, err := proxy.ParseURL(rawProxy)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProxy, err)
+	}
+	if isCloudMetadata(proxyURL.Hostname()) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProxy, ErrBlockedMetadata)
+	}
+	if cfg.BlockLocal && isLocalOrPrivateIP(proxyURL.Hostname()) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProxy, ErrBlockedLocal)
+	}
+	return proxyURL, nil
+}
+
+// clientFor returns the client for a request: the shared one, or a copy
+// routed through a proxy and/or holding the session's cookie jar. A caller's
+// proxy overrides the pool; when the pool is used, the chosen proxy is
+// returned so its health can be reported.
+func (e *HTTPEngine) clientFor(fetchReq *types.FetchRequest) (*http.Client, *url.URL, error) {
+	client := e.client
+	var pooled *url.URL
+
+	switch {
+	case fetchReq.Proxy != "":
+		proxyURL, err := requestProxy(e.config, fetchReq.Proxy)
+		if err != nil {
+			return nil, nil, err
+		}
+		client = e.variantClient(proxyURL, fetchReq.ForceHTTP1)
+
+	case e.proxies != nil:
+		pooled = e.proxies.Pick(hostOf(fetchReq.URL))
+		client = e.variantClient(pooled, fetchReq.ForceHTTP1)
+
+	case fetchReq.ForceHTTP1:
+		client = e.variantClient(nil, true)
+	}
+
+	// A named session gets its own jar, so cookies set during redirects are kept
+	if fetchReq.Session != "" {
+		jar, err := e.sessions.Get(fetchReq.Session)
+		if err != nil {
+			return nil, nil, err
+		}
+		sessionClient := *client
+		sessionClient.Jar = jar
+		client = &sessionClient
+	}
+
+	return client, pooled, nil
+}
+
+// variantClient returns a client that sends requests through proxyURL (if
+// non-nil) and, with forceHTTP1, never negotiates HTTP/2
+func (e *HTTPEngine) variantClient(proxyURL *url.URL, forceHTTP1 bool) *http.Client {
+	key := ""
+	if proxyURL != nil {
+		key = proxyURL.String()
+	}
+	if forceHTTP1 {
+		key += "|http1"
+	}
+	if cached, ok := e.clients.Load(key); ok {
+		return cached.(*http.Client)
+	}
+
+	transport := e.client.Transport.(*http.Transport).Clone()
+	if proxyURL != nil {
+		// The transport handles TLS itself through a proxy's tunnel, so
+		// these requests keep net/http's header order
+		transport.Proxy = http.ProxyURL(proxyURL)
+		transport.DialContext = e.proxyDial
+		transport.DialTLSContext = nil
+	}
+	if forceHTTP1 {
+		// A non-nil, empty TLSNextProto map turns off ALPN negotiation of h2
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		transport.TLSClientConfig = &tls.Config{NextProtos: []string{"http/1.1"}}
+		if proxyURL == nil {
+			transport.DialTLSContext = orderedTLSDial(e.directDial, transport.TLSClientConfig, transport.TLSHandshakeTimeout)
+		}
+	}
+
+	client := *e.client
+	client.Transport = transport
+	cached, _ := e.clients.LoadOrStore(key, &client)
+	return cached.(*http.Client)
+}
+
+// redirectChain lists the redirects that led to resp, oldest first, by
+// walking back through the responses net/http keeps on each request
+func redirectChain(resp *http.Response) []types.Redirect {
+	var hops []types.Redirect
+	for req := resp.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		hops = append(hops, types.Redirect{
+			URL:        req.Response.Request.URL.String(),
+			StatusCode: req.Response.StatusCode,
+			Location:   req.URL.String(),
+		})
+	}
+	for i, j := 0, len(hops)-1; i < j; i, j = i+1, j-1 {
+		hops[i], hops[j] = hops[j], hops[i]
+	}
+	return hops
+}
+
+// ProxyStats returns the health of the configured proxy pool, or nil
+func (e *HTTPEngine) ProxyStats() []proxy.Status {
+	if e.proxies == nil {
+		return nil
+	}
+	return e.proxies.Stats()
+}
+
+// protocolName reports the negotiated protocol the way ALPN names it
+func protocolName(resp *http.Response) string {
+	switch resp.ProtoMajor {
+	case 2:
+		return "h2"
+	case 3:
+		return "h3"
+	}
+	return strings.ToLower(resp.Proto)
+}
+
 // validateURL validates the URL and checks for security issues
 func (e *HTTPEngine) validateURL(fetchURL string) error {
 	parsedURL, err := url.Parse(fetchURL)
 	if err != nil {
-		return fmt.Errorf("invalid URL: %w", err)
+		return fmt.Errorf("%w: %v", ErrInvalidURL, err)
 	}
 
-	// Check scheme
-	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
-		return fmt.Errorf("unsupported scheme: %s", parsedURL.Scheme)
+	// Check scheme against the operator's policy and what HTTP can fetch
+	if err := checkScheme(e.config, fetchURL); err != nil {
+		return err
 	}
-
-	// Check for local/private IPs if blocking is enabled
-	if e.config.BlockLocal {
-		host := parsedURL.Hostname()
-		if isLocalOrPrivateIP(host) {
-			return fmt.Errorf("access to local/private IP addresses is blocked")
-		}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return fmt.Errorf("%w: %s", ErrUnsupportedScheme, parsedURL.Scheme)
 	}
 
-	return nil
+	return checkTarget(e.config, fetchURL)
 }
 
-// readResponseBody reads the response body with size limits and decompression
-func (e *HTTPEngine) readResponseBody(resp *http.Response, maxContentLength int) ([]byte, error) {
-	var reader io.Reader = resp.Body
+// readResponseBody reads the response body with size limits and
+// decompression, also returning how many bytes were received before decoding.
+// A body past the stream threshold is written to disk as it arrives: the
+// download is returned along with the bytes read before the threshold.
+func (e *HTTPEngine) readResponseBody(resp *http.Response, fetchReq *types.FetchRequest) ([]byte, *download, int64, error) {
+	// Undo any content coding the server applied
+	counter := &countingReader{r: e.throttle(resp.Request.Context(), resp.Body, fetchReq)}
+	reader, cleanup, err := decodeContent(counter, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return nil, nil, counter.n, err
+	}
+	defer cleanup()
 
-	// Handle gzip compression
-	if resp.Header.Get("Content-Encoding") == "gzip" {
-		gzipReader, err := gzip.NewReader(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+	// A server that ignored the Range header sent the whole body
+	if fetchReq.Range != nil && resp.StatusCode == http.StatusOK {
+		if reader, err = limitToRange(reader, fetchReq.Range); err != nil {
+			return nil, nil, counter.n, err
 		}
-		defer gzipReader.Close()
-		reader = gzipReader
 	}
 
-	// Read with size limit
-	limitedReader := io.LimitReader(reader, int64(maxContentLength)+1)
+	limit := int64(fetchReq.MaxContentLength)
+	streams := e.streams(fetchReq)
+	if streams {
+		limit = e.config.StreamThreshold
+	}
+
+	// Read with size limit; this applies to the decompressed bytes, so a
+	// small compressed body can't expand past max_content_length
+	limitedReader := io.LimitReader(reader, limit+1)
 	body, err := io.ReadAll(limitedReader)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, nil, counter.n, fmt.Errorf("failed to read response: %w", classifyError(err))
 	}
 
-	// Check if content was truncated
-	if len(body) > maxContentLength {
-		return body[:maxContentLength], fmt.Errorf("content exceeds maximum length of %d bytes", maxContentLength)
+	if int64(len(body)) > limit {
+		if streams {
+			dl, err := e.streamToFile(body, reader)
+			return body, dl, counter.n, err
+		}
+		// Check if content was truncated
+		return body[:limit], nil, counter.n, fmt.Errorf("%w of %d bytes", ErrContentTooLarge, limit)
 	}
 
-	return body, nil
+	return body, nil, counter.n, nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
 
 // isLocalOrPrivateIP checks if the given host is a local or private IP
@@ -219,17 +667,54 @@ func isLocalOrPrivateIP(host string) bool {
 		return true
 	}
 
-	// Parse IP
-	ip := net.ParseIP(host)
-	if ip == nil {
-		// Try to resolve hostname
-		ips, err := net.LookupIP(host)
-		if err != nil || len(ips) == 0 {
-			return false
+	// Check every address, literal or resolved, so a name with one public
+	// and one private record can't be used to reach the private one
+	for _, ip := range resolveHost(host) {
+		if isPrivateIP(ip) {
+			return true
 		}
-		ip = ips[0]
 	}
+	return false
+}
+
+// isLoopbackHost reports whether host is localhost or a loopback address,
+// judged from the name alone
+func isLoopbackHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// dialGuard returns a net.Dialer Control hook that refuses connections to
+// metadata services and, with blockLocal, to local or private addresses.
+// validateURL checks the host before the request; this checks the address
+// actually dialed, so a name can't resolve to a public address for the
+// check and an internal one for the connection.
+func dialGuard(blockLocal bool) func(network, address string, conn syscall.RawConn) error {
+	return func(network, address string, conn syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return nil
+		}
+		if isMetadataIP(ip) {
+			return ErrBlockedMetadata
+		}
+		if blockLocal && isPrivateIP(ip) {
+			return ErrBlockedLocal
+		}
+		return nil
+	}
+}
 
+// isPrivateIP checks if ip is loopback, unspecified, private or link-local
+func isPrivateIP(ip net.IP) bool {
 	// Check for private IP ranges
 	privateRanges := []string{
 		"10.0.0.0/8",
@@ -250,5 +735,5 @@ func isLocalOrPrivateIP(host string) bool {
 		}
 	}
 
-	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast()
+	return ip.IsLoopback() || ip.IsUnspecified() || ip.IsPrivate() || ip.IsLinkLocalUnicast()
 }
@@ -0,0 +1,86 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gomcpgo/url_fetcher/pkg/config"
+)
+
+// TestDialGuard tests that the address actually dialed is checked, whatever
+// the host name resolved to when the URL was validated
+func TestDialGuard(t *testing.T) {
+	tests := []struct {
+		address    string
+		blockLocal bool
+		want       error
+	}{
+		{"169.254.169.254:80", false, ErrBlockedMetadata},
+		{"[fd00:ec2::254]:80", false, ErrBlockedMetadata},
+		{"[64:ff9b::a9fe:a9fe]:80", false, ErrBlockedMetadata},
+		{"127.0.0.1:80", false, nil},
+		{"127.0.0.1:80", true, ErrBlockedLocal},
+		{"10.1.2.3:443", true, ErrBlockedLocal},
+		{"[::1]:80", true, ErrBlockedLocal},
+		{"93.184.216.34:443", true, nil},
+	}
+	for _, tt := range tests {
+		err := dialGuard(tt.blockLocal)("tcp", tt.address, nil)
+		if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+			t.Errorf("dialGuard(%v)(%s) = %v, want %v", tt.blockLocal, tt.address, err, tt.want)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// A direct connection is refused; one to a proxy on the same address isn't
+	engine := NewHTTPEngine(&config.Config{BlockLocal: true, ConnectTimeout: 5 * time.Second})
+	dial := engine.client.Transport.(*http.Transport).DialContext
+	if _, err := dial(context.Background(), "tcp", server.Listener.Addr().String()); !errors.Is(err, ErrBlockedLocal) {
+		t.Errorf("Expected a direct dial to a local address to be refused, got %v", err)
+	}
+	conn, err := engine.proxyDial(context.Background(), "tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected a proxy dial to a local address to succeed, got %v", err)
+	}
+	conn.Close()
+
+	var opErr *net.OpError
+	if _, err := dial(context.Background(), "tcp", "169.254.169.254:80"); !errors.As(err, &opErr) || !errors.Is(err, ErrBlockedMetadata) {
+		t.Errorf("Expected a dial to a metadata address to be refused, got %v", err)
+	}
+}
+
+// TestNewDialer tests that the dual-stack fallback delay reaches the dialer
+// as configured: zero for Go's default, negative to disable the race
+func TestNewDialer(t *testing.T) {
+	for _, tt := range []struct {
+		env  string
+		want time.Duration
+	}{
+		{"", 300 * time.Millisecond},
+		{"50", 50 * time.Millisecond},
+		{"0", 0},
+		{"-1", -time.Millisecond},
+	} {
+		t.Setenv("FETCH_URL_DUAL_STACK_FALLBACK_MS", tt.env)
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			t.Fatalf("LoadConfig(%q) failed: %v", tt.env, err)
+		}
+		if got := newDialer(cfg).FallbackDelay; got != tt.want {
+			t.Errorf("FETCH_URL_DUAL_STACK_FALLBACK_MS=%q gave a fallback delay of %v, want %v", tt.env, got, tt.want)
+		}
+	}
+
+	t.Setenv("FETCH_URL_DUAL_STACK_FALLBACK_MS", "soon")
+	if _, err := config.LoadConfig(); err == nil {
+		t.Error("Expected an invalid delay to be rejected")
+	}
+}
@@ -0,0 +1,220 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/storage"
+	"github.com/chromedp/chromedp"
+	"github.com/gomcpgo/url_fetcher/pkg/config"
+	"github.com/gomcpgo/url_fetcher/pkg/session"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// Login runs the configured login flow for a session and stores the cookies
+// the browser ends up with in it, returning how many the session now holds
+func (f *Fetcher) Login(ctx context.Context, name string) (int, error) {
+	if f.config.Offline {
+		return 0, ErrOffline
+	}
+	flow, ok := f.loginFlow(name)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrNoLoginFlow, name)
+	}
+
+	jar, err := f.sessions.Get(name)
+	if err != nil {
+		return 0, err
+	}
+	if err := f.chromeEngine.Login(ctx, flow, jar); err != nil {
+		return 0, err
+	}
+	jar.Renew()
+	if err := f.sessions.Save(name); err != nil {
+		return 0, err
+	}
+	return jar.Len(), nil
+}
+
+// loginFlow returns the login flow configured for a session
+func (f *Fetcher) loginFlow(name string) (config.LoginFlow, bool) {
+	for _, flow := range f.config.LoginFlows {
+		if flow.Session == name {
+			return flow, true
+		}
+	}
+	return config.LoginFlow{}, false
+}
+
+// ensureLoggedIn runs a session's login flow before its first use, so
+// fetches naming a scripted session see authenticated content without an
+// explicit login call. Sessions known to have expired log in again when
+// their flow allows it.
+func (f *Fetcher) ensureLoggedIn(ctx context.Context, name string) error {
+	flow, ok := f.loginFlow(name)
+	if !ok {
+		return nil
+	}
+	if jar, ok := f.sessions.Lookup(name); ok && jar.Len() > 0 {
+		if !jar.Expired() || !flow.AutoRelogin {
+			return nil
+		}
+	}
+	_, err := f.Login(ctx, name)
+	return err
+}
+
+// loginPathPattern matches the paths sites commonly redirect to when a login
+// is required
+var loginPathPattern = regexp.MustCompile(`(?i)/(log-?in|sign-?in|signon|auth|sso|session/new)(/|\.|$)`)
+
+// atLoginWall reports whether a session fetch was redirected to a login page:
+// the session's login flow URL, or a login-looking path the request itself
+// didn't ask for
+func (f *Fetcher) atLoginWall(req *types.FetchRequest, response *types.FetchResponse) bool {
+	final := redirectedTo(req, response)
+	if final == nil {
+		return false
+	}
+
+	if flow, ok := f.loginFlow(req.Session); ok {
+		if login, err := url.Parse(flow.URL); err == nil &&
+			strings.EqualFold(login.Hostname(), final.Hostname()) && login.Path == final.Path {
+			return true
+		}
+	}
+	return redirectedToLogin(req, response)
+}
+
+// redirectedTo returns where a fetch ended up if that isn't the page it
+// asked for, and nil otherwise
+func redirectedTo(req *types.FetchRequest, response *types.FetchResponse) *url.URL {
+	if response == nil || response.FinalURL == "" {
+		return nil
+	}
+	final, err := url.Parse(response.FinalURL)
+	if err != nil {
+		return nil
+	}
+	requested, err := url.Parse(req.URL)
+	if err != nil || (final.Host == requested.Host && final.Path == requested.Path) {
+		return nil
+	}
+	return final
+}
+
+// redirectedToLogin reports whether a fetch ended up on a login-looking path
+// it didn't ask for
+func redirectedToLogin(req *types.FetchRequest, response *types.FetchResponse) bool {
+	final := redirectedTo(req, response)
+	if final == nil {
+		return false
+	}
+	requested, _ := url.Parse(req.URL)
+	return loginPathPattern.MatchString(final.Path) && !loginPathPattern.MatchString(requested.Path)
+}
+
+// reauthenticate handles a session fetch that landed on a login page. The
+// session is marked expired; if its flow allows, it is logged in again and
+// the request refetched. Either way a warning tells the caller what happened.
+func (f *Fetcher) reauthenticate(ctx context.Context, req *types.FetchRequest, response *types.FetchResponse, chromeAvailable bool) (*types.FetchResponse, error) {
+	name := req.Session
+	if jar, ok := f.sessions.Lookup(name); ok {
+		jar.MarkExpired()
+	}
+
+	flow, ok := f.loginFlow(name)
+	if !ok || !flow.AutoRelogin {
+		response.Warnings = append(response.Warnings,
+			fmt.Sprintf("session %s has expired (redirected to %s); log in again or import fresh cookies", name, response.FinalURL))
+		return response, nil
+	}
+
+	if _, err := f.Login(ctx, name); err != nil {
+		response.Warnings = append(response.Warnings,
+			fmt.Sprintf("session %s has expired (redirected to %s) and logging in again failed: %v", name, response.FinalURL, err))
+		return response, nil
+	}
+
+	retried, err := f.fetchWithEngine(ctx, req, chromeAvailable)
+	if err != nil {
+		return retried, err
+	}
+	if f.atLoginWall(req, retried) {
+		if jar, ok := f.sessions.Lookup(name); ok {
+			jar.MarkExpired()
+		}
+		retried.Warnings = append(retried.Warnings,
+			fmt.Sprintf("session %s logged in again but was still redirected to %s", name, retried.FinalURL))
+		return retried, nil
+	}
+	retried.Warnings = append(retried.Warnings,
+		fmt.Sprintf("session %s had expired; logged in again and refetched", name))
+	return retried, nil
+}
+
+// Login fills in and submits a login form in a fresh tab, waits for the
+// logged-in page, and copies every cookie the browser holds into jar
+func (e *ChromeEngine) Login(ctx context.Context, flow config.LoginFlow, jar *session.Session) error {
+	if !e.isAvailable {
+		return ErrChromeUnavailable
+	}
+
+	instanceID, err := e.pool.acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("login flow for session %s failed: %w", flow.Session, classifyError(err))
+	}
+	defer func() {
+		e.pool.available <- instanceID
+	}()
+
+	timeoutCtx, cancel := e.newTab(ctx, instanceID, nil)
+	defer cancel()
+
+	actions := []chromedp.Action{
+		network.Enable(),
+		chromedp.Navigate(flow.URL),
+		chromedp.WaitVisible(flow.UsernameSelector, chromedp.ByQuery),
+		chromedp.SendKeys(flow.UsernameSelector, flow.Username, chromedp.ByQuery),
+		chromedp.WaitVisible(flow.PasswordSelector, chromedp.ByQuery),
+		chromedp.SendKeys(flow.PasswordSelector, flow.Password, chromedp.ByQuery),
+		chromedp.Click(flow.SubmitSelector, chromedp.ByQuery),
+	}
+	if flow.WaitSelector != "" {
+		actions = append(actions, chromedp.WaitVisible(flow.WaitSelector, chromedp.ByQuery))
+	} else {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			return waitForPageStability(ctx, 15*time.Second)
+		}))
+	}
+	actions = append(actions,
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			// Logins often bounce through an SSO domain, so keep every cookie
+			cookies, err := storage.GetCookies().Do(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to read login cookies: %w", err)
+			}
+			for _, c := range cookies {
+				host := strings.TrimPrefix(c.Domain, ".")
+				storeSessionCookies(jar, "https://"+host+c.Path, []*network.Cookie{c})
+			}
+
+			// The browser instance is shared; don't leak this session into the next fetch
+			return network.ClearBrowserCookies().Do(ctx)
+		}),
+	)
+
+	if err := chromedp.Run(timeoutCtx, actions...); err != nil {
+		if ctx.Err() != nil {
+			// The tab was closed because the caller gave up
+			err = ctx.Err()
+		}
+		return fmt.Errorf("login flow for session %s failed: %w", flow.Session, classifyError(err))
+	}
+	return nil
+}
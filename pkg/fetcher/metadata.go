@@ -0,0 +1,173 @@
+package fetcher
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gomcpgo/url_fetcher/pkg/config"
+)
+
+// metadataHosts are the names cloud providers serve instance metadata (and
+// with it, credentials) under
+var metadataHosts = map[string]bool{
+	"metadata":                   true,
+	"metadata.google.internal":   true,
+	"metadata.goog":              true,
+	"instance-data":              true,
+	"instance-data.ec2.internal": true,
+}
+
+// metadataIPs are the addresses of cloud metadata services
+var metadataIPs = []net.IP{
+	net.ParseIP("169.254.169.254"), // AWS, GCP, Azure, OpenStack, DigitalOcean
+	net.ParseIP("169.254.170.2"),   // AWS ECS task metadata
+	net.ParseIP("169.254.169.123"), // AWS time sync
+	net.ParseIP("100.100.100.200"), // Alibaba Cloud
+	net.ParseIP("192.0.0.192"),     // Oracle Cloud
+	net.ParseIP("fd00:ec2::254"),   // AWS over IPv6
+	net.ParseIP("fd00:ec2::23"),    // AWS DNS over IPv6
+}
+
+// nat64Prefix is the well-known prefix that embeds an IPv4 address in IPv6
+var nat64Prefix = net.ParseIP("64:ff9b::")
+
+// checkTarget rejects a URL whose host is a cloud metadata service or, with
+// BlockLocal, a local or private address. URLs without a host, such as
+// data: URLs, pass.
+func checkTarget(cfg *config.Config, rawURL string) error {
+	host := hostOf(rawURL)
+	if host == "" {
+		return nil
+	}
+	// Metadata services hand out credentials, so they are never fetched
+	if isCloudMetadata(host) {
+		return ErrBlockedMetadata
+	}
+	if cfg.BlockLocal && isLocalOrPrivateIP(host) {
+		return ErrBlockedLocal
+	}
+	return nil
+}
+
+// targetCache remembers checkTarget's verdict per host for one page load,
+// whose many requests mostly go to a handful of hosts
+type targetCache struct {
+	cfg     *config.Config
+	mu      sync.Mutex
+	verdict map[string]error
+}
+
+func newTargetCache(cfg *config.Config) *targetCache {
+	return &targetCache{cfg: cfg, verdict: make(map[string]error)}
+}
+
+// check returns checkTarget's verdict on rawURL
+func (c *targetCache) check(rawURL string) error {
+	host := hostOf(rawURL)
+	c.mu.Lock()
+	err, ok := c.verdict[host]
+	c.mu.Unlock()
+	if ok {
+		return err
+	}
+	err = checkTarget(c.cfg, rawURL)
+	c.mu.Lock()
+	c.verdict[host] = err
+	c.mu.Unlock()
+	return err
+}
+
+// isCloudMetadata reports whether host names a cloud metadata service, by
+// name or by any address it is written as or resolves to
+func isCloudMetadata(host string) bool {
+	name := strings.TrimSuffix(strings.ToLower(host), ".")
+	if metadataHosts[name] {
+		return true
+	}
+	for _, ip := range resolveHost(host) {
+		if isMetadataIP(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isMetadataIP reports whether ip is a metadata service address, including
+// when it is wrapped in an IPv4-mapped or NAT64 IPv6 address
+func isMetadataIP(ip net.IP) bool {
+	if ip16 := ip.To16(); ip16 != nil && ip.To4() == nil && net.IP(ip16[:12]).Equal(nat64Prefix[:12]) {
+		ip = net.IP(ip16[12:])
+	}
+	for _, metadata := range metadataIPs {
+		if ip.Equal(metadata) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveHost returns the addresses host refers to. Literal addresses are
+// parsed the way browsers and inet_aton do, so decimal (2852039166), hex
+// (0xa9fea9fe), octal (0251.0376.0251.0376) and shortened (169.254.43518)
+// forms can't slip past a check on the dotted form. Names are looked up in
+// DNS; nil means the host couldn't be resolved.
+func resolveHost(host string) []net.IP {
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}
+	}
+	if ip := parseLooseIPv4(host); ip != nil {
+		return []net.IP{ip}
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil
+	}
+	return ips
+}
+
+// parseLooseIPv4 parses the one to four part, decimal/octal/hex IPv4 forms
+// inet_aton accepts, or returns nil
+func parseLooseIPv4(host string) net.IP {
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return nil
+	}
+
+	values := make([]uint64, len(parts))
+	for i, part := range parts {
+		var value uint64
+		var err error
+		switch {
+		case strings.HasPrefix(part, "0x") || strings.HasPrefix(part, "0X"):
+			value, err = strconv.ParseUint(part[2:], 16, 32)
+		case len(part) > 1 && part[0] == '0':
+			value, err = strconv.ParseUint(part[1:], 8, 32)
+		default:
+			value, err = strconv.ParseUint(part, 10, 32)
+		}
+		if err != nil {
+			return nil
+		}
+		values[i] = value
+	}
+
+	// All but the last part are single bytes; the last fills the remainder
+	var addr uint64
+	for _, value := range values[:len(values)-1] {
+		if value > 0xff {
+			return nil
+		}
+		addr = addr<<8 | value
+	}
+	last := values[len(values)-1]
+	remaining := uint(8 * (5 - len(values)))
+	if last >= 1<<remaining {
+		return nil
+	}
+	addr = addr<<remaining | last
+
+	return net.IPv4(byte(addr>>24), byte(addr>>16), byte(addr>>8), byte(addr))
+}
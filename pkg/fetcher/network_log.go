@@ -0,0 +1,120 @@
+package fetcher
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// maxNetworkEntries bounds how many requests a page adds to its response;
+// later ones are counted but dropped
+const maxNetworkEntries = 500
+
+// networkLog records the requests a page makes while Chrome renders it
+type networkLog struct {
+	mu      sync.Mutex
+	start   time.Time
+	entries []types.NetworkEntry
+	started map[network.RequestID]time.Time
+	open    map[network.RequestID]int // index of each request's current entry
+	dropped int
+}
+
+func newNetworkLog() *networkLog {
+	return &networkLog{
+		started: make(map[network.RequestID]time.Time),
+		open:    make(map[network.RequestID]int),
+	}
+}
+
+// listen is a chromedp target listener feeding the log
+func (l *networkLog) listen(ev interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch ev := ev.(type) {
+	case *network.EventRequestWillBeSent:
+		if strings.HasPrefix(ev.Request.URL, "data:") {
+			return
+		}
+		at := monotonic(ev.Timestamp)
+		// A redirect reuses the request ID: the hop ends here
+		if ev.RedirectResponse != nil {
+			l.respond(ev.RequestID, ev.RedirectResponse)
+			l.finish(ev.RequestID, at, 0, "")
+		}
+		if len(l.entries) >= maxNetworkEntries {
+			l.dropped++
+			return
+		}
+		if l.start.IsZero() {
+			l.start = at
+		}
+		l.open[ev.RequestID] = len(l.entries)
+		l.started[ev.RequestID] = at
+		l.entries = append(l.entries, types.NetworkEntry{
+			URL:       ev.Request.URL,
+			Method:    ev.Request.Method,
+			Type:      strings.ToLower(string(ev.Type)),
+			StartedMs: at.Sub(l.start).Milliseconds(),
+		})
+	case *network.EventResponseReceived:
+		l.respond(ev.RequestID, ev.Response)
+	case *network.EventLoadingFinished:
+		l.finish(ev.RequestID, monotonic(ev.Timestamp), int64(ev.EncodedDataLength), "")
+	case *network.EventLoadingFailed:
+		l.finish(ev.RequestID, monotonic(ev.Timestamp), 0, ev.ErrorText)
+	}
+}
+
+// respond records a request's response; l.mu must be held
+func (l *networkLog) respond(id network.RequestID, response *network.Response) {
+	i, ok := l.open[id]
+	if !ok {
+		return
+	}
+	l.entries[i].Status = int(response.Status)
+	l.entries[i].MimeType = response.MimeType
+	l.entries[i].Bytes = int64(response.EncodedDataLength)
+}
+
+// finish closes a request's entry; l.mu must be held
+func (l *networkLog) finish(id network.RequestID, at time.Time, bytes int64, errorText string) {
+	i, ok := l.open[id]
+	if !ok {
+		return
+	}
+	entry := &l.entries[i]
+	entry.DurationMs = at.Sub(l.started[id]).Milliseconds()
+	if bytes > 0 {
+		entry.Bytes = bytes
+	}
+	entry.Error = errorText
+	delete(l.open, id)
+	delete(l.started, id)
+}
+
+// apply adds the recorded requests to a response, with a warning if some
+// were dropped. Requests still in flight are left without a duration.
+func (l *networkLog) apply(response *types.FetchResponse) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	response.Network = l.entries
+	if l.dropped > 0 {
+		response.Warnings = append(response.Warnings,
+			fmt.Sprintf("%d more network requests were not recorded", l.dropped))
+	}
+}
+
+// monotonic converts an event timestamp, falling back to now without one
+func monotonic(t *cdp.MonotonicTime) time.Time {
+	if t == nil {
+		return time.Now()
+	}
+	return t.Time()
+}
@@ -0,0 +1,95 @@
+package fetcher
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// TestNetworkLog tests recording a page's requests from Chrome's events:
+// redirect hops, data: URLs, failures and requests still in flight
+func TestNetworkLog(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) *cdp.MonotonicTime {
+		ts := cdp.MonotonicTime(start.Add(time.Duration(ms) * time.Millisecond))
+		return &ts
+	}
+	requests := newNetworkLog()
+
+	// The document redirects once, keeping its request ID
+	requests.listen(&network.EventRequestWillBeSent{
+		RequestID: "1", Type: network.ResourceTypeDocument, Timestamp: at(0),
+		Request: &network.Request{URL: "http://example.com/", Method: "GET"},
+	})
+	requests.listen(&network.EventRequestWillBeSent{
+		RequestID: "1", Type: network.ResourceTypeDocument, Timestamp: at(30),
+		Request:          &network.Request{URL: "https://example.com/", Method: "GET"},
+		RedirectResponse: &network.Response{Status: 301, MimeType: "text/html", EncodedDataLength: 120},
+	})
+	requests.listen(&network.EventResponseReceived{
+		RequestID: "1", Type: network.ResourceTypeDocument,
+		Response: &network.Response{Status: 200, MimeType: "text/html", EncodedDataLength: 300},
+	})
+	requests.listen(&network.EventLoadingFinished{RequestID: "1", Timestamp: at(100), EncodedDataLength: 5000})
+
+	// Inline data isn't a request
+	requests.listen(&network.EventRequestWillBeSent{
+		RequestID: "2", Type: network.ResourceTypeImage, Timestamp: at(110),
+		Request: &network.Request{URL: "data:image/png;base64,iVBORw0KGgo=", Method: "GET"},
+	})
+
+	requests.listen(&network.EventRequestWillBeSent{
+		RequestID: "3", Type: network.ResourceTypeScript, Timestamp: at(120),
+		Request: &network.Request{URL: "https://cdn.example/app.js", Method: "GET"},
+	})
+	requests.listen(&network.EventLoadingFailed{RequestID: "3", Timestamp: at(125), ErrorText: "net::ERR_BLOCKED_BY_CLIENT"})
+
+	// The page is captured before this one finishes
+	requests.listen(&network.EventRequestWillBeSent{
+		RequestID: "4", Type: network.ResourceTypeXHR, Timestamp: at(150),
+		Request: &network.Request{URL: "https://api.example/poll", Method: "POST"},
+	})
+
+	want := []types.NetworkEntry{
+		{URL: "http://example.com/", Method: "GET", Type: "document", Status: 301, MimeType: "text/html", Bytes: 120, DurationMs: 30},
+		{URL: "https://example.com/", Method: "GET", Type: "document", Status: 200, MimeType: "text/html", Bytes: 5000, StartedMs: 30, DurationMs: 70},
+		{URL: "https://cdn.example/app.js", Method: "GET", Type: "script", StartedMs: 120, DurationMs: 5, Error: "net::ERR_BLOCKED_BY_CLIENT"},
+		{URL: "https://api.example/poll", Method: "POST", Type: "xhr", StartedMs: 150},
+	}
+	response := &types.FetchResponse{}
+	requests.apply(response)
+	if !reflect.DeepEqual(response.Network, want) {
+		t.Errorf("Expected %+v, got %+v", want, response.Network)
+	}
+	if len(response.Warnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", response.Warnings)
+	}
+}
+
+// TestNetworkLogLimit tests that requests over the limit are counted and
+// reported rather than recorded
+func TestNetworkLogLimit(t *testing.T) {
+	requests := newNetworkLog()
+	for i := 0; i < maxNetworkEntries+3; i++ {
+		requests.listen(&network.EventRequestWillBeSent{
+			RequestID: network.RequestID(fmt.Sprint(i)), Type: network.ResourceTypeImage,
+			Request: &network.Request{URL: fmt.Sprintf("https://example.com/%d.png", i), Method: "GET"},
+		})
+	}
+	// Events for a dropped request are ignored
+	requests.listen(&network.EventLoadingFinished{RequestID: network.RequestID(fmt.Sprint(maxNetworkEntries)), EncodedDataLength: 100})
+
+	response := &types.FetchResponse{}
+	requests.apply(response)
+	if len(response.Network) != maxNetworkEntries {
+		t.Errorf("Expected %d entries, got %d", maxNetworkEntries, len(response.Network))
+	}
+	if want := []string{"3 more network requests were not recorded"}; !reflect.DeepEqual(response.Warnings, want) {
+		t.Errorf("Expected warnings %v, got %v", want, response.Warnings)
+	}
+}
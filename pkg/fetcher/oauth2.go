@@ -0,0 +1,85 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gomcpgo/url_fetcher/pkg/config"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// oauth2Provider supplies cached access tokens for requests to its domains
+type oauth2Provider struct {
+	domains []string
+	grant   *clientcredentials.Config
+	client  *http.Client
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+// newOAuth2Providers builds a provider per configured client. Tokens are
+// fetched lazily on first use and reused until shortly before they expire.
+func newOAuth2Providers(cfg *config.Config) []*oauth2Provider {
+	providers := make([]*oauth2Provider, 0, len(cfg.OAuth2))
+	for _, c := range cfg.OAuth2 {
+		providers = append(providers, &oauth2Provider{
+			domains: c.Domains,
+			grant: &clientcredentials.Config{
+				ClientID:     c.ClientID,
+				ClientSecret: c.ClientSecret,
+				TokenURL:     c.TokenURL,
+				Scopes:       c.Scopes,
+			},
+			// Token requests get the same overall deadline as fetches
+			client: &http.Client{Timeout: cfg.Timeout},
+		})
+	}
+	return providers
+}
+
+// accessToken returns the cached token, or fetches a new one as part of the
+// request ctx belongs to, so a cancelled fetch stops waiting for it.
+// Concurrent fetches wait for the same token request.
+func (p *oauth2Provider) accessToken(ctx context.Context) (*oauth2.Token, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token.Valid() {
+		return p.token, nil
+	}
+	token, err := p.grant.Token(context.WithValue(ctx, oauth2.HTTPClient, p.client))
+	if err != nil {
+		return nil, err
+	}
+	p.token = token
+	return token, nil
+}
+
+// oauth2Auth returns bearer credentials for req's host if a configured client
+// covers it. Explicit auth on the request always wins.
+func (f *Fetcher) oauth2Auth(ctx context.Context, req *types.FetchRequest) (*types.Auth, error) {
+	if req.Auth != nil || len(f.oauth2) == 0 {
+		return nil, nil
+	}
+
+	host := hostOf(req.URL)
+	for _, provider := range f.oauth2 {
+		for _, domain := range provider.domains {
+			domain = strings.ToLower(domain)
+			if host != domain && !strings.HasSuffix(host, "."+domain) {
+				continue
+			}
+			token, err := provider.accessToken(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to obtain OAuth2 token for %s: %w", host, err)
+			}
+			return &types.Auth{Type: types.AuthBearer, Token: token.AccessToken}, nil
+		}
+	}
+	return nil, nil
+}
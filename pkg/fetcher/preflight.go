@@ -0,0 +1,92 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// preflightSkippedTypes are media types the processor can't do anything
+// useful with; a preflight that sees one of these skips the download
+var preflightSkippedTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/",
+	"application/octet-stream",
+	"application/zip",
+	"application/gzip",
+	"application/x-tar",
+	"application/x-7z-compressed",
+	"application/vnd.rar",
+}
+
+// preflight issues a HEAD request to a host we haven't fetched from before.
+// If the advertised size exceeds the limit or the type is one we skip, it
+// returns a metadata-only response and true so the caller can avoid the GET.
+// Any HEAD failure (including servers that don't support HEAD) returns false
+// and the normal GET proceeds.
+func (e *HTTPEngine) preflight(ctx context.Context, client *http.Client, fetchReq *types.FetchRequest, headers []headerField, startTime time.Time) (*types.FetchResponse, bool) {
+	host := hostOf(fetchReq.URL)
+	if _, known := e.knownHosts.Load(host); known {
+		return nil, false
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, fetchReq.URL, nil)
+	if err != nil {
+		return nil, false
+	}
+	applyHeaders(req, headers)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, false
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, false
+	}
+
+	var reason string
+	contentType := resp.Header.Get("Content-Type")
+	if !processableType(contentType) {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		reason = fmt.Sprintf("content type %s is not processable", mediaType)
+	}
+	if maxSize := e.maxBodySize(fetchReq); reason == "" && fetchReq.Range == nil && maxSize > 0 && resp.ContentLength > maxSize {
+		reason = fmt.Sprintf("content length %d exceeds maximum of %d bytes", resp.ContentLength, maxSize)
+	}
+	if reason == "" {
+		return nil, false
+	}
+
+	return &types.FetchResponse{
+		URL:           fetchReq.URL,
+		Engine:        e.name,
+		StatusCode:    resp.StatusCode,
+		ContentType:   contentType,
+		ContentLength: resp.ContentLength,
+		Skipped:       true,
+		Format:        types.FormatText,
+		FetchTimeMs:   time.Since(startTime).Milliseconds(),
+		Warnings:      []string{"Download skipped after HEAD preflight: " + reason},
+	}, true
+}
+
+// processableType reports whether contentType is one the processor can do
+// something useful with
+func processableType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for _, prefix := range preflightSkippedTypes {
+		if strings.HasPrefix(mediaType, prefix) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package fetcher
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// rangeHeader formats r as a Range header value
+func rangeHeader(r *types.ByteRange) string {
+	if r.End < 0 {
+		return fmt.Sprintf("bytes=%d-", r.Start)
+	}
+	return fmt.Sprintf("bytes=%d-%d", r.Start, r.End)
+}
+
+// withRange adds a Range header for r to headers. A range applies to the
+// encoded body, which can't be decoded from the middle, so compression is
+// turned off.
+func withRange(headers []headerField, r *types.ByteRange) []headerField {
+	ranged := make([]headerField, 0, len(headers)+1)
+	for _, field := range headers {
+		if field.name == "Accept-Encoding" {
+			field.value = "identity"
+		}
+		ranged = append(ranged, field)
+	}
+	return append(ranged, headerField{"Range", rangeHeader(r)})
+}
+
+// limitToRange cuts reader down to r, for a server that ignored the Range
+// header and sent the whole body: the bytes before the range are discarded
+// and reading stops at its end, so only the range is downloaded
+func limitToRange(reader io.Reader, r *types.ByteRange) (io.Reader, error) {
+	if _, err := io.CopyN(io.Discard, reader, r.Start); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read response: %w", classifyError(err))
+	}
+	if r.End < 0 {
+		return reader, nil
+	}
+	return io.LimitReader(reader, r.End-r.Start+1), nil
+}
+
+// servedRange describes the part of the body a range request got back: the
+// server's Content-Range for a 206, or the range cut from a full 200 body
+// (bodyLen bytes of it)
+func servedRange(resp *http.Response, r *types.ByteRange, bodyLen int64) string {
+	if resp.StatusCode == http.StatusPartialContent {
+		return resp.Header.Get("Content-Range")
+	}
+	total := "*"
+	if resp.ContentLength >= 0 && resp.Header.Get("Content-Encoding") == "" {
+		total = fmt.Sprint(resp.ContentLength)
+	}
+	if bodyLen == 0 {
+		return "bytes */" + total
+	}
+	return fmt.Sprintf("bytes %d-%d/%s", r.Start, r.Start+bodyLen-1, total)
+}
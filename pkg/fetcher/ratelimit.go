@@ -0,0 +1,105 @@
+package fetcher
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxIdleBuckets is how many host buckets are kept before full ones, which
+// carry no state worth keeping, are swept
+const maxIdleBuckets = 1024
+
+// hostLimiter spaces out requests to each host with a token bucket: a host
+// may take burst requests at once, then rate requests per second
+type hostLimiter struct {
+	rate  float64 // tokens per second; 0 when unlimited
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	delayed int64
+}
+
+// bucket is one host's tokens as of last; tokens go negative while requests
+// are waiting for them
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newHostLimiter(rate float64, burst int) *hostLimiter {
+	return &hostLimiter{rate: rate, burst: float64(max(burst, 1)), buckets: make(map[string]*bucket)}
+}
+
+// wait blocks until host may be sent another request. Requests are served in
+// the order they arrive: each one reserves the next token, and hands it back
+// if ctx ends first.
+func (l *hostLimiter) wait(ctx context.Context, host string) error {
+	if l.rate <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	b := l.refill(host, now)
+	b.tokens--
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / l.rate * float64(time.Second))
+		l.delayed++
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.refill(host, time.Now()).tokens++
+		l.mu.Unlock()
+		return classifyError(ctx.Err())
+	}
+}
+
+// refill returns host's bucket with the tokens earned since it was last
+// used; l.mu must be held
+func (l *hostLimiter) refill(host string, now time.Time) *bucket {
+	b := l.buckets[host]
+	if b == nil {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.sweep(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[host] = b
+		return b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	return b
+}
+
+// sweep drops the buckets that have refilled completely; l.mu must be held
+func (l *hostLimiter) sweep(now time.Time) {
+	for host, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, host)
+		}
+	}
+}
+
+// stats fills in the limiter's part of the scheduler stats
+func (l *hostLimiter) stats(stats *SchedulerStats) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats.HostRate = l.rate
+	if l.rate > 0 {
+		stats.HostBurst = int(l.burst)
+	}
+	stats.RateLimited = l.delayed
+}
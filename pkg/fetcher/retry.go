@@ -0,0 +1,114 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomcpgo/url_fetcher/pkg/config"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// retryPolicy returns the policy for a request: the built-in defaults,
+// overridden by FETCH_URL_RETRY and then by the request's retry parameter
+func retryPolicy(cfg *config.Config, req *types.FetchRequest) types.RetryPolicy {
+	return types.DefaultRetryPolicy.Merge(&cfg.Retry).Merge(req.Retry)
+}
+
+// retryDelay returns how long to wait before the given retry (1 for the
+// first): the base delay doubled for each earlier retry, capped and jittered
+func retryDelay(policy types.RetryPolicy, retry int) time.Duration {
+	delay := float64(policy.BaseDelayMs) * math.Pow(2, float64(retry-1))
+	if policy.MaxDelayMs > 0 && delay > float64(policy.MaxDelayMs) {
+		delay = float64(policy.MaxDelayMs)
+	}
+	if policy.Jitter > 0 {
+		delay *= 1 + policy.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay * float64(time.Millisecond))
+}
+
+// retryableStatus reports whether the policy retries a response status
+func retryableStatus(policy types.RetryPolicy, statusCode int) bool {
+	for _, code := range policy.StatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// retryableError reports whether the policy retries a failed attempt. Only
+// timeouts and connection failures are; errors that would repeat, such as a
+// rejected redirect or an oversized body, never are.
+func retryableError(policy types.RetryPolicy, err error) bool {
+	if policy.NetworkErrors != nil && !*policy.NetworkErrors {
+		return false
+	}
+	return transientError(err)
+}
+
+// transientError reports whether err is a timeout or connection failure,
+// which may not happen again, rather than something about the request or
+// the response's status
+func transientError(err error) bool {
+	switch {
+	case errors.Is(err, context.Canceled),
+		errors.Is(err, ErrTooManyRedirects),
+		errors.Is(err, ErrUnsafeRedirect),
+		errors.Is(err, ErrBlockedLocal),
+		errors.Is(err, ErrBlockedMetadata),
+		errors.Is(err, ErrContentTooLarge),
+		errors.Is(err, ErrChromeUnavailable):
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		// Statuses are retried by retryableStatus
+		return false
+	}
+
+	var netErr net.Error
+	return errors.Is(err, ErrTimeout) || errors.As(err, &netErr) ||
+		strings.Contains(err.Error(), "net::ERR_") // Chrome's network errors
+}
+
+// parseRetryAfter reads a Retry-After header, given in seconds or as an
+// HTTP date, as the time to wait from now
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// waitRetry sleeps for delay, returning early with a classified error if
+// ctx ends first
+func waitRetry(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return classifyError(ctx.Err())
+	}
+}
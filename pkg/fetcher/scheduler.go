@@ -0,0 +1,141 @@
+package fetcher
+
+import (
+	"context"
+	"sync"
+)
+
+// scheduler limits how many fetches run at once, overall and per host, so
+// interactive calls and crawls share one budget and no site gets more than
+// its share of connections
+type scheduler struct {
+	global  chan struct{} // nil when unlimited
+	perHost int           // 0 when unlimited
+
+	mu     sync.Mutex
+	hosts  map[string]*hostSlots
+	queued int
+	active int
+}
+
+// hostSlots tracks one host's running fetches; it is dropped once no fetch
+// holds or waits for it
+type hostSlots struct {
+	slots chan struct{}
+	users int
+}
+
+// SchedulerStats reports fetches running and waiting for a slot. Hosts
+// counts hosts with fetches running, when per-host limits are on.
+// RateLimited counts fetches the per-host rate limit has delayed.
+type SchedulerStats struct {
+	Active         int     `json:"active"`
+	Queued         int     `json:"queued"`
+	Hosts          int     `json:"hosts,omitempty"`
+	MaxConcurrency int     `json:"max_concurrency,omitempty"`
+	MaxPerHost     int     `json:"max_per_host,omitempty"`
+	HostRate       float64 `json:"host_rate,omitempty"`
+	HostBurst      int     `json:"host_burst,omitempty"`
+	RateLimited    int64   `json:"rate_limited,omitempty"`
+}
+
+func newScheduler(maxConcurrency, maxPerHost int) *scheduler {
+	s := &scheduler{perHost: maxPerHost, hosts: make(map[string]*hostSlots)}
+	if maxConcurrency > 0 {
+		s.global = make(chan struct{}, maxConcurrency)
+	}
+	return s
+}
+
+// acquire waits for a slot for host, taking the host's slot first so a fetch
+// waiting on a busy host doesn't hold up fetches to other hosts. The returned
+// function releases the slot.
+func (s *scheduler) acquire(ctx context.Context, host string) (func(), error) {
+	s.mu.Lock()
+	s.queued++
+	var hs *hostSlots
+	if s.perHost > 0 {
+		hs = s.hosts[host]
+		if hs == nil {
+			hs = &hostSlots{slots: make(chan struct{}, s.perHost)}
+			s.hosts[host] = hs
+		}
+		hs.users++
+	}
+	s.mu.Unlock()
+
+	dequeue := func(acquired bool) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.queued--
+		if acquired {
+			s.active++
+		} else if hs != nil {
+			s.dropHost(host, hs)
+		}
+	}
+
+	if hs != nil {
+		select {
+		case hs.slots <- struct{}{}:
+		case <-ctx.Done():
+			dequeue(false)
+			return nil, classifyError(ctx.Err())
+		}
+	}
+	if s.global != nil {
+		select {
+		case s.global <- struct{}{}:
+		case <-ctx.Done():
+			if hs != nil {
+				<-hs.slots
+			}
+			dequeue(false)
+			return nil, classifyError(ctx.Err())
+		}
+	}
+	dequeue(true)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if s.global != nil {
+				<-s.global
+			}
+			if hs != nil {
+				<-hs.slots
+			}
+			s.mu.Lock()
+			s.active--
+			if hs != nil {
+				s.dropHost(host, hs)
+			}
+			s.mu.Unlock()
+		})
+	}, nil
+}
+
+// dropHost releases one use of a host's slots; s.mu must be held
+func (s *scheduler) dropHost(host string, hs *hostSlots) {
+	hs.users--
+	if hs.users == 0 {
+		delete(s.hosts, host)
+	}
+}
+
+// stats returns a snapshot of the scheduler's load
+func (s *scheduler) stats() SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := SchedulerStats{Active: s.active, Queued: s.queued, MaxPerHost: s.perHost}
+	if s.global != nil {
+		stats.MaxConcurrency = cap(s.global)
+	}
+	for _, hs := range s.hosts {
+		if len(hs.slots) > 0 {
+			stats.Hosts++
+		}
+	}
+	return stats
+}
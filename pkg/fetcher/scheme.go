@@ -0,0 +1,97 @@
+package fetcher
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gomcpgo/url_fetcher/pkg/config"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// defaultSchemes are accepted when no scheme policy is configured
+var defaultSchemes = []string{"http", "https"}
+
+// checkScheme returns ErrUnsupportedScheme unless rawURL's scheme is one the
+// operator allows (FETCH_URL_SCHEMES, by default http and https)
+func checkScheme(cfg *config.Config, rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+
+	schemes := cfg.Schemes
+	if len(schemes) == 0 {
+		schemes = defaultSchemes
+	}
+	for _, scheme := range schemes {
+		if parsed.Scheme == scheme {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s (allowed: %s)", ErrUnsupportedScheme, parsed.Scheme, strings.Join(schemes, ", "))
+}
+
+// isDataURL reports whether rawURL is an inline data: URL
+func isDataURL(rawURL string) bool {
+	return len(rawURL) >= 5 && strings.EqualFold(rawURL[:5], "data:")
+}
+
+// fetchData decodes a data: URL (RFC 2397) into a response, without any
+// network access, so inline documents go through the same processing as
+// fetched ones
+func fetchData(req *types.FetchRequest) (*types.FetchResponse, error) {
+	startTime := time.Now()
+
+	meta, data, ok := strings.Cut(req.URL[5:], ",")
+	if !ok {
+		err := fmt.Errorf("%w: data URL has no comma", ErrInvalidURL)
+		return types.ErrorResponse(req.URL, req.Engine, err, time.Since(startTime)), err
+	}
+
+	var body []byte
+	var err error
+	if mediaType, isBase64 := strings.CutSuffix(meta, ";base64"); isBase64 {
+		meta = mediaType
+		var unescaped string
+		if unescaped, err = url.PathUnescape(data); err == nil {
+			body, err = base64.StdEncoding.DecodeString(unescaped)
+		}
+	} else {
+		var unescaped string
+		unescaped, err = url.PathUnescape(data)
+		body = []byte(unescaped)
+	}
+	if err != nil {
+		err = fmt.Errorf("%w: data URL: %v", ErrInvalidURL, err)
+		return types.ErrorResponse(req.URL, req.Engine, err, time.Since(startTime)), err
+	}
+
+	if len(body) > req.MaxContentLength {
+		err := fmt.Errorf("%w of %d bytes", ErrContentTooLarge, req.MaxContentLength)
+		return types.ErrorResponse(req.URL, req.Engine, err, time.Since(startTime)), err
+	}
+
+	contentType := meta
+	if contentType == "" || strings.HasPrefix(contentType, ";") {
+		contentType = "text/plain" + contentType
+	}
+	content, originalCharset, err := normalizeCharset(body, contentType)
+	if err != nil {
+		return types.ErrorResponse(req.URL, req.Engine, err, time.Since(startTime)), err
+	}
+
+	return &types.FetchResponse{
+		URL:           req.URL,
+		Engine:        req.Engine,
+		StatusCode:    200,
+		ContentType:   contentType,
+		Charset:       originalCharset,
+		ContentLength: int64(len(body)),
+		Content:       content,
+		Format:        types.FormatHTML, // Will be processed later
+		FetchTimeMs:   time.Since(startTime).Milliseconds(),
+	}, nil
+}
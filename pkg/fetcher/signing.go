@@ -0,0 +1,96 @@
+package fetcher
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// signingSecretPrefix namespaces the environment variables a signing spec may
+// read, so callers can't turn the server into an oracle for unrelated secrets
+const signingSecretPrefix = "FETCH_URL_SECRET_"
+
+// secretNamePattern restricts secret names to environment variable syntax
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// signURL adds an HMAC signature (and optionally an expiry) to rawURL. The
+// signed string is the path, then "?" and the query with parameters sorted
+// by name, excluding the signature parameter itself.
+func signURL(rawURL string, spec *types.SignSpec, now time.Time) (string, error) {
+	if !secretNamePattern.MatchString(spec.Secret) {
+		return "", fmt.Errorf("%w: invalid secret name %q", ErrSigning, spec.Secret)
+	}
+	envName := signingSecretPrefix + strings.ToUpper(spec.Secret)
+	secret := os.Getenv(envName)
+	if secret == "" {
+		return "", fmt.Errorf("%w: %s is not set", ErrSigning, envName)
+	}
+
+	var newHash func() hash.Hash
+	switch strings.ToLower(spec.Algorithm) {
+	case "", "hmac-sha256":
+		newHash = sha256.New
+	case "hmac-sha1":
+		newHash = sha1.New
+	case "hmac-sha512":
+		newHash = sha512.New
+	default:
+		return "", fmt.Errorf("%w: unsupported algorithm %s", ErrSigning, spec.Algorithm)
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+
+	param := spec.Param
+	if param == "" {
+		param = "signature"
+	}
+
+	query := parsed.Query()
+	query.Del(param)
+	if spec.ExpiresParam != "" {
+		ttl := spec.TTLSeconds
+		if ttl <= 0 {
+			ttl = 300
+		}
+		query.Set(spec.ExpiresParam, strconv.FormatInt(now.Add(time.Duration(ttl)*time.Second).Unix(), 10))
+	}
+
+	// url.Values.Encode sorts by key, which makes the signed string canonical
+	canonical := parsed.EscapedPath() + "?" + query.Encode()
+
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write([]byte(canonical))
+	sum := mac.Sum(nil)
+
+	var signature string
+	switch strings.ToLower(spec.Encoding) {
+	case "", "hex":
+		signature = hex.EncodeToString(sum)
+	case "base64":
+		signature = base64.StdEncoding.EncodeToString(sum)
+	case "base64url":
+		signature = base64.RawURLEncoding.EncodeToString(sum)
+	default:
+		return "", fmt.Errorf("%w: unsupported encoding %s", ErrSigning, spec.Encoding)
+	}
+
+	query.Set(param, signature)
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
@@ -0,0 +1,133 @@
+package fetcher
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// requestTimer records when the phases of an HTTP request happen. Every new
+// connection attempt resets it, so after redirects it describes the last hop.
+type requestTimer struct {
+	mu           sync.Mutex
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	reused       bool
+}
+
+// trace returns the hooks that feed the timer
+func (t *requestTimer) trace() *httptrace.ClientTrace {
+	record := func(field *time.Time) {
+		t.mu.Lock()
+		*field = time.Now()
+		t.mu.Unlock()
+	}
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			t.mu.Lock()
+			t.dnsStart, t.dnsDone = time.Time{}, time.Time{}
+			t.connectStart, t.connectDone = time.Time{}, time.Time{}
+			t.tlsStart, t.tlsDone = time.Time{}, time.Time{}
+			t.wroteRequest, t.firstByte = time.Time{}, time.Time{}
+			t.reused = false
+			t.mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.reused = info.Reused
+			t.mu.Unlock()
+		},
+		DNSStart: func(httptrace.DNSStartInfo) { record(&t.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { record(&t.dnsDone) },
+		// With dual-stack dialing several connects may race; keep the
+		// first start and the winning finish
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+			t.mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				record(&t.connectDone)
+			}
+		},
+		// orderedTLSDial reports its handshake, and the transport then
+		// reports it again for HTTP/2; keep the first start
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			if t.tlsStart.IsZero() {
+				t.tlsStart = time.Now()
+			}
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { record(&t.tlsDone) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { record(&t.wroteRequest) },
+		GotFirstResponseByte: func() { record(&t.firstByte) },
+	}
+}
+
+// timing reports the recorded phases. Transports that don't call the trace
+// hooks, such as HTTP/3, only get the time until headersReceived.
+func (t *requestTimer) timing(requestStart, headersReceived time.Time, download time.Duration) *types.Timing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timing := &types.Timing{
+		DNSMs:            between(t.dnsStart, t.dnsDone),
+		ConnectMs:        between(t.connectStart, t.connectDone),
+		TLSMs:            between(t.tlsStart, t.tlsDone),
+		DownloadMs:       download.Milliseconds(),
+		ConnectionReused: t.reused,
+	}
+	switch {
+	case !t.wroteRequest.IsZero() && !t.firstByte.IsZero():
+		timing.TTFBMs = between(t.wroteRequest, t.firstByte)
+	default:
+		timing.TTFBMs = between(requestStart, headersReceived)
+	}
+	return timing
+}
+
+// between returns the milliseconds from start to end, or 0 if either is unset
+func between(start, end time.Time) int64 {
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return 0
+	}
+	return end.Sub(start).Milliseconds()
+}
+
+// resourceTiming converts Chrome's timing of the main document request,
+// whose phases are offsets in milliseconds that are -1 when they didn't happen
+func resourceTiming(timing *types.Timing, rt *network.ResourceTiming) {
+	if rt == nil {
+		return
+	}
+	span := func(start, end float64) int64 {
+		if start < 0 || end < start {
+			return 0
+		}
+		return int64(end - start)
+	}
+	timing.DNSMs = span(rt.DNSStart, rt.DNSEnd)
+	// Chrome's connect phase includes the TLS handshake
+	connectEnd := rt.ConnectEnd
+	if rt.SslStart >= 0 {
+		connectEnd = rt.SslStart
+	}
+	timing.ConnectMs = span(rt.ConnectStart, connectEnd)
+	timing.TLSMs = span(rt.SslStart, rt.SslEnd)
+	timing.TTFBMs = span(rt.SendEnd, rt.ReceiveHeadersEnd)
+	timing.ConnectionReused = rt.ConnectStart < 0
+}
@@ -0,0 +1,51 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// retryForbidden retries a request the HTTP engines got a 403 for with each
+// of the configured fallback user agent profiles in turn, since some sites
+// refuse browser-like clients yet serve crawlers or curl. The first profile
+// to succeed is reported in the response; if none does, the original
+// failure is returned.
+func (f *Fetcher) retryForbidden(ctx context.Context, req *types.FetchRequest, response *types.FetchResponse, err error) (*types.FetchResponse, error) {
+	var statusErr *StatusError
+	if len(f.config.UAFallback) == 0 || !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusForbidden {
+		return response, err
+	}
+
+	var tried []string
+	for _, profile := range f.config.UAFallback {
+		userAgent := types.UserAgentPresets[profile]
+		if userAgent == "" || userAgent == req.UserAgent {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		retry := *req
+		retry.UserAgent = userAgent
+		retryResponse, retryErr := f.httpEngine.Fetch(ctx, &retry)
+		if retryErr == nil {
+			retryResponse.UserAgentProfile = profile
+			retryResponse.Warnings = append(retryResponse.Warnings,
+				fmt.Sprintf("refused with status 403; succeeded with the %s user agent", profile))
+			return retryResponse, nil
+		}
+		tried = append(tried, profile)
+	}
+
+	if response != nil && len(tried) > 0 {
+		response.Warnings = append(response.Warnings,
+			fmt.Sprintf("refused with status 403; also failed with the %s user agents", strings.Join(tried, ", ")))
+	}
+	return response, err
+}
@@ -0,0 +1,59 @@
+package fetcher
+
+import (
+	"sync"
+
+	"github.com/gomcpgo/url_fetcher/pkg/config"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// maxRotationDomains caps the domains whose user agent is remembered;
+// past it the assignments start over
+const maxRotationDomains = 10000
+
+// uaRotator picks the user agent for fetches that didn't ask for one, so a
+// batch of fetches doesn't present a single fingerprint
+type uaRotator struct {
+	mode string // "request", "domain" or "" when off
+	pool []string
+
+	mu      sync.Mutex
+	next    int
+	domains map[string]string
+}
+
+func newUARotator(cfg *config.Config) *uaRotator {
+	pool := cfg.UAPool
+	if len(pool) == 0 {
+		pool = types.UserAgentPool
+	}
+	return &uaRotator{mode: cfg.UARotation, pool: pool, domains: make(map[string]string)}
+}
+
+// pick returns the user agent for a fetch from host, or "" to keep the
+// request's own. Only the default user agent is replaced: one the caller
+// chose is kept, as is a session's, since a login is tied to the browser
+// that made it.
+func (r *uaRotator) pick(req *types.FetchRequest, host string) string {
+	if r.mode == "" || req.UserAgent != types.DefaultUserAgent || req.Session != "" {
+		return ""
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mode == "domain" {
+		if userAgent, ok := r.domains[host]; ok {
+			return userAgent
+		}
+		if len(r.domains) >= maxRotationDomains {
+			r.domains = make(map[string]string)
+		}
+	}
+	userAgent := r.pool[r.next%len(r.pool)]
+	r.next++
+	if r.mode == "domain" {
+		r.domains[host] = userAgent
+	}
+	return userAgent
+}
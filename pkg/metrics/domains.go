@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// domainCounters are the raw per-domain counters, persisted as-is
+type domainCounters struct {
+	Fetches     int64 `json:"fetches"`
+	Errors      int64 `json:"errors"`
+	TotalTimeMs int64 `json:"total_time_ms"`
+	Bytes       int64 `json:"bytes"`
+	CacheHits   int64 `json:"cache_hits"`
+	CacheMisses int64 `json:"cache_misses"`
+}
+
+// DomainStats summarizes activity for a single domain
+type DomainStats struct {
+	Domain       string  `json:"domain"`
+	Fetches      int64   `json:"fetches"`
+	Errors       int64   `json:"errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	Bytes        int64   `json:"bytes"`
+	CacheHits    int64   `json:"cache_hits"`
+	CacheMisses  int64   `json:"cache_misses"`
+	CacheHitRate float64 `json:"cache_hit_rate"`
+}
+
+// RecordDomainFetch records a network fetch against a domain
+func (c *Collector) RecordDomainFetch(domain string, duration time.Duration, bytes int, failed bool) {
+	if domain == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d := c.domain(domain)
+	d.Fetches++
+	d.TotalTimeMs += duration.Milliseconds()
+	d.Bytes += int64(bytes)
+	if failed {
+		d.Errors++
+	}
+}
+
+// RecordCacheLookup records whether a request for a domain was served from cache
+func (c *Collector) RecordCacheLookup(domain string, hit bool) {
+	if domain == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d := c.domain(domain)
+	if hit {
+		d.CacheHits++
+	} else {
+		d.CacheMisses++
+	}
+}
+
+// DomainStats returns per-domain statistics sorted by fetch count, descending
+func (c *Collector) DomainStats() []DomainStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := make([]DomainStats, 0, len(c.domains))
+	for domain, d := range c.domains {
+		s := DomainStats{
+			Domain:       domain,
+			Fetches:      d.Fetches,
+			Errors:       d.Errors,
+			AvgLatencyMs: averageMs(time.Duration(d.TotalTimeMs)*time.Millisecond, d.Fetches),
+			Bytes:        d.Bytes,
+			CacheHits:    d.CacheHits,
+			CacheMisses:  d.CacheMisses,
+		}
+		if d.Fetches > 0 {
+			s.ErrorRate = float64(d.Errors) / float64(d.Fetches)
+		}
+		if lookups := d.CacheHits + d.CacheMisses; lookups > 0 {
+			s.CacheHitRate = float64(d.CacheHits) / float64(lookups)
+		}
+		stats = append(stats, s)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Fetches != stats[j].Fetches {
+			return stats[i].Fetches > stats[j].Fetches
+		}
+		return stats[i].Domain < stats[j].Domain
+	})
+
+	return stats
+}
+
+// LoadDomainStats merges previously saved domain counters from path. A
+// missing file is not an error.
+func (c *Collector) LoadDomainStats(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read domain stats: %w", err)
+	}
+
+	var saved map[string]*domainCounters
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("failed to parse domain stats: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for domain, counters := range saved {
+		d := c.domain(domain)
+		d.Fetches += counters.Fetches
+		d.Errors += counters.Errors
+		d.TotalTimeMs += counters.TotalTimeMs
+		d.Bytes += counters.Bytes
+		d.CacheHits += counters.CacheHits
+		d.CacheMisses += counters.CacheMisses
+	}
+
+	return nil
+}
+
+// SaveDomainStats writes the domain counters to path atomically
+func (c *Collector) SaveDomainStats(path string) error {
+	c.mu.Lock()
+	data, err := json.Marshal(c.domains)
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode domain stats: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write domain stats: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// domain returns the counters for a domain, creating them if needed.
+// The caller must hold c.mu.
+func (c *Collector) domain(domain string) *domainCounters {
+	d, ok := c.domains[domain]
+	if !ok {
+		d = &domainCounters{}
+		c.domains[domain] = d
+	}
+	return d
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// Collector accumulates lightweight runtime counters for the server
+type Collector struct {
+	startTime time.Time
+	mu        sync.Mutex
+	fetches   map[string]int64
+	errors    map[string]int64
+	totalTime map[string]time.Duration
+	domains   map[string]*domainCounters
+}
+
+// EngineStats summarizes fetch activity for a single engine
+type EngineStats struct {
+	Fetches        int64   `json:"fetches"`
+	Errors         int64   `json:"errors"`
+	AvgFetchTimeMs float64 `json:"avg_fetch_time_ms"`
+}
+
+// Snapshot is a point-in-time copy of the collected metrics
+type Snapshot struct {
+	Uptime         string                 `json:"uptime"`
+	UptimeSeconds  int64                  `json:"uptime_seconds"`
+	TotalFetches   int64                  `json:"total_fetches"`
+	TotalErrors    int64                  `json:"total_errors"`
+	AvgFetchTimeMs float64                `json:"avg_fetch_time_ms"`
+	Engines        map[string]EngineStats `json:"engines"`
+}
+
+// NewCollector creates a new metrics collector
+func NewCollector() *Collector {
+	return &Collector{
+		startTime: time.Now(),
+		fetches:   make(map[string]int64),
+		errors:    make(map[string]int64),
+		totalTime: make(map[string]time.Duration),
+		domains:   make(map[string]*domainCounters),
+	}
+}
+
+// RecordFetch records the outcome of a single fetch on the given engine
+func (c *Collector) RecordFetch(engine string, duration time.Duration, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.fetches[engine]++
+	c.totalTime[engine] += duration
+	if failed {
+		c.errors[engine]++
+	}
+}
+
+// Snapshot returns the current metrics
+func (c *Collector) Snapshot() Snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	uptime := time.Since(c.startTime)
+	snap := Snapshot{
+		Uptime:        uptime.Round(time.Second).String(),
+		UptimeSeconds: int64(uptime.Seconds()),
+		Engines:       make(map[string]EngineStats),
+	}
+
+	var totalTime time.Duration
+	for engine, count := range c.fetches {
+		snap.TotalFetches += count
+		snap.TotalErrors += c.errors[engine]
+		totalTime += c.totalTime[engine]
+
+		snap.Engines[engine] = EngineStats{
+			Fetches:        count,
+			Errors:         c.errors[engine],
+			AvgFetchTimeMs: averageMs(c.totalTime[engine], count),
+		}
+	}
+	snap.AvgFetchTimeMs = averageMs(totalTime, snap.TotalFetches)
+
+	return snap
+}
+
+// averageMs returns total/count in milliseconds, or zero when count is zero
+func averageMs(total time.Duration, count int64) float64 {
+	if count == 0 {
+		return 0
+	}
+	return float64(total.Milliseconds()) / float64(count)
+}
@@ -0,0 +1,129 @@
+package processor
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// languageSampleSize caps how much text is examined for detection
+const languageSampleSize = 5000
+
+// stopwords are very frequent function words for Latin-script languages.
+// Counting hits is crude but reliable on anything longer than a sentence.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "in", "that", "it", "for", "with", "was", "on", "are", "this"},
+	"es": {"el", "la", "de", "que", "y", "en", "los", "se", "del", "las", "por", "un", "para", "con"},
+	"fr": {"le", "la", "les", "de", "et", "des", "est", "un", "une", "du", "que", "pour", "dans", "pas"},
+	"de": {"der", "die", "und", "das", "ist", "nicht", "mit", "den", "von", "zu", "ein", "sich", "auf", "für"},
+	"it": {"il", "di", "che", "e", "la", "per", "un", "non", "del", "della", "sono", "con", "una", "gli"},
+	"pt": {"o", "de", "que", "e", "do", "da", "em", "um", "para", "não", "uma", "os", "com", "no"},
+	"nl": {"de", "het", "een", "en", "van", "is", "dat", "niet", "op", "te", "zijn", "voor", "met", "ook"},
+}
+
+// scriptLanguages maps Unicode scripts that mostly identify a single language
+var scriptLanguages = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Han, "zh"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Greek, "el"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Thai, "th"},
+}
+
+// detectLanguage returns a lowercase ISO 639-1 code for the document,
+// preferring the declared <html lang> and falling back to statistics over the
+// visible text. It returns "" when nothing can be determined.
+func (p *Processor) detectLanguage(htmlContent string) string {
+	if doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent)); err == nil {
+		if lang, ok := doc.Find("html").First().Attr("lang"); ok {
+			if code := primaryLanguageTag(lang); code != "" {
+				return code
+			}
+		}
+	}
+
+	return DetectTextLanguage(p.simpleTextExtraction(htmlContent))
+}
+
+// primaryLanguageTag reduces a BCP 47 tag like "en-US" to "en"
+func primaryLanguageTag(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	if len(tag) < 2 || len(tag) > 3 {
+		return ""
+	}
+	return tag
+}
+
+// DetectTextLanguage guesses the language of plain text by script, then by
+// stopword frequency for Latin-script text
+func DetectTextLanguage(text string) string {
+	if len(text) > languageSampleSize {
+		text = text[:languageSampleSize]
+	}
+
+	// Script detection: Japanese kana outranks Han since Japanese mixes both
+	scriptCounts := make(map[string]int)
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, s := range scriptLanguages {
+			if unicode.Is(s.table, r) {
+				scriptCounts[s.lang]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	if scriptCounts["ja"] > 0 && scriptCounts["ja"]*10 >= letters {
+		return "ja"
+	}
+	best, bestCount := "", 0
+	for lang, count := range scriptCounts {
+		if count > bestCount {
+			best, bestCount = lang, count
+		}
+	}
+	if bestCount*2 > letters {
+		return best
+	}
+
+	// Stopword detection for Latin scripts
+	counts := make(map[string]int)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		counts[word]++
+	}
+
+	best, bestScore := "", 0
+	for lang, words := range stopwords {
+		score := 0
+		for _, w := range words {
+			score += counts[w]
+		}
+		if score > bestScore {
+			best, bestScore = lang, score
+		}
+	}
+	if bestScore < 3 {
+		return ""
+	}
+	return best
+}
@@ -0,0 +1,100 @@
+package processor
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// maxLeadLength bounds the lead sentence kept under each heading of an outline
+const maxLeadLength = 200
+
+// Outline condenses markdown to its headings, each followed by the first
+// sentence of the section beneath it, in at most limit characters (0 for no
+// limit). A document without headings is reduced to the first sentence of
+// each paragraph instead.
+func Outline(markdown string, limit int) string {
+	blocks := markdownBlocks(markdown)
+
+	hasHeadings := false
+	for _, block := range blocks {
+		if isHeading(block) {
+			hasHeadings = true
+			break
+		}
+	}
+
+	var lines []string
+	length := 0
+	add := func(line string) bool {
+		n := utf8.RuneCountInString(line) + 2
+		if limit > 0 && length+n > limit {
+			return false
+		}
+		lines = append(lines, line)
+		length += n
+		return true
+	}
+
+	// The document's opening paragraph is kept even before the first heading
+	needLead := true
+	for _, block := range blocks {
+		if isHeading(block) {
+			if !add(block) {
+				break
+			}
+			needLead = true
+			continue
+		}
+		if strings.HasPrefix(block, "```") || (hasHeadings && !needLead) {
+			continue
+		}
+		if lead := leadSentence(block); lead != "" {
+			if !add(lead) {
+				break
+			}
+			needLead = false
+		}
+	}
+
+	return strings.Join(lines, "\n\n")
+}
+
+// markdownBlocks splits markdown into its blank-line separated blocks
+func markdownBlocks(markdown string) []string {
+	var blocks []string
+	for _, block := range strings.Split(markdown, "\n\n") {
+		if block = strings.TrimSpace(block); block != "" {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// isHeading reports whether a block is an ATX heading such as "## Usage"
+func isHeading(block string) bool {
+	level := headingLevel(block)
+	return level >= 1 && level <= 6 && !strings.Contains(block, "\n") &&
+		strings.HasPrefix(block[level:], " ")
+}
+
+// leadSentence returns the first sentence of a block on a single line,
+// shortened at a word boundary if it runs past maxLeadLength
+func leadSentence(block string) string {
+	text := strings.Join(strings.Fields(block), " ")
+	for i, r := range text {
+		if (r == '.' || r == '!' || r == '?') && strings.HasPrefix(text[i+1:], " ") {
+			text = text[:i+1]
+			break
+		}
+	}
+
+	if utf8.RuneCountInString(text) <= maxLeadLength {
+		return text
+	}
+	runes := []rune(text)[:maxLeadLength]
+	cut := string(runes)
+	if space := strings.LastIndex(cut, " "); space > 0 {
+		cut = cut[:space]
+	}
+	return cut + "…"
+}
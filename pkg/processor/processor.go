@@ -1,6 +1,9 @@
 package processor
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/url"
 	"strings"
@@ -14,7 +17,8 @@ import (
 
 // Processor handles content processing for different formats
 type Processor struct {
-	policy *bluemonday.Policy
+	policy   *bluemonday.Policy
+	profiles []Profile
 }
 
 // NewProcessor creates a new content processor
@@ -27,13 +31,46 @@ func NewProcessor() *Processor {
 	}
 }
 
-// Process converts content to the requested format
-func (p *Processor) Process(response *types.FetchResponse) error {
+// Process converts content to the requested format. It returns ctx's error
+// without converting if the caller has already given up.
+func (p *Processor) Process(ctx context.Context, response *types.FetchResponse) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// Extract title first if not already set
 	if response.Title == "" {
 		response.Title = p.extractTitle(response.Content)
 	}
 
+	// Detect language from the raw document before it is transformed
+	if response.Language == "" {
+		response.Language = p.detectLanguage(response.Content)
+	}
+
+	// Apply the site's extraction profile, if one is configured
+	var extracted *profileResult
+	if profile := p.profileFor(response.URL); profile != nil {
+		if result, err := profile.apply(response.Content); err == nil {
+			extracted = result
+			response.Content = result.html
+			if result.title != "" {
+				response.Title = result.title
+			}
+		}
+	}
+
+	// Readability and markdown conversion are the expensive part
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// A profile body selector already isolated the main content, so readability
+	// is skipped in favor of converting exactly what was selected
+	if extracted != nil && extracted.body {
+		return p.processSelected(response, extracted)
+	}
+
 	switch response.Format {
 	case types.FormatText:
 		text, err := p.extractText(response.Content, response.URL)
@@ -55,13 +92,179 @@ func (p *Processor) Process(response *types.FetchResponse) error {
 		}
 		response.Content = markdown
 
+	case types.FormatArticle:
+		article, err := p.extractArticle(response.Content, response.URL)
+		if err != nil {
+			return fmt.Errorf("failed to extract article: %w", err)
+		}
+		if extracted != nil {
+			article.Author = firstNonEmpty(extracted.author, article.Author)
+			article.PublishedDate = firstNonEmpty(extracted.date, article.PublishedDate)
+		}
+		response.Article = article
+		response.Content = article.Text
+
+	default:
+		return fmt.Errorf("unsupported format: %s", response.Format)
+	}
+
+	response.ContentHash = Fingerprint(response.Content)
+
+	return nil
+}
+
+// processSelected converts content isolated by a profile body selector
+func (p *Processor) processSelected(response *types.FetchResponse, extracted *profileResult) error {
+	switch response.Format {
+	case types.FormatText:
+		response.Content = p.simpleTextExtraction(response.Content)
+
+	case types.FormatHTML:
+		response.Content = p.cleanHTML(response.Content)
+
+	case types.FormatMarkdown:
+		response.Content = p.htmlToMarkdown(response.Content)
+
+	case types.FormatArticle:
+		text := p.simpleTextExtraction(response.Content)
+		response.Article = &types.Article{
+			Title:         response.Title,
+			Author:        extracted.author,
+			PublishedDate: extracted.date,
+			Text:          text,
+			Language:      response.Language,
+			WordCount:     len(strings.Fields(text)),
+		}
+		response.Content = text
+
 	default:
 		return fmt.Errorf("unsupported format: %s", response.Format)
 	}
 
+	response.ContentHash = Fingerprint(response.Content)
+
 	return nil
 }
 
+// firstNonEmpty returns the first non-empty string
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Fingerprint returns the hex SHA-256 of text with whitespace normalized, so
+// reflowed but otherwise identical content hashes the same
+func Fingerprint(text string) string {
+	normalized := strings.Join(strings.Fields(text), " ")
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// nextPageTexts are anchor texts commonly used for "next page" links
+var nextPageTexts = map[string]bool{
+	"next":        true,
+	"next page":   true,
+	"next »":      true,
+	"next ›":      true,
+	"next >":      true,
+	"next →":      true,
+	"»":           true,
+	"›":           true,
+	"older posts": true,
+}
+
+// FindNextPage returns the absolute URL of the next page of a paginated
+// document, or "" if none is found. rel=next hints are preferred over anchor
+// text heuristics, and only same-host links are considered.
+func (p *Processor) FindNextPage(htmlContent, pageURL string) string {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
+	if err != nil {
+		return ""
+	}
+
+	var candidates []string
+	doc.Find(`link[rel~="next"], a[rel~="next"]`).Each(func(i int, s *goquery.Selection) {
+		if href, ok := s.Attr("href"); ok {
+			candidates = append(candidates, href)
+		}
+	})
+	doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+		text := strings.ToLower(strings.Join(strings.Fields(s.Text()), " "))
+		label := strings.ToLower(s.AttrOr("aria-label", ""))
+		if nextPageTexts[text] || label == "next page" || label == "next" {
+			candidates = append(candidates, s.AttrOr("href", ""))
+		}
+	})
+
+	for _, href := range candidates {
+		ref, err := url.Parse(strings.TrimSpace(href))
+		if err != nil || href == "" || strings.HasPrefix(href, "#") {
+			continue
+		}
+		next := base.ResolveReference(ref)
+		next.Fragment = ""
+		if next.Host != base.Host || next.String() == pageURL {
+			continue
+		}
+		if next.Scheme != "http" && next.Scheme != "https" {
+			continue
+		}
+		return next.String()
+	}
+
+	return ""
+}
+
+// ExtractLinks returns the distinct absolute http(s) links in a document, in
+// document order and without fragments, with their anchor text
+func (p *Processor) ExtractLinks(htmlContent, pageURL string) []types.Link {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
+	if err != nil {
+		return nil
+	}
+
+	// A <base href> changes what relative links resolve against
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if ref, err := url.Parse(strings.TrimSpace(href)); err == nil {
+			base = base.ResolveReference(ref)
+		}
+	}
+
+	var links []types.Link
+	seen := make(map[string]bool)
+	doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+		ref, err := url.Parse(strings.TrimSpace(s.AttrOr("href", "")))
+		if err != nil {
+			return
+		}
+		link := base.ResolveReference(ref)
+		link.Fragment = ""
+		if (link.Scheme != "http" && link.Scheme != "https") || seen[link.String()] {
+			return
+		}
+		seen[link.String()] = true
+		links = append(links, types.Link{
+			URL:  link.String(),
+			Text: strings.Join(strings.Fields(s.Text()), " "),
+		})
+	})
+	return links
+}
+
 // extractTitle extracts the title from HTML content
 func (p *Processor) extractTitle(htmlContent string) string {
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
@@ -106,6 +309,73 @@ func (p *Processor) extractText(htmlContent, urlStr string) (string, error) {
 	return strings.Join(cleanedLines, "\n\n"), nil
 }
 
+// extractArticle runs readability and keeps its metadata alongside the main text
+func (p *Processor) extractArticle(htmlContent, urlStr string) (*types.Article, error) {
+	parsedURL, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	article, err := readability.FromReader(strings.NewReader(htmlContent), parsedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := p.extractText(htmlContent, urlStr)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &types.Article{
+		Title:     article.Title,
+		Byline:    article.Byline,
+		SiteName:  article.SiteName,
+		Excerpt:   article.Excerpt,
+		Text:      text,
+		TopImage:  article.Image,
+		Language:  article.Language,
+		WordCount: len(strings.Fields(article.TextContent)),
+	}
+
+	// readability doesn't expose author or publish date, so read them from
+	// the common meta conventions
+	if doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent)); err == nil {
+		result.Author = firstMetaContent(doc,
+			`meta[name="author"]`,
+			`meta[property="article:author"]`,
+			`[itemprop="author"] [itemprop="name"]`,
+			`[rel="author"]`)
+		result.PublishedDate = firstMetaContent(doc,
+			`meta[property="article:published_time"]`,
+			`meta[itemprop="datePublished"]`,
+			`meta[name="date"]`,
+			`meta[name="pubdate"]`,
+			`time[datetime]`)
+	}
+
+	return result, nil
+}
+
+// firstMetaContent returns the first non-empty value found by the selectors,
+// reading content/datetime attributes before falling back to element text
+func firstMetaContent(doc *goquery.Document, selectors ...string) string {
+	for _, selector := range selectors {
+		sel := doc.Find(selector).First()
+		if sel.Length() == 0 {
+			continue
+		}
+		for _, attr := range []string{"content", "datetime"} {
+			if val, ok := sel.Attr(attr); ok && strings.TrimSpace(val) != "" {
+				return strings.TrimSpace(val)
+			}
+		}
+		if text := strings.TrimSpace(sel.Text()); text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
 // simpleTextExtraction performs basic text extraction from HTML
 func (p *Processor) simpleTextExtraction(htmlContent string) string {
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
@@ -0,0 +1,140 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Profile holds site-specific extraction rules, applied to every page whose
+// host matches one of Domains
+type Profile struct {
+	// Domains are host patterns: "example.com" matches the domain and its
+	// subdomains, and glob patterns like "docs.*.org" are also accepted
+	Domains []string `json:"domains"`
+
+	// Title, Body, Date and Author are CSS selectors for the page parts
+	Title  string `json:"title,omitempty"`
+	Body   string `json:"body,omitempty"`
+	Date   string `json:"date,omitempty"`
+	Author string `json:"author,omitempty"`
+
+	// Remove lists selectors for elements dropped before extraction
+	Remove []string `json:"remove,omitempty"`
+}
+
+// profileResult is what a profile extracted from a page
+type profileResult struct {
+	html   string
+	body   bool
+	title  string
+	date   string
+	author string
+}
+
+// LoadProfiles reads extraction profiles from a JSON file containing an
+// array of Profile objects
+func LoadProfiles(filePath string) ([]Profile, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profiles: %w", err)
+	}
+
+	var profiles []Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse profiles: %w", err)
+	}
+
+	for i, profile := range profiles {
+		if len(profile.Domains) == 0 {
+			return nil, fmt.Errorf("profile %d has no domains", i)
+		}
+	}
+
+	return profiles, nil
+}
+
+// SetProfiles installs site extraction profiles; the first matching profile wins
+func (p *Processor) SetProfiles(profiles []Profile) {
+	p.profiles = profiles
+}
+
+// profileFor returns the profile matching the URL's host, or nil
+func (p *Processor) profileFor(urlStr string) *Profile {
+	if len(p.profiles) == 0 {
+		return nil
+	}
+
+	parsed, err := url.Parse(urlStr)
+	if err != nil {
+		return nil
+	}
+	host := strings.ToLower(parsed.Hostname())
+
+	for i := range p.profiles {
+		for _, pattern := range p.profiles[i].Domains {
+			if matchDomain(strings.ToLower(pattern), host) {
+				return &p.profiles[i]
+			}
+		}
+	}
+	return nil
+}
+
+// matchDomain reports whether host matches a profile domain pattern
+func matchDomain(pattern, host string) bool {
+	if host == pattern || strings.HasSuffix(host, "."+pattern) {
+		return true
+	}
+	matched, _ := path.Match(pattern, host)
+	return matched
+}
+
+// apply runs the profile's rules over the document
+func (profile *Profile) apply(htmlContent string) (*profileResult, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, selector := range profile.Remove {
+		doc.Find(selector).Remove()
+	}
+
+	result := &profileResult{
+		title:  selectText(doc, profile.Title),
+		date:   selectText(doc, profile.Date),
+		author: selectText(doc, profile.Author),
+	}
+
+	if profile.Body != "" {
+		if body := doc.Find(profile.Body); body.Length() > 0 {
+			var b strings.Builder
+			body.Each(func(i int, s *goquery.Selection) {
+				if h, err := goquery.OuterHtml(s); err == nil {
+					b.WriteString(h)
+				}
+			})
+			result.html = "<html><body>" + b.String() + "</body></html>"
+			result.body = true
+			return result, nil
+		}
+	}
+
+	result.html, err = doc.Html()
+	return result, err
+}
+
+// selectText returns the trimmed text (or content/datetime attribute) of the
+// first element matching selector, or "" if selector is empty or unmatched
+func selectText(doc *goquery.Document, selector string) string {
+	if selector == "" {
+		return ""
+	}
+	return firstMetaContent(doc, selector)
+}
@@ -0,0 +1,232 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// SelectorSuggestion is a candidate CSS selector with a confidence score and
+// a sample of the text it selects
+type SelectorSuggestion struct {
+	Selector string  `json:"selector"`
+	Score    float64 `json:"score"`
+	Matches  int     `json:"matches"`
+	Sample   string  `json:"sample"`
+}
+
+// SelectorSuggestions groups candidates for each part of an extraction
+// profile, best first
+type SelectorSuggestions struct {
+	Body   []SelectorSuggestion `json:"body"`
+	Title  []SelectorSuggestion `json:"title"`
+	Date   []SelectorSuggestion `json:"date"`
+	Author []SelectorSuggestion `json:"author"`
+	Remove []string             `json:"remove"`
+}
+
+// maxSuggestions caps the candidates returned per field
+const maxSuggestions = 3
+
+var (
+	boilerplatePattern = regexp.MustCompile(`(?i)nav|menu|footer|header|sidebar|comment|share|social|related|promo|banner|cookie|subscribe|advert|\bads?\b`)
+	contentPattern     = regexp.MustCompile(`(?i)article|content|post|entry|story|body|main|text`)
+	datePattern        = regexp.MustCompile(`(?i)date|time|published|posted`)
+	authorPattern      = regexp.MustCompile(`(?i)author|byline|writer`)
+)
+
+// SuggestSelectors analyzes a page and proposes selectors for the body,
+// title, date and author, plus boilerplate blocks worth removing. Body
+// candidates are ranked by text density and semantic tags.
+func (p *Processor) SuggestSelectors(htmlContent string) (*SelectorSuggestions, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HTML: %w", err)
+	}
+	doc.Find("script, style, noscript, template").Remove()
+
+	suggestions := &SelectorSuggestions{}
+
+	// Body: dense text with few links, boosted for semantic containers
+	var body []SelectorSuggestion
+	doc.Find("article, main, section, div, [itemprop='articleBody'], [role='main']").Each(func(i int, s *goquery.Selection) {
+		text := collapseSpace(s.Text())
+		if len(text) < 200 {
+			return
+		}
+		linkText := len(collapseSpace(s.Find("a").Text()))
+		linkDensity := float64(linkText) / float64(len(text))
+		paragraphs := s.Find("p").Length()
+
+		score := float64(len(text)) * (1 - linkDensity) * (1 + 0.1*float64(paragraphs))
+		node := s.Get(0)
+		hint := attr(node, "id") + " " + attr(node, "class")
+		switch {
+		case node.Data == "article" || node.Data == "main" || attr(node, "itemprop") == "articleBody" || attr(node, "role") == "main":
+			score *= 2
+		case contentPattern.MatchString(hint):
+			score *= 1.5
+		}
+		if boilerplatePattern.MatchString(hint) {
+			score *= 0.2
+		}
+
+		body = append(body, newSuggestion(doc, s, score))
+	})
+	suggestions.Body = topSuggestions(body)
+
+	// Title: headings first, then Open Graph
+	var title []SelectorSuggestion
+	doc.Find("h1").Each(func(i int, s *goquery.Selection) {
+		title = append(title, newSuggestion(doc, s, 10-float64(i)))
+	})
+	if og := doc.Find(`meta[property="og:title"]`); og.Length() > 0 {
+		title = append(title, newSuggestion(doc, og.First(), 5))
+	}
+	suggestions.Title = topSuggestions(title)
+
+	// Date and author: semantic markup beats class-name heuristics
+	suggestions.Date = topSuggestions(semanticCandidates(doc,
+		[]string{`time[datetime]`, `[itemprop="datePublished"]`, `meta[property="article:published_time"]`},
+		datePattern))
+	suggestions.Author = topSuggestions(semanticCandidates(doc,
+		[]string{`[rel="author"]`, `[itemprop="author"]`, `meta[name="author"]`},
+		authorPattern))
+
+	// Remove: boilerplate landmarks present on the page
+	seen := make(map[string]bool)
+	doc.Find("nav, aside, footer, header, [class], [id]").Each(func(i int, s *goquery.Selection) {
+		node := s.Get(0)
+		hint := attr(node, "id") + " " + attr(node, "class")
+		if node.Data != "nav" && node.Data != "aside" && node.Data != "footer" && !boilerplatePattern.MatchString(hint) {
+			return
+		}
+		selector := cssSelector(node)
+		if !seen[selector] && len(suggestions.Remove) < 10 {
+			seen[selector] = true
+			suggestions.Remove = append(suggestions.Remove, selector)
+		}
+	})
+
+	return suggestions, nil
+}
+
+// semanticCandidates scores elements matched by semantic selectors highly and
+// elements whose class or id matches pattern lower
+func semanticCandidates(doc *goquery.Document, semantic []string, pattern *regexp.Regexp) []SelectorSuggestion {
+	var candidates []SelectorSuggestion
+	for i, selector := range semantic {
+		if s := doc.Find(selector); s.Length() > 0 {
+			candidates = append(candidates, SelectorSuggestion{
+				Selector: selector,
+				Score:    10 - float64(i),
+				Matches:  s.Length(),
+				Sample:   sampleText(s.First()),
+			})
+		}
+	}
+	doc.Find("[class], [id]").Each(func(i int, s *goquery.Selection) {
+		node := s.Get(0)
+		text := collapseSpace(s.Text())
+		if !pattern.MatchString(attr(node, "id")+" "+attr(node, "class")) || text == "" || len(text) > 100 {
+			return
+		}
+		candidates = append(candidates, newSuggestion(doc, s, 5))
+	})
+	return candidates
+}
+
+// newSuggestion builds a suggestion for the selection's generated selector
+func newSuggestion(doc *goquery.Document, s *goquery.Selection, score float64) SelectorSuggestion {
+	selector := cssSelector(s.Get(0))
+	return SelectorSuggestion{
+		Selector: selector,
+		Score:    score,
+		Matches:  doc.Find(selector).Length(),
+		Sample:   sampleText(s),
+	}
+}
+
+// topSuggestions deduplicates by selector, preferring selectors that match a
+// single element, and returns the best few
+func topSuggestions(candidates []SelectorSuggestion) []SelectorSuggestion {
+	best := make(map[string]SelectorSuggestion)
+	for _, c := range candidates {
+		if c.Matches > 1 {
+			c.Score /= float64(c.Matches)
+		}
+		if existing, ok := best[c.Selector]; !ok || c.Score > existing.Score {
+			best[c.Selector] = c
+		}
+	}
+
+	result := make([]SelectorSuggestion, 0, len(best))
+	for _, c := range best {
+		result = append(result, c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].Selector < result[j].Selector
+	})
+	if len(result) > maxSuggestions {
+		result = result[:maxSuggestions]
+	}
+	return result
+}
+
+// cssSelector builds a short selector for node: tag#id, else tag.class
+func cssSelector(node *html.Node) string {
+	if id := attr(node, "id"); id != "" && !strings.ContainsAny(id, " .:#[]") {
+		return node.Data + "#" + id
+	}
+	selector := node.Data
+	for _, class := range strings.Fields(attr(node, "class")) {
+		if strings.ContainsAny(class, ".:#[]/") {
+			continue
+		}
+		selector += "." + class
+		if strings.Count(selector, ".") == 2 {
+			break
+		}
+	}
+	if node.Data == "meta" {
+		if property := attr(node, "property"); property != "" {
+			selector = fmt.Sprintf(`meta[property=%q]`, property)
+		}
+	}
+	return selector
+}
+
+// attr returns an attribute of node, or ""
+func attr(node *html.Node, key string) string {
+	for _, a := range node.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// sampleText returns the first 120 characters of the selection's text or
+// content attribute
+func sampleText(s *goquery.Selection) string {
+	text := collapseSpace(s.Text())
+	if text == "" {
+		text = s.AttrOr("content", s.AttrOr("datetime", ""))
+	}
+	if runes := []rune(text); len(runes) > 120 {
+		text = string(runes[:120]) + "…"
+	}
+	return text
+}
+
+// collapseSpace trims text and collapses internal whitespace
+func collapseSpace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,127 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxOmittedHeadings bounds the outline of omitted sections after a cut
+const maxOmittedHeadings = 50
+
+// Truncate shortens content to at most limit characters, cutting at the
+// last paragraph break (which is also where headings start) that fits, else
+// at a line break, sentence end or word. The result ends with a
+// "[truncated at X of Y chars]" marker followed by the headings of the
+// sections that were cut off, so the same input always truncates the same
+// way and the reader knows what is missing. Both count towards the limit:
+// the outline gets at most half of what the marker leaves, and lists as
+// many headings as fit. A limit too small for the marker gets a bare cut.
+func Truncate(content string, limit int) string {
+	total := utf8.RuneCountInString(content)
+	if limit <= 0 || total <= limit {
+		return content
+	}
+
+	// The marker can't be longer than with both counts at total
+	room := limit - utf8.RuneCountInString(truncationMarker(total, total))
+	if room <= 0 {
+		return cutAt(content, limit)
+	}
+	outline := omittedOutline(omittedHeadings(content[len(cutAt(content, room)):]), room/2)
+	kept := cutAt(content, room-utf8.RuneCountInString(outline))
+
+	var b strings.Builder
+	b.WriteString(kept)
+	b.WriteString(truncationMarker(utf8.RuneCountInString(kept), total))
+	// Cutting earlier may have omitted more headings, in the room left over
+	headings := omittedHeadings(content[len(kept):])
+	b.WriteString(omittedOutline(headings, limit-utf8.RuneCountInString(b.String())))
+	return b.String()
+}
+
+// cutAt returns the longest prefix of content of at most limit characters
+// that ends on a boundary, less any headings left without their sections
+func cutAt(content string, limit int) string {
+	if limit <= 0 {
+		return ""
+	}
+	head := string([]rune(content)[:min(limit, utf8.RuneCountInString(content))])
+	cut := -1
+	// Boundaries in the first half of the window would throw away too much
+	for _, boundary := range []string{"\n\n", "\n", ". ", "! ", "? ", " "} {
+		if i := strings.LastIndex(head, boundary); i > len(head)/2 {
+			cut = i
+			if boundary != "\n\n" && boundary != "\n" && boundary != " " {
+				cut++ // keep the sentence's punctuation
+			}
+			break
+		}
+	}
+	if cut < 0 {
+		cut = len(head)
+	}
+	kept := strings.TrimRight(head[:cut], " \t\n")
+
+	// A heading whose section was cut off belongs with the omitted sections
+	for {
+		i := strings.LastIndex(kept, "\n\n")
+		if i < 0 || !isHeading(kept[i+2:]) {
+			break
+		}
+		kept = strings.TrimRight(kept[:i], " \t\n")
+	}
+	return kept
+}
+
+// truncationMarker is the note Truncate appends after the kept content
+func truncationMarker(kept, total int) string {
+	return fmt.Sprintf("\n\n[truncated at %d of %d chars]", kept, total)
+}
+
+// omittedOutline renders the "Omitted sections" list in at most limit
+// characters, listing as many headings as fit (and at most
+// maxOmittedHeadings) and counting the rest. It's empty if there are no
+// headings, or not even the count fits.
+func omittedOutline(headings []string, limit int) string {
+	for n := min(len(headings), maxOmittedHeadings); n >= 0 && len(headings) > 0; n-- {
+		var b strings.Builder
+		b.WriteString("\n\nOmitted sections:")
+		for _, heading := range headings[:n] {
+			b.WriteString("\n" + heading)
+		}
+		if n < len(headings) {
+			fmt.Fprintf(&b, "\n- … and %d more", len(headings)-n)
+		}
+		if utf8.RuneCountInString(b.String()) <= limit {
+			return b.String()
+		}
+	}
+	return ""
+}
+
+// omittedHeadings lists the markdown headings in text as a list indented by
+// level, relative to the highest level present
+func omittedHeadings(text string) []string {
+	var lines []string
+	top := 6
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if isHeading(line) {
+			lines = append(lines, line)
+			top = min(top, headingLevel(line))
+		}
+	}
+
+	headings := make([]string, len(lines))
+	for i, line := range lines {
+		level := headingLevel(line)
+		headings[i] = strings.Repeat("  ", level-top) + "- " + strings.TrimSpace(line[level:])
+	}
+	return headings
+}
+
+// headingLevel returns the number of leading #s of a heading
+func headingLevel(heading string) int {
+	return len(heading) - len(strings.TrimLeft(heading, "#"))
+}
@@ -0,0 +1,81 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/gomcpgo/url_fetcher/pkg/types"
+)
+
+// ProcessXPath is like Process but first narrows the document to the nodes
+// selected by an XPath expression. Element results are converted to the
+// requested format like a profile body selector; text and attribute results
+// (e.g. //a/@href or //h2/text()) are returned one value per line.
+func (p *Processor) ProcessXPath(ctx context.Context, response *types.FetchResponse, expr string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	compiled, err := xpath.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("invalid xpath: %w", err)
+	}
+
+	doc, err := htmlquery.Parse(strings.NewReader(response.Content))
+	if err != nil {
+		return fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	if response.Title == "" {
+		response.Title = p.extractTitle(response.Content)
+	}
+	if response.Language == "" {
+		response.Language = p.detectLanguage(response.Content)
+	}
+
+	var elements strings.Builder
+	var values []string
+	switch result := compiled.Evaluate(htmlquery.CreateXPathNavigator(doc)).(type) {
+	case *xpath.NodeIterator:
+		for result.MoveNext() {
+			nav := result.Current().(*htmlquery.NodeNavigator)
+			if nav.NodeType() == xpath.ElementNode {
+				elements.WriteString(htmlquery.OutputHTML(nav.Current(), true))
+				continue
+			}
+			// Text and attribute nodes carry a plain value
+			if value := strings.TrimSpace(nav.Value()); value != "" {
+				values = append(values, value)
+			}
+		}
+	default:
+		// Scalar expressions such as count(//a) or string(//title)
+		values = append(values, fmt.Sprint(result))
+	}
+
+	if elements.Len() == 0 && len(values) == 0 {
+		response.Content = ""
+		response.ContentHash = Fingerprint(response.Content)
+		return fmt.Errorf("xpath matched no nodes: %s", expr)
+	}
+
+	if elements.Len() == 0 {
+		response.Content = strings.Join(values, "\n")
+		response.ContentHash = Fingerprint(response.Content)
+		return nil
+	}
+
+	response.Content = "<html><body>" + elements.String() + "</body></html>"
+	return p.processSelected(response, &profileResult{html: response.Content, body: true})
+}
+
+// ValidateXPath reports whether expr is a valid XPath expression
+func ValidateXPath(expr string) error {
+	if _, err := xpath.Compile(expr); err != nil {
+		return fmt.Errorf("invalid xpath: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package provenance
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// AlgorithmHMACSHA256 is the only signature algorithm records use
+const AlgorithmHMACSHA256 = "hmac-sha256"
+
+// Record describes what was fetched, when and with which settings, so a
+// downstream system can check a result it was handed
+type Record struct {
+	ServerVersion string    `json:"server_version"`
+	URL           string    `json:"url"`
+	FetchedAt     time.Time `json:"fetched_at"`
+	ContentSHA256 string    `json:"content_sha256"`
+	OptionsHash   string    `json:"options_hash"`
+	Algorithm     string    `json:"algorithm,omitempty"`
+	Signature     string    `json:"signature,omitempty"`
+}
+
+// New creates an unsigned record. options is a canonical description of the
+// request settings; only its hash is kept.
+func New(version, url string, fetchedAt time.Time, content, options string) *Record {
+	return &Record{
+		ServerVersion: version,
+		URL:           url,
+		FetchedAt:     fetchedAt.UTC(),
+		ContentSHA256: sha256Hex(content),
+		OptionsHash:   sha256Hex(options),
+	}
+}
+
+// Sign adds an HMAC-SHA256 signature over the record's fields
+func (r *Record) Sign(key []byte) {
+	r.Algorithm = AlgorithmHMACSHA256
+	r.Signature = hex.EncodeToString(r.mac(key))
+}
+
+// Verify reports whether the record carries a valid signature for key
+func (r *Record) Verify(key []byte) bool {
+	if r.Algorithm != AlgorithmHMACSHA256 {
+		return false
+	}
+	signature, err := hex.DecodeString(r.Signature)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(signature, r.mac(key))
+}
+
+// Payload returns the string that is signed: the fields joined by newlines,
+// with the timestamp in RFC 3339 form with nanoseconds
+func (r *Record) Payload() string {
+	return strings.Join([]string{
+		r.ServerVersion,
+		r.URL,
+		r.FetchedAt.UTC().Format(time.RFC3339Nano),
+		r.ContentSHA256,
+		r.OptionsHash,
+	}, "\n")
+}
+
+func (r *Record) mac(key []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(r.Payload()))
+	return h.Sum(nil)
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
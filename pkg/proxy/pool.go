@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Rotation strategies
+const (
+	// RotationRoundRobin cycles through healthy proxies request by request
+	RotationRoundRobin = "round-robin"
+	// RotationSticky keeps each target domain on the same proxy while it is healthy
+	RotationSticky = "sticky"
+)
+
+// Health checking: a proxy that fails this many requests in a row is taken
+// out of rotation for the cooldown period
+const (
+	failureThreshold = 3
+	cooldown         = time.Minute
+)
+
+// ParseURL parses and validates a proxy URL. Supported schemes are http,
+// https, socks5 and socks5h.
+func ParseURL(raw string) (*url.URL, error) {
+	proxyURL, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+	if proxyURL.Hostname() == "" {
+		return nil, fmt.Errorf("proxy URL has no host")
+	}
+	return proxyURL, nil
+}
+
+// Pool rotates requests across a set of proxies, skipping unhealthy ones
+type Pool struct {
+	rotation string
+
+	mu      sync.Mutex
+	members []*member
+	next    int
+}
+
+type member struct {
+	url       *url.URL
+	failures  int
+	downUntil time.Time
+}
+
+// Status describes one proxy in the pool; credentials are redacted
+type Status struct {
+	URL       string    `json:"url"`
+	Healthy   bool      `json:"healthy"`
+	Failures  int       `json:"consecutive_failures"`
+	DownUntil time.Time `json:"down_until,omitempty"`
+}
+
+// NewPool creates a pool from proxy URLs using the given rotation strategy
+func NewPool(rawURLs []string, rotation string) (*Pool, error) {
+	if len(rawURLs) == 0 {
+		return nil, fmt.Errorf("proxy pool is empty")
+	}
+	switch rotation {
+	case "":
+		rotation = RotationRoundRobin
+	case RotationRoundRobin, RotationSticky:
+	default:
+		return nil, fmt.Errorf("unknown proxy rotation %q", rotation)
+	}
+
+	p := &Pool{rotation: rotation}
+	for _, raw := range rawURLs {
+		proxyURL, err := ParseURL(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %s: %w", raw, err)
+		}
+		p.members = append(p.members, &member{url: proxyURL})
+	}
+	return p, nil
+}
+
+// Pick returns the proxy to use for a request to host. If every proxy is
+// cooling down, the one that recovers soonest is used rather than none.
+func (p *Pool) Pick(host string) *url.URL {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	n := len(p.members)
+
+	start := 0
+	if p.rotation == RotationSticky {
+		h := fnv.New32a()
+		h.Write([]byte(host))
+		start = int(h.Sum32() % uint32(n))
+	} else {
+		start = p.next
+		p.next = (p.next + 1) % n
+	}
+
+	soonest := p.members[start]
+	for i := 0; i < n; i++ {
+		m := p.members[(start+i)%n]
+		if !now.Before(m.downUntil) {
+			return m.url
+		}
+		if m.downUntil.Before(soonest.downUntil) {
+			soonest = m
+		}
+	}
+	return soonest.url
+}
+
+// ReportSuccess marks a proxy healthy
+func (p *Pool) ReportSuccess(proxyURL *url.URL) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if m := p.find(proxyURL); m != nil {
+		m.failures = 0
+		m.downUntil = time.Time{}
+	}
+}
+
+// ReportFailure records a failed request through a proxy, taking it out of
+// rotation after repeated failures
+func (p *Pool) ReportFailure(proxyURL *url.URL) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if m := p.find(proxyURL); m != nil {
+		m.failures++
+		if m.failures >= failureThreshold {
+			m.downUntil = time.Now().Add(cooldown)
+			m.failures = 0
+		}
+	}
+}
+
+// URLs returns every proxy in the pool, in configured order
+func (p *Pool) URLs() []*url.URL {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	urls := make([]*url.URL, len(p.members))
+	for i, m := range p.members {
+		urls[i] = m.url
+	}
+	return urls
+}
+
+// Stats returns the health of each proxy
+func (p *Pool) Stats() []Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	stats := make([]Status, len(p.members))
+	for i, m := range p.members {
+		stats[i] = Status{
+			URL:      m.url.Redacted(),
+			Healthy:  !now.Before(m.downUntil),
+			Failures: m.failures,
+		}
+		if !stats[i].Healthy {
+			stats[i].DownUntil = m.downUntil
+		}
+	}
+	return stats
+}
+
+// find returns the member for proxyURL. The caller must hold p.mu.
+func (p *Pool) find(proxyURL *url.URL) *member {
+	for _, m := range p.members {
+		if m.url == proxyURL || m.url.String() == proxyURL.String() {
+			return m
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportedCookie covers the fields of the common JSON cookie exports:
+// browser extensions (expirationDate, hostOnly) and Playwright/Puppeteer
+// storage state (expires)
+type exportedCookie struct {
+	Name           string  `json:"name"`
+	Value          string  `json:"value"`
+	Domain         string  `json:"domain"`
+	Path           string  `json:"path"`
+	Secure         bool    `json:"secure"`
+	HTTPOnly       bool    `json:"httpOnly"`
+	HostOnly       *bool   `json:"hostOnly"`
+	ExpirationDate float64 `json:"expirationDate"`
+	Expires        float64 `json:"expires"`
+}
+
+// ParseCookieExport parses cookies exported from a browser, either as a
+// Netscape cookies.txt file or as JSON (an array of cookies, or an object
+// with a "cookies" array). Domains keep a leading dot when the cookie
+// applies to subdomains.
+func ParseCookieExport(data []byte) ([]*http.Cookie, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		return parseJSONCookies(trimmed)
+	}
+	return parseNetscapeCookies(trimmed)
+}
+
+func parseJSONCookies(data []byte) ([]*http.Cookie, error) {
+	var exported []exportedCookie
+	if data[0] == '{' {
+		var state struct {
+			Cookies []exportedCookie `json:"cookies"`
+		}
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("invalid JSON cookie export: %w", err)
+		}
+		exported = state.Cookies
+	} else if err := json.Unmarshal(data, &exported); err != nil {
+		return nil, fmt.Errorf("invalid JSON cookie export: %w", err)
+	}
+
+	cookies := make([]*http.Cookie, 0, len(exported))
+	for i, e := range exported {
+		if e.Name == "" || e.Domain == "" {
+			return nil, fmt.Errorf("cookie %d is missing name or domain", i)
+		}
+		domain := e.Domain
+		if e.HostOnly != nil && !*e.HostOnly && !strings.HasPrefix(domain, ".") {
+			domain = "." + domain
+		}
+		cookie := &http.Cookie{
+			Name:     e.Name,
+			Value:    e.Value,
+			Domain:   domain,
+			Path:     e.Path,
+			Secure:   e.Secure,
+			HttpOnly: e.HTTPOnly,
+		}
+		// Session cookies are exported with no expiry or -1
+		if expiry := e.ExpirationDate + e.Expires; expiry > 0 {
+			cookie.Expires = time.Unix(int64(expiry), 0)
+		}
+		cookies = append(cookies, cookie)
+	}
+	return cookies, nil
+}
+
+func parseNetscapeCookies(data []byte) ([]*http.Cookie, error) {
+	var cookies []*http.Cookie
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		// curl and browsers mark HttpOnly cookies with this comment prefix
+		httpOnly := false
+		if strings.HasPrefix(line, "#HttpOnly_") {
+			httpOnly = true
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// domain, include subdomains, path, secure, expiry, name, value
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: expected 7 tab-separated fields, got %d", lineNumber, len(fields))
+		}
+
+		domain := fields[0]
+		if strings.EqualFold(fields[1], "TRUE") && !strings.HasPrefix(domain, ".") {
+			domain = "." + domain
+		}
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Domain:   domain,
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		if expiry, err := strconv.ParseInt(fields[4], 10, 64); err == nil && expiry > 0 {
+			cookie.Expires = time.Unix(expiry, 0)
+		}
+		cookies = append(cookies, cookie)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(cookies) == 0 {
+		return nil, fmt.Errorf("no cookies found")
+	}
+	return cookies, nil
+}
+
+// Import adds exported cookies to the session and returns how many were
+// stored; expired cookies are skipped
+func (s *Session) Import(cookies []*http.Cookie) int {
+	imported := 0
+	now := time.Now()
+	for _, c := range cookies {
+		if !c.Expires.IsZero() && c.Expires.Before(now) {
+			continue
+		}
+
+		host := strings.TrimPrefix(c.Domain, ".")
+		scheme := "http"
+		if c.Secure {
+			scheme = "https"
+		}
+		u := &url.URL{Scheme: scheme, Host: host, Path: "/"}
+
+		cookie := *c
+		if !strings.HasPrefix(c.Domain, ".") {
+			// Host-only cookies carry no Domain attribute
+			cookie.Domain = ""
+		}
+		s.SetCookies(u, []*http.Cookie{&cookie})
+		imported++
+	}
+	return imported
+}
@@ -0,0 +1,373 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrInvalidName is returned for session names that aren't safe file names
+var ErrInvalidName = errors.New("invalid session name")
+
+// namePattern restricts session names so they can double as file names
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Session is a named cookie jar shared by fetches that reference it
+type Session struct {
+	name string
+	jar  *cookiejar.Jar
+
+	mu       sync.Mutex
+	created  time.Time
+	lastUsed time.Time
+	// expired is set when a fetch lands on a login page instead of the
+	// content, and cleared by the next login or cookie import
+	expired bool
+	// cookies mirrors everything stored in jar, keyed by origin and then by
+	// domain|path|name, because cookiejar can't enumerate its contents
+	cookies map[string]map[string]*http.Cookie
+}
+
+// Info summarizes a session for listing
+type Info struct {
+	Name     string    `json:"name"`
+	Cookies  int       `json:"cookies"`
+	Domains  []string  `json:"domains"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used"`
+	Expired  bool      `json:"expired"`
+}
+
+// savedSession is the on-disk form of a session
+type savedSession struct {
+	Name     string                    `json:"name"`
+	Created  time.Time                 `json:"created"`
+	LastUsed time.Time                 `json:"last_used"`
+	Expired  bool                      `json:"expired,omitempty"`
+	Cookies  map[string][]*http.Cookie `json:"cookies"`
+}
+
+func newSession(name string) *Session {
+	// A nil public suffix list is enough for per-session isolation
+	jar, _ := cookiejar.New(nil)
+	now := time.Now()
+	return &Session{
+		name:     name,
+		jar:      jar,
+		created:  now,
+		lastUsed: now,
+		cookies:  make(map[string]map[string]*http.Cookie),
+	}
+}
+
+// Name returns the session name
+func (s *Session) Name() string {
+	return s.name
+}
+
+// SetCookies implements http.CookieJar
+func (s *Session) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	s.jar.SetCookies(u, cookies)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastUsed = time.Now()
+
+	origin := u.Scheme + "://" + u.Host + "/"
+	stored, ok := s.cookies[origin]
+	if !ok {
+		stored = make(map[string]*http.Cookie)
+		s.cookies[origin] = stored
+	}
+	for _, c := range cookies {
+		copied := *c
+		// Store an absolute expiry so the cookie survives a reload correctly
+		if copied.MaxAge > 0 {
+			copied.Expires = time.Now().Add(time.Duration(copied.MaxAge) * time.Second)
+			copied.MaxAge = 0
+		}
+		key := copied.Domain + "|" + copied.Path + "|" + copied.Name
+		if c.MaxAge < 0 || (!copied.Expires.IsZero() && copied.Expires.Before(time.Now())) {
+			delete(stored, key)
+			continue
+		}
+		stored[key] = &copied
+	}
+}
+
+// Len returns the number of cookies stored in the session
+func (s *Session) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, stored := range s.cookies {
+		n += len(stored)
+	}
+	return n
+}
+
+// AllCookies returns the session's unexpired cookies, keyed by the origin
+// that set them
+func (s *Session) AllCookies() map[string][]*http.Cookie {
+	return s.snapshot().Cookies
+}
+
+// Cookies implements http.CookieJar
+func (s *Session) Cookies(u *url.URL) []*http.Cookie {
+	s.mu.Lock()
+	s.lastUsed = time.Now()
+	s.mu.Unlock()
+	return s.jar.Cookies(u)
+}
+
+// MarkExpired records that the site no longer accepts the session's login
+func (s *Session) MarkExpired() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expired = true
+}
+
+// Renew clears the expired mark after the session has been logged in again
+func (s *Session) Renew() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expired = false
+}
+
+// Expired reports whether the session's login was last seen to have expired
+func (s *Session) Expired() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.expired
+}
+
+// info summarizes the session
+func (s *Session) info() Info {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := Info{Name: s.name, Created: s.created, LastUsed: s.lastUsed, Expired: s.expired, Domains: []string{}}
+	for origin, stored := range s.cookies {
+		if len(stored) == 0 {
+			continue
+		}
+		info.Cookies += len(stored)
+		if u, err := url.Parse(origin); err == nil {
+			info.Domains = append(info.Domains, u.Hostname())
+		}
+	}
+	sort.Strings(info.Domains)
+	return info
+}
+
+// snapshot returns the on-disk form of the session, dropping expired cookies
+func (s *Session) snapshot() savedSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	saved := savedSession{
+		Name:     s.name,
+		Created:  s.created,
+		LastUsed: s.lastUsed,
+		Expired:  s.expired,
+		Cookies:  make(map[string][]*http.Cookie),
+	}
+	now := time.Now()
+	for origin, stored := range s.cookies {
+		for _, c := range stored {
+			if !c.Expires.IsZero() && c.Expires.Before(now) {
+				continue
+			}
+			saved.Cookies[origin] = append(saved.Cookies[origin], c)
+		}
+	}
+	return saved
+}
+
+// Manager owns the named sessions and optionally persists them to a directory
+type Manager struct {
+	dir string
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewManager creates a session manager. If dir is non-empty, sessions saved
+// there by a previous run are loaded and later changes are written back.
+func NewManager(dir string) (*Manager, error) {
+	m := &Manager{
+		dir:      dir,
+		sessions: make(map[string]*Session),
+	}
+	if dir == "" {
+		return m, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return m, fmt.Errorf("failed to create sessions directory: %w", err)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return m, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	for _, path := range paths {
+		if err := m.load(path); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+// load restores one saved session
+func (m *Manager) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read session %s: %w", path, err)
+	}
+	var saved savedSession
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("failed to parse session %s: %w", path, err)
+	}
+	if !namePattern.MatchString(saved.Name) {
+		return fmt.Errorf("%w in %s: %q", ErrInvalidName, path, saved.Name)
+	}
+
+	s := newSession(saved.Name)
+	for origin, cookies := range saved.Cookies {
+		u, err := url.Parse(origin)
+		if err != nil {
+			continue
+		}
+		s.SetCookies(u, cookies)
+	}
+	s.created = saved.Created
+	s.lastUsed = saved.LastUsed
+	s.expired = saved.Expired
+	m.sessions[saved.Name] = s
+	return nil
+}
+
+// Get returns the named session, creating it on first use
+func (m *Manager) Get(name string) (*Session, error) {
+	if !namePattern.MatchString(name) {
+		return nil, fmt.Errorf("%w: %q (use letters, digits, '-' and '_')", ErrInvalidName, name)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[name]
+	if !ok {
+		s = newSession(name)
+		m.sessions[name] = s
+	}
+	return s, nil
+}
+
+// Lookup returns the named session if it exists
+func (m *Manager) Lookup(name string) (*Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[name]
+	return s, ok
+}
+
+// List returns a summary of every session, sorted by name
+func (m *Manager) List() []Info {
+	m.mu.Lock()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	m.mu.Unlock()
+
+	infos := make([]Info, 0, len(sessions))
+	for _, s := range sessions {
+		infos = append(infos, s.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// ImportFile imports a browser cookie export into the named session and
+// saves it, returning how many cookies were stored
+func (m *Manager) ImportFile(name, path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cookie file: %w", err)
+	}
+	return m.Import(name, data)
+}
+
+// Import parses a browser cookie export into the named session, creating it
+// if needed, and saves it
+func (m *Manager) Import(name string, data []byte) (int, error) {
+	cookies, err := ParseCookieExport(data)
+	if err != nil {
+		return 0, err
+	}
+	s, err := m.Get(name)
+	if err != nil {
+		return 0, err
+	}
+	imported := s.Import(cookies)
+	if imported > 0 {
+		// Fresh cookies from a logged-in browser replace the expired login
+		s.Renew()
+	}
+	return imported, m.Save(name)
+}
+
+// Delete removes a session and its saved file, reporting whether it existed
+func (m *Manager) Delete(name string) (bool, error) {
+	m.mu.Lock()
+	_, ok := m.sessions[name]
+	delete(m.sessions, name)
+	m.mu.Unlock()
+
+	if !ok || m.dir == "" {
+		return ok, nil
+	}
+	if err := os.Remove(m.path(name)); err != nil && !os.IsNotExist(err) {
+		return true, fmt.Errorf("failed to delete session file: %w", err)
+	}
+	return true, nil
+}
+
+// Save writes the named session to disk; it is a no-op without a directory
+func (m *Manager) Save(name string) error {
+	if m.dir == "" {
+		return nil
+	}
+	s, ok := m.Lookup(name)
+	if !ok {
+		return nil
+	}
+
+	data, err := json.Marshal(s.snapshot())
+	if err != nil {
+		return fmt.Errorf("failed to encode session %s: %w", name, err)
+	}
+
+	path := m.path(name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write session %s: %w", name, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// path returns the file a session is saved to
+func (m *Manager) path(name string) string {
+	return filepath.Join(m.dir, name+".json")
+}
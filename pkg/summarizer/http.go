@@ -0,0 +1,63 @@
+package summarizer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxResponseSize bounds how much of a summarizer's reply is read
+const maxResponseSize = 1024 * 1024
+
+// HTTP delegates to an external summarization service. It POSTs
+// {"text": ..., "sentences": n} to the endpoint and expects
+// {"summary": ...} back, so any model or service can be plugged in behind a
+// small adapter.
+type HTTP struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewHTTP creates a summarizer that calls endpoint
+func NewHTTP(endpoint string, timeout time.Duration) *HTTP {
+	return &HTTP{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+// Summarize implements Summarizer
+func (h *HTTP) Summarize(ctx context.Context, text string, sentences int) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{"text": text, "sentences": sentences})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("summarizer request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("summarizer returned status %s", resp.Status)
+	}
+
+	var result struct {
+		Summary string `json:"summary"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid summarizer response: %w", err)
+	}
+	return result.Summary, nil
+}
@@ -0,0 +1,133 @@
+package summarizer
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Summarizer condenses text to a short abstract of about the given number
+// of sentences
+type Summarizer interface {
+	Summarize(ctx context.Context, text string, sentences int) (string, error)
+}
+
+// minSentenceWords skips headings, captions and other fragments that rarely
+// make a useful summary sentence
+const minSentenceWords = 5
+
+// stopWords are frequent English words that say nothing about the topic
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "are": true, "but": true, "not": true,
+	"you": true, "all": true, "any": true, "can": true, "had": true, "her": true,
+	"was": true, "one": true, "our": true, "out": true, "has": true, "have": true,
+	"his": true, "how": true, "its": true, "may": true, "new": true, "now": true,
+	"see": true, "who": true, "did": true, "get": true, "him": true, "use": true,
+	"that": true, "with": true, "this": true, "from": true, "they": true, "will": true,
+	"would": true, "there": true, "their": true, "what": true, "about": true,
+	"which": true, "when": true, "make": true, "like": true, "into": true,
+	"than": true, "then": true, "them": true, "these": true, "some": true,
+	"other": true, "been": true, "were": true, "also": true, "more": true,
+	"only": true, "such": true, "your": true, "each": true, "most": true,
+}
+
+// Extractive is a local summarizer that picks the sentences whose words are
+// most frequent in the whole text, favoring earlier ones, and returns them in
+// their original order. It needs no network access.
+type Extractive struct{}
+
+// Summarize implements Summarizer
+func (Extractive) Summarize(ctx context.Context, text string, sentences int) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	var candidates []string
+	for _, sentence := range splitSentences(text) {
+		if len(strings.Fields(sentence)) >= minSentenceWords {
+			candidates = append(candidates, sentence)
+		}
+	}
+	if sentences <= 0 || len(candidates) == 0 {
+		return "", nil
+	}
+	if len(candidates) <= sentences {
+		return strings.Join(candidates, " "), nil
+	}
+
+	frequency := make(map[string]int)
+	for _, sentence := range candidates {
+		for _, word := range contentWords(sentence) {
+			frequency[word]++
+		}
+	}
+
+	type scored struct {
+		index int
+		score float64
+	}
+	ranked := make([]scored, len(candidates))
+	for i, sentence := range candidates {
+		words := contentWords(sentence)
+		total := 0
+		for _, word := range words {
+			total += frequency[word]
+		}
+		score := 0.0
+		if len(words) > 0 {
+			score = float64(total) / float64(len(words))
+		}
+		// Openings tend to state what the page is about
+		score *= 1 + 0.5/float64(i+1)
+		ranked[i] = scored{index: i, score: score}
+	}
+	sort.SliceStable(ranked, func(a, b int) bool { return ranked[a].score > ranked[b].score })
+
+	chosen := ranked[:sentences]
+	sort.Slice(chosen, func(a, b int) bool { return chosen[a].index < chosen[b].index })
+
+	summary := make([]string, len(chosen))
+	for i, c := range chosen {
+		summary[i] = candidates[c.index]
+	}
+	return strings.Join(summary, " "), nil
+}
+
+// splitSentences splits text at line breaks and at sentence-ending
+// punctuation followed by a space
+func splitSentences(text string) []string {
+	var sentences []string
+	for _, line := range strings.Split(text, "\n") {
+		start := 0
+		runes := []rune(line)
+		for i, r := range runes {
+			if (r == '.' || r == '!' || r == '?') && i+1 < len(runes) && unicode.IsSpace(runes[i+1]) {
+				sentences = appendSentence(sentences, string(runes[start:i+1]))
+				start = i + 1
+			}
+		}
+		sentences = appendSentence(sentences, string(runes[start:]))
+	}
+	return sentences
+}
+
+func appendSentence(sentences []string, sentence string) []string {
+	if sentence = strings.Join(strings.Fields(sentence), " "); sentence != "" {
+		sentences = append(sentences, sentence)
+	}
+	return sentences
+}
+
+// contentWords returns the lowercased words of a sentence that carry meaning
+func contentWords(sentence string) []string {
+	var words []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(sentence), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(word)) >= 3 && !stopWords[word] {
+			words = append(words, word)
+		}
+	}
+	return words
+}
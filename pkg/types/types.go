@@ -1,10 +1,18 @@
 package types
 
-import "time"
+import (
+	"fmt"
+	"net/textproto"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
 
 // Engine types
 const (
 	EngineHTTP   = "http"
+	EngineHTTP3  = "http3"
 	EngineChrome = "chrome"
 )
 
@@ -13,42 +21,517 @@ const (
 	FormatText     = "text"
 	FormatHTML     = "html"
 	FormatMarkdown = "markdown"
+	FormatArticle  = "article"
+
+	// FormatOutline is never requested; oversized markdown is returned in
+	// it when it exceeds the response budget
+	FormatOutline = "outline"
 )
 
 // Default values
 const (
-	DefaultEngine          = EngineHTTP
-	DefaultFormat          = FormatText
+	DefaultEngine           = EngineHTTP
+	DefaultFormat           = FormatText
 	DefaultMaxContentLength = 10 * 1024 * 1024 // 10MB
-	DefaultUserAgent       = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	DefaultPaginationPages  = 5
+	DefaultMaxRedirects     = 5
+	DefaultResponseBudget   = 100000 // characters
+	MaxPaginationPages      = 20
+	MaxSummarySentences     = 20
+	MaxWaitMs               = 30000
+	DefaultScrolls          = 10
+	MaxScrolls              = 50
+	MaxActions              = 20
+	MaxViewportSize         = 10000 // pixels
+	DefaultUserAgent        = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
 )
 
+// DefaultRetryPolicy retries server errors and network failures twice,
+// after about one and then two seconds
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelayMs: 1000,
+	MaxDelayMs:  30000,
+	Jitter:      0.2,
+	StatusCodes: []int{500, 502, 503, 504},
+}
+
+// MaxRetryAttempts caps RetryPolicy.MaxAttempts
+const MaxRetryAttempts = 10
+
+// RetryPolicy controls how a failed fetch is retried. Delays grow
+// exponentially from BaseDelayMs, up to MaxDelayMs, and each is randomized
+// by up to Jitter (a fraction) either way. Zero fields are unset, so a
+// policy can override another field by field.
+type RetryPolicy struct {
+	MaxAttempts   int     `json:"max_attempts,omitempty"` // including the first
+	BaseDelayMs   int     `json:"base_delay_ms,omitempty"`
+	MaxDelayMs    int     `json:"max_delay_ms,omitempty"`
+	Jitter        float64 `json:"jitter,omitempty"`
+	StatusCodes   []int   `json:"status_codes,omitempty"`   // responses that are retried
+	NetworkErrors *bool   `json:"network_errors,omitempty"` // retry timeouts and connection failures; unset means true
+}
+
+// Merge returns p with the fields set in override replacing its own
+func (p RetryPolicy) Merge(override *RetryPolicy) RetryPolicy {
+	if override == nil {
+		return p
+	}
+	if override.MaxAttempts != 0 {
+		p.MaxAttempts = override.MaxAttempts
+	}
+	if override.BaseDelayMs != 0 {
+		p.BaseDelayMs = override.BaseDelayMs
+	}
+	if override.MaxDelayMs != 0 {
+		p.MaxDelayMs = override.MaxDelayMs
+	}
+	if override.Jitter != 0 {
+		p.Jitter = override.Jitter
+	}
+	if override.StatusCodes != nil {
+		p.StatusCodes = override.StatusCodes
+	}
+	if override.NetworkErrors != nil {
+		p.NetworkErrors = override.NetworkErrors
+	}
+	return p
+}
+
+// Validate checks that every set field is in range
+func (p RetryPolicy) Validate() error {
+	if p.MaxAttempts < 0 || p.MaxAttempts > MaxRetryAttempts {
+		return fmt.Errorf("max_attempts must be between 1 and %d", MaxRetryAttempts)
+	}
+	if p.BaseDelayMs < 0 || p.MaxDelayMs < 0 {
+		return fmt.Errorf("retry delays must be non-negative")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("jitter must be between 0 and 1")
+	}
+	for _, code := range p.StatusCodes {
+		if code < 400 || code > 599 {
+			return fmt.Errorf("retryable status codes must be between 400 and 599, got %d", code)
+		}
+	}
+	return nil
+}
+
+// UserAgentPresets maps the names accepted by the user_agent parameter to
+// full UA strings
+var UserAgentPresets = map[string]string{
+	"desktop-chrome": DefaultUserAgent,
+	"mobile-safari":  "Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1",
+	"googlebot":      "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+	"curl":           "curl/8.4.0",
+}
+
+// Resources the Chrome engine can block
+const (
+	BlockImage     = "image"
+	BlockFont      = "font"
+	BlockMedia     = "media"
+	BlockAnalytics = "analytics" // third-party analytics, tag managers and ad trackers
+)
+
+// BlockableResources lists every resource that can be blocked
+var BlockableResources = []string{BlockImage, BlockFont, BlockMedia, BlockAnalytics}
+
+// Viewport is the screen Chrome emulates for a page
+type Viewport struct {
+	Width             int     `json:"width"`
+	Height            int     `json:"height"`
+	DeviceScaleFactor float64 `json:"device_scale_factor,omitempty"` // 0 is 1
+	Mobile            bool    `json:"mobile,omitempty"`              // mobile layout, meta viewport and touch events
+}
+
+// Validate checks that the viewport's dimensions are in range
+func (v Viewport) Validate() error {
+	if v.Width < 1 || v.Width > MaxViewportSize || v.Height < 1 || v.Height > MaxViewportSize {
+		return fmt.Errorf("viewport width and height must be between 1 and %d", MaxViewportSize)
+	}
+	if v.DeviceScaleFactor < 0 || v.DeviceScaleFactor > 10 {
+		return fmt.Errorf("device_scale_factor must be between 0 and 10")
+	}
+	return nil
+}
+
+// Device is an emulation preset: a viewport and the user agent of a browser
+// on it
+type Device struct {
+	Viewport  Viewport
+	UserAgent string
+}
+
+// DevicePresets maps the names accepted by the device parameter to presets
+var DevicePresets = map[string]Device{
+	"desktop": {Viewport{Width: 1920, Height: 1080, DeviceScaleFactor: 1}, DefaultUserAgent},
+	"laptop":  {Viewport{Width: 1366, Height: 768, DeviceScaleFactor: 1}, DefaultUserAgent},
+	"iphone":  {Viewport{Width: 393, Height: 852, DeviceScaleFactor: 3, Mobile: true}, UserAgentPresets["mobile-safari"]},
+	"ipad":    {Viewport{Width: 820, Height: 1180, DeviceScaleFactor: 2, Mobile: true}, "Mozilla/5.0 (iPad; CPU OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1"},
+	"pixel":   {Viewport{Width: 412, Height: 915, DeviceScaleFactor: 2.625, Mobile: true}, "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36"},
+}
+
+// Geolocation is the position Chrome reports to a page through the
+// Geolocation API
+type Geolocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Accuracy  float64 `json:"accuracy,omitempty"` // meters; 0 is 100
+}
+
+// Validate checks that the position is on the globe
+func (g Geolocation) Validate() error {
+	if g.Latitude < -90 || g.Latitude > 90 {
+		return fmt.Errorf("latitude must be between -90 and 90")
+	}
+	if g.Longitude < -180 || g.Longitude > 180 {
+		return fmt.Errorf("longitude must be between -180 and 180")
+	}
+	if g.Accuracy < 0 {
+		return fmt.Errorf("accuracy can't be negative")
+	}
+	return nil
+}
+
+// UserAgentPool is what user agent rotation cycles through by default:
+// current desktop browsers across engines and platforms, each of which gets
+// its own browser's Accept and client hint headers
+var UserAgentPool = []string{
+	DefaultUserAgent,
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0",
+	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:121.0) Gecko/20100101 Firefox/121.0",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:121.0) Gecko/20100101 Firefox/121.0",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15",
+}
+
 // FetchRequest represents a request to fetch a URL
 type FetchRequest struct {
-	URL              string `json:"url"`
-	Engine           string `json:"engine,omitempty"`
-	Format           string `json:"format,omitempty"`
-	MaxContentLength int    `json:"max_content_length,omitempty"`
+	URL              string            `json:"url"`
+	Engine           string            `json:"engine,omitempty"`
+	Format           string            `json:"format,omitempty"`
+	MaxContentLength int               `json:"max_content_length,omitempty"`
+	FollowPagination int               `json:"follow_pagination,omitempty"`
+	Offset           int               `json:"offset,omitempty"`
+	ChunkSize        int               `json:"chunk_size,omitempty"`
+	Preflight        bool              `json:"preflight,omitempty"`
+	NoCache          bool              `json:"no_cache,omitempty"`      // skip the cached copy, but still cache the result
+	ForceRefresh     bool              `json:"force_refresh,omitempty"` // evict the cached copy and replace it with a fresh fetch
+	ServeStale       *bool             `json:"serve_stale,omitempty"`   // nil uses the server setting
+	CacheOnly        bool              `json:"cache_only,omitempty"`    // answer from the cache, never the network
+	Cookies          []Cookie          `json:"cookies,omitempty"`
+	Auth             *Auth             `json:"auth,omitempty"`
+	Headers          map[string]string `json:"headers,omitempty"` // sent with the request, over the engine's defaults
+	Session          string            `json:"session,omitempty"`
+	XPath            string            `json:"xpath,omitempty"`
+	Proxy            string            `json:"proxy,omitempty"`
+	UserAgent        string            `json:"user_agent,omitempty"`
+	ForceHTTP1       bool              `json:"force_http1,omitempty"`
+	MaxRedirects     *int              `json:"max_redirects,omitempty"`   // nil uses the server limit; 0 doesn't follow
+	Range            *ByteRange        `json:"range,omitempty"`           // fetch only these bytes of the body
+	WaitMs           int               `json:"wait_ms,omitempty"`         // extra wait after the page settles (Chrome only)
+	Scroll           int               `json:"scroll,omitempty"`          // times to scroll to the bottom before capture (Chrome only)
+	CaptureNetwork   bool              `json:"capture_network,omitempty"` // record the page's requests (Chrome only)
+	ReturnCookies    bool              `json:"return_cookies,omitempty"`  // return the cookies the page set (Chrome only)
+	Actions          []Action          `json:"actions,omitempty"`         // interaction run before capture (Chrome only)
+	Device           string            `json:"device,omitempty"`          // a DevicePresets name, setting Viewport and UserAgent when they're unset
+	Viewport         *Viewport         `json:"viewport,omitempty"`        // screen to emulate (Chrome only); nil keeps the browser's
+	Locale           string            `json:"locale,omitempty"`          // BCP 47 tag, e.g. "de-DE": Accept-Language, and in Chrome the page's language and formatting
+	Timezone         string            `json:"timezone,omitempty"`        // IANA time zone the page sees, e.g. "Europe/Berlin" (Chrome only)
+	Geolocation      *Geolocation      `json:"geolocation,omitempty"`     // position the page sees (Chrome only)
+	Block            []string          `json:"block,omitempty"`           // BlockableResources not to load (Chrome only); nil uses the server setting
+	MaxBandwidth     int64             `json:"max_bandwidth,omitempty"`   // download rate cap in bytes per second, under the server's own
+	Retry            *RetryPolicy      `json:"retry,omitempty"`           // overrides the server's retry policy field by field
+	Sign             *SignSpec         `json:"sign,omitempty"`
+	Provenance       bool              `json:"provenance,omitempty"`
+	SummarySentences int               `json:"summary_sentences,omitempty"`
+
+	// Revalidate is a cached response to revalidate: its ETag and
+	// LastModified make the HTTP engine's request conditional, and on a 304
+	// a copy of it is returned in place of a body
+	Revalidate *FetchResponse `json:"-"`
+}
+
+// ByteRange selects part of a response body, in bytes of the decoded body
+type ByteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"` // inclusive; -1 reads to the end of the body
+}
+
+// Auth types
+const (
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+)
+
+// SignSpec describes how to sign a URL with an HMAC over its path and query.
+// The secret is named, not given: it is read from the environment variable
+// FETCH_URL_SECRET_<Secret> so it never passes through the conversation.
+type SignSpec struct {
+	Secret       string `json:"secret"`
+	Algorithm    string `json:"algorithm,omitempty"`     // hmac-sha256 (default), hmac-sha1, hmac-sha512
+	Param        string `json:"param,omitempty"`         // query parameter for the signature; default "signature"
+	ExpiresParam string `json:"expires_param,omitempty"` // optional query parameter for a Unix expiry time
+	TTLSeconds   int    `json:"ttl_seconds,omitempty"`   // lifetime when ExpiresParam is set; default 300
+	Encoding     string `json:"encoding,omitempty"`      // hex (default), base64 or base64url
+}
+
+// Action types
+const (
+	ActionClick  = "click"
+	ActionType   = "type"
+	ActionPress  = "press"
+	ActionWait   = "wait"
+	ActionScroll = "scroll"
+)
+
+// ActionKeys are the named keys a press action accepts besides single characters
+var ActionKeys = []string{"Enter", "Tab", "Escape", "Backspace", "Delete", "ArrowUp", "ArrowDown", "ArrowLeft", "ArrowRight", "PageUp", "PageDown", "Home", "End"}
+
+// Action is a step of scripted interaction with a page in Chrome, run after
+// it loads and before it is captured
+type Action struct {
+	Type     string `json:"type"`
+	Selector string `json:"selector,omitempty"` // CSS selector: clicked, typed into, waited for or scrolled into view
+	Text     string `json:"text,omitempty"`     // type: text to enter
+	Key      string `json:"key,omitempty"`      // press: a single character or one of ActionKeys
+	Ms       int    `json:"ms,omitempty"`       // wait: milliseconds, when not waiting for a selector
+}
+
+// Validate checks that the action has the fields its type needs
+func (a Action) Validate() error {
+	switch a.Type {
+	case ActionClick:
+		if a.Selector == "" {
+			return fmt.Errorf("click requires selector")
+		}
+	case ActionType:
+		if a.Selector == "" || a.Text == "" {
+			return fmt.Errorf("type requires selector and text")
+		}
+	case ActionPress:
+		if utf8.RuneCountInString(a.Key) != 1 && !slices.Contains(ActionKeys, a.Key) {
+			return fmt.Errorf("press requires key, a single character or one of %v", ActionKeys)
+		}
+	case ActionWait:
+		if a.Selector == "" && (a.Ms <= 0 || a.Ms > MaxWaitMs) {
+			return fmt.Errorf("wait requires selector or ms between 1 and %d", MaxWaitMs)
+		}
+	case ActionScroll:
+	default:
+		return fmt.Errorf("unknown action type %q (must be click, type, press, wait or scroll)", a.Type)
+	}
+	return nil
+}
+
+// ManagedHeaders are request headers the fetcher or the transport sets
+// itself, which requests can't override
+var ManagedHeaders = []string{"Host", "Connection", "Content-Length", "Transfer-Encoding", "Upgrade", "Accept-Encoding", "Cookie", "Range"}
+
+// ValidateHeaders checks a request's own headers: names must be valid
+// header tokens and not managed, and values can't span lines
+func ValidateHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		if name == "" || strings.IndexFunc(name, func(r rune) bool { return !isTokenRune(r) }) >= 0 {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		canonical := textproto.CanonicalMIMEHeaderKey(name)
+		if slices.Contains(ManagedHeaders, canonical) {
+			return fmt.Errorf("header %s can't be set per request", canonical)
+		}
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return fmt.Errorf("invalid value for header %s", canonical)
+		}
+	}
+	return nil
+}
+
+// isTokenRune reports whether r may appear in a header name (RFC 9110 tchar)
+func isTokenRune(r rune) bool {
+	return r < 0x7f && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", r))
+}
+
+// ValidateLocale checks that locale is a BCP 47 language tag: a 2 or 3
+// letter language, then optional script, region and variant subtags, such
+// as "de", "pt-BR" or "zh-Hant-TW"
+func ValidateLocale(locale string) error {
+	subtags := strings.Split(locale, "-")
+	if n := len(subtags[0]); n < 2 || n > 3 || !isAlnum(subtags[0], false) {
+		return fmt.Errorf("invalid locale %q", locale)
+	}
+	for _, subtag := range subtags[1:] {
+		if len(subtag) < 1 || len(subtag) > 8 || !isAlnum(subtag, true) {
+			return fmt.Errorf("invalid locale %q", locale)
+		}
+	}
+	return nil
+}
+
+// isAlnum reports whether s is all ASCII letters, or letters and digits
+func isAlnum(s string, digits bool) bool {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || digits && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Auth holds credentials sent as an Authorization header
+type Auth struct {
+	Type     string `json:"type"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// Cookie is a name/value pair sent with a request. Without a domain it is
+// sent to the requested host only; with one, to that domain and its
+// subdomains, e.g. after a redirect to www.
+type Cookie struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Domain string `json:"domain,omitempty"`
+	Path   string `json:"path,omitempty"`
 }
 
 // FetchResponse represents the response from fetching a URL
 type FetchResponse struct {
-	URL             string   `json:"url"`
-	Engine          string   `json:"engine"`
-	StatusCode      int      `json:"status_code"`
-	ContentType     string   `json:"content_type"`
-	Content         string   `json:"content"`
-	Format          string   `json:"format"`
-	Title           string   `json:"title,omitempty"`
-	FetchTimeMs     int64    `json:"fetch_time_ms"`
-	Warnings        []string `json:"warnings,omitempty"`
-	ChromeAvailable bool     `json:"chrome_available"`
+	URL              string           `json:"url"`
+	FinalURL         string           `json:"final_url,omitempty"`
+	Redirects        []Redirect       `json:"redirects,omitempty"`
+	Location         string           `json:"location,omitempty"`
+	UserAgentProfile string           `json:"user_agent_profile,omitempty"`
+	UserAgent        string           `json:"user_agent,omitempty"` // the rotated user agent, when rotation picked one
+	Engine           string           `json:"engine"`
+	StatusCode       int              `json:"status_code"`
+	ContentType      string           `json:"content_type"`
+	Charset          string           `json:"charset,omitempty"`
+	Protocol         string           `json:"protocol,omitempty"`
+	ContentLength    int64            `json:"content_length,omitempty"`
+	ContentRange     string           `json:"content_range,omitempty"` // the part of the body returned for a range request, e.g. "bytes 0-999/52340"
+	ETag             string           `json:"etag,omitempty"`
+	LastModified     string           `json:"last_modified,omitempty"`
+	CacheControl     string           `json:"cache_control,omitempty"`
+	Expires          string           `json:"expires,omitempty"`
+	NotModified      bool             `json:"not_modified,omitempty"` // a 304 confirmed the revalidated copy is current
+	Stale            bool             `json:"stale,omitempty"`        // a cached copy served because the fetch failed
+	Error            string           `json:"error,omitempty"`        // the failure a negatively cached entry stands for
+	BlockedBy        string           `json:"blocked_by,omitempty"`   // "cloudflare", "akamai", "captcha" or "login_wall" when the page isn't the real content
+	Skipped          bool             `json:"skipped,omitempty"`
+	File             string           `json:"file,omitempty"` // a body too large to hold in memory, streamed here; Content is a preview of it
+	Content          string           `json:"content"`
+	Format           string           `json:"format"`
+	Title            string           `json:"title,omitempty"`
+	Language         string           `json:"language,omitempty"`
+	ContentHash      string           `json:"content_hash,omitempty"`
+	Article          *Article         `json:"article,omitempty"`
+	FetchTimeMs      int64            `json:"fetch_time_ms"`
+	Attempts         int              `json:"attempts,omitempty"`
+	Timing           *Timing          `json:"timing,omitempty"`
+	Console          []ConsoleMessage `json:"console,omitempty"`        // Chrome: what the page logged, and its uncaught exceptions
+	Network          []NetworkEntry   `json:"network,omitempty"`        // Chrome: the page's requests, when captured
+	Cookies          []PageCookie     `json:"cookies,omitempty"`        // Chrome: cookies the page set, when requested
+	BytesReceived    int64            `json:"bytes_received,omitempty"` // network bytes, before decompression; Chrome counts every resource the page loaded
+	RenderMs         int64            `json:"render_ms,omitempty"`      // Chrome: time a browser instance was held
+	FetchedAt        time.Time        `json:"fetched_at"`
+	Warnings         []string         `json:"warnings,omitempty"`
+	Pages            []string         `json:"pages,omitempty"`
+	ChromeAvailable  bool             `json:"chrome_available"`
+}
+
+// Link is a hyperlink found on a page, with its anchor text
+type Link struct {
+	URL  string `json:"url"`
+	Text string `json:"text,omitempty"`
+}
+
+// Timing breaks a fetch's duration down into phases, in milliseconds. The
+// network phases describe the final request, after any redirects and
+// retries; phases that didn't happen, such as DNS on a reused connection, or
+// took under a millisecond are omitted.
+type Timing struct {
+	QueueMs          int64 `json:"queue_ms,omitempty"` // waiting for a fetch slot or a browser instance
+	DNSMs            int64 `json:"dns_ms,omitempty"`
+	ConnectMs        int64 `json:"connect_ms,omitempty"`
+	TLSMs            int64 `json:"tls_ms,omitempty"`
+	TTFBMs           int64 `json:"ttfb_ms,omitempty"` // request sent until the first response byte
+	DownloadMs       int64 `json:"download_ms,omitempty"`
+	NavigationMs     int64 `json:"navigation_ms,omitempty"` // Chrome: navigation until the load event
+	StabilityMs      int64 `json:"stability_ms,omitempty"`  // Chrome: waiting for the page to settle
+	ConnectionReused bool  `json:"connection_reused,omitempty"`
+}
+
+// ConsoleMessage is a message a page logged in Chrome: a console call, an
+// uncaught exception (level "exception") or a browser warning or error
+// about it, such as a failed resource load
+type ConsoleMessage struct {
+	Level  string `json:"level"`
+	Text   string `json:"text"`
+	Source string `json:"source,omitempty"` // script or resource URL and line, when known
+}
+
+// NetworkEntry is one request a page made in Chrome, as a HAR entry would
+// record it. A redirect ends one entry and starts the next.
+type NetworkEntry struct {
+	URL        string `json:"url"`
+	Method     string `json:"method"`
+	Type       string `json:"type"`             // document, script, xhr, fetch, image...
+	Status     int    `json:"status,omitempty"` // 0 if no response arrived
+	MimeType   string `json:"mime_type,omitempty"`
+	Bytes      int64  `json:"bytes,omitempty"` // encoded bytes received
+	StartedMs  int64  `json:"started_ms"`      // since the page's first request
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// PageCookie is a cookie a page set in Chrome, in Playwright's storage
+// state format so a list of them can be imported into a session as is, or
+// passed back as a request's cookies
+type PageCookie struct {
+	Name     string  `json:"name"`
+	Value    string  `json:"value"`
+	Domain   string  `json:"domain"` // a leading dot when subdomains get it too
+	Path     string  `json:"path"`
+	Expires  float64 `json:"expires"` // Unix seconds; -1 for a session cookie
+	HTTPOnly bool    `json:"httpOnly"`
+	Secure   bool    `json:"secure"`
+	SameSite string  `json:"sameSite,omitempty"`
+}
+
+// Redirect is one hop of a redirect chain: URL answered StatusCode,
+// sending the client on to Location
+type Redirect struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+	Location   string `json:"location"`
+}
+
+// Article holds structured metadata extracted by the article format
+type Article struct {
+	Title         string `json:"title"`
+	Byline        string `json:"byline,omitempty"`
+	Author        string `json:"author,omitempty"`
+	PublishedDate string `json:"published_date,omitempty"`
+	SiteName      string `json:"site_name,omitempty"`
+	Excerpt       string `json:"excerpt,omitempty"`
+	Text          string `json:"text"`
+	TopImage      string `json:"top_image,omitempty"`
+	Language      string `json:"language,omitempty"`
+	WordCount     int    `json:"word_count"`
 }
 
 // CacheEntry represents a cached response
 type CacheEntry struct {
 	Response  *FetchResponse
 	ExpiresAt time.Time
+
+	// RetainUntil is when the entry may be dropped: its expiry, or later if
+	// it is kept to be revalidated or served when a fetch fails. Zero means
+	// its expiry.
+	RetainUntil time.Time
 }
 
 // Error response helper
@@ -61,4 +544,4 @@ func ErrorResponse(url string, engine string, err error, fetchTime time.Duration
 		Format:      FormatText,
 		FetchTimeMs: fetchTime.Milliseconds(),
 	}
-}
\ No newline at end of file
+}
@@ -1,15 +1,45 @@
 package test
 
 import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unicode/utf8"
 
+	"github.com/andybalholm/brotli"
 	"github.com/gomcpgo/url_fetcher/pkg/cache"
 	"github.com/gomcpgo/url_fetcher/pkg/config"
+	"github.com/gomcpgo/url_fetcher/pkg/crawler"
+	"github.com/gomcpgo/url_fetcher/pkg/discovery"
 	"github.com/gomcpgo/url_fetcher/pkg/fetcher"
 	"github.com/gomcpgo/url_fetcher/pkg/processor"
+	"github.com/gomcpgo/url_fetcher/pkg/provenance"
+	"github.com/gomcpgo/url_fetcher/pkg/proxy"
+	"github.com/gomcpgo/url_fetcher/pkg/session"
+	"github.com/gomcpgo/url_fetcher/pkg/summarizer"
 	"github.com/gomcpgo/url_fetcher/pkg/types"
+	"github.com/klauspost/compress/zstd"
 )
 
 func TestHTTPEngine(t *testing.T) {
@@ -30,7 +60,7 @@ func TestHTTPEngine(t *testing.T) {
 		MaxContentLength: 1024 * 1024, // 1MB
 	}
 	
-	resp, err := f.Fetch(req)
+	resp, err := f.Fetch(context.Background(), req)
 	if err != nil {
 		t.Fatalf("Failed to fetch URL: %v", err)
 	}
@@ -82,7 +112,7 @@ func TestContentProcessor(t *testing.T) {
 				Format:  tt.format,
 			}
 			
-			err := p.Process(resp)
+			err := p.Process(context.Background(), resp)
 			if err != nil {
 				t.Errorf("Process failed: %v", err)
 			}
@@ -101,6 +131,122 @@ func TestContentProcessor(t *testing.T) {
 	}
 }
 
+func TestArticleFormat(t *testing.T) {
+	p := processor.NewProcessor()
+
+	paragraph := "<p>Go is an open source programming language that makes it simple to build secure, scalable systems. It was designed at Google and is used widely for network services.</p>"
+	resp := &types.FetchResponse{
+		URL:    "https://example.com/posts/go",
+		Format: types.FormatArticle,
+		Content: `<html><head><title>Why Go</title>
+			<meta name="author" content="Jane Doe">
+			<meta property="article:published_time" content="2024-01-02T03:04:05Z">
+			</head><body><article><h1>Why Go</h1>` +
+			strings.Repeat(paragraph, 5) + `</article></body></html>`,
+	}
+
+	if err := p.Process(context.Background(), resp); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	if resp.Article == nil {
+		t.Fatal("Expected article metadata")
+	}
+	if resp.Article.Author != "Jane Doe" {
+		t.Errorf("Expected author 'Jane Doe', got '%s'", resp.Article.Author)
+	}
+	if resp.Article.PublishedDate != "2024-01-02T03:04:05Z" {
+		t.Errorf("Unexpected published date '%s'", resp.Article.PublishedDate)
+	}
+	if resp.Article.WordCount == 0 || resp.Content == "" {
+		t.Errorf("Expected article text and word count, got %d words", resp.Article.WordCount)
+	}
+}
+
+func TestFindNextPage(t *testing.T) {
+	p := processor.NewProcessor()
+
+	tests := []struct {
+		name     string
+		html     string
+		expected string
+	}{
+		{"rel=next link", `<html><head><link rel="next" href="/post?page=2"></head><body></body></html>`, "https://example.com/post?page=2"},
+		{"next anchor text", `<html><body><a href="page/3">Next »</a></body></html>`, "https://example.com/page/3"},
+		{"other host ignored", `<html><body><a rel="next" href="https://other.com/2">Next</a></body></html>`, ""},
+		{"no pagination", `<html><body><a href="/about">About</a></body></html>`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := p.FindNextPage(tt.html, "https://example.com/post")
+			if next != tt.expected {
+				t.Errorf("Expected '%s', got '%s'", tt.expected, next)
+			}
+		})
+	}
+}
+
+func TestLanguageDetection(t *testing.T) {
+	p := processor.NewProcessor()
+
+	tests := []struct {
+		name     string
+		html     string
+		expected string
+	}{
+		{"lang attribute", `<html lang="de-AT"><body><p>Hello</p></body></html>`, "de"},
+		{"english text", `<html><body><p>The quick brown fox jumps over the lazy dog and it is in the garden with the cat.</p></body></html>`, "en"},
+		{"spanish text", `<html><body><p>El perro de la casa se fue con los niños por el parque para jugar en la tarde.</p></body></html>`, "es"},
+		{"russian text", `<html><body><p>Быстрая коричневая лиса прыгает через ленивую собаку.</p></body></html>`, "ru"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &types.FetchResponse{Content: tt.html, Format: types.FormatText}
+			if err := p.Process(context.Background(), resp); err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if resp.Language != tt.expected {
+				t.Errorf("Expected language '%s', got '%s'", tt.expected, resp.Language)
+			}
+		})
+	}
+}
+
+func TestExtractionProfiles(t *testing.T) {
+	p := processor.NewProcessor()
+	p.SetProfiles([]processor.Profile{
+		{
+			Domains: []string{"example.com"},
+			Title:   "h1.headline",
+			Body:    "div.story",
+			Author:  ".writer",
+			Remove:  []string{".ad"},
+		},
+	})
+
+	html := `<html><head><title>Site name</title></head><body>
+		<h1 class="headline">Real headline</h1><span class="writer">Ann Author</span>
+		<div class="story"><p>Story text.</p><div class="ad">Buy now</div></div>
+		<div class="sidebar">Sidebar</div></body></html>`
+
+	resp := &types.FetchResponse{URL: "https://news.example.com/a", Content: html, Format: types.FormatArticle}
+	if err := p.Process(context.Background(), resp); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	if resp.Title != "Real headline" {
+		t.Errorf("Expected profile title, got '%s'", resp.Title)
+	}
+	if resp.Content != "Story text." {
+		t.Errorf("Expected only the selected body, got '%s'", resp.Content)
+	}
+	if resp.Article == nil || resp.Article.Author != "Ann Author" {
+		t.Errorf("Expected profile author, got %+v", resp.Article)
+	}
+}
+
 func TestCache(t *testing.T) {
 	c := cache.NewCache(time.Second * 2)
 	
@@ -131,6 +277,57 @@ func TestCache(t *testing.T) {
 	}
 }
 
+func TestCacheManagement(t *testing.T) {
+	c := cache.NewCache(time.Hour)
+
+	resp := &types.FetchResponse{StatusCode: 200, Content: "Test content"}
+	c.Set("https://example.com/docs/a", types.EngineHTTP, types.FormatText, resp)
+	c.Set("https://example.com/docs/a", types.EngineHTTP, types.FormatMarkdown, resp)
+	c.Set("https://example.com/docs/b", types.EngineHTTP, types.FormatText, resp)
+	c.Set("https://example.com/blog/c", types.EngineHTTP, types.FormatText, resp)
+
+	c.Get("https://example.com/docs/a", types.EngineHTTP, types.FormatText)
+	c.Get("https://example.com/missing", types.EngineHTTP, types.FormatText)
+
+	stats := c.Stats()
+	if stats.Entries != 4 || stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if stats.HitRate != 0.5 {
+		t.Errorf("Expected hit rate 0.5, got %f", stats.HitRate)
+	}
+
+	if removed := c.DeleteURL("https://example.com/docs/a"); removed != 2 {
+		t.Errorf("Expected DeleteURL to remove 2 entries, removed %d", removed)
+	}
+
+	removed, err := c.DeletePattern("https://example.com/docs/*")
+	if err != nil {
+		t.Fatalf("DeletePattern failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected DeletePattern to remove 1 entry, removed %d", removed)
+	}
+
+	// A '|' in the URL isn't taken for the end of it
+	c.Set("https://example.com/search?q=a|b", types.EngineHTTP, types.FormatText, resp)
+	c.Set("https://example.com/search?q=a|c", types.EngineHTTP, types.FormatText, resp)
+	c.Set("data:text/plain,left|right", types.EngineHTTP, types.FormatText, resp)
+	if removed := c.DeleteURL("https://example.com/search?q=a|b"); removed != 1 {
+		t.Errorf("Expected DeleteURL to remove the entry with '|' in its query, removed %d", removed)
+	}
+	if removed := c.DeleteURL("data:text/plain,left|right"); removed != 1 {
+		t.Errorf("Expected DeleteURL to remove the data: URL entry, removed %d", removed)
+	}
+	if removed, err := c.DeletePattern("https://example.com/search?q=a|*"); err != nil || removed != 1 {
+		t.Errorf("Expected DeletePattern to remove the entry with '|' in its query, removed %d (%v)", removed, err)
+	}
+
+	if removed := c.Clear(); removed != 1 {
+		t.Errorf("Expected Clear to remove 1 entry, removed %d", removed)
+	}
+}
+
 func TestURLValidation(t *testing.T) {
 	cfg := &config.Config{
 		BlockLocal:     true,
@@ -162,7 +359,7 @@ func TestURLValidation(t *testing.T) {
 				Format: types.FormatText,
 			}
 			
-			_, err := f.Fetch(req)
+			_, err := f.Fetch(context.Background(), req)
 			if tt.shouldErr && err == nil {
 				t.Errorf("Expected error for URL %s, but got none", tt.url)
 			}
@@ -173,6 +370,37 @@ func TestURLValidation(t *testing.T) {
 	}
 }
 
+func TestErrorTaxonomy(t *testing.T) {
+	cfg := &config.Config{
+		BlockLocal:     true,
+		ChromePoolSize: 1,
+		CacheTTL:       time.Hour,
+		Timeout:        5 * time.Second,
+	}
+
+	f := fetcher.NewFetcher(cfg)
+	defer f.Close()
+
+	tests := []struct {
+		url      string
+		engine   string
+		expected error
+	}{
+		{"http://127.0.0.1/", types.EngineHTTP, fetcher.ErrBlockedLocal},
+		{"ftp://example.com/file", types.EngineHTTP, fetcher.ErrUnsupportedScheme},
+		{"https://example.com", "lynx", fetcher.ErrUnsupportedEngine},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			_, err := f.Fetch(context.Background(), &types.FetchRequest{URL: tt.url, Engine: tt.engine})
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
 // Enhanced integration tests with real websites
 func TestRealWebsiteIntegration(t *testing.T) {
 	if testing.Short() {
@@ -250,7 +478,7 @@ func TestRealWebsiteIntegration(t *testing.T) {
 				MaxContentLength: 2 * 1024 * 1024, // 2MB
 			}
 			
-			resp, err := f.Fetch(req)
+			resp, err := f.Fetch(context.Background(), req)
 			if err != nil {
 				t.Fatalf("Failed to fetch %s: %v", tc.url, err)
 			}
@@ -356,13 +584,13 @@ func TestFormatConversion(t *testing.T) {
 				MaxContentLength: 1024 * 1024, // 1MB
 			}
 			
-			resp, err := f.Fetch(req)
+			resp, err := f.Fetch(context.Background(), req)
 			if err != nil {
 				t.Fatalf("Failed to fetch for format %s: %v", fmt.format, err)
 			}
 			
 			// Process the content
-			err = p.Process(resp)
+			err = p.Process(context.Background(), resp)
 			if err != nil {
 				t.Fatalf("Failed to process content for format %s: %v", fmt.format, err)
 			}
@@ -414,7 +642,7 @@ func TestEngineComparison(t *testing.T) {
 				MaxContentLength: 1024 * 1024,
 			}
 			
-			resp, err := f.Fetch(req)
+			resp, err := f.Fetch(context.Background(), req)
 			if err != nil {
 				// Chrome might not be available, check for fallback
 				if engine == types.EngineChrome && strings.Contains(err.Error(), "Chrome") {
@@ -472,7 +700,7 @@ func TestContentSizeLimits(t *testing.T) {
 		MaxContentLength: 500, // Very small limit
 	}
 	
-	resp, err := f.Fetch(req)
+	resp, err := f.Fetch(context.Background(), req)
 	// Content size limit may cause an error or truncation
 	if err != nil {
 		// If there's an error, it should be due to content size limit
@@ -515,7 +743,7 @@ func TestChromeEngineWithJavaScript(t *testing.T) {
 		MaxContentLength: 1024 * 1024,
 	}
 	
-	resp, err := f.Fetch(req)
+	resp, err := f.Fetch(context.Background(), req)
 	if err != nil {
 		if strings.Contains(err.Error(), "Chrome") {
 			t.Skipf("Chrome not available: %v", err)
@@ -561,4 +789,4015 @@ func abs(x int) int {
 		return -x
 	}
 	return x
-}
\ No newline at end of file
+}
+
+// TestSuggestSelectors tests selector suggestions for extraction profiles
+func TestSuggestSelectors(t *testing.T) {
+	p := processor.NewProcessor()
+
+	html := `<html><head><title>Post</title></head><body>
+		<nav class="site-nav"><a href="/">Home</a><a href="/about">About</a></nav>
+		<div class="post-body">
+			<h1 class="post-title">A Long Story</h1>
+			<span class="byline">Jane Doe</span>
+			<time datetime="2024-03-01">March 1</time>
+			<p>` + strings.Repeat("Plenty of readable words here. ", 20) + `</p>
+			<p>` + strings.Repeat("More paragraph text follows. ", 20) + `</p>
+		</div>
+		<footer>Copyright</footer>
+	</body></html>`
+
+	suggestions, err := p.SuggestSelectors(html)
+	if err != nil {
+		t.Fatalf("SuggestSelectors failed: %v", err)
+	}
+
+	if len(suggestions.Body) == 0 || suggestions.Body[0].Selector != "div.post-body" {
+		t.Errorf("Expected body selector div.post-body, got %+v", suggestions.Body)
+	}
+	if len(suggestions.Title) == 0 || suggestions.Title[0].Selector != "h1.post-title" {
+		t.Errorf("Expected title selector h1.post-title, got %+v", suggestions.Title)
+	}
+	if len(suggestions.Date) == 0 || suggestions.Date[0].Selector != "time[datetime]" {
+		t.Errorf("Expected date selector time[datetime], got %+v", suggestions.Date)
+	}
+	if len(suggestions.Author) == 0 || suggestions.Author[0].Selector != "span.byline" {
+		t.Errorf("Expected author selector span.byline, got %+v", suggestions.Author)
+	}
+	if !strings.Contains(strings.Join(suggestions.Remove, " "), "nav.site-nav") {
+		t.Errorf("Expected nav.site-nav in remove list, got %v", suggestions.Remove)
+	}
+}
+
+// TestSessions tests named cookie jars and their persistence
+func TestSessions(t *testing.T) {
+	dir := t.TempDir()
+
+	m, err := session.NewManager(dir)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	if _, err := m.Get("../escape"); !errors.Is(err, session.ErrInvalidName) {
+		t.Errorf("Expected ErrInvalidName, got %v", err)
+	}
+
+	jira, err := m.Get("jira")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	u, _ := url.Parse("https://jira.example.com/login")
+	jira.SetCookies(u, []*http.Cookie{{Name: "sid", Value: "abc", MaxAge: 3600}})
+
+	other, _ := m.Get("other")
+	if len(other.Cookies(u)) != 0 {
+		t.Error("Expected sessions to be isolated")
+	}
+	if err := m.Save("jira"); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// A new manager over the same directory restores the jar
+	reloaded, err := session.NewManager(dir)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	restored, ok := reloaded.Lookup("jira")
+	if !ok {
+		t.Fatal("Expected jira session to be restored")
+	}
+	cookies := restored.Cookies(u)
+	if len(cookies) != 1 || cookies[0].Value != "abc" {
+		t.Errorf("Expected restored sid cookie, got %v", cookies)
+	}
+
+	infos := reloaded.List()
+	if len(infos) != 1 || infos[0].Cookies != 1 || infos[0].Domains[0] != "jira.example.com" {
+		t.Errorf("Unexpected session list: %+v", infos)
+	}
+
+	if deleted, err := reloaded.Delete("jira"); !deleted || err != nil {
+		t.Errorf("Expected delete to succeed, got %v, %v", deleted, err)
+	}
+	if _, ok := reloaded.Lookup("jira"); ok {
+		t.Error("Expected jira session to be gone")
+	}
+}
+
+// TestXPathExtraction tests XPath selection of elements and values
+func TestXPathExtraction(t *testing.T) {
+	p := processor.NewProcessor()
+
+	html := `<html><head><title>Report</title></head><body>
+		<div id="nav"><a href="/home">Home</a></div>
+		<table><tr><td>Subtotal</td><td>10</td></tr><tr><td>Total</td><td>42</td></tr></table>
+		<ul><li><a href="/a">A</a></li><li><a href="/b">B</a></li></ul>
+	</body></html>`
+
+	// Element matches are converted to the requested format
+	resp := &types.FetchResponse{Content: html, Format: types.FormatText}
+	if err := p.ProcessXPath(context.Background(), resp, "//tr[td[text()='Total']]"); err != nil {
+		t.Fatalf("ProcessXPath failed: %v", err)
+	}
+	if !strings.Contains(resp.Content, "42") || strings.Contains(resp.Content, "Subtotal") {
+		t.Errorf("Expected only the Total row, got '%s'", resp.Content)
+	}
+	if resp.Title != "Report" {
+		t.Errorf("Expected title from the full document, got '%s'", resp.Title)
+	}
+
+	// Attribute matches are returned one per line
+	resp = &types.FetchResponse{Content: html, Format: types.FormatText}
+	if err := p.ProcessXPath(context.Background(), resp, "//ul//a/@href"); err != nil {
+		t.Fatalf("ProcessXPath failed: %v", err)
+	}
+	if resp.Content != "/a\n/b" {
+		t.Errorf("Expected href values, got '%s'", resp.Content)
+	}
+
+	// Scalar expressions are returned as a single value
+	resp = &types.FetchResponse{Content: html, Format: types.FormatText}
+	if err := p.ProcessXPath(context.Background(), resp, "count(//li)"); err != nil {
+		t.Fatalf("ProcessXPath failed: %v", err)
+	}
+	if resp.Content != "2" {
+		t.Errorf("Expected count 2, got '%s'", resp.Content)
+	}
+
+	if err := processor.ValidateXPath("//div[@id="); err == nil {
+		t.Error("Expected invalid xpath to be rejected")
+	}
+}
+
+// TestProxy tests routing requests through a per-request proxy
+func TestProxy(t *testing.T) {
+	var proxied string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body>via proxy</body></html>"))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{BlockLocal: false, Timeout: 5 * time.Second}
+	engine := fetcher.NewHTTPEngine(cfg)
+
+	// The proxy answers for a host that doesn't resolve
+	req := &types.FetchRequest{URL: "http://proxied.invalid/page", MaxContentLength: 1024, Proxy: server.URL}
+	resp, err := engine.Fetch(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Fetch via proxy failed: %v", err)
+	}
+	if proxied != "http://proxied.invalid/page" || !strings.Contains(resp.Content, "via proxy") {
+		t.Errorf("Expected request to go through the proxy, got %q / %q", proxied, resp.Content)
+	}
+
+	// Local proxies are rejected when local access is blocked
+	blocked := fetcher.NewHTTPEngine(&config.Config{BlockLocal: true, Timeout: 5 * time.Second})
+	req = &types.FetchRequest{URL: "http://example.com", MaxContentLength: 1024, Proxy: server.URL}
+	if _, err := blocked.Fetch(context.Background(), req); !errors.Is(err, fetcher.ErrInvalidProxy) {
+		t.Errorf("Expected ErrInvalidProxy for local proxy, got %v", err)
+	}
+
+	if _, err := proxy.ParseURL("ftp://proxy:21"); err == nil {
+		t.Error("Expected ftp proxy scheme to be rejected")
+	}
+
+	// Chrome opens the page in a browser context routed through the proxy
+	f := fetcher.NewFetcher(cfg)
+	defer f.Close()
+	if !f.ChromeAvailable() {
+		t.Skip("Chrome not available")
+	}
+	req = &types.FetchRequest{URL: "http://proxied.invalid/chrome", Engine: types.EngineChrome, Proxy: server.URL}
+	resp, err = f.Fetch(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Chrome fetch via proxy failed: %v", err)
+	}
+	if proxied != "http://proxied.invalid/chrome" || !strings.Contains(resp.Content, "via proxy") {
+		t.Errorf("Expected Chrome's request to go through the proxy, got %q / %q", proxied, resp.Content)
+	}
+}
+
+// TestProxyPool tests proxy rotation and health checking
+func TestProxyPool(t *testing.T) {
+	urls := []string{"http://p1:8080", "http://p2:8080", "socks5://p3:1080"}
+
+	pool, err := proxy.NewPool(urls, proxy.RotationRoundRobin)
+	if err != nil {
+		t.Fatalf("NewPool failed: %v", err)
+	}
+	var picked []string
+	for i := 0; i < 4; i++ {
+		picked = append(picked, pool.Pick("example.com").Host)
+	}
+	if strings.Join(picked, ",") != "p1:8080,p2:8080,p3:1080,p1:8080" {
+		t.Errorf("Unexpected round-robin order: %v", picked)
+	}
+
+	// Repeated failures take a proxy out of rotation
+	p2 := pool.URLs()[1]
+	for i := 0; i < 3; i++ {
+		pool.ReportFailure(p2)
+	}
+	for i := 0; i < 6; i++ {
+		if pool.Pick("example.com") == p2 {
+			t.Fatal("Expected unhealthy proxy to be skipped")
+		}
+	}
+	if stats := pool.Stats(); stats[1].Healthy {
+		t.Errorf("Expected p2 to be reported unhealthy: %+v", stats[1])
+	}
+
+	// Sticky rotation keeps a domain on one proxy
+	sticky, _ := proxy.NewPool(urls, proxy.RotationSticky)
+	first := sticky.Pick("news.example.com")
+	for i := 0; i < 5; i++ {
+		if sticky.Pick("news.example.com") != first {
+			t.Fatal("Expected sticky rotation to reuse the same proxy")
+		}
+	}
+
+	if _, err := proxy.NewPool(urls, "random"); err == nil {
+		t.Error("Expected unknown rotation to be rejected")
+	}
+}
+
+// TestUserAgentPresets tests that presets resolve and headers match the UA
+func TestUserAgentPresets(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write([]byte("<html><body>ok</body></html>"))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{BlockLocal: false, Timeout: 5 * time.Second}
+	f := fetcher.NewFetcher(cfg)
+	defer f.Close()
+
+	req := &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP, UserAgent: "curl"}
+	if _, err := f.Fetch(context.Background(), req); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if got.Get("User-Agent") != types.UserAgentPresets["curl"] {
+		t.Errorf("Expected curl UA, got '%s'", got.Get("User-Agent"))
+	}
+	if got.Get("Sec-Fetch-Mode") != "" || got.Get("Sec-CH-UA") != "" {
+		t.Error("Expected no browser headers for curl")
+	}
+
+	req = &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP, UserAgent: "mobile-safari"}
+	if _, err := f.Fetch(context.Background(), req); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if !strings.Contains(got.Get("User-Agent"), "iPhone") || got.Get("Sec-Fetch-Mode") != "navigate" {
+		t.Errorf("Expected mobile Safari navigation headers, got %v", got)
+	}
+	if got.Get("Sec-CH-UA") != "" {
+		t.Error("Expected no client hints for Safari")
+	}
+}
+
+// TestClientHints tests that Chromium user agents get Sec-CH-UA hints that
+// agree with the UA string, and other browsers none
+func TestClientHints(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write([]byte("<html><body>ok</body></html>"))
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{BlockLocal: false, Timeout: 5 * time.Second})
+	defer f.Close()
+
+	tests := []struct {
+		userAgent string
+		brand     string
+		mobile    string
+		platform  string
+	}{
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			`"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`, "?0", `"Windows"`,
+		},
+		{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36 Edg/119.0.2151.97",
+			`"Not_A Brand";v="8", "Chromium";v="119", "Microsoft Edge";v="119"`, "?0", `"macOS"`,
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Mobile Safari/537.36",
+			`"Not_A Brand";v="8", "Chromium";v="121", "Google Chrome";v="121"`, "?1", `"Android"`,
+		},
+	}
+	for _, tt := range tests {
+		if _, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP, UserAgent: tt.userAgent}); err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+		if got.Get("Sec-CH-UA") != tt.brand || got.Get("Sec-CH-UA-Mobile") != tt.mobile || got.Get("Sec-CH-UA-Platform") != tt.platform {
+			t.Errorf("Unexpected client hints for %s: %q, %q, %q", tt.userAgent, got.Get("Sec-CH-UA"), got.Get("Sec-CH-UA-Mobile"), got.Get("Sec-CH-UA-Platform"))
+		}
+		if got.Get("User-Agent") != tt.userAgent || got.Get("Sec-Fetch-Mode") != "navigate" {
+			t.Errorf("Expected Chromium navigation headers, got %v", got)
+		}
+	}
+
+	firefox := "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"
+	if _, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP, UserAgent: firefox}); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if got.Get("Sec-CH-UA") != "" || got.Get("Sec-CH-UA-Platform") != "" {
+		t.Error("Expected no client hints for Firefox")
+	}
+	if got.Get("Accept-Language") != "en-US,en;q=0.5" {
+		t.Errorf("Expected Firefox's Accept-Language, got %q", got.Get("Accept-Language"))
+	}
+}
+
+// TestHeaderOrder tests that HTTP/1.1 requests put their headers on the
+// wire in the order the user agent's browser sends them, including on a
+// reused connection
+func TestHeaderOrder(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer listener.Close()
+
+	var mu sync.Mutex
+	var requests [][]string
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					requestLine, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					var names []string
+					for {
+						line, err := reader.ReadString('\n')
+						if err != nil {
+							return
+						}
+						line = strings.TrimRight(line, "\r\n")
+						if line == "" {
+							break
+						}
+						name, _, _ := strings.Cut(line, ":")
+						names = append(names, strings.ToLower(name))
+					}
+					if strings.HasPrefix(requestLine, "GET ") {
+						mu.Lock()
+						requests = append(requests, names)
+						mu.Unlock()
+					}
+					body := "<html><body>ok</body></html>"
+					fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+				}
+			}()
+		}
+	}()
+
+	f := fetcher.NewFetcher(&config.Config{BlockLocal: false, Timeout: 5 * time.Second})
+	defer f.Close()
+
+	chrome := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	firefox := "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"
+	tests := []struct {
+		userAgent string
+		want      []string
+	}{
+		{chrome, []string{"host", "cache-control", "sec-ch-ua", "sec-ch-ua-mobile", "sec-ch-ua-platform", "upgrade-insecure-requests", "user-agent", "accept", "sec-fetch-site", "sec-fetch-mode", "sec-fetch-user", "sec-fetch-dest", "accept-encoding", "accept-language"}},
+		{chrome, []string{"host", "cache-control", "sec-ch-ua", "sec-ch-ua-mobile", "sec-ch-ua-platform", "upgrade-insecure-requests", "user-agent", "accept", "sec-fetch-site", "sec-fetch-mode", "sec-fetch-user", "sec-fetch-dest", "accept-encoding", "accept-language"}},
+		{firefox, []string{"host", "user-agent", "accept", "accept-language", "accept-encoding", "upgrade-insecure-requests", "sec-fetch-dest", "sec-fetch-mode", "sec-fetch-site", "sec-fetch-user"}},
+	}
+	for i, tt := range tests {
+		url := fmt.Sprintf("http://%s/page%d", listener.Addr(), i)
+		if _, err := f.Fetch(context.Background(), &types.FetchRequest{URL: url, Engine: types.EngineHTTP, UserAgent: tt.userAgent}); err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+		mu.Lock()
+		got := requests[len(requests)-1]
+		mu.Unlock()
+		if len(got) < len(tt.want) || !reflect.DeepEqual(got[:len(tt.want)], tt.want) {
+			t.Errorf("Request %d: expected headers to start %v, got %v", i, tt.want, got)
+		}
+	}
+}
+
+// TestHeadPreflight tests when a HEAD preflight skips the download: for a
+// type we can't process or a size over the limit, on a host not fetched
+// successfully before
+func TestHeadPreflight(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/photo":
+			w.Header().Set("Content-Type", "image/jpeg")
+		case "/no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html")
+		default:
+			w.Header().Set("Content-Type", "text/html")
+		}
+		body := strings.Repeat("<p>page</p>", 200)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		if r.Method == http.MethodGet {
+			w.Write([]byte(body))
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		req       types.FetchRequest
+		skipped   bool
+		requested []string
+	}{
+		{"unprocessable type", types.FetchRequest{URL: server.URL + "/photo"}, true, []string{"HEAD /photo"}},
+		{"over the size limit", types.FetchRequest{URL: server.URL + "/page", MaxContentLength: 1000}, true, []string{"HEAD /page"}},
+		{"within the limits", types.FetchRequest{URL: server.URL + "/page"}, false, []string{"HEAD /page", "GET /page"}},
+		{"range ignores the size", types.FetchRequest{URL: server.URL + "/page", MaxContentLength: 1000, Range: &types.ByteRange{Start: 0, End: 99}}, false, []string{"HEAD /page", "GET /page"}},
+		{"HEAD not allowed", types.FetchRequest{URL: server.URL + "/no-head"}, false, []string{"HEAD /no-head", "GET /no-head"}},
+	}
+	for _, tt := range tests {
+		// A fresh fetcher, so the host hasn't been fetched from before
+		f := fetcher.NewFetcher(&config.Config{Timeout: 5 * time.Second, ConnectTimeout: 5 * time.Second})
+		requests = nil
+		req := tt.req
+		req.Engine = types.EngineHTTP
+		req.Preflight = true
+		resp, err := f.Fetch(context.Background(), &req)
+		f.Close()
+		if err != nil {
+			t.Errorf("%s: fetch failed: %v", tt.name, err)
+			continue
+		}
+		if resp.Skipped != tt.skipped {
+			t.Errorf("%s: expected skipped %v, got %+v", tt.name, tt.skipped, resp)
+		}
+		if !reflect.DeepEqual(requests, tt.requested) {
+			t.Errorf("%s: expected requests %v, got %v", tt.name, tt.requested, requests)
+		}
+	}
+
+	// A host fetched successfully before is trusted to serve what it says
+	f := fetcher.NewFetcher(&config.Config{Timeout: 5 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer f.Close()
+	f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL + "/page", Engine: types.EngineHTTP, Preflight: true})
+	requests = nil
+	resp, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL + "/page", Engine: types.EngineHTTP, Preflight: true, MaxContentLength: 100000})
+	if err != nil || resp.Skipped {
+		t.Fatalf("Expected a fetch without preflight, got %+v, %v", resp, err)
+	}
+	if !reflect.DeepEqual(requests, []string{"GET /page"}) {
+		t.Errorf("Expected no HEAD for a known host, got %v", requests)
+	}
+}
+
+// TestCharsetNormalization tests that text bodies are decoded to UTF-8 and
+// binary ones left byte for byte as they were
+func TestCharsetNormalization(t *testing.T) {
+	binary := []byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a, 0x1a, 0x0a, 0x80, 0x93, 0xe9, 0xff, 0x00}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latin":
+			w.Header().Set("Content-Type", "text/html; charset=windows-1252")
+			w.Write([]byte("<html><body><p>Caf\xe9 \x93quoted\x94</p></body></html>"))
+		case "/bom":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("\xef\xbb\xbf{\"name\": \"caf\xc3\xa9\"}"))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(binary)
+		case "/octets":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(binary)
+		}
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{BlockLocal: false, Timeout: 5 * time.Second})
+	defer f.Close()
+	fetch := func(path string) *types.FetchResponse {
+		t.Helper()
+		resp, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL + path, Engine: types.EngineHTTP})
+		if err != nil {
+			t.Fatalf("Fetch of %s failed: %v", path, err)
+		}
+		return resp
+	}
+
+	if resp := fetch("/latin"); !strings.Contains(resp.Content, "Café “quoted”") || resp.Charset != "windows-1252" {
+		t.Errorf("Expected windows-1252 decoded to UTF-8, got %q in %q", resp.Content, resp.Charset)
+	}
+	if resp := fetch("/bom"); resp.Content != `{"name": "café"}` || resp.Charset != "utf-8" {
+		t.Errorf("Expected UTF-8 without the BOM, got %q in %q", resp.Content, resp.Charset)
+	}
+	for _, path := range []string{"/image", "/octets"} {
+		if resp := fetch(path); resp.Content != string(binary) || resp.Charset != "" {
+			t.Errorf("Expected %s unchanged and without a charset, got %q in %q", path, resp.Content, resp.Charset)
+		}
+	}
+}
+
+// TestProtocolReporting tests that the negotiated protocol is reported
+func TestProtocolReporting(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><body>ok</body></html>"))
+	}))
+	defer server.Close()
+
+	engine := fetcher.NewHTTPEngine(&config.Config{BlockLocal: false, Timeout: 5 * time.Second})
+	for _, forceHTTP1 := range []bool{false, true} {
+		req := &types.FetchRequest{URL: server.URL, MaxContentLength: 1024, ForceHTTP1: forceHTTP1}
+		resp, err := engine.Fetch(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+		if resp.Protocol != "http/1.1" {
+			t.Errorf("Expected http/1.1 over plain HTTP, got '%s'", resp.Protocol)
+		}
+	}
+}
+
+// TestSignedURLs tests server-side HMAC signing of request URLs
+func TestSignedURLs(t *testing.T) {
+	t.Setenv("FETCH_URL_SECRET_TEST", "s3cret")
+
+	var query url.Values
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		path = r.URL.EscapedPath()
+		w.Write([]byte("<html><body>signed</body></html>"))
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{BlockLocal: false, Timeout: 5 * time.Second})
+	defer f.Close()
+
+	req := &types.FetchRequest{
+		URL:    server.URL + "/file?b=2&a=1",
+		Engine: types.EngineHTTP,
+		Sign:   &types.SignSpec{Secret: "test", ExpiresParam: "expires"},
+	}
+	resp, err := f.Fetch(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if resp.URL != server.URL+"/file?b=2&a=1" {
+		t.Errorf("Expected the unsigned URL in the response, got '%s'", resp.URL)
+	}
+
+	signature := query.Get("signature")
+	query.Del("signature")
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(path + "?" + query.Encode()))
+	if signature != hex.EncodeToString(mac.Sum(nil)) || query.Get("expires") == "" {
+		t.Errorf("Unexpected signature %q for query %v", signature, query)
+	}
+
+	req = &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP, Sign: &types.SignSpec{Secret: "missing"}}
+	if _, err := f.Fetch(context.Background(), req); !errors.Is(err, fetcher.ErrSigning) {
+		t.Errorf("Expected ErrSigning for an unset secret, got %v", err)
+	}
+}
+
+// TestHTTP3Fallback tests that the HTTP/3 engine falls back to TCP
+func TestHTTP3Fallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><body>over tcp</body></html>"))
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{BlockLocal: false, Timeout: 5 * time.Second})
+	defer f.Close()
+
+	// Plain http:// can't be spoken over QUIC, so this always falls back
+	resp, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP3})
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if resp.Protocol != "http/1.1" || !strings.Contains(resp.Content, "over tcp") {
+		t.Errorf("Expected the HTTP engine's response, got %+v", resp)
+	}
+	if len(resp.Warnings) == 0 || !strings.Contains(resp.Warnings[0], "fell back") {
+		t.Errorf("Expected a fallback warning, got %v", resp.Warnings)
+	}
+}
+
+// TestOAuth2ClientCredentials tests token acquisition and caching per domain
+func TestOAuth2ClientCredentials(t *testing.T) {
+	tokenRequests := 0
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"tok-123","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	var authorization string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.Write([]byte("<html><body>data</body></html>"))
+	}))
+	defer api.Close()
+
+	cfg := &config.Config{
+		BlockLocal: false,
+		Timeout:    5 * time.Second,
+		OAuth2: []config.OAuth2Client{{
+			Domains:      []string{"127.0.0.1"},
+			TokenURL:     tokenServer.URL,
+			ClientID:     "id",
+			ClientSecret: "secret",
+		}},
+	}
+	f := fetcher.NewFetcher(cfg)
+	defer f.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := f.Fetch(context.Background(), &types.FetchRequest{URL: api.URL, Engine: types.EngineHTTP}); err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+		if authorization != "Bearer tok-123" {
+			t.Errorf("Expected bearer token, got '%s'", authorization)
+		}
+	}
+	if tokenRequests != 1 {
+		t.Errorf("Expected the token to be cached, got %d token requests", tokenRequests)
+	}
+
+	// A token request is part of the fetch and stops when the fetch does
+	release := make(chan struct{})
+	slowTokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"tok-456","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer slowTokens.Close()
+	cfg.OAuth2[0].TokenURL = slowTokens.URL
+	f = fetcher.NewFetcher(cfg)
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := f.Fetch(ctx, &types.FetchRequest{URL: api.URL, Engine: types.EngineHTTP})
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 2*time.Second {
+		t.Errorf("Expected the token request to end with the fetch's deadline, got %v after %v", err, time.Since(start))
+	}
+	close(release)
+	if _, err := f.Fetch(context.Background(), &types.FetchRequest{URL: api.URL, Engine: types.EngineHTTP}); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if authorization != "Bearer tok-456" {
+		t.Errorf("Expected a token after the cancelled request, got '%s'", authorization)
+	}
+}
+
+// TestContentEncodings tests decoding of compressed responses
+func TestContentEncodings(t *testing.T) {
+	const body = "<html><body>encoded body</body></html>"
+	encoders := map[string]func(io.Writer) io.WriteCloser{
+		"br":      func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) },
+		"gzip":    func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate": func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+		"zstd": func(w io.Writer) io.WriteCloser {
+			zw, _ := zstd.NewWriter(w)
+			return zw
+		},
+	}
+
+	var acceptEncoding string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		coding := r.URL.Query().Get("coding")
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", coding)
+		enc := encoders[coding](w)
+		if r.URL.Query().Get("big") != "" {
+			enc.Write([]byte("<html><body>" + strings.Repeat("a", 64*1024) + "</body></html>"))
+		} else {
+			enc.Write([]byte(body))
+		}
+		enc.Close()
+	}))
+	defer server.Close()
+
+	engine := fetcher.NewHTTPEngine(&config.Config{BlockLocal: false, Timeout: 5 * time.Second})
+	for coding := range encoders {
+		resp, err := engine.Fetch(context.Background(), &types.FetchRequest{URL: server.URL + "/?coding=" + coding, MaxContentLength: 1024})
+		if err != nil {
+			t.Fatalf("%s: Fetch failed: %v", coding, err)
+		}
+		if !strings.Contains(resp.Content, "encoded body") {
+			t.Errorf("%s: Expected decoded content, got %q", coding, resp.Content)
+		}
+		if !strings.Contains(acceptEncoding, coding) {
+			t.Errorf("Expected %s to be advertised, got '%s'", coding, acceptEncoding)
+		}
+	}
+
+	// The size limit applies after decompression
+	_, err := engine.Fetch(context.Background(), &types.FetchRequest{URL: server.URL + "/?coding=zstd&big=1", MaxContentLength: 1024})
+	if !errors.Is(err, fetcher.ErrContentTooLarge) {
+		t.Errorf("Expected ErrContentTooLarge for a body that expands past the limit, got %v", err)
+	}
+}
+
+// TestCookieImport tests importing browser cookie exports into sessions
+func TestCookieImport(t *testing.T) {
+	m, err := session.NewManager("")
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+
+	netscape := "# Netscape HTTP Cookie File\n" +
+		".example.com\tTRUE\t/\tTRUE\t4102444800\tsid\tabc\n" +
+		"#HttpOnly_app.example.com\tFALSE\t/\tFALSE\t0\ttoken\txyz\n" +
+		"old.example.com\tFALSE\t/\tFALSE\t1\texpired\tgone\n"
+	imported, err := m.Import("browser", []byte(netscape))
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if imported != 2 {
+		t.Errorf("Expected 2 unexpired cookies, got %d", imported)
+	}
+
+	s, _ := m.Lookup("browser")
+	u, _ := url.Parse("https://app.example.com/")
+	names := []string{}
+	for _, c := range s.Cookies(u) {
+		names = append(names, c.Name)
+	}
+	if strings.Join(names, ",") != "sid,token" && strings.Join(names, ",") != "token,sid" {
+		t.Errorf("Expected domain and host cookies, got %v", names)
+	}
+
+	exported := `[{"name":"pref","value":"1","domain":"example.org","path":"/","hostOnly":true,"expirationDate":4102444800}]`
+	if imported, err := m.Import("browser", []byte(exported)); err != nil || imported != 1 {
+		t.Fatalf("JSON import failed: %d, %v", imported, err)
+	}
+	u, _ = url.Parse("http://example.org/")
+	if cookies := s.Cookies(u); len(cookies) != 1 || cookies[0].Value != "1" {
+		t.Errorf("Expected imported JSON cookie, got %v", cookies)
+	}
+
+	if _, err := m.Import("browser", []byte("not\ta\tcookie")); err == nil {
+		t.Error("Expected malformed export to be rejected")
+	}
+}
+
+// TestLoginFlows tests loading scripted login flows and running them by session
+func TestLoginFlows(t *testing.T) {
+	flows := `[{"session":"wiki","url":"https://wiki.example.com/login","username_selector":"#user",` +
+		`"username_env":"TEST_WIKI_USER","password_selector":"#pass","password_env":"TEST_WIKI_PASS",` +
+		`"submit_selector":"button"}]`
+	t.Setenv("FETCH_URL_LOGIN_FLOWS", flows)
+
+	if _, err := config.LoadConfig(); err == nil {
+		t.Error("Expected an error when the credential variables are unset")
+	}
+
+	t.Setenv("TEST_WIKI_USER", "alice")
+	t.Setenv("TEST_WIKI_PASS", "hunter2")
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if len(cfg.LoginFlows) != 1 || cfg.LoginFlows[0].Username != "alice" || cfg.LoginFlows[0].Password != "hunter2" {
+		t.Fatalf("Expected resolved login flow, got %+v", cfg.LoginFlows)
+	}
+
+	f := fetcher.NewFetcher(cfg)
+	defer f.Close()
+
+	if _, err := f.Login(context.Background(), "other"); !errors.Is(err, fetcher.ErrNoLoginFlow) {
+		t.Errorf("Expected ErrNoLoginFlow, got %v", err)
+	}
+	if !f.ChromeAvailable() {
+		if _, err := f.Login(context.Background(), "wiki"); !errors.Is(err, fetcher.ErrChromeUnavailable) {
+			t.Errorf("Expected ErrChromeUnavailable, got %v", err)
+		}
+	}
+}
+
+// TestCookieScope tests that request cookies with a domain or path are only
+// sent where they apply, and that a session hands over every cookie it holds
+func TestCookieScope(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("Cookie")
+		w.Write([]byte("<html><body>ok</body></html>"))
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{BlockLocal: false, Timeout: 5 * time.Second})
+	defer f.Close()
+	req := &types.FetchRequest{URL: server.URL + "/app/page", Engine: types.EngineHTTP, Cookies: []types.Cookie{
+		{Name: "plain", Value: "1"},
+		{Name: "here", Value: "2", Domain: "127.0.0.1", Path: "/app"},
+		{Name: "elsewhere", Value: "3", Domain: ".example.com"},
+		{Name: "other_path", Value: "4", Path: "/admin"},
+	}}
+	f.ApplyDefaults(req)
+	if _, err := f.Fetch(context.Background(), req); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if received != "plain=1; here=2" {
+		t.Errorf("Expected only the applicable cookies, got %q", received)
+	}
+
+	m, err := session.NewManager("")
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	s, err := m.Get("sso")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	app, _ := url.Parse("https://app.example.com/")
+	login, _ := url.Parse("https://login.example.net/")
+	s.SetCookies(app, []*http.Cookie{{Name: "sid", Value: "a"}})
+	s.SetCookies(login, []*http.Cookie{{Name: "sso", Value: "b", Domain: ".example.net"}, {Name: "gone", Value: "c", Expires: time.Now().Add(-time.Hour)}})
+	all := s.AllCookies()
+	if len(all) != 2 || len(all["https://app.example.com/"]) != 1 || len(all["https://login.example.net/"]) != 1 {
+		t.Errorf("Expected one unexpired cookie per origin, got %v", all)
+	}
+}
+
+// TestSessionExpiry tests that a session redirected to a login page is
+// marked expired and the caller is warned
+func TestSessionExpiry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			w.Write([]byte("<html><body><form>Sign in</form></body></html>"))
+			return
+		}
+		if _, err := r.Cookie("sid"); err != nil {
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.Path), http.StatusFound)
+			return
+		}
+		w.Write([]byte("<html><body>Dashboard</body></html>"))
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer f.Close()
+
+	s, err := f.Sessions().Get("app")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	u, _ := url.Parse(server.URL)
+	s.SetCookies(u, []*http.Cookie{{Name: "sid", Value: "abc"}})
+
+	resp, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL + "/dashboard", Session: "app"})
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if s.Expired() || len(resp.Warnings) != 0 {
+		t.Errorf("Expected a live session, got expired=%v warnings=%v", s.Expired(), resp.Warnings)
+	}
+
+	// The site forgets the login
+	s.SetCookies(u, []*http.Cookie{{Name: "sid", MaxAge: -1}})
+	resp, err = f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL + "/dashboard", Session: "app"})
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if !s.Expired() {
+		t.Error("Expected the session to be marked expired")
+	}
+	if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "session app has expired") {
+		t.Errorf("Expected an expiry warning, got %v", resp.Warnings)
+	}
+	if infos := f.Sessions().List(); len(infos) != 1 || !infos[0].Expired {
+		t.Errorf("Expected list_sessions to report expiry, got %+v", infos)
+	}
+
+	// Fetching the login page on purpose is not an expiry
+	s.Renew()
+	if _, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL + "/login", Session: "app"}); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if s.Expired() {
+		t.Error("Expected a direct login page fetch not to expire the session")
+	}
+}
+
+// TestFetchCancellation tests that a caller's deadline or cancellation aborts
+// the request in flight
+func TestFetchCancellation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+		w.Write([]byte("<html><body>Too late</body></html>"))
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{Timeout: 30 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := f.Fetch(ctx, &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP})
+	if !errors.Is(err, fetcher.ErrTimeout) {
+		t.Errorf("Expected ErrTimeout from the caller's deadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the fetch to stop at the deadline, took %v", elapsed)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := f.Fetch(cancelled, &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	p := processor.NewProcessor()
+	resp := &types.FetchResponse{Content: "<html><body>Hi</body></html>", Format: types.FormatText}
+	if err := p.Process(cancelled, resp); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected processing to stop for a cancelled caller, got %v", err)
+	}
+}
+
+// TestProvenance tests signing and verifying provenance records
+func TestProvenance(t *testing.T) {
+	fetchedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	record := provenance.New("1.2.3", "https://example.com", fetchedAt, "Example Domain", "engine=http;variant=text")
+
+	sum := sha256.Sum256([]byte("Example Domain"))
+	if record.ContentSHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Unexpected content hash %s", record.ContentSHA256)
+	}
+	if record.Verify([]byte("key")) {
+		t.Error("Expected an unsigned record not to verify")
+	}
+
+	record.Sign([]byte("key"))
+	if record.Algorithm != provenance.AlgorithmHMACSHA256 || !record.Verify([]byte("key")) {
+		t.Fatalf("Expected a verifiable signature, got %+v", record)
+	}
+	if record.Verify([]byte("other")) {
+		t.Error("Expected verification to fail with the wrong key")
+	}
+
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("1.2.3\nhttps://example.com\n2024-05-01T12:00:00Z\n" + record.ContentSHA256 + "\n" + record.OptionsHash))
+	if record.Signature != hex.EncodeToString(mac.Sum(nil)) {
+		t.Error("Expected the signature to cover the documented payload")
+	}
+
+	record.ContentSHA256 = strings.Repeat("0", 64)
+	if record.Verify([]byte("key")) {
+		t.Error("Expected a tampered record not to verify")
+	}
+}
+
+// TestComplianceBlocklist tests loading hosts and CSV blocklists and
+// rejecting matching URLs with a policy error
+func TestComplianceBlocklist(t *testing.T) {
+	dir := t.TempDir()
+	hostsFile := dir + "/blocked.hosts"
+	hosts := "# blocked hosts\n0.0.0.0 blocked.example\n127.0.0.1 localhost\nbare.example\n"
+	if err := os.WriteFile(hostsFile, []byte(hosts), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("domain,jurisdiction,reason\n" +
+			"gambling.example,DE,\"GlüStV, section 4\"\n" +
+			"https://news.example/banned/,,court order\n" +
+			"elsewhere.example,US,export control\n"))
+	}))
+	defer remote.Close()
+
+	cfg := &config.Config{
+		Timeout:       10 * time.Second,
+		Blocklists:    []string{hostsFile, remote.URL + "/list.csv"},
+		Jurisdictions: []string{"de"},
+	}
+	f := fetcher.NewFetcher(cfg)
+	defer f.Close()
+
+	tests := []struct {
+		url     string
+		blocked bool
+	}{
+		{"https://blocked.example/", true},
+		{"https://www.blocked.example/page", true},
+		{"https://notblocked.example/", false},
+		{"https://bare.example/", true},
+		{"https://gambling.example/", true},
+		{"https://elsewhere.example/", false},
+		{"https://news.example/banned/story", true},
+		{"https://news.example/allowed/story", false},
+		{"http://localhost/", false},
+	}
+	for _, tt := range tests {
+		err := f.CheckPolicy(tt.url)
+		if tt.blocked != errors.Is(err, fetcher.ErrBlockedByPolicy) {
+			t.Errorf("CheckPolicy(%s) = %v, want blocked=%v", tt.url, err, tt.blocked)
+		}
+	}
+
+	if err := f.CheckPolicy("https://gambling.example/"); err == nil || !strings.Contains(err.Error(), "section 4") {
+		t.Errorf("Expected the reason in the policy error, got %v", err)
+	}
+	if _, err := f.Fetch(context.Background(), &types.FetchRequest{URL: "https://blocked.example/"}); !errors.Is(err, fetcher.ErrBlockedByPolicy) {
+		t.Errorf("Expected Fetch to refuse a blocked URL, got %v", err)
+	}
+
+	status := f.BlocklistStatus()
+	if status == nil || !status.Loaded || status.Domains != 3 || status.URLs != 1 {
+		t.Errorf("Unexpected blocklist status: %+v", status)
+	}
+
+	// A blocklist that never loads refuses everything
+	missing := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, Blocklists: []string{dir + "/missing.hosts"}})
+	defer missing.Close()
+	if err := missing.CheckPolicy("https://example.com/"); !errors.Is(err, fetcher.ErrBlockedByPolicy) {
+		t.Errorf("Expected an unloaded blocklist to fail closed, got %v", err)
+	}
+}
+
+// TestRedirectChain tests that each redirect hop and the final URL are
+// reported
+func TestRedirectChain(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/moved", http.StatusMovedPermanently)
+		case "/moved":
+			http.Redirect(w, r, "/new", http.StatusFound)
+		default:
+			w.Write([]byte("<html><body>New home</body></html>"))
+		}
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer f.Close()
+
+	resp, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL + "/old", Engine: types.EngineHTTP})
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if resp.FinalURL != server.URL+"/new" {
+		t.Errorf("Expected final URL %s/new, got %s", server.URL, resp.FinalURL)
+	}
+	expected := []types.Redirect{
+		{URL: server.URL + "/old", StatusCode: http.StatusMovedPermanently, Location: server.URL + "/moved"},
+		{URL: server.URL + "/moved", StatusCode: http.StatusFound, Location: server.URL + "/new"},
+	}
+	if !reflect.DeepEqual(resp.Redirects, expected) {
+		t.Errorf("Unexpected redirect chain %+v", resp.Redirects)
+	}
+
+	resp, err = f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL + "/new", Engine: types.EngineHTTP})
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if len(resp.Redirects) != 0 || resp.FinalURL != server.URL+"/new" {
+		t.Errorf("Expected no redirects, got %+v ending at %s", resp.Redirects, resp.FinalURL)
+	}
+}
+
+// TestMaxRedirects tests the redirect limit from config and per request,
+// including returning the redirect itself when following is disabled
+func TestMaxRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var hop int
+		if _, err := fmt.Sscanf(r.URL.Path, "/hop/%d", &hop); err == nil && hop > 0 {
+			http.Redirect(w, r, fmt.Sprintf("/hop/%d", hop-1), http.StatusFound)
+			return
+		}
+		w.Write([]byte("<html><body>Arrived</body></html>"))
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second, MaxRedirects: 2})
+	defer f.Close()
+	ctx := context.Background()
+
+	if _, err := f.Fetch(ctx, &types.FetchRequest{URL: server.URL + "/hop/2", Engine: types.EngineHTTP}); err != nil {
+		t.Errorf("Expected 2 redirects to be followed, got %v", err)
+	}
+	if _, err := f.Fetch(ctx, &types.FetchRequest{URL: server.URL + "/hop/3", Engine: types.EngineHTTP}); !errors.Is(err, fetcher.ErrTooManyRedirects) {
+		t.Errorf("Expected ErrTooManyRedirects, got %v", err)
+	}
+
+	limit := 3
+	if _, err := f.Fetch(ctx, &types.FetchRequest{URL: server.URL + "/hop/3", Engine: types.EngineHTTP, MaxRedirects: &limit}); err != nil {
+		t.Errorf("Expected the per-request limit to allow 3 redirects, got %v", err)
+	}
+
+	limit = 0
+	resp, err := f.Fetch(ctx, &types.FetchRequest{URL: server.URL + "/hop/3", Engine: types.EngineHTTP, MaxRedirects: &limit})
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusFound || resp.Location != server.URL+"/hop/2" || len(resp.Redirects) != 0 {
+		t.Errorf("Expected the unfollowed redirect to /hop/2, got status %d location %q", resp.StatusCode, resp.Location)
+	}
+}
+
+// TestOutline tests condensing oversized markdown to its headings and lead
+// sentences
+func TestOutline(t *testing.T) {
+	markdown := "Welcome to the guide. It covers everything.\n\n# Install\n\nRun the installer. Then restart.\n\nMore detail here.\n\n## Configure\n\n```\nkey=value\n```\n\nEdit the file! Save it."
+
+	expected := "Welcome to the guide.\n\n# Install\n\nRun the installer.\n\n## Configure\n\nEdit the file!"
+	if outline := processor.Outline(markdown, 0); outline != expected {
+		t.Errorf("Unexpected outline %q", outline)
+	}
+
+	if outline := processor.Outline(markdown, 45); outline != "Welcome to the guide.\n\n# Install" {
+		t.Errorf("Expected the outline to stop within the limit, got %q", outline)
+	}
+
+	if digest := processor.Outline("First. Second.\n\nThird point. Fourth.", 0); digest != "First.\n\nThird point." {
+		t.Errorf("Expected lead sentences of each paragraph without headings, got %q", digest)
+	}
+}
+
+// TestTruncate tests cutting content at a paragraph boundary with a marker
+// and an outline of the omitted sections
+func TestTruncate(t *testing.T) {
+	markdown := "# Guide\n\nFirst paragraph here. It continues for a while, so that there is something to keep.\n\n## Install\n\nRun the installer.\n\n### Linux\n\nUse the package.\n\n## Usage\n\nRun it."
+
+	expected := "# Guide\n\nFirst paragraph here. It continues for a while, so that\n\n[truncated at 64 of 172 chars]\n\nOmitted sections:\n- Install\n  - Linux\n- Usage"
+	if truncated := processor.Truncate(markdown, 150); truncated != expected {
+		t.Errorf("Unexpected truncation %q", truncated)
+	}
+	if processor.Truncate(markdown, 150) != processor.Truncate(markdown, 150) {
+		t.Error("Expected truncation to be deterministic")
+	}
+
+	// The marker and outline count towards the limit
+	var long strings.Builder
+	for i := 0; i < 80; i++ {
+		fmt.Fprintf(&long, "## Section %d\n\nBody text for this section.\n\n", i)
+	}
+	for _, content := range []string{markdown, long.String()} {
+		for limit := 1; limit < utf8.RuneCountInString(content); limit++ {
+			if truncated := processor.Truncate(content, limit); utf8.RuneCountInString(truncated) > limit {
+				t.Fatalf("Expected at most %d chars, got %d: %q", limit, utf8.RuneCountInString(truncated), truncated)
+			}
+		}
+	}
+	if truncated := processor.Truncate(long.String(), 300); !strings.HasSuffix(truncated, "\n- Section 10\n- … and 69 more") {
+		t.Errorf("Expected the outline to be shortened to fit, got %q", truncated)
+	}
+
+	if truncated := processor.Truncate("one two three four five six seven eight nine ten eleven twelve", 50); truncated != "one two three four\n\n[truncated at 18 of 62 chars]" {
+		t.Errorf("Expected a cut at a word boundary, got %q", truncated)
+	}
+	if truncated := processor.Truncate("one two three four five six seven", 20); truncated != "one two three four" {
+		t.Errorf("Expected a bare cut when the marker doesn't fit, got %q", truncated)
+	}
+
+	if processor.Truncate(markdown, 1000) != markdown {
+		t.Error("Expected content within the limit to be unchanged")
+	}
+}
+
+// TestUnsafeRedirect tests that a redirect from an allowed host to a local
+// address is rejected when local addresses are blocked
+func TestUnsafeRedirect(t *testing.T) {
+	// Acting as the egress proxy lets a "public" host answer the request
+	var proxied []string
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		switch r.URL.Path {
+		case "/internal":
+			http.Redirect(w, r, "http://10.0.0.5/admin", http.StatusFound)
+		case "/elsewhere":
+			http.Redirect(w, r, "http://other.example/page", http.StatusMovedPermanently)
+		default:
+			w.Write([]byte("<html><body>Public page</body></html>"))
+		}
+	}))
+	defer proxyServer.Close()
+
+	f := fetcher.NewFetcher(&config.Config{
+		BlockLocal:     true,
+		Timeout:        10 * time.Second,
+		ConnectTimeout: 5 * time.Second,
+		Proxies:        []string{proxyServer.URL},
+	})
+	defer f.Close()
+	ctx := context.Background()
+
+	_, err := f.Fetch(ctx, &types.FetchRequest{URL: "http://redirector.example/internal", Engine: types.EngineHTTP})
+	if !errors.Is(err, fetcher.ErrUnsafeRedirect) || !errors.Is(err, fetcher.ErrBlockedLocal) {
+		t.Fatalf("Expected the redirect to a private address to be rejected, got %v", err)
+	}
+	if len(proxied) != 1 {
+		t.Errorf("Expected the blocked hop not to be requested, got %v", proxied)
+	}
+
+	resp, err := f.Fetch(ctx, &types.FetchRequest{URL: "http://redirector.example/elsewhere", Engine: types.EngineHTTP})
+	if err != nil {
+		t.Fatalf("Expected a redirect to another public host to be followed, got %v", err)
+	}
+	if resp.FinalURL != "http://other.example/page" {
+		t.Errorf("Unexpected final URL %s", resp.FinalURL)
+	}
+
+	// Metadata services are refused on a redirect even without BlockLocal
+	metadataRedirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer metadataRedirect.Close()
+	open := fetcher.NewFetcher(&config.Config{BlockLocal: false, Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer open.Close()
+	_, err = open.Fetch(ctx, &types.FetchRequest{URL: metadataRedirect.URL, Engine: types.EngineHTTP})
+	if !errors.Is(err, fetcher.ErrUnsafeRedirect) || !errors.Is(err, fetcher.ErrBlockedMetadata) {
+		t.Errorf("Expected the redirect to a metadata address to be rejected, got %v", err)
+	}
+}
+
+// TestUserAgentFallback tests retrying a 403 with the configured fallback
+// user agent profiles
+func TestUserAgentFallback(t *testing.T) {
+	var agents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents = append(agents, r.UserAgent())
+		if !strings.HasPrefix(r.UserAgent(), "curl/") {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("<html><body>Plain clients welcome</body></html>"))
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{
+		Timeout:        10 * time.Second,
+		ConnectTimeout: 5 * time.Second,
+		UAFallback:     []string{"googlebot", "curl"},
+	})
+	defer f.Close()
+
+	resp, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP})
+	if err != nil {
+		t.Fatalf("Expected the curl profile to succeed, got %v", err)
+	}
+	if resp.UserAgentProfile != "curl" || len(agents) != 3 {
+		t.Errorf("Expected success as curl on the third attempt, got %q after %v", resp.UserAgentProfile, agents)
+	}
+
+	noFallback := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer noFallback.Close()
+	_, err = noFallback.Fetch(context.Background(), &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP})
+	var statusErr *fetcher.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a 403 without fallback profiles, got %v", err)
+	}
+}
+
+// TestCloudMetadataBlocked tests that metadata endpoints are refused however
+// their address is written, even with local blocking off
+func TestCloudMetadataBlocked(t *testing.T) {
+	f := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer f.Close()
+
+	urls := []string{
+		"http://169.254.169.254/latest/meta-data/",
+		"http://metadata.google.internal/computeMetadata/v1/",
+		"http://METADATA.GOOGLE.INTERNAL./computeMetadata/v1/",
+		"http://100.100.100.200/latest/meta-data/",
+		"http://[fd00:ec2::254]/latest/meta-data/",
+		"http://[::ffff:169.254.169.254]/latest/meta-data/",
+		"http://[::ffff:a9fe:a9fe]/latest/meta-data/",
+		"http://[64:ff9b::a9fe:a9fe]/latest/meta-data/",
+		"http://2852039166/latest/meta-data/",
+		"http://0xa9fea9fe/latest/meta-data/",
+		"http://0251.0376.0251.0376/latest/meta-data/",
+		"http://169.254.43518/latest/meta-data/",
+		"http://0xa9.254.0251.254/latest/meta-data/",
+	}
+	for _, u := range urls {
+		_, err := f.Fetch(context.Background(), &types.FetchRequest{URL: u, Engine: types.EngineHTTP})
+		if !errors.Is(err, fetcher.ErrBlockedMetadata) {
+			t.Errorf("Expected %s to be blocked as a metadata endpoint, got %v", u, err)
+		}
+	}
+
+	blockLocal := fetcher.NewFetcher(&config.Config{BlockLocal: true, Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer blockLocal.Close()
+	for _, u := range []string{"http://2130706433/", "http://0x7f.1/", "http://0.0.0.0/"} {
+		_, err := blockLocal.Fetch(context.Background(), &types.FetchRequest{URL: u, Engine: types.EngineHTTP})
+		if !errors.Is(err, fetcher.ErrBlockedLocal) {
+			t.Errorf("Expected %s to be blocked as local, got %v", u, err)
+		}
+	}
+}
+
+// TestChromeMetadataBlocked tests that the Chrome engine doesn't reach
+// metadata services either, directly or through a redirect of the page
+func TestChromeMetadataBlocked(t *testing.T) {
+	f := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer f.Close()
+
+	for _, u := range []string{"http://169.254.169.254/latest/meta-data/", "http://metadata.google.internal/computeMetadata/v1/"} {
+		_, err := f.Fetch(context.Background(), &types.FetchRequest{URL: u, Engine: types.EngineChrome})
+		if !errors.Is(err, fetcher.ErrBlockedMetadata) {
+			t.Errorf("Expected %s to be blocked as a metadata endpoint, got %v", u, err)
+		}
+	}
+
+	blockLocal := fetcher.NewFetcher(&config.Config{BlockLocal: true, Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer blockLocal.Close()
+	if _, err := blockLocal.Fetch(context.Background(), &types.FetchRequest{URL: "http://127.0.0.1/", Engine: types.EngineChrome}); !errors.Is(err, fetcher.ErrBlockedLocal) {
+		t.Errorf("Expected a local URL to be blocked, got %v", err)
+	}
+
+	if !f.ChromeAvailable() {
+		t.Skip("Chrome not available")
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer server.Close()
+	_, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL, Engine: types.EngineChrome})
+	if !errors.Is(err, fetcher.ErrUnsafeRedirect) || !errors.Is(err, fetcher.ErrBlockedMetadata) {
+		t.Errorf("Expected a redirect to metadata to be blocked, got %v", err)
+	}
+}
+
+// TestDomainLists tests the domain allowlist and denylist, including on
+// redirect hops
+func TestDomainLists(t *testing.T) {
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/leave" {
+			http.Redirect(w, r, "http://elsewhere.example/", http.StatusFound)
+			return
+		}
+		w.Write([]byte("<html><body>Approved</body></html>"))
+	}))
+	defer proxyServer.Close()
+
+	f := fetcher.NewFetcher(&config.Config{
+		Timeout:        10 * time.Second,
+		ConnectTimeout: 5 * time.Second,
+		Proxies:        []string{proxyServer.URL},
+		AllowDomains:   []string{"docs.example", "*.wiki.example"},
+		DenyDomains:    []string{"private.docs.example"},
+	})
+	defer f.Close()
+
+	allowed := []string{"http://docs.example/", "http://api.docs.example/", "http://en.wiki.example/"}
+	for _, u := range allowed {
+		if err := f.CheckPolicy(u); err != nil {
+			t.Errorf("Expected %s to be allowed, got %v", u, err)
+		}
+	}
+	denied := []string{"http://private.docs.example/", "http://a.private.docs.example/", "http://wiki.example/", "http://notdocs.example/"}
+	for _, u := range denied {
+		if err := f.CheckPolicy(u); !errors.Is(err, fetcher.ErrDomainNotAllowed) {
+			t.Errorf("Expected %s to be refused, got %v", u, err)
+		}
+	}
+
+	if _, err := f.Fetch(context.Background(), &types.FetchRequest{URL: "http://docs.example/", Engine: types.EngineHTTP}); err != nil {
+		t.Errorf("Expected an allowed fetch to succeed, got %v", err)
+	}
+	_, err := f.Fetch(context.Background(), &types.FetchRequest{URL: "http://docs.example/leave", Engine: types.EngineHTTP})
+	if !errors.Is(err, fetcher.ErrUnsafeRedirect) || !errors.Is(err, fetcher.ErrDomainNotAllowed) {
+		t.Errorf("Expected a redirect off the allowlist to be refused, got %v", err)
+	}
+}
+
+// TestSummarizer tests the extractive summarizer and the external service
+// protocol
+func TestSummarizer(t *testing.T) {
+	text := "Go is a programming language designed at Google for building reliable software.\n" +
+		"The weather was pleasant on the day of the first public announcement.\n" +
+		"Go programs compile quickly and the language has built-in concurrency support.\n" +
+		"Short line.\n" +
+		"Many companies now use the Go programming language for reliable network software."
+
+	summary, err := summarizer.Extractive{}.Summarize(context.Background(), text, 2)
+	if err != nil {
+		t.Fatalf("Summarize failed: %v", err)
+	}
+	expected := "Go is a programming language designed at Google for building reliable software. " +
+		"Many companies now use the Go programming language for reliable network software."
+	if summary != expected {
+		t.Errorf("Unexpected summary %q", summary)
+	}
+
+	if summary, _ := (summarizer.Extractive{}).Summarize(context.Background(), text, 10); strings.Contains(summary, "Short line") {
+		t.Errorf("Expected fragments to be left out, got %q", summary)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Text      string `json:"text"`
+			Sentences int    `json:"sentences"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || r.Method != http.MethodPost {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"summary": fmt.Sprintf("%d sentences of %d chars", body.Sentences, len(body.Text))})
+	}))
+	defer server.Close()
+
+	summary, err = summarizer.NewHTTP(server.URL, 5*time.Second).Summarize(context.Background(), "some text", 3)
+	if err != nil || summary != "3 sentences of 9 chars" {
+		t.Errorf("Unexpected external summary %q (%v)", summary, err)
+	}
+}
+
+// TestSchemePolicy tests restricting and extending the accepted URL schemes,
+// including inline data: URLs
+func TestSchemePolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><body>Plain HTTP</body></html>"))
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	httpsOnly := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second, Schemes: []string{"https"}})
+	defer httpsOnly.Close()
+	for _, engine := range []string{types.EngineHTTP, types.EngineChrome} {
+		if _, err := httpsOnly.Fetch(ctx, &types.FetchRequest{URL: server.URL, Engine: engine}); !errors.Is(err, fetcher.ErrUnsupportedScheme) {
+			t.Errorf("Expected http to be refused for the %s engine, got %v", engine, err)
+		}
+	}
+
+	defaults := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer defaults.Close()
+	if _, err := defaults.Fetch(ctx, &types.FetchRequest{URL: "data:text/plain,hello"}); !errors.Is(err, fetcher.ErrUnsupportedScheme) {
+		t.Errorf("Expected data URLs to be refused by default, got %v", err)
+	}
+
+	withData := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second, Schemes: []string{"http", "https", "data"}})
+	defer withData.Close()
+	resp, err := withData.Fetch(ctx, &types.FetchRequest{URL: "data:text/html;base64,PGgxPkRvY3M8L2gxPg=="})
+	if err != nil {
+		t.Fatalf("Expected a base64 data URL to be decoded, got %v", err)
+	}
+	if resp.Content != "<h1>Docs</h1>" || resp.ContentType != "text/html" {
+		t.Errorf("Unexpected data URL response %q (%s)", resp.Content, resp.ContentType)
+	}
+	resp, err = withData.Fetch(ctx, &types.FetchRequest{URL: "data:,Hello%2C%20World"})
+	if err != nil || resp.Content != "Hello, World" || resp.ContentType != "text/plain" {
+		t.Errorf("Unexpected percent-encoded data URL response %+v (%v)", resp, err)
+	}
+}
+
+// TestCrawler tests breadth-first crawling with depth, include/exclude globs,
+// deduplication, breadcrumbs, the page limit and loading presets
+func TestCrawler(t *testing.T) {
+	pages := map[string]string{
+		"/":            `<a href="/docs/a">A</a> <a href="/docs/b#top">B</a> <a href="/blog/post">Blog</a> <a href="https://elsewhere.example/docs/x">Away</a>`,
+		"/docs/a":      `<a href="/docs/b">B again</a> <a href="/docs/deep/c">C</a>`,
+		"/docs/b":      `<a href="/">Home</a>`,
+		"/docs/deep/c": `<a href="/docs/deep/d">D</a>`,
+		"/docs/deep/d": `Too deep`,
+		"/blog/post":   `Blog post`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "<html><head><title>%s</title></head><body>%s</body></html>", r.URL.Path, body)
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer f.Close()
+	proc := processor.NewProcessor()
+
+	var requested []string
+	fetch := func(ctx context.Context, rawURL string) (crawler.Page, []types.Link) {
+		requested = append(requested, strings.TrimPrefix(rawURL, server.URL))
+		resp, err := f.Fetch(ctx, &types.FetchRequest{URL: rawURL, Engine: types.EngineHTTP})
+		if err != nil {
+			return crawler.Page{Error: err.Error()}, nil
+		}
+		return crawler.Page{StatusCode: resp.StatusCode}, proc.ExtractLinks(resp.Content, rawURL)
+	}
+
+	spec := crawler.Spec{Seeds: []string{server.URL + "/"}, Depth: 2, Exclude: []string{"/blog/**"}}
+	result, err := crawler.Run(context.Background(), spec, fetch, nil)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	expected := []string{"/", "/docs/a", "/docs/b", "/docs/deep/c"}
+	if !reflect.DeepEqual(requested, expected) {
+		t.Errorf("Expected %v to be fetched, got %v", expected, requested)
+	}
+	if result.Fetched != 4 || result.StopReason != crawler.StopCompleted {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if page := result.Pages[3]; page.Depth != 2 || page.Parent != server.URL+"/docs/a" || page.AnchorText != "C" {
+		t.Errorf("Unexpected breadcrumb %+v", page)
+	}
+	if page := result.Pages[0]; page.Parent != "" || page.AnchorText != "" {
+		t.Errorf("Expected no breadcrumb on the seed, got %+v", page)
+	}
+
+	requested = nil
+	spec = crawler.Spec{Seeds: []string{server.URL + "/"}, Depth: 3, Include: []string{"/docs/*"}, MaxPages: 2}
+	result, err = crawler.Run(context.Background(), spec, fetch, nil)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !reflect.DeepEqual(requested, []string{"/", "/docs/a"}) || result.StopReason != crawler.StopMaxPages {
+		t.Errorf("Expected the page limit to stop the crawl, fetched %v (%s)", requested, result.StopReason)
+	}
+
+	if _, err := crawler.Run(context.Background(), crawler.Spec{Seeds: []string{server.URL}, Depth: 9}, fetch, nil); err == nil {
+		t.Error("Expected an excessive depth to be rejected")
+	}
+
+	presetsFile := t.TempDir() + "/presets.json"
+	os.WriteFile(presetsFile, []byte(`{"docs": {"seeds": ["https://docs.example.com/"], "depth": 2, "include": ["/docs/**"]}}`), 0o644)
+	presets, err := crawler.LoadPresets(presetsFile)
+	if err != nil {
+		t.Fatalf("LoadPresets failed: %v", err)
+	}
+	if presets["docs"].Depth != 2 || len(presets["docs"].Include) != 1 {
+		t.Errorf("Unexpected presets %+v", presets)
+	}
+	os.WriteFile(presetsFile, []byte(`{"broken": {"depth": 1}}`), 0o644)
+	if _, err := crawler.LoadPresets(presetsFile); err == nil {
+		t.Error("Expected a preset without seeds to be rejected")
+	}
+}
+
+// TestCrawlFilters tests the include/exclude pattern forms on batch seeds
+func TestCrawlFilters(t *testing.T) {
+	seeds := []string{
+		"https://example.com/docs/guide",
+		"https://example.com/docs/manual.pdf",
+		"https://example.com/blog/post",
+		"https://example.com/blogroll",
+		"https://example.com/docs/list?sort=asc",
+		"https://example.com/v2/api",
+	}
+
+	cases := []struct {
+		include  []string
+		exclude  []string
+		expected []string
+	}{
+		{[]string{"/docs/**"}, []string{"*.pdf", "query"}, []string{"/docs/guide"}},
+		{nil, []string{"/blog"}, []string{"/docs/guide", "/docs/manual.pdf", "/blogroll", "/docs/list?sort=asc", "/v2/api"}},
+		{[]string{"query:sort", "re:/v[0-9]+/"}, nil, []string{"/docs/list?sort=asc", "/v2/api"}},
+		{[]string{"https://example.com/blog*"}, nil, []string{"/blogroll"}},
+	}
+	for _, c := range cases {
+		var fetched []string
+		fetch := func(ctx context.Context, rawURL string) (crawler.Page, []types.Link) {
+			fetched = append(fetched, strings.TrimPrefix(rawURL, "https://example.com"))
+			return crawler.Page{StatusCode: http.StatusOK}, nil
+		}
+		spec := crawler.Spec{Seeds: seeds, Include: c.include, Exclude: c.exclude}
+		result, err := crawler.Run(context.Background(), spec, fetch, nil)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if !reflect.DeepEqual(fetched, c.expected) {
+			t.Errorf("include %v exclude %v: expected %v, got %v", c.include, c.exclude, c.expected, fetched)
+		}
+		if result.Filtered != len(seeds)-len(c.expected) {
+			t.Errorf("Expected %d filtered, got %d", len(seeds)-len(c.expected), result.Filtered)
+		}
+	}
+
+	if err := (&crawler.Spec{Seeds: seeds, Include: []string{"re:("}}).Validate(); err == nil {
+		t.Error("Expected an invalid regular expression to be rejected")
+	}
+}
+
+// TestCrawlBudgets tests that crawls stop at their byte and time budgets and
+// report why
+func TestCrawlBudgets(t *testing.T) {
+	// Every page links to two new ones, so the crawl never runs out of work
+	var count int
+	fetch := func(ctx context.Context, rawURL string) (crawler.Page, []types.Link) {
+		count++
+		links := []types.Link{
+			{URL: fmt.Sprintf("https://example.com/%d", 2*count)},
+			{URL: fmt.Sprintf("https://example.com/%d", 2*count+1)},
+		}
+		return crawler.Page{StatusCode: http.StatusOK, Bytes: 400}, links
+	}
+
+	spec := crawler.Spec{Seeds: []string{"https://example.com/"}, Depth: 5, MaxBytes: 1000}
+	result, err := crawler.Run(context.Background(), spec, fetch, nil)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result.StopReason != crawler.StopMaxBytes || result.Fetched != 3 || result.Bytes != 1200 {
+		t.Errorf("Expected the byte budget to stop after 3 pages, got %+v", result)
+	}
+
+	slow := func(ctx context.Context, rawURL string) (crawler.Page, []types.Link) {
+		if rawURL == "https://example.com/slow" {
+			<-ctx.Done()
+			return crawler.Page{Error: ctx.Err().Error()}, nil
+		}
+		return crawler.Page{StatusCode: http.StatusOK}, []types.Link{{URL: "https://example.com/slow"}}
+	}
+	spec = crawler.Spec{Seeds: []string{"https://example.com/"}, Depth: 1, MaxDurationSeconds: 1}
+	result, err = crawler.Run(context.Background(), spec, slow, nil)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result.StopReason != crawler.StopMaxDuration || len(result.Pages) != 1 || result.DurationMs < 1000 {
+		t.Errorf("Expected the time budget to abandon the slow page, got %+v", result)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, _ = crawler.Run(ctx, spec, slow, nil)
+	if result.StopReason != crawler.StopCancelled {
+		t.Errorf("Expected a cancelled crawl, got %s", result.StopReason)
+	}
+
+	if err := (&crawler.Spec{Seeds: spec.Seeds, MaxBytes: 2 * crawler.MaxBytes}).Validate(); err == nil {
+		t.Error("Expected a byte budget over the maximum to be rejected")
+	}
+}
+
+// TestRequestTiming tests the timing breakdown of an HTTP fetch
+func TestRequestTiming(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("<html><body>first half"))
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(" second half</body></html>"))
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer f.Close()
+
+	resp, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP})
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	timing := resp.Timing
+	if timing == nil {
+		t.Fatal("Expected a timing breakdown")
+	}
+	if timing.TTFBMs < 90 || timing.DownloadMs < 90 {
+		t.Errorf("Expected about 100ms to first byte and 100ms downloading, got %+v", timing)
+	}
+	if timing.TTFBMs+timing.DownloadMs > resp.FetchTimeMs {
+		t.Errorf("Phases %+v exceed the total of %dms", timing, resp.FetchTimeMs)
+	}
+	if timing.ConnectionReused || timing.TLSMs != 0 {
+		t.Errorf("Expected a new plain HTTP connection, got %+v", timing)
+	}
+}
+
+// TestRetryPolicy tests retrying configured statuses with backoff, per-request
+// overrides and giving up on statuses the policy doesn't retry
+func TestRetryPolicy(t *testing.T) {
+	var requests int
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		times = append(times, time.Now())
+		if r.URL.Path == "/flaky" && requests < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if r.URL.Path == "/teapot" {
+			w.WriteHeader(http.StatusTeapot)
+			return
+		}
+		w.Write([]byte("<html><body>ok</body></html>"))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Timeout:        10 * time.Second,
+		ConnectTimeout: 5 * time.Second,
+		Retry:          types.RetryPolicy{BaseDelayMs: 50, Jitter: 0.01},
+	}
+	f := fetcher.NewFetcher(cfg)
+	defer f.Close()
+
+	resp, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL + "/flaky", Engine: types.EngineHTTP})
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if resp.Attempts != 3 || requests != 3 {
+		t.Errorf("Expected success on the third attempt, got %d attempts and %d requests", resp.Attempts, requests)
+	}
+	if first, second := times[1].Sub(times[0]), times[2].Sub(times[1]); first < 45*time.Millisecond || second < 90*time.Millisecond {
+		t.Errorf("Expected backoff of about 50ms then 100ms, got %v and %v", first, second)
+	}
+
+	requests = 0
+	_, err = f.Fetch(context.Background(), &types.FetchRequest{
+		URL: server.URL + "/flaky", Engine: types.EngineHTTP, Retry: &types.RetryPolicy{MaxAttempts: 1},
+	})
+	if err == nil || requests != 1 {
+		t.Errorf("Expected a single failed attempt, got %d requests (%v)", requests, err)
+	}
+
+	requests = 0
+	_, err = f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL + "/teapot", Engine: types.EngineHTTP})
+	if err == nil || requests != 1 {
+		t.Errorf("Expected 418 not to be retried, got %d requests", requests)
+	}
+
+	requests = 0
+	_, err = f.Fetch(context.Background(), &types.FetchRequest{
+		URL: server.URL + "/teapot", Engine: types.EngineHTTP, Retry: &types.RetryPolicy{StatusCodes: []int{418}},
+	})
+	if err == nil || requests != 3 {
+		t.Errorf("Expected 418 to be retried when listed, got %d requests", requests)
+	}
+
+	if err := (types.RetryPolicy{MaxAttempts: 50}).Validate(); err == nil {
+		t.Error("Expected too many attempts to be rejected")
+	}
+}
+
+// TestActionValidation tests that scripted Chrome steps are checked for the
+// fields their type needs
+func TestActionValidation(t *testing.T) {
+	valid := []types.Action{
+		{Type: types.ActionClick, Selector: "#accept"},
+		{Type: types.ActionType, Selector: "input[name=q]", Text: "query"},
+		{Type: types.ActionPress, Key: "Enter"},
+		{Type: types.ActionPress, Key: "a"},
+		{Type: types.ActionWait, Selector: ".results"},
+		{Type: types.ActionWait, Ms: 500},
+		{Type: types.ActionScroll},
+	}
+	for _, action := range valid {
+		if err := action.Validate(); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", action, err)
+		}
+	}
+
+	invalid := []types.Action{
+		{Type: types.ActionClick},
+		{Type: types.ActionType, Selector: "input"},
+		{Type: types.ActionPress, Key: "Shift+A"},
+		{Type: types.ActionWait},
+		{Type: types.ActionWait, Ms: types.MaxWaitMs + 1},
+		{Type: "hover", Selector: "a"},
+	}
+	for _, action := range invalid {
+		if err := action.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", action)
+		}
+	}
+}
+
+// TestDeviceEmulation tests that device presets fill in the viewport and
+// user agent a request leaves unset
+func TestDeviceEmulation(t *testing.T) {
+	f := fetcher.NewFetcher(&config.Config{Timeout: 5 * time.Second})
+	defer f.Close()
+
+	req := &types.FetchRequest{URL: "https://example.com", Engine: types.EngineChrome, Device: "iPhone"}
+	f.ApplyDefaults(req)
+	iphone := types.DevicePresets["iphone"]
+	if req.Viewport == nil || *req.Viewport != iphone.Viewport {
+		t.Errorf("Expected the iphone viewport, got %+v", req.Viewport)
+	}
+	if req.UserAgent != iphone.UserAgent {
+		t.Errorf("Expected the iphone user agent, got %s", req.UserAgent)
+	}
+
+	// What the request sets itself wins
+	viewport := &types.Viewport{Width: 800, Height: 600}
+	req = &types.FetchRequest{URL: "https://example.com", Engine: types.EngineChrome, Device: "pixel", Viewport: viewport, UserAgent: "curl"}
+	f.ApplyDefaults(req)
+	if req.Viewport != viewport || req.UserAgent != types.UserAgentPresets["curl"] {
+		t.Errorf("Expected the request's own viewport and user agent, got %+v and %s", req.Viewport, req.UserAgent)
+	}
+
+	if err := (types.Viewport{Width: 0, Height: 600}).Validate(); err == nil {
+		t.Error("Expected a zero width to be rejected")
+	}
+	if err := (types.Viewport{Width: 390, Height: 844, DeviceScaleFactor: 3, Mobile: true}).Validate(); err != nil {
+		t.Errorf("Expected a phone viewport to be valid, got %v", err)
+	}
+}
+
+// TestChromeBlock tests that the configured resource blocking applies to
+// requests that don't choose their own
+func TestChromeBlock(t *testing.T) {
+	t.Setenv("FETCH_URL_CHROME_BLOCK", "image,Font,analytics")
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if want := []string{types.BlockImage, types.BlockFont, types.BlockAnalytics}; !reflect.DeepEqual(cfg.ChromeBlock, want) {
+		t.Errorf("Expected %v blocked, got %v", want, cfg.ChromeBlock)
+	}
+
+	f := fetcher.NewFetcher(cfg)
+	defer f.Close()
+	req := &types.FetchRequest{URL: "https://example.com", Engine: types.EngineChrome}
+	f.ApplyDefaults(req)
+	if !reflect.DeepEqual(req.Block, cfg.ChromeBlock) {
+		t.Errorf("Expected the configured blocking, got %v", req.Block)
+	}
+	req = &types.FetchRequest{URL: "https://example.com", Engine: types.EngineChrome, Block: []string{}}
+	f.ApplyDefaults(req)
+	if len(req.Block) != 0 {
+		t.Errorf("Expected an empty block list to load everything, got %v", req.Block)
+	}
+
+	t.Setenv("FETCH_URL_CHROME_BLOCK", "image,scripts")
+	if _, err := config.LoadConfig(); err == nil {
+		t.Error("Expected an unknown resource to be rejected")
+	}
+}
+
+// TestChromeWait tests that wait_ms gives a timer that fires after the page
+// has settled time to run before the page is captured
+func TestChromeWait(t *testing.T) {
+	f := fetcher.NewFetcher(&config.Config{BlockLocal: false, Timeout: 30 * time.Second})
+	defer f.Close()
+	if !f.ChromeAvailable() {
+		t.Skip("Chrome not available")
+	}
+
+	// The timer fires well after the network has been idle long enough for
+	// the page to count as loaded
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><div id="out">waiting</div><script>
+setTimeout(() => { document.getElementById("out").textContent = "delayed content" }, 2500)
+</script></body></html>`))
+	}))
+	defer server.Close()
+
+	req := &types.FetchRequest{URL: server.URL, Engine: types.EngineChrome, Format: types.FormatText}
+	resp, err := f.Fetch(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if strings.Contains(resp.Content, "delayed content") {
+		t.Errorf("Expected the page to be captured before the timer fired, got %q", resp.Content)
+	}
+
+	req = &types.FetchRequest{URL: server.URL, Engine: types.EngineChrome, Format: types.FormatText, WaitMs: 3000}
+	resp, err = f.Fetch(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if !strings.Contains(resp.Content, "delayed content") {
+		t.Errorf("Expected wait_ms to capture the delayed content, got %q", resp.Content)
+	}
+}
+
+// TestChromeScroll tests that scroll loads what an infinite-scrolling page
+// adds as it is scrolled, and stops scrolling once the page stops growing
+func TestChromeScroll(t *testing.T) {
+	f := fetcher.NewFetcher(&config.Config{BlockLocal: false, Timeout: 60 * time.Second})
+	defer f.Close()
+	if !f.ChromeAvailable() {
+		t.Skip("Chrome not available")
+	}
+
+	// Each scroll to the bottom loads the next batch, until there are three
+	var loads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/more" {
+			if n := loads.Add(1); n <= 3 {
+				fmt.Fprintf(w, "batch %d", n)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><div style="height:3000px">first screen</div><script>
+let loading = false
+window.addEventListener("scroll", () => {
+	if (loading || window.innerHeight + window.scrollY < document.documentElement.scrollHeight - 10) return
+	loading = true
+	fetch("/more").then(r => r.text()).then(text => {
+		if (text) {
+			const batch = document.createElement("div")
+			batch.style.height = "3000px"
+			batch.textContent = text
+			document.body.appendChild(batch)
+		}
+		loading = false
+	})
+})
+</script></body></html>`))
+	}))
+	defer server.Close()
+
+	req := &types.FetchRequest{URL: server.URL, Engine: types.EngineChrome, Format: types.FormatText}
+	resp, err := f.Fetch(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if strings.Contains(resp.Content, "batch 1") || loads.Load() != 0 {
+		t.Errorf("Expected nothing loaded without scrolling, got %q", resp.Content)
+	}
+
+	req = &types.FetchRequest{URL: server.URL, Engine: types.EngineChrome, Format: types.FormatText, Scroll: 10}
+	resp, err = f.Fetch(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	for _, batch := range []string{"batch 1", "batch 2", "batch 3"} {
+		if !strings.Contains(resp.Content, batch) {
+			t.Errorf("Expected scrolling to load %q, got %q", batch, resp.Content)
+		}
+	}
+	// Three batches, then one scroll that finds nothing more
+	if n := loads.Load(); n != 4 {
+		t.Errorf("Expected scrolling to stop once the page stopped growing, got %d loads", n)
+	}
+}
+
+// TestPageCookieExport tests that cookies returned from a Chrome fetch can
+// be imported into a session as they are
+func TestPageCookieExport(t *testing.T) {
+	returned := []types.PageCookie{
+		{Name: "sid", Value: "abc", Domain: ".example.com", Path: "/", Expires: float64(time.Now().Add(time.Hour).Unix()), HTTPOnly: true, Secure: true, SameSite: "Lax"},
+		{Name: "consent", Value: "yes", Domain: "www.example.com", Path: "/", Expires: -1},
+	}
+	data, err := json.Marshal(returned)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	cookies, err := session.ParseCookieExport(data)
+	if err != nil {
+		t.Fatalf("ParseCookieExport failed: %v", err)
+	}
+	if len(cookies) != 2 {
+		t.Fatalf("Expected 2 cookies, got %d", len(cookies))
+	}
+	if c := cookies[0]; c.Name != "sid" || c.Value != "abc" || c.Domain != ".example.com" || !c.HttpOnly || !c.Secure || c.Expires.IsZero() {
+		t.Errorf("Expected the persistent cookie intact, got %+v", c)
+	}
+	if c := cookies[1]; c.Name != "consent" || !c.Expires.IsZero() {
+		t.Errorf("Expected a session cookie without expiry, got %+v", c)
+	}
+}
+
+// TestConcurrencyLimits tests the per-host and global limits on fetches in
+// flight, and that loopback servers aren't held to them
+func TestConcurrencyLimits(t *testing.T) {
+	var mu sync.Mutex
+	running := make(map[string]int)
+	peak := make(map[string]int)
+	// Acting as the egress proxy, so the hosts aren't loopback addresses
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := strings.Cut(r.Host, ":")
+		mu.Lock()
+		running[host]++
+		running["all"]++
+		peak[host] = max(peak[host], running[host])
+		peak["all"] = max(peak["all"], running["all"])
+		mu.Unlock()
+
+		time.Sleep(100 * time.Millisecond)
+
+		mu.Lock()
+		running[host]--
+		running["all"]--
+		mu.Unlock()
+		w.Write([]byte("<html><body>ok</body></html>"))
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{
+		Timeout:        10 * time.Second,
+		ConnectTimeout: 5 * time.Second,
+		MaxConcurrency: 3,
+		MaxPerHost:     2,
+		Proxies:        []string{server.URL},
+	})
+	defer f.Close()
+
+	urls := []string{"http://one.example/", "http://two.example/"}
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func(rawURL string) {
+			defer wg.Done()
+			if _, err := f.Fetch(context.Background(), &types.FetchRequest{URL: rawURL, Engine: types.EngineHTTP}); err != nil {
+				t.Errorf("Fetch failed: %v", err)
+			}
+		}(urls[i%2])
+	}
+	wg.Wait()
+
+	if peak["one.example"] != 2 || peak["two.example"] != 2 {
+		t.Errorf("Expected at most 2 concurrent fetches per host, got %v", peak)
+	}
+	if peak["all"] != 3 {
+		t.Errorf("Expected at most 3 concurrent fetches overall, got %d", peak["all"])
+	}
+	if stats := f.SchedulerStats(); stats.Active != 0 || stats.Queued != 0 || stats.Hosts != 0 {
+		t.Errorf("Expected an idle scheduler, got %+v", stats)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.Fetch(ctx, &types.FetchRequest{URL: urls[0], Engine: types.EngineHTTP})
+		}()
+	}
+	wg.Wait()
+	if stats := f.SchedulerStats(); stats.Active != 0 || stats.Queued != 0 {
+		t.Errorf("Expected abandoned waits to release their slots, got %+v", stats)
+	}
+
+	// Fetched directly, the same server is loopback and runs unqueued
+	direct := fetcher.NewFetcher(&config.Config{
+		Timeout:        10 * time.Second,
+		ConnectTimeout: 5 * time.Second,
+		MaxConcurrency: 1,
+		MaxPerHost:     1,
+	})
+	defer direct.Close()
+	time.Sleep(150 * time.Millisecond) // for the abandoned requests to finish
+	mu.Lock()
+	peak["all"] = 0
+	mu.Unlock()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := direct.Fetch(context.Background(), &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP}); err != nil {
+				t.Errorf("Fetch failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if peak["all"] != 4 {
+		t.Errorf("Expected loopback fetches not to be queued, got %d concurrent", peak["all"])
+	}
+}
+
+// TestRetryAfter tests waiting out Retry-After on throttled responses, and
+// giving up when the wait doesn't fit in the request timeout
+func TestRetryAfter(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch {
+		case r.URL.Path == "/busy" && requests == 1:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case r.URL.Path == "/later":
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/throttled":
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte("<html><body>ok</body></html>"))
+		}
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{Timeout: 5 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer f.Close()
+
+	start := time.Now()
+	resp, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL + "/busy", Engine: types.EngineHTTP})
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second || resp.Attempts != 2 {
+		t.Errorf("Expected a retry after 1s, got %d attempts in %v", resp.Attempts, elapsed)
+	}
+	if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "throttled (status 429)") {
+		t.Errorf("Expected a throttling warning, got %v", resp.Warnings)
+	}
+
+	requests = 0
+	start = time.Now()
+	resp, err = f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL + "/later", Engine: types.EngineHTTP})
+	if err == nil || requests != 1 || time.Since(start) > time.Second {
+		t.Errorf("Expected a wait longer than the timeout to fail at once, got %d requests (%v)", requests, err)
+	}
+	if resp == nil || len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "wait 2m0s") {
+		t.Errorf("Expected a warning with the requested wait, got %+v", resp)
+	}
+
+	requests = 0
+	if _, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL + "/throttled", Engine: types.EngineHTTP}); err == nil || requests != 1 {
+		t.Errorf("Expected a 429 without Retry-After not to be retried, got %d requests", requests)
+	}
+}
+
+// TestCrawlPlan tests dry-run fetch plans: deduplication, filters, policy
+// checks and the page budget, without any fetching
+func TestCrawlPlan(t *testing.T) {
+	f := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, DenyDomains: []string{"denied.example"}})
+	defer f.Close()
+
+	spec := crawler.Spec{
+		Seeds: []string{
+			"https://example.com/a",
+			"https://example.com/a#again",
+			"https://example.com/report.pdf",
+			"https://denied.example/",
+			"ftp://example.com/file",
+			"https://example.com/b",
+			"https://example.com/c",
+		},
+		Exclude:  []string{"*.pdf"},
+		MaxPages: 2,
+	}
+	plan, err := crawler.MakePlan(spec, f.CheckURL)
+	if err != nil {
+		t.Fatalf("MakePlan failed: %v", err)
+	}
+	if !reflect.DeepEqual(plan.Fetch, []string{"https://example.com/a", "https://example.com/b"}) {
+		t.Errorf("Unexpected URLs to fetch %v", plan.Fetch)
+	}
+	var reasons []string
+	for _, skipped := range plan.Skipped {
+		reasons = append(reasons, skipped.Reason)
+	}
+	expected := []string{crawler.SkipDuplicate, crawler.SkipFiltered, crawler.SkipBlocked, crawler.SkipBlocked, crawler.SkipMaxPages}
+	if !reflect.DeepEqual(reasons, expected) {
+		t.Errorf("Expected skip reasons %v, got %+v", expected, plan.Skipped)
+	}
+	if plan.Skipped[2].Detail == "" || plan.MaxPages != 2 || plan.MaxBytes != crawler.DefaultMaxBytes {
+		t.Errorf("Unexpected plan details %+v", plan)
+	}
+
+	spec = crawler.Spec{Seeds: []string{"https://Docs.example.com/", "https://example.org/"}, Depth: 2}
+	plan, _ = crawler.MakePlan(spec, f.CheckURL)
+	if !reflect.DeepEqual(plan.Hosts, []string{"docs.example.com", "example.org"}) {
+		t.Errorf("Unexpected hosts %v", plan.Hosts)
+	}
+}
+
+// TestCrawlJobs tests reading an async crawl's pages with cursors while it
+// runs, and cancelling a job
+func TestCrawlJobs(t *testing.T) {
+	release := make(chan struct{})
+	fetch := func(ctx context.Context, rawURL string) (crawler.Page, []types.Link) {
+		if rawURL == "https://example.com/" {
+			return crawler.Page{StatusCode: http.StatusOK}, []types.Link{
+				{URL: "https://example.com/a"}, {URL: "https://example.com/b"}, {URL: "https://example.com/c"},
+			}
+		}
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return crawler.Page{Error: ctx.Err().Error()}, nil
+		}
+		return crawler.Page{StatusCode: http.StatusOK}, nil
+	}
+
+	jobs := crawler.NewJobs()
+	defer jobs.CancelAll()
+	spec := crawler.Spec{Seeds: []string{"https://example.com/"}, Depth: 1}
+	job, err := jobs.Start(spec, fetch, nil)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	// waitFor polls the job until it has n pages
+	waitFor := func(n int) crawler.JobResults {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			results := job.Results(0, 0)
+			if results.Total >= n || time.Now().After(deadline) {
+				return results
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	results := waitFor(1)
+	if results.Status != crawler.JobRunning || results.Total != 1 || !results.HasMore || results.Result != nil {
+		t.Fatalf("Expected the seed while the job is running, got %+v", results)
+	}
+
+	close(release)
+	waitFor(4)
+	for job.Status() == crawler.JobRunning {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var urls []string
+	cursor := 0
+	for {
+		results := job.Results(cursor, 2)
+		for _, page := range results.Pages {
+			urls = append(urls, page.URL)
+		}
+		cursor = results.NextCursor
+		if !results.HasMore {
+			if results.Status != crawler.JobDone || results.Result == nil || results.Result.Fetched != 4 {
+				t.Errorf("Expected a completed job summary, got %+v", results)
+			}
+			break
+		}
+	}
+	expected := []string{"https://example.com/", "https://example.com/a", "https://example.com/b", "https://example.com/c"}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("Expected pages %v, got %v", expected, urls)
+	}
+	if got, ok := jobs.Get(job.ID); !ok || got != job {
+		t.Error("Expected the finished job to be kept")
+	}
+
+	blocked := func(ctx context.Context, rawURL string) (crawler.Page, []types.Link) {
+		<-ctx.Done()
+		return crawler.Page{Error: ctx.Err().Error()}, nil
+	}
+	job, err = jobs.Start(spec, blocked, nil)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	job.Cancel()
+	for job.Status() == crawler.JobRunning {
+		time.Sleep(10 * time.Millisecond)
+	}
+	results = job.Results(0, 0)
+	if results.Result == nil || results.Result.StopReason != crawler.StopCancelled || results.Total != 0 {
+		t.Errorf("Expected a cancelled job, got %+v", results)
+	}
+}
+
+// TestHostRateLimit tests the per-host token bucket: a burst goes out at
+// once, later requests are spaced at the rate, other hosts aren't held up
+// and loopback servers aren't limited at all
+func TestHostRateLimit(t *testing.T) {
+	var mu sync.Mutex
+	var times []time.Time
+	// Acting as the egress proxy, so the hosts aren't loopback addresses
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host == "one.example" {
+			mu.Lock()
+			times = append(times, time.Now())
+			mu.Unlock()
+		}
+		w.Write([]byte("<html><body>ok</body></html>"))
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{
+		Timeout:        10 * time.Second,
+		ConnectTimeout: 5 * time.Second,
+		HostRate:       10,
+		HostBurst:      2,
+		Proxies:        []string{server.URL},
+	})
+	defer f.Close()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := f.Fetch(context.Background(), &types.FetchRequest{URL: "http://one.example/", Engine: types.EngineHTTP}); err != nil {
+				t.Errorf("Fetch failed: %v", err)
+			}
+		}()
+	}
+
+	// Another host has its own bucket
+	time.Sleep(20 * time.Millisecond)
+	if _, err := f.Fetch(context.Background(), &types.FetchRequest{URL: "http://two.example/", Engine: types.EngineHTTP}); err != nil {
+		t.Errorf("Fetch failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("Expected another host not to wait, took %v", elapsed)
+	}
+	wg.Wait()
+
+	// 2 at once, then 4 more at 10 per second
+	if len(times) != 6 || times[5].Sub(start) < 350*time.Millisecond {
+		t.Errorf("Expected 6 requests spaced over about 400ms, got %d in %v", len(times), time.Since(start))
+	}
+	if stats := f.SchedulerStats(); stats.RateLimited != 4 || stats.HostRate != 10 || stats.HostBurst != 2 {
+		t.Errorf("Unexpected rate limit stats %+v", stats)
+	}
+
+	// A wait that outlasts the request ends with it
+	for i := 0; i < 2; i++ {
+		f.Fetch(context.Background(), &types.FetchRequest{URL: "http://one.example/", Engine: types.EngineHTTP})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := f.Fetch(ctx, &types.FetchRequest{URL: "http://one.example/", Engine: types.EngineHTTP}); err == nil {
+		t.Error("Expected a rate-limited fetch to end with its context")
+	}
+
+	// Fetched directly, the same server is loopback and isn't rate limited
+	direct := fetcher.NewFetcher(&config.Config{
+		Timeout:        10 * time.Second,
+		ConnectTimeout: 5 * time.Second,
+		HostRate:       1,
+		HostBurst:      1,
+	})
+	defer direct.Close()
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := direct.Fetch(context.Background(), &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP}); err != nil {
+			t.Errorf("Fetch failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected loopback fetches not to wait, took %v", elapsed)
+	}
+	if stats := direct.SchedulerStats(); stats.RateLimited != 0 {
+		t.Errorf("Expected no rate-limited loopback fetches, got %+v", stats)
+	}
+}
+
+// TestUsageAccounting tests the network bytes of a compressed fetch and a
+// crawl's network and disk usage
+func TestUsageAccounting(t *testing.T) {
+	body := strings.Repeat("<p>compressible</p>", 500)
+	var compressed strings.Builder
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte(body))
+	gz.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write([]byte(compressed.String()))
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer f.Close()
+
+	resp, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP, MaxContentLength: len(body)})
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if resp.BytesReceived != int64(compressed.Len()) || len(resp.Content) != len(body) {
+		t.Errorf("Expected %d bytes received for %d bytes of content, got %d for %d",
+			compressed.Len(), len(body), resp.BytesReceived, len(resp.Content))
+	}
+
+	fetch := func(ctx context.Context, rawURL string) (crawler.Page, []types.Link) {
+		return crawler.Page{StatusCode: http.StatusOK, Bytes: 300, RenderMs: 1500, Content: "page"}, nil
+	}
+	path := filepath.Join(t.TempDir(), "out", "pages.jsonl")
+	output, err := crawler.CreateOutput(path)
+	if err != nil {
+		t.Fatalf("CreateOutput failed: %v", err)
+	}
+	jobs := crawler.NewJobs()
+	spec := crawler.Spec{Seeds: []string{"https://example.com/a", "https://example.com/b"}, Output: "pages.jsonl"}
+	job, err := jobs.Start(spec, fetch, output)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	for job.Status() == crawler.JobRunning {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Expected an output file: %v", err)
+	}
+	results := job.Results(0, 0)
+	expected := crawler.Usage{NetworkBytes: 600, DiskBytes: info.Size(), RenderSeconds: 3}
+	if results.Result == nil || results.Result.Usage != expected || results.Usage != expected {
+		t.Errorf("Expected usage %+v, got %+v", expected, results)
+	}
+	if results.Pages[0].Content != "" {
+		t.Error("Expected pages written to the output to be stored without content")
+	}
+}
+
+// TestNotModified tests a conditional request answered with 304: no body is
+// read and the cached copy is returned with its own status
+func TestNotModified(t *testing.T) {
+	var conditional int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			conditional++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("<html><head><title>Cached</title></head><body>cached body</body></html>"))
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer f.Close()
+
+	first, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP})
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if first.ETag != `"v1"` || first.NotModified {
+		t.Fatalf("Expected a full response with its ETag, got %+v", first)
+	}
+
+	second, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP, Revalidate: first})
+	if err != nil {
+		t.Fatalf("Expected a 304 not to be an error, got %v", err)
+	}
+	if conditional != 1 || !second.NotModified || second.StatusCode != http.StatusOK ||
+		second.Content != first.Content || second.ETag != `"v1"` || second.BytesReceived != 0 {
+		t.Errorf("Expected the cached copy back, got %+v", second)
+	}
+	if second == first || first.NotModified {
+		t.Error("Expected the cached response not to be modified")
+	}
+
+	c := cache.NewCache(time.Minute)
+	c.Set(server.URL, types.EngineHTTP, types.FormatText, &types.FetchResponse{StatusCode: http.StatusNotModified, NotModified: true})
+	if _, found := c.Get(server.URL, types.EngineHTTP, types.FormatText); found {
+		t.Error("Expected a bare 304 not to be cached")
+	}
+}
+
+// TestDiscoverEndpoints tests probing well-known endpoints, including ones
+// named by the home page and robots.txt, and rejecting catch-all pages
+func TestDiscoverEndpoints(t *testing.T) {
+	files := map[string]string{
+		"/": `<html><head><link rel="alternate" type="application/rss+xml" href="/blog/rss">` +
+			`<link rel="manifest" href="/app.webmanifest"></head><body>home</body></html>`,
+		"/robots.txt":               "User-agent: *\nDisallow: /private\nSitemap: /maps/main.xml\n",
+		"/maps/main.xml":            `<?xml version="1.0"?><urlset><url><loc>/a</loc></url><url><loc>/b</loc></url></urlset>`,
+		"/blog/rss":                 `<rss><channel><title>Blog</title><item><title>One</title></item></channel></rss>`,
+		"/app.webmanifest":          `{"name": "Example App", "icons": [{}, {}]}`,
+		"/.well-known/security.txt": "Contact: mailto:security@example.com\nExpires: 2030-01-01T00:00:00Z\n",
+		"/openapi.json":             `{"openapi": "3.1.0", "info": {"title": "API", "version": "2"}, "paths": {"/a": {}, "/b": {}}}`,
+		"/llms.txt":                 "# Example\n\n- [Docs](https://example.com/docs)\n",
+		"/feed":                     "<!DOCTYPE html><html><body>catch-all</body></html>",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer f.Close()
+
+	fetch := func(ctx context.Context, rawURL string) (*types.FetchResponse, error) {
+		return f.Fetch(ctx, &types.FetchRequest{URL: rawURL, Engine: types.EngineHTTP, Retry: &types.RetryPolicy{MaxAttempts: 1}})
+	}
+	result, err := discovery.Discover(context.Background(), server.URL+"/some/page", fetch)
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+
+	found := make(map[string]discovery.Endpoint)
+	for _, endpoint := range result.Endpoints {
+		found[strings.TrimPrefix(endpoint.URL, server.URL)] = endpoint
+	}
+	expected := map[string]string{
+		"/robots.txt":               discovery.KindRobots,
+		"/maps/main.xml":            discovery.KindSitemap,
+		"/.well-known/security.txt": discovery.KindSecurity,
+		"/blog/rss":                 discovery.KindFeed,
+		"/app.webmanifest":          discovery.KindManifest,
+		"/openapi.json":             discovery.KindOpenAPI,
+		"/llms.txt":                 discovery.KindLLMs,
+	}
+	if len(found) != len(expected) {
+		t.Errorf("Expected %d endpoints, got %+v", len(expected), result.Endpoints)
+	}
+	for path, kind := range expected {
+		if found[path].Kind != kind {
+			t.Errorf("Expected %s to be found as %s, got %+v", path, kind, found[path])
+		}
+	}
+
+	if entries := found["/maps/main.xml"].Metadata["entries"]; entries != 2 {
+		t.Errorf("Expected 2 sitemap entries, got %v", entries)
+	}
+	if title := found["/blog/rss"].Metadata["title"]; title != "Blog" {
+		t.Errorf("Expected the feed title, got %v", title)
+	}
+	if paths := found["/openapi.json"].Metadata["paths"]; paths != 2 {
+		t.Errorf("Expected 2 API paths, got %v", paths)
+	}
+	if title := found["/llms.txt"].Metadata["title"]; title != "Example" {
+		t.Errorf("Expected the llms.txt title, got %v", title)
+	}
+
+	missing := strings.Join(result.Missing, " ")
+	if !strings.Contains(missing, server.URL+"/feed ") || !strings.Contains(missing, "/sitemap.xml") {
+		t.Errorf("Expected the catch-all page and absent files to be missing, got %v", result.Missing)
+	}
+}
+
+// TestBotChallenge tests recognizing bot-challenge pages, not retrying them
+// over HTTP, and the warning when Chrome can't be escalated to
+func TestBotChallenge(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/cloudflare":
+			w.Header().Set("Cf-Mitigated", "challenge")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("<html><head><title>Just a moment...</title></head></html>"))
+		case "/perimeterx":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.WriteHeader(http.StatusForbidden)
+			gz := gzip.NewWriter(w)
+			gz.Write([]byte(`<html><body><div id="px-captcha"></div></body></html>`))
+			gz.Close()
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("<html><body>Forbidden</body></html>"))
+		}
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second, EscalateChallenges: true})
+	defer f.Close()
+
+	for path, vendor := range map[string]string{"/cloudflare": "cloudflare", "/perimeterx": "perimeterx", "/plain": ""} {
+		requests = 0
+		resp, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL + path, Engine: types.EngineHTTP})
+		var statusErr *fetcher.StatusError
+		if !errors.As(err, &statusErr) || statusErr.Challenge != vendor {
+			t.Errorf("%s: expected challenge %q, got %v", path, vendor, err)
+			continue
+		}
+		if requests != 1 {
+			t.Errorf("%s: expected a challenge not to be retried, got %d requests", path, requests)
+		}
+		warned := resp != nil && len(resp.Warnings) > 0 && strings.Contains(resp.Warnings[len(resp.Warnings)-1], "Chrome isn't available")
+		if warned != (vendor != "") {
+			t.Errorf("%s: unexpected warnings %v", path, resp.Warnings)
+		}
+	}
+}
+
+// TestBlockedBy tests classifying interstitials that stand in for the page
+func TestBlockedBy(t *testing.T) {
+	pages := map[string]string{
+		"/challenge": `<html><head><title>Just a moment...</title></head><body><script src="/cdn-cgi/challenge-platform/h/b/orchestrate"></script></body></html>`,
+		"/captcha":   `<html><body><p>Please verify you are human</p><div class="g-recaptcha"></div></body></html>`,
+		"/signin":    `<html><head><title>Sign in</title></head><body><form><input type="password" name="p"></form></body></html>`,
+		"/article":   `<html><head><title>Article</title></head><body><p>` + strings.Repeat("Real content. ", 3000) + `</p><div class="g-recaptcha"></div></body></html>`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/members" {
+			http.Redirect(w, r, "/login?next=/members", http.StatusFound)
+			return
+		}
+		if r.URL.Path == "/login" {
+			r.URL.Path = "/signin"
+		}
+		w.Write([]byte(pages[r.URL.Path]))
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer f.Close()
+
+	expected := map[string]string{
+		"/challenge": fetcher.BlockedCloudflare,
+		"/captcha":   fetcher.BlockedCaptcha,
+		"/signin":    fetcher.BlockedLoginWall,
+		"/members":   fetcher.BlockedLoginWall,
+		"/article":   "",
+	}
+	for path, blockedBy := range expected {
+		resp, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL + path, Engine: types.EngineHTTP})
+		if err != nil {
+			t.Fatalf("%s: fetch failed: %v", path, err)
+		}
+		if resp.BlockedBy != blockedBy {
+			t.Errorf("%s: expected blocked_by %q, got %q", path, blockedBy, resp.BlockedBy)
+		}
+		if (len(resp.Warnings) > 0) != (blockedBy != "") {
+			t.Errorf("%s: unexpected warnings %v", path, resp.Warnings)
+		}
+	}
+
+	c := cache.NewCache(time.Minute)
+	c.Set(server.URL, types.EngineHTTP, types.FormatText, &types.FetchResponse{StatusCode: http.StatusOK, BlockedBy: fetcher.BlockedCaptcha})
+	if _, found := c.Get(server.URL, types.EngineHTTP, types.FormatText); found {
+		t.Error("Expected a blocked page not to be cached")
+	}
+}
+
+// TestUARotation tests that the default user agent rotates per request or
+// per domain, with headers matching each browser
+func TestUARotation(t *testing.T) {
+	var mu sync.Mutex
+	var agents []string
+	var hints []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents = append(agents, r.Header.Get("User-Agent"))
+		hints = append(hints, r.Header.Get("Sec-CH-UA"))
+		mu.Unlock()
+		w.Write([]byte("<html><body>ok</body></html>"))
+	}))
+	defer server.Close()
+
+	chrome := "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	firefox := "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"
+	pool := []string{chrome, firefox}
+
+	fetch := func(f *fetcher.Fetcher, rawURL, userAgent string) *types.FetchResponse {
+		t.Helper()
+		req := &types.FetchRequest{URL: rawURL, Engine: types.EngineHTTP, UserAgent: userAgent}
+		f.ApplyDefaults(req)
+		resp, err := f.Fetch(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+		return resp
+	}
+
+	// Request mode cycles through the pool
+	f := fetcher.NewFetcher(&config.Config{BlockLocal: false, Timeout: 5 * time.Second, UARotation: "request", UAPool: pool})
+	defer f.Close()
+	for i := 0; i < 4; i++ {
+		if resp := fetch(f, server.URL, ""); resp.UserAgent != pool[i%2] {
+			t.Errorf("Fetch %d: expected user_agent %s, got '%s'", i, pool[i%2], resp.UserAgent)
+		}
+	}
+	mu.Lock()
+	if agents[0] != chrome || agents[1] != firefox || agents[2] != chrome {
+		t.Errorf("Expected the pool in order, got %v", agents)
+	}
+	if hints[0] == "" || hints[1] != "" {
+		t.Errorf("Expected client hints for Chrome only, got %q", hints)
+	}
+	mu.Unlock()
+
+	// A user agent the caller chose is kept
+	if resp := fetch(f, server.URL, "curl"); resp.UserAgent != "" {
+		t.Errorf("Expected no rotation for an explicit user agent, got '%s'", resp.UserAgent)
+	}
+
+	// Domain mode keeps each domain on one user agent
+	f2 := fetcher.NewFetcher(&config.Config{BlockLocal: false, Timeout: 5 * time.Second, UARotation: "domain", UAPool: pool})
+	defer f2.Close()
+	other := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	first := fetch(f2, server.URL, "").UserAgent
+	second := fetch(f2, other, "").UserAgent
+	if first == second {
+		t.Errorf("Expected domains to get different user agents, both got '%s'", first)
+	}
+	for i := 0; i < 3; i++ {
+		if got := fetch(f2, server.URL, "").UserAgent; got != first {
+			t.Errorf("Expected domain rotation to stick to '%s', got '%s'", first, got)
+		}
+	}
+
+	// Rotation is off by default
+	f3 := fetcher.NewFetcher(&config.Config{BlockLocal: false, Timeout: 5 * time.Second})
+	defer f3.Close()
+	if resp := fetch(f3, server.URL, ""); resp.UserAgent != "" {
+		t.Errorf("Expected no rotation by default, got '%s'", resp.UserAgent)
+	}
+}
+
+// TestStreamToDisk tests that bodies past the stream threshold are written
+// to a file and returned as a preview
+func TestStreamToDisk(t *testing.T) {
+	page := "<html><head><title>Big</title></head><body><p>" + strings.Repeat("word ", 40000) + "</p></body></html>"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	cfg := &config.Config{BlockLocal: false, Timeout: 5 * time.Second, StreamThreshold: 10000, MaxDownloadSize: 1 << 20, DownloadDir: dir}
+	f := fetcher.NewFetcher(cfg)
+	defer f.Close()
+
+	req := &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP}
+	f.ApplyDefaults(req)
+	resp, err := f.Fetch(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if resp.File == "" || filepath.Dir(resp.File) != dir {
+		t.Fatalf("Expected the body to be streamed into %s, got file '%s'", dir, resp.File)
+	}
+	data, err := os.ReadFile(resp.File)
+	if err != nil || string(data) != page {
+		t.Fatalf("Expected the file to hold the full body (%d bytes), got %d bytes (%v)", len(page), len(data), err)
+	}
+	if resp.ContentLength != int64(len(page)) {
+		t.Errorf("Expected content_length %d, got %d", len(page), resp.ContentLength)
+	}
+	if !strings.HasPrefix(resp.Content, "<html>") || len(resp.Content) > 64*1024 {
+		t.Errorf("Expected a preview of the body, got %d chars", len(resp.Content))
+	}
+
+	c := cache.NewCache(time.Hour)
+	c.Set(server.URL, types.EngineHTTP, types.FormatText, resp)
+	if _, found := c.Get(server.URL, types.EngineHTTP, types.FormatText); found {
+		t.Error("Expected a streamed response not to be cached")
+	}
+
+	// Small bodies stay in memory
+	small := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><body>small</body></html>"))
+	}))
+	defer small.Close()
+	req = &types.FetchRequest{URL: small.URL, Engine: types.EngineHTTP}
+	f.ApplyDefaults(req)
+	if resp, err := f.Fetch(context.Background(), req); err != nil || resp.File != "" {
+		t.Errorf("Expected a small body in memory, got file '%s' (%v)", resp.File, err)
+	}
+
+	// Past the download limit the body is refused and its file removed
+	cfg.MaxDownloadSize = 50000
+	f2 := fetcher.NewFetcher(cfg)
+	defer f2.Close()
+	req = &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP}
+	f2.ApplyDefaults(req)
+	if _, err := f2.Fetch(context.Background(), req); !errors.Is(err, fetcher.ErrContentTooLarge) {
+		t.Errorf("Expected ErrContentTooLarge, got %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected only the first download to remain, found %d files", len(entries))
+	}
+
+	// A max_content_length below the threshold is still a hard limit
+	req = &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP, MaxContentLength: 5000}
+	f.ApplyDefaults(req)
+	if _, err := f.Fetch(context.Background(), req); !errors.Is(err, fetcher.ErrContentTooLarge) {
+		t.Errorf("Expected ErrContentTooLarge below the threshold, got %v", err)
+	}
+}
+
+// TestRangeRequests tests that byte ranges are requested, and cut from the
+// full body when the server ignores them
+func TestRangeRequests(t *testing.T) {
+	body := strings.Repeat("0123456789", 100)
+	var gotRange, gotEncoding string
+	ranged := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRange, gotEncoding = r.Header.Get("Range"), r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Type", "text/plain")
+		http.ServeContent(w, r, "data.txt", time.Time{}, strings.NewReader(body))
+	}))
+	defer ranged.Close()
+	full := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(body))
+	}))
+	defer full.Close()
+
+	cfg := &config.Config{BlockLocal: false, Timeout: 5 * time.Second}
+	f := fetcher.NewFetcher(cfg)
+	defer f.Close()
+
+	fetch := func(rawURL string, r *types.ByteRange) *types.FetchResponse {
+		t.Helper()
+		req := &types.FetchRequest{URL: rawURL, Engine: types.EngineHTTP, Range: r}
+		f.ApplyDefaults(req)
+		resp, err := f.Fetch(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+		return resp
+	}
+
+	resp := fetch(ranged.URL, &types.ByteRange{Start: 10, End: 24})
+	if gotRange != "bytes=10-24" || gotEncoding != "identity" {
+		t.Errorf("Expected Range bytes=10-24 without compression, got '%s' / '%s'", gotRange, gotEncoding)
+	}
+	if resp.StatusCode != http.StatusPartialContent || resp.Content != body[10:25] {
+		t.Errorf("Expected 206 with bytes 10-24, got %d '%s'", resp.StatusCode, resp.Content)
+	}
+	if resp.ContentRange != "bytes 10-24/1000" {
+		t.Errorf("Expected content_range 'bytes 10-24/1000', got '%s'", resp.ContentRange)
+	}
+
+	// Without an end the range runs to the end of the body
+	if resp := fetch(ranged.URL, &types.ByteRange{Start: 990, End: -1}); resp.Content != body[990:] {
+		t.Errorf("Expected the last 10 bytes, got '%s'", resp.Content)
+	}
+
+	// A server without range support has the range cut from the full body
+	resp = fetch(full.URL, &types.ByteRange{Start: 0, End: 99})
+	if resp.Content != body[:100] || resp.ContentRange != "bytes 0-99/1000" {
+		t.Errorf("Expected the first 100 bytes, got %d bytes, content_range '%s'", len(resp.Content), resp.ContentRange)
+	}
+	if len(resp.Warnings) == 0 || !strings.Contains(resp.Warnings[0], "range requests") {
+		t.Errorf("Expected a warning about the missing range support, got %v", resp.Warnings)
+	}
+	if resp := fetch(full.URL, &types.ByteRange{Start: 995, End: 2000}); resp.Content != body[995:] {
+		t.Errorf("Expected the tail of the body, got '%s'", resp.Content)
+	}
+
+	// A range past the end of the body is refused
+	req := &types.FetchRequest{URL: ranged.URL, Engine: types.EngineHTTP, Range: &types.ByteRange{Start: 5000, End: -1}}
+	f.ApplyDefaults(req)
+	var statusErr *fetcher.StatusError
+	if _, err := f.Fetch(context.Background(), req); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("Expected a 416 status error, got %v", err)
+	}
+}
+
+// TestBandwidthLimit tests that downloads are held to the global and
+// per-request bandwidth limits
+func TestBandwidthLimit(t *testing.T) {
+	body := strings.Repeat("x", 24000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	fetch := func(f *fetcher.Fetcher, maxBandwidth int64) time.Duration {
+		t.Helper()
+		req := &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP, MaxBandwidth: maxBandwidth}
+		f.ApplyDefaults(req)
+		start := time.Now()
+		resp, err := f.Fetch(context.Background(), req)
+		if err != nil {
+			t.Errorf("Fetch failed: %v", err)
+		} else if len(resp.Content) != len(body) {
+			t.Errorf("Expected the whole body, got %d bytes", len(resp.Content))
+		}
+		return time.Since(start)
+	}
+
+	// A second's worth of bytes arrives at once, the rest at the limit
+	f := fetcher.NewFetcher(&config.Config{BlockLocal: false, Timeout: 5 * time.Second})
+	defer f.Close()
+	if elapsed := fetch(f, 0); elapsed > 300*time.Millisecond {
+		t.Errorf("Expected an unthrottled fetch to be quick, took %s", elapsed)
+	}
+	if elapsed := fetch(f, 16000); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected max_bandwidth to slow the download to ~500ms, took %s", elapsed)
+	}
+
+	// The server's limit is shared by every download
+	limited := fetcher.NewFetcher(&config.Config{BlockLocal: false, Timeout: 5 * time.Second, BandwidthLimit: 32000})
+	defer limited.Close()
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fetch(limited, 0)
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected concurrent downloads to share the limit (~500ms), took %s", elapsed)
+	}
+}
+
+// TestStaleRevalidation tests that expired entries with validators are kept
+// for revalidation, and that a 304 renews them
+func TestStaleRevalidation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		if r.Header.Get("If-Modified-Since") != "" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("<html><body>docs</body></html>"))
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer f.Close()
+	c := cache.NewCache(50 * time.Millisecond)
+
+	first, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP})
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	first.Content = "docs" // as processed
+	c.Set(server.URL, types.EngineHTTP, types.FormatText, first)
+	c.Set("https://example.com/plain", types.EngineHTTP, types.FormatText, &types.FetchResponse{StatusCode: http.StatusOK, Content: "plain"})
+	if _, found := c.Stale(server.URL, types.EngineHTTP, types.FormatText); found {
+		t.Error("Expected a fresh entry not to be reported stale")
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	if _, found := c.Get(server.URL, types.EngineHTTP, types.FormatText); found {
+		t.Fatal("Expected the entry to have expired")
+	}
+	stale, found := c.Stale(server.URL, types.EngineHTTP, types.FormatText)
+	if !found || stale.Content != "docs" {
+		t.Fatalf("Expected the expired entry to be kept for revalidation, got %v", stale)
+	}
+	c.Get("https://example.com/plain", types.EngineHTTP, types.FormatText)
+	if _, found := c.Stale("https://example.com/plain", types.EngineHTTP, types.FormatText); found {
+		t.Error("Expected an entry without validators to be dropped on expiry")
+	}
+
+	revalidated, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP, Revalidate: stale})
+	if err != nil || !revalidated.NotModified || revalidated.Content != "docs" {
+		t.Fatalf("Expected the stale copy to be confirmed, got %+v (%v)", revalidated, err)
+	}
+	c.Set(server.URL, types.EngineHTTP, types.FormatText, revalidated)
+	cached, found := c.Get(server.URL, types.EngineHTTP, types.FormatText)
+	if !found || cached.Content != "docs" {
+		t.Fatal("Expected the 304 to renew the entry's TTL")
+	}
+	if cached.NotModified || !revalidated.NotModified {
+		t.Error("Expected the renewed entry, and only it, to be stored without not_modified")
+	}
+}
+
+// TestCacheControl tests that no-store responses aren't cached and that
+// origin TTLs, when enabled, are bounded by the configured limits
+func TestCacheControl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+		w.Write([]byte("<html><body>page</body></html>"))
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{Timeout: 10 * time.Second, ConnectTimeout: 5 * time.Second})
+	defer f.Close()
+	fetch := func(cc string) (string, *types.FetchResponse) {
+		t.Helper()
+		rawURL := server.URL + "/?cc=" + url.QueryEscape(cc)
+		resp, err := f.Fetch(context.Background(), &types.FetchRequest{URL: rawURL, Engine: types.EngineHTTP})
+		if err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+		return rawURL, resp
+	}
+	cached := func(c *cache.Cache, rawURL string) bool {
+		_, found := c.Get(rawURL, types.EngineHTTP, types.FormatText)
+		return found
+	}
+
+	// no-store is honored even with the global TTL
+	global := cache.NewCache(time.Hour)
+	rawURL, resp := fetch("private, no-store")
+	if resp.CacheControl != "private, no-store" {
+		t.Errorf("Expected the Cache-Control header to be recorded, got '%s'", resp.CacheControl)
+	}
+	global.Set(rawURL, types.EngineHTTP, types.FormatText, resp)
+	if cached(global, rawURL) {
+		t.Error("Expected a no-store response not to be cached")
+	}
+	rawURL, resp = fetch("max-age=0")
+	global.Set(rawURL, types.EngineHTTP, types.FormatText, resp)
+	if !cached(global, rawURL) {
+		t.Error("Expected the global TTL to apply without origin TTLs")
+	}
+
+	// With origin TTLs, max-age sets the lifetime within the bounds
+	origin := cache.NewCache(time.Hour)
+	origin.SetOriginTTL(50*time.Millisecond, 200*time.Millisecond)
+	short, resp := fetch("max-age=0")
+	origin.Set(short, types.EngineHTTP, types.FormatText, resp)
+	long, resp := fetch("max-age=86400")
+	origin.Set(long, types.EngineHTTP, types.FormatText, resp)
+	plain, resp := fetch("")
+	origin.Set(plain, types.EngineHTTP, types.FormatText, resp)
+	noCache, resp := fetch("no-cache")
+	origin.Set(noCache, types.EngineHTTP, types.FormatText, resp)
+
+	if !cached(origin, short) || !cached(origin, long) || !cached(origin, plain) {
+		t.Fatal("Expected the entries to be fresh")
+	}
+	if cached(origin, noCache) {
+		t.Error("Expected a no-cache response without validators not to be served from cache")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if cached(origin, short) {
+		t.Error("Expected max-age=0 to be raised only to the minimum TTL")
+	}
+	if !cached(origin, long) {
+		t.Error("Expected max-age=86400 to still be fresh")
+	}
+	time.Sleep(150 * time.Millisecond)
+	if cached(origin, long) {
+		t.Error("Expected max-age=86400 to be capped at the maximum TTL")
+	}
+	if !cached(origin, plain) {
+		t.Error("Expected a response without freshness headers to keep the global TTL")
+	}
+
+	// Expires counts from the fetch
+	expires := &types.FetchResponse{StatusCode: http.StatusOK, FetchedAt: time.Now(), Expires: time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)}
+	origin.Set("https://example.com/expired", types.EngineHTTP, types.FormatText, expires)
+	time.Sleep(100 * time.Millisecond)
+	if cached(origin, "https://example.com/expired") {
+		t.Error("Expected a past Expires date to get the minimum TTL")
+	}
+}
+
+// TestCacheLRU tests that the cache stays within its entry and byte limits
+// by evicting the least recently used entries
+func TestCacheLRU(t *testing.T) {
+	page := func(content string) *types.FetchResponse {
+		return &types.FetchResponse{StatusCode: http.StatusOK, Content: content}
+	}
+	found := func(c *cache.Cache, rawURL string) bool {
+		_, ok := c.Get(rawURL, types.EngineHTTP, types.FormatText)
+		return ok
+	}
+
+	c := cache.NewCache(time.Hour)
+	c.SetLimits(3, 0)
+	for _, u := range []string{"https://a.test", "https://b.test", "https://c.test"} {
+		c.Set(u, types.EngineHTTP, types.FormatText, page("x"))
+	}
+	found(c, "https://a.test") // a is now more recent than b
+	c.Set("https://d.test", types.EngineHTTP, types.FormatText, page("x"))
+	if found(c, "https://b.test") || !found(c, "https://a.test") || !found(c, "https://d.test") {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+	if stats := c.Stats(); stats.Entries != 3 || stats.Evictions != 1 || stats.MaxEntries != 3 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	// Replacing an entry doesn't count it twice
+	c.Set("https://d.test", types.EngineHTTP, types.FormatText, page("xy"))
+	if stats := c.Stats(); stats.Entries != 3 || stats.Evictions != 1 {
+		t.Errorf("Expected a replaced entry to keep its place, got %+v", stats)
+	}
+
+	// The byte limit evicts as many entries as it takes
+	big := strings.Repeat("x", 4000)
+	sized := cache.NewCache(time.Hour)
+	sized.SetLimits(0, 10000)
+	for _, u := range []string{"https://a.test", "https://b.test"} {
+		sized.Set(u, types.EngineHTTP, types.FormatText, page(big))
+	}
+	sized.Set("https://c.test", types.EngineHTTP, types.FormatText, page(big))
+	if found(sized, "https://a.test") || !found(sized, "https://c.test") {
+		t.Error("Expected the byte limit to evict the oldest entry")
+	}
+	if stats := sized.Stats(); stats.Bytes > 10000 || stats.Entries != 2 {
+		t.Errorf("Expected the cache within 10000 bytes, got %+v", stats)
+	}
+	sized.Set("https://huge.test", types.EngineHTTP, types.FormatText, page(strings.Repeat("x", 20000)))
+	if found(sized, "https://huge.test") || !found(sized, "https://c.test") {
+		t.Error("Expected an entry larger than the cache not to be stored")
+	}
+
+	if removed := sized.Clear(); removed != 2 || sized.Stats().Bytes != 0 {
+		t.Errorf("Expected Clear to empty the cache, removed %d", removed)
+	}
+}
+
+// TestDiskCache tests that persisted entries survive a new cache instance,
+// are loaded lazily and stay within the disk cap
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+
+	c := cache.NewCache(time.Hour)
+	if err := c.SetDisk(dir, 0); err != nil {
+		t.Fatalf("SetDisk failed: %v", err)
+	}
+	c.Set("https://a.test/docs", types.EngineHTTP, types.FormatMarkdown, &types.FetchResponse{StatusCode: http.StatusOK, Content: "# Docs", Title: "Docs"})
+	c.Set("https://b.test/", types.EngineHTTP, types.FormatText, &types.FetchResponse{StatusCode: http.StatusOK, Content: "b"})
+
+	// A restarted server starts with nothing in memory
+	restarted := cache.NewCache(time.Hour)
+	if err := restarted.SetDisk(dir, 0); err != nil {
+		t.Fatalf("SetDisk failed: %v", err)
+	}
+	if stats := restarted.Stats(); stats.Entries != 0 || stats.DiskBytes == 0 {
+		t.Errorf("Expected entries on disk only, got %+v", stats)
+	}
+	resp, found := restarted.Get("https://a.test/docs", types.EngineHTTP, types.FormatMarkdown)
+	if !found || resp.Content != "# Docs" || resp.Title != "Docs" {
+		t.Fatalf("Expected the entry to be loaded from disk, got %+v", resp)
+	}
+	if restarted.Size() != 1 {
+		t.Errorf("Expected only the looked-up entry in memory, got %d", restarted.Size())
+	}
+
+	// Deletes reach entries that were never loaded
+	if removed := restarted.DeleteURL("https://b.test/"); removed != 1 {
+		t.Errorf("Expected the unloaded entry to be deleted, removed %d", removed)
+	}
+	if _, found := c.Get("https://b.test/", types.EngineHTTP, types.FormatText); !found {
+		t.Error("Expected the first instance's memory to be unaffected")
+	}
+	if removed := restarted.Clear(); removed != 1 {
+		t.Errorf("Expected Clear to remove 1 entry, removed %d", removed)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(files) != 0 {
+		t.Errorf("Expected Clear to remove the files, found %d", len(files))
+	}
+
+	// Past the cap the least recently used files are removed
+	capped := cache.NewCache(time.Hour)
+	if err := capped.SetDisk(dir, 3000); err != nil {
+		t.Fatalf("SetDisk failed: %v", err)
+	}
+	content := strings.Repeat("x", 1000)
+	for _, u := range []string{"https://1.test", "https://2.test", "https://3.test", "https://4.test"} {
+		capped.Set(u, types.EngineHTTP, types.FormatText, &types.FetchResponse{StatusCode: http.StatusOK, Content: content})
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := capped.Stats(); stats.DiskBytes > 3000 {
+		t.Errorf("Expected the disk cache within 3000 bytes, got %d", stats.DiskBytes)
+	}
+	reloaded := cache.NewCache(time.Hour)
+	reloaded.SetDisk(dir, 3000)
+	if _, found := reloaded.Get("https://1.test", types.EngineHTTP, types.FormatText); found {
+		t.Error("Expected the oldest entry to be evicted from disk")
+	}
+	if _, found := reloaded.Get("https://4.test", types.EngineHTTP, types.FormatText); !found {
+		t.Error("Expected the newest entry to be on disk")
+	}
+
+	// Writes a crash interrupted are cleaned up, unless they may be in progress
+	stale, fresh := filepath.Join(dir, "tmp-123"), filepath.Join(dir, "tmp-456")
+	for _, path := range []string{stale, fresh} {
+		if err := os.WriteFile(path, []byte(`{"key":`), 0o600); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(stale, old, old)
+	if err := cache.NewCache(time.Hour).SetDisk(dir, 0); err != nil {
+		t.Fatalf("SetDisk failed: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("Expected the leftover temporary file to be removed, got %v", err)
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("Expected a recent temporary file to be kept, got %v", err)
+	}
+
+	// An entry past its retention is removed when it's read, by any lookup
+	expiring := cache.NewCache(50 * time.Millisecond)
+	expiring.SetDisk(dir, 0)
+	expiring.Set("https://5.test", types.EngineHTTP, types.FormatText, &types.FetchResponse{StatusCode: http.StatusOK, Content: "5"})
+	time.Sleep(100 * time.Millisecond)
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	later := cache.NewCache(time.Hour)
+	later.SetDisk(dir, 0)
+	if _, found := later.Fallback("https://5.test", types.EngineHTTP, types.FormatText); found {
+		t.Error("Expected the expired entry not to be served")
+	}
+	if remaining, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(remaining) != len(files)-1 {
+		t.Errorf("Expected the expired entry's file to be removed, %d of %d files left", len(remaining), len(files))
+	}
+}
+
+// TestCacheStore tests sharing a cache between instances through a Redis store
+func TestCacheStore(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+
+	if _, err := cache.NewRedisStore("redis://:wrong@" + server.addr + "/2"); err == nil {
+		t.Error("Expected a bad password to be rejected")
+	}
+	if _, err := cache.NewRedisStore("memcached://" + server.addr); err == nil {
+		t.Error("Expected an unsupported scheme to be rejected")
+	}
+
+	newShared := func() *cache.Cache {
+		store, err := cache.NewRedisStore("redis://:secret@" + server.addr + "/2")
+		if err != nil {
+			t.Fatalf("NewRedisStore failed: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		c := cache.NewCache(time.Hour)
+		c.SetStore(store)
+		return c
+	}
+	first, second := newShared(), newShared()
+
+	first.Set("https://a.test/docs", types.EngineHTTP, types.FormatMarkdown, &types.FetchResponse{StatusCode: http.StatusOK, Content: "# Docs", Title: "Docs"})
+	first.Set("https://a.test/blog", types.EngineHTTP, types.FormatText, &types.FetchResponse{StatusCode: http.StatusOK, Content: "blog"})
+	first.Set("https://b.test/", types.EngineHTTP, types.FormatText, &types.FetchResponse{StatusCode: http.StatusOK, Content: "b"})
+
+	// Entries stored by one instance are hits for another
+	resp, found := second.Get("https://a.test/docs", types.EngineHTTP, types.FormatMarkdown)
+	if !found || resp.Content != "# Docs" || resp.Title != "Docs" {
+		t.Fatalf("Expected the shared entry, got %+v", resp)
+	}
+	if stats := second.Stats(); stats.Entries != 3 || stats.Hits != 1 {
+		t.Errorf("Expected 3 shared entries and 1 hit, got %+v", stats)
+	}
+
+	// Deletes apply to every instance
+	if removed, err := second.DeletePattern("https://a.test/*"); err != nil || removed != 2 {
+		t.Errorf("Expected the pattern to remove 2 entries, removed %d (%v)", removed, err)
+	}
+	if _, found := first.Get("https://a.test/blog", types.EngineHTTP, types.FormatText); found {
+		t.Error("Expected the deleted entry to be gone for the other instance")
+	}
+	if removed := first.Clear(); removed != 1 {
+		t.Errorf("Expected Clear to remove 1 entry, removed %d", removed)
+	}
+
+	// Entries expire on the server, kept longer when they can be revalidated
+	short := newShared()
+	short.SetOriginTTL(0, 0)
+	short.Set("https://c.test/", types.EngineHTTP, types.FormatText, &types.FetchResponse{StatusCode: http.StatusOK, Content: "c", CacheControl: "max-age=60"})
+	short.Set("https://d.test/", types.EngineHTTP, types.FormatText, &types.FetchResponse{StatusCode: http.StatusOK, Content: "d", CacheControl: "max-age=60", ETag: `"d"`})
+	ttls := server.ttls()
+	if ttl := ttls["url_fetcher:cache:https://c.test/|http|text"]; ttl <= 0 || ttl > time.Minute {
+		t.Errorf("Expected the entry to expire with its max-age, got %v", ttl)
+	}
+	if ttl := ttls["url_fetcher:cache:https://d.test/|http|text"]; ttl <= cache.StaleRetention {
+		t.Errorf("Expected a revalidatable entry to be kept past its max-age, got %v", ttl)
+	}
+
+	// A server restart is picked up by the next command
+	server.dropConnections()
+	if _, found := short.Get("https://d.test/", types.EngineHTTP, types.FormatText); !found {
+		t.Error("Expected the store to reconnect")
+	}
+}
+
+// fakeRedis is just enough of a Redis server for TestCacheStore
+type fakeRedis struct {
+	addr     string
+	password string
+
+	mu     sync.Mutex
+	data   map[string]string
+	expiry map[string]time.Time
+	conns  []net.Conn
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &fakeRedis{addr: listener.Addr().String(), password: password, data: map[string]string{}, expiry: map[string]time.Time{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mu.Lock()
+			server.conns = append(server.conns, conn)
+			server.mu.Unlock()
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeRedis) ttls() map[string]time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ttls := make(map[string]time.Duration)
+	for key, at := range s.expiry {
+		ttls[key] = time.Until(at)
+	}
+	return ttls
+}
+
+func (s *fakeRedis) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authed := s.password == ""
+	for {
+		var n int
+		if _, err := fmt.Fscanf(reader, "*%d\r\n", &n); err != nil {
+			return
+		}
+		args := make([]string, n)
+		for i := range args {
+			var size int
+			if _, err := fmt.Fscanf(reader, "$%d\r\n", &size); err != nil {
+				return
+			}
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, buf); err != nil {
+				return
+			}
+			args[i] = string(buf[:size])
+		}
+		fmt.Fprint(conn, s.handle(args, &authed))
+	}
+}
+
+func (s *fakeRedis) handle(args []string, authed *bool) string {
+	bulk := func(v string) string { return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v) }
+	command := strings.ToUpper(args[0])
+	if command == "AUTH" {
+		if args[len(args)-1] != s.password {
+			return "-WRONGPASS invalid password\r\n"
+		}
+		*authed = true
+		return "+OK\r\n"
+	}
+	if !*authed {
+		return "-NOAUTH Authentication required\r\n"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch command {
+	case "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		value, ok := s.data[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(value)
+	case "SET":
+		s.data[args[1]] = args[2]
+		if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+			ms, _ := strconv.ParseInt(args[4], 10, 64)
+			s.expiry[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return "+OK\r\n"
+	case "DEL":
+		removed := 0
+		for _, key := range args[1:] {
+			if _, ok := s.data[key]; ok {
+				delete(s.data, key)
+				delete(s.expiry, key)
+				removed++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", removed)
+	case "SCAN":
+		prefix := strings.TrimSuffix(args[3], "*")
+		var keys []string
+		for key := range s.data {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, bulk(key))
+			}
+		}
+		return fmt.Sprintf("*2\r\n%s*%d\r\n%s", bulk("0"), len(keys), strings.Join(keys, ""))
+	default:
+		return "-ERR unknown command\r\n"
+	}
+}
+
+// TestRawCacheFormats tests serving every format from one cached raw body
+func TestRawCacheFormats(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><title>Guide</title></head><body><h1>Install</h1><p>Run the <b>installer</b> first.</p></body></html>`)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{Timeout: 10 * time.Second}
+	f := fetcher.NewFetcher(cfg)
+	defer f.Close()
+	p := processor.NewProcessor()
+	c := cache.NewCache(time.Hour)
+
+	raw, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP, Format: types.FormatText, MaxContentLength: 1024 * 1024})
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	c.Set(server.URL, types.EngineHTTP, "raw", raw)
+
+	derived := make(map[string]string)
+	for _, format := range []string{types.FormatText, types.FormatHTML, types.FormatMarkdown} {
+		cached, found := c.Get(server.URL, types.EngineHTTP, "raw")
+		if !found {
+			t.Fatalf("Expected the raw body to be cached for %s", format)
+		}
+		response := *cached
+		response.Format = format
+		if err := p.Process(context.Background(), &response); err != nil {
+			t.Fatalf("Process(%s) failed: %v", format, err)
+		}
+		derived[format] = response.Content
+	}
+
+	if requests.Load() != 1 {
+		t.Errorf("Expected a single fetch, got %d", requests.Load())
+	}
+	if strings.Contains(derived[types.FormatText], "<") || !strings.Contains(derived[types.FormatText], "installer") {
+		t.Errorf("Expected plain text, got %q", derived[types.FormatText])
+	}
+	if !strings.Contains(derived[types.FormatMarkdown], "**installer**") {
+		t.Errorf("Expected markdown, got %q", derived[types.FormatMarkdown])
+	}
+	if !strings.Contains(derived[types.FormatHTML], "<b>installer</b>") {
+		t.Errorf("Expected HTML, got %q", derived[types.FormatHTML])
+	}
+
+	// The cached body is left as it was fetched
+	if cached, _ := c.Get(server.URL, types.EngineHTTP, "raw"); !strings.Contains(cached.Content, "<title>Guide</title>") {
+		t.Errorf("Expected the cached body to stay raw, got %q", cached.Content)
+	}
+}
+
+// TestCacheRefresh tests replacing an entry while lookups wait for it
+func TestCacheRefresh(t *testing.T) {
+	c := cache.NewCache(time.Hour)
+	c.Set("https://a.test/", types.EngineHTTP, "raw", &types.FetchResponse{StatusCode: http.StatusOK, Content: "old"})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	refreshed := make(chan *types.FetchResponse)
+	go func() {
+		resp, err := c.Refresh("https://a.test/", types.EngineHTTP, "raw", func() (*types.FetchResponse, error) {
+			close(started)
+			<-release
+			return &types.FetchResponse{StatusCode: http.StatusOK, Content: "new"}, nil
+		})
+		if err != nil {
+			t.Errorf("Refresh failed: %v", err)
+		}
+		refreshed <- resp
+	}()
+	<-started
+
+	// A lookup during the refresh gets the new copy, not the old one or a miss
+	looked := make(chan string)
+	go func() {
+		resp, found := c.Get("https://a.test/", types.EngineHTTP, "raw")
+		if !found {
+			looked <- ""
+			return
+		}
+		looked <- resp.Content
+	}()
+	select {
+	case content := <-looked:
+		t.Fatalf("Expected the lookup to wait for the refresh, got %q", content)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if resp := <-refreshed; resp.Content != "new" {
+		t.Errorf("Expected the fresh response, got %q", resp.Content)
+	}
+	if content := <-looked; content != "new" {
+		t.Errorf("Expected the waiting lookup to get the new copy, got %q", content)
+	}
+
+	// A failed refresh leaves the entry evicted
+	_, err := c.Refresh("https://a.test/", types.EngineHTTP, "raw", func() (*types.FetchResponse, error) {
+		return nil, errors.New("connection refused")
+	})
+	if err == nil {
+		t.Error("Expected the fetch error to be returned")
+	}
+	if _, found := c.Get("https://a.test/", types.EngineHTTP, "raw"); found {
+		t.Error("Expected the entry to stay evicted")
+	}
+}
+
+// TestServeStale tests falling back to a cached copy when the origin fails
+func TestServeStale(t *testing.T) {
+	page := &types.FetchResponse{StatusCode: http.StatusOK, Content: "cached"}
+
+	plain := cache.NewCache(50 * time.Millisecond)
+	plain.Set("https://a.test/", types.EngineHTTP, "raw", page)
+	stale := cache.NewCache(50 * time.Millisecond)
+	stale.SetServeStale(true)
+	stale.Set("https://a.test/", types.EngineHTTP, "raw", page)
+
+	if resp, ok := stale.Fallback("https://a.test/", types.EngineHTTP, "raw"); !ok || resp.Content != "cached" {
+		t.Errorf("Expected a fresh entry to be a fallback, got %+v", resp)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// Without validators, expired entries are only kept with serve-stale
+	if _, ok := plain.Fallback("https://a.test/", types.EngineHTTP, "raw"); ok {
+		t.Error("Expected the expired entry to be gone without serve-stale")
+	}
+	if _, found := stale.Get("https://a.test/", types.EngineHTTP, "raw"); found {
+		t.Error("Expected the expired entry to miss")
+	}
+	if resp, ok := stale.Fallback("https://a.test/", types.EngineHTTP, "raw"); !ok || resp.Content != "cached" {
+		t.Errorf("Expected the expired entry as a fallback, got %+v", resp)
+	}
+	if _, ok := stale.Stale("https://a.test/", types.EngineHTTP, "raw"); ok {
+		t.Error("Expected an entry without validators not to be revalidated")
+	}
+
+	// Only failures of the origin are worth falling back for
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{Timeout: 5 * time.Second, Retry: types.RetryPolicy{MaxAttempts: 1}})
+	defer f.Close()
+	for path, upstream := range map[string]bool{"/down": true, "/missing": false} {
+		_, err := f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL + path, Engine: types.EngineHTTP, MaxContentLength: 1024})
+		if err == nil {
+			t.Fatalf("Expected %s to fail", path)
+		}
+		if fetcher.IsUpstreamError(err) != upstream {
+			t.Errorf("Expected IsUpstreamError(%v) = %v", err, upstream)
+		}
+	}
+	if !fetcher.IsUpstreamError(fmt.Errorf("%w: dial tcp", fetcher.ErrTimeout)) {
+		t.Error("Expected a timeout to be an upstream error")
+	}
+	if fetcher.IsUpstreamError(fetcher.ErrBlockedLocal) {
+		t.Error("Expected a blocked URL not to be an upstream error")
+	}
+}
+
+// TestNegativeCache tests caching failed fetches of dead URLs
+func TestNegativeCache(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/down":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{Timeout: 5 * time.Second, Retry: types.RetryPolicy{MaxAttempts: 1}})
+	defer f.Close()
+	fetch := func(path string) (*types.FetchResponse, error) {
+		return f.Fetch(context.Background(), &types.FetchRequest{URL: server.URL + path, Engine: types.EngineHTTP, MaxContentLength: 1024})
+	}
+
+	for path, dead := range map[string]bool{"/missing": true, "/gone": true, "/down": false} {
+		if _, err := fetch(path); err == nil || fetcher.IsDeadURL(err) != dead {
+			t.Errorf("Expected IsDeadURL(%v) = %v", err, dead)
+		}
+	}
+	if !fetcher.IsDeadURL(fmt.Errorf("dial: %w", &net.DNSError{Err: "no such host", Name: "nope.invalid", IsNotFound: true})) {
+		t.Error("Expected an unresolvable host to be a dead URL")
+	}
+
+	c := cache.NewCache(time.Hour)
+	resp, err := fetch("/missing")
+	c.SetNegative(server.URL+"/missing", types.EngineHTTP, "raw", resp, err)
+	if _, ok := c.Failure(server.URL+"/missing", types.EngineHTTP, "raw"); ok {
+		t.Error("Expected failures not to be cached without a negative TTL")
+	}
+
+	c.SetNegativeTTL(50 * time.Millisecond)
+	c.SetNegative(server.URL+"/missing", types.EngineHTTP, "raw", resp, err)
+	c.SetNegative("https://nope.invalid/", types.EngineHTTP, "raw", nil, errors.New("no such host"))
+
+	failure, ok := c.Failure(server.URL+"/missing", types.EngineHTTP, "raw")
+	if !ok || !strings.Contains(failure.Content, "404") || failure.Error != err.Error() {
+		t.Errorf("Expected the cached 404, got %+v", failure)
+	}
+	if failure, ok := c.Failure("https://nope.invalid/", types.EngineHTTP, "raw"); !ok || failure.Content != "no such host" || failure.Error != "no such host" {
+		t.Errorf("Expected the cached DNS failure, got %+v", failure)
+	}
+	if _, found := c.Get(server.URL+"/missing", types.EngineHTTP, "raw"); found {
+		t.Error("Expected a failure not to be a cache hit")
+	}
+
+	// A successful fetch replaces the failure, and failures expire on their own TTL
+	c.Set("https://nope.invalid/", types.EngineHTTP, "raw", &types.FetchResponse{StatusCode: http.StatusOK, Content: "back"})
+	if _, ok := c.Failure("https://nope.invalid/", types.EngineHTTP, "raw"); ok {
+		t.Error("Expected the page to replace its failure")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := c.Failure(server.URL+"/missing", types.EngineHTTP, "raw"); ok {
+		t.Error("Expected the failure to expire")
+	}
+	if _, found := c.Get("https://nope.invalid/", types.EngineHTTP, "raw"); !found {
+		t.Error("Expected the page to keep the cache TTL")
+	}
+}
+
+// TestCacheKeyNormalization tests that trivially different URLs share a
+// cache entry, and that Chrome's entries keep the fragment
+func TestCacheKeyNormalization(t *testing.T) {
+	c := cache.NewCache(time.Hour)
+	c.SetTrackingParams([]string{"utm_*", "fbclid", "gclid"})
+	c.Set("https://example.com/docs?b=2&a=1", types.EngineHTTP, "raw", &types.FetchResponse{StatusCode: http.StatusOK, Content: "docs"})
+
+	for _, u := range []string{
+		"https://example.com/docs?b=2&a=1",
+		"HTTPS://Example.COM:443/docs?a=1&b=2",
+		"https://example.com/docs?a=1&b=2#install",
+		"https://example.com/docs?utm_source=news&a=1&fbclid=x&b=2&gclid=y",
+	} {
+		if resp, found := c.Get(u, types.EngineHTTP, "raw"); !found || resp.Content != "docs" {
+			t.Errorf("Expected %s to hit the cached entry", u)
+		}
+	}
+	for _, u := range []string{
+		"https://example.com/docs?a=1&b=3",
+		"https://example.com/Docs?a=1&b=2",
+		"https://example.com:8443/docs?a=1&b=2",
+		"http://example.com/docs?a=1&b=2",
+	} {
+		if _, found := c.Get(u, types.EngineHTTP, "raw"); found {
+			t.Errorf("Expected %s to miss", u)
+		}
+	}
+
+	// Repeated parameters keep their order, and an empty path is "/"
+	c.Set("https://example.com?tag=b&tag=a", types.EngineHTTP, "raw", &types.FetchResponse{StatusCode: http.StatusOK, Content: "tags"})
+	if _, found := c.Get("https://example.com/?tag=b&tag=a", types.EngineHTTP, "raw"); !found {
+		t.Error("Expected an empty path to match /")
+	}
+	if _, found := c.Get("https://example.com/?tag=a&tag=b", types.EngineHTTP, "raw"); found {
+		t.Error("Expected reordered values of one parameter to miss")
+	}
+
+	// Invalidation normalizes too
+	if removed := c.DeleteURL("https://EXAMPLE.com/docs?a=1&b=2&utm_medium=email"); removed != 1 {
+		t.Errorf("Expected DeleteURL to remove the entry, removed %d", removed)
+	}
+
+	// Chrome renders hash-routed apps, so its entries keep the fragment
+	c.Set("https://app.example.com/#/inbox", types.EngineChrome, "raw", &types.FetchResponse{StatusCode: http.StatusOK, Content: "inbox"})
+	c.Set("https://app.example.com/#/settings", types.EngineChrome, "raw", &types.FetchResponse{StatusCode: http.StatusOK, Content: "settings"})
+	c.Set("https://app.example.com/", types.EngineHTTP, "raw", &types.FetchResponse{StatusCode: http.StatusOK, Content: "shell"})
+	for u, want := range map[string]string{
+		"https://APP.example.com:443/#/inbox": "inbox",
+		"https://app.example.com/#/settings":  "settings",
+	} {
+		if resp, found := c.Get(u, types.EngineChrome, "raw"); !found || resp.Content != want {
+			t.Errorf("Expected %s to hit the %s entry", u, want)
+		}
+	}
+	if _, found := c.Get("https://app.example.com/", types.EngineChrome, "raw"); found {
+		t.Error("Expected a Chrome request without the fragment to miss")
+	}
+	if resp, found := c.Get("https://app.example.com/#/inbox", types.EngineHTTP, "raw"); !found || resp.Content != "shell" {
+		t.Error("Expected the HTTP engine to ignore the fragment")
+	}
+	if removed := c.DeleteURL("https://app.example.com/#/inbox"); removed != 3 {
+		t.Errorf("Expected DeleteURL to remove every entry of the page, removed %d", removed)
+	}
+
+	// Without tracking patterns, every parameter counts
+	plain := cache.NewCache(time.Hour)
+	plain.Set("https://example.com/?utm_source=a", types.EngineHTTP, "raw", &types.FetchResponse{StatusCode: http.StatusOK, Content: "a"})
+	if _, found := plain.Get("https://example.com/?utm_source=b", types.EngineHTTP, "raw"); found {
+		t.Error("Expected utm_source to be part of the key without tracking patterns")
+	}
+}
+
+// TestCacheCompression tests that in-memory entries are held compressed
+// and come back intact
+func TestCacheCompression(t *testing.T) {
+	content := strings.Repeat("<p>The same paragraph, over and over again.</p>\n", 2000)
+
+	plain := cache.NewCache(time.Hour)
+	plain.Set("https://a.test/", types.EngineHTTP, "raw", &types.FetchResponse{StatusCode: http.StatusOK, Content: content})
+	uncompressed := plain.Stats().Bytes
+
+	for _, algorithm := range []string{cache.CompressionGzip, cache.CompressionZstd} {
+		c := cache.NewCache(time.Hour)
+		if err := c.SetCompression(algorithm); err != nil {
+			t.Fatalf("SetCompression(%s) failed: %v", algorithm, err)
+		}
+		c.Set("https://a.test/", types.EngineHTTP, "raw", &types.FetchResponse{StatusCode: http.StatusOK, Content: content, Title: "Page"})
+		c.Set("https://a.test/small", types.EngineHTTP, "raw", &types.FetchResponse{StatusCode: http.StatusOK, Content: "small"})
+
+		if stats := c.Stats(); stats.Bytes*4 > uncompressed*2 {
+			t.Errorf("%s: expected compression to shrink %d bytes, got %d for both entries", algorithm, uncompressed, stats.Bytes)
+		}
+		resp, found := c.Get("https://a.test/", types.EngineHTTP, "raw")
+		if !found || resp.Content != content || resp.Title != "Page" {
+			t.Errorf("%s: expected the entry to decompress intact", algorithm)
+		}
+		resp, found = c.Get("https://a.test/small", types.EngineHTTP, "raw")
+		if !found || resp.Content != "small" {
+			t.Errorf("%s: expected the small entry back, got %+v", algorithm, resp)
+		}
+	}
+
+	if err := cache.NewCache(time.Hour).SetCompression("lz4"); err == nil {
+		t.Error("Expected an unsupported algorithm to be rejected")
+	}
+}
+
+// TestOfflineMode tests that offline mode refuses network fetches
+func TestOfflineMode(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		fmt.Fprint(w, "online")
+	}))
+	defer server.Close()
+
+	offline := fetcher.NewFetcher(&config.Config{Timeout: 5 * time.Second, Offline: true})
+	defer offline.Close()
+	for _, engine := range []string{types.EngineHTTP, types.EngineHTTP3, types.EngineChrome} {
+		_, err := offline.Fetch(context.Background(), &types.FetchRequest{URL: server.URL, Engine: engine})
+		if !errors.Is(err, fetcher.ErrOffline) {
+			t.Errorf("Expected ErrOffline for %s, got %v", engine, err)
+		}
+	}
+	if requests.Load() != 0 {
+		t.Errorf("Expected no requests to reach the server, got %d", requests.Load())
+	}
+
+	online := fetcher.NewFetcher(&config.Config{Timeout: 5 * time.Second})
+	defer online.Close()
+	if resp, err := online.Fetch(context.Background(), &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP, MaxContentLength: 1024}); err != nil || resp.Content != "online" {
+		t.Errorf("Expected the fetch to go through online, got %v", err)
+	}
+}
+
+// TestCustomHeaders tests sending a request's own headers over the defaults
+func TestCustomHeaders(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.Write([]byte("<html><body>ok</body></html>"))
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{BlockLocal: false, Timeout: 5 * time.Second})
+	defer f.Close()
+	req := &types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP, Headers: map[string]string{
+		"accept-language": "de-DE",
+		"Referer":         "https://example.com/",
+		"X-Api-Key":       "secret",
+	}}
+	f.ApplyDefaults(req)
+	if _, err := f.Fetch(context.Background(), req); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if got := received.Values("Accept-Language"); !reflect.DeepEqual(got, []string{"de-DE"}) {
+		t.Errorf("Expected Accept-Language to replace the default, got %v", got)
+	}
+	if received.Get("Referer") != "https://example.com/" || received.Get("X-Api-Key") != "secret" {
+		t.Errorf("Expected the custom headers to be sent, got %v", received)
+	}
+
+	for _, headers := range []map[string]string{
+		{"Host": "example.com"},
+		{"cookie": "a=1"},
+		{"Bad Name": "x"},
+		{"X-Split": "a\r\nInjected: 1"},
+	} {
+		if err := types.ValidateHeaders(headers); err == nil {
+			t.Errorf("Expected %v to be rejected", headers)
+		}
+	}
+	if err := types.ValidateHeaders(map[string]string{"X-Trace": "abc"}); err != nil {
+		t.Errorf("Expected a plain header to be accepted, got %v", err)
+	}
+}
+
+// TestLocaleEmulation tests fetching as a locale and validating regional options
+func TestLocaleEmulation(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.Write([]byte("<html><body>ok</body></html>"))
+	}))
+	defer server.Close()
+
+	f := fetcher.NewFetcher(&config.Config{BlockLocal: false, Timeout: 5 * time.Second})
+	defer f.Close()
+	fetch := func(req *types.FetchRequest) {
+		t.Helper()
+		f.ApplyDefaults(req)
+		if _, err := f.Fetch(context.Background(), req); err != nil {
+			t.Fatalf("Fetch failed: %v", err)
+		}
+	}
+
+	fetch(&types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP, Locale: "de-DE"})
+	if got := received.Values("Accept-Language"); !reflect.DeepEqual(got, []string{"de-DE,de;q=0.9"}) {
+		t.Errorf("Expected the locale's Accept-Language, got %v", got)
+	}
+	fetch(&types.FetchRequest{URL: server.URL, Engine: types.EngineHTTP, Locale: "fr", Headers: map[string]string{"Accept-Language": "fr-CA"}})
+	if got := received.Get("Accept-Language"); got != "fr-CA" {
+		t.Errorf("Expected a custom Accept-Language to win over the locale, got %q", got)
+	}
+
+	for _, locale := range []string{"de", "pt-BR", "zh-Hant-TW", "es-419"} {
+		if err := types.ValidateLocale(locale); err != nil {
+			t.Errorf("Expected %q to be accepted, got %v", locale, err)
+		}
+	}
+	for _, locale := range []string{"", "d", "de_DE", "de-", "en-US;q=1", "german"} {
+		if err := types.ValidateLocale(locale); err == nil {
+			t.Errorf("Expected %q to be rejected", locale)
+		}
+	}
+
+	if err := (types.Geolocation{Latitude: 52.52, Longitude: 13.405}).Validate(); err != nil {
+		t.Errorf("Expected a valid position, got %v", err)
+	}
+	for _, g := range []types.Geolocation{{Latitude: 91}, {Longitude: -181}, {Accuracy: -1}} {
+		if err := g.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", g)
+		}
+	}
+}
+
+// TestChromePathAndFlags tests configuring the browser binary and its flags
+func TestChromePathAndFlags(t *testing.T) {
+	t.Setenv("FETCH_URL_CHROME_PATH", "/nonexistent/chrome")
+	t.Setenv("FETCH_URL_CHROME_FLAGS", "--lang=de-DE  --disable-extensions --disable-features=Translate,MediaRouter")
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.ChromePath != "/nonexistent/chrome" {
+		t.Errorf("Expected the configured path, got %q", cfg.ChromePath)
+	}
+	if want := []string{"--lang=de-DE", "--disable-extensions", "--disable-features=Translate,MediaRouter"}; !reflect.DeepEqual(cfg.ChromeFlags, want) {
+		t.Errorf("Expected flags %v, got %v", want, cfg.ChromeFlags)
+	}
+
+	// A configured path that doesn't exist isn't replaced by another browser
+	f := fetcher.NewFetcher(cfg)
+	defer f.Close()
+	if f.ChromeAvailable() {
+		t.Error("Expected Chrome to be unavailable with a missing binary")
+	}
+
+	for _, flags := range []string{"lang=de-DE", "--", "--=x", "-v"} {
+		t.Setenv("FETCH_URL_CHROME_FLAGS", flags)
+		if _, err := config.LoadConfig(); err == nil {
+			t.Errorf("Expected %q to be rejected", flags)
+		}
+	}
+}
+
+// TestRemoteChrome tests configuring a remote browser and failing fetches
+// with the reason when it can't be reached
+func TestRemoteChrome(t *testing.T) {
+	for _, val := range []string{"chrome:3000", "ftp://chrome:3000", "ws://"} {
+		t.Setenv("FETCH_URL_CHROME_WS_URL", val)
+		if _, err := config.LoadConfig(); err == nil {
+			t.Errorf("Expected %q to be rejected", val)
+		}
+	}
+
+	// Nothing listens on a closed server's port
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	t.Setenv("FETCH_URL_CHROME_WS_URL", "ws://"+server.Listener.Addr().String()+"/?token=secret")
+	t.Setenv("FETCH_URL_CHROME_PATH", "/nonexistent/chrome")
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	cfg.BlockLocal = false
+
+	f := fetcher.NewFetcher(cfg)
+	defer f.Close()
+	if !f.ChromeAvailable() {
+		t.Fatal("Expected a remote browser to be used without a local one")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req := &types.FetchRequest{URL: "https://example.com", Engine: types.EngineChrome}
+	f.ApplyDefaults(req)
+	_, err = f.Fetch(ctx, req)
+	if !errors.Is(err, fetcher.ErrChromeUnavailable) {
+		t.Fatalf("Expected the unreachable browser to be reported, got %v", err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("Expected the endpoint's token to be redacted, got %v", err)
+	}
+}
+
+// TestRequestDefaults tests configuring default request options, which a
+// request's own values override
+func TestRequestDefaults(t *testing.T) {
+	t.Setenv("FETCH_URL_DEFAULTS", `{"engine":"http","format":"text","max_content_length":5000,"xpath":"//main","user_agent":"curl","device":"iphone","summary_sentences":3,"chunk_size":2000,"response_budget":8000}`)
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.ResponseBudget != 8000 {
+		t.Errorf("Expected the default response budget, got %d", cfg.ResponseBudget)
+	}
+	t.Setenv("FETCH_URL_RESPONSE_BUDGET", "100")
+	if cfg, err := config.LoadConfig(); err != nil || cfg.ResponseBudget != 100 {
+		t.Errorf("Expected FETCH_URL_RESPONSE_BUDGET to win, got %d (%v)", cfg.ResponseBudget, err)
+	}
+
+	f := fetcher.NewFetcher(cfg)
+	defer f.Close()
+
+	req := &types.FetchRequest{URL: "https://example.com"}
+	f.ApplyDefaults(req)
+	iphone := types.DevicePresets["iphone"]
+	if req.Engine != types.EngineHTTP || req.Format != types.FormatText || req.MaxContentLength != 5000 ||
+		req.XPath != "//main" || req.SummarySentences != 3 || req.ChunkSize != 2000 || req.Device != "iphone" {
+		t.Errorf("Expected the configured defaults, got %+v", req)
+	}
+	if req.UserAgent != iphone.UserAgent || req.Viewport == nil || *req.Viewport != iphone.Viewport {
+		t.Errorf("Expected the default device's user agent and viewport, got %q %+v", req.UserAgent, req.Viewport)
+	}
+
+	req = &types.FetchRequest{
+		URL:              "https://example.com",
+		Engine:           types.EngineChrome,
+		Format:           types.FormatHTML,
+		MaxContentLength: 100,
+		XPath:            "//article",
+		UserAgent:        "googlebot",
+		SummarySentences: 1,
+		ChunkSize:        10,
+		Viewport:         &types.Viewport{Width: 800, Height: 600},
+	}
+	f.ApplyDefaults(req)
+	if req.Engine != types.EngineChrome || req.Format != types.FormatHTML || req.MaxContentLength != 100 ||
+		req.XPath != "//article" || req.SummarySentences != 1 || req.ChunkSize != 10 {
+		t.Errorf("Expected the request's own options, got %+v", req)
+	}
+	if req.UserAgent != types.UserAgentPresets["googlebot"] || req.Viewport.Width != 800 {
+		t.Errorf("Expected the request's user agent and viewport, got %q %+v", req.UserAgent, req.Viewport)
+	}
+
+	// The default user agent applies when no device picks one
+	cfg.Defaults.Device = ""
+	bare := fetcher.NewFetcher(cfg)
+	defer bare.Close()
+	req = &types.FetchRequest{URL: "https://example.com"}
+	bare.ApplyDefaults(req)
+	if req.UserAgent != types.UserAgentPresets["curl"] {
+		t.Errorf("Expected the default user agent preset, got %q", req.UserAgent)
+	}
+	req = &types.FetchRequest{URL: "https://example.com", Device: "iphone"}
+	bare.ApplyDefaults(req)
+	if req.UserAgent != iphone.UserAgent {
+		t.Errorf("Expected the request's device to pick the user agent, got %q", req.UserAgent)
+	}
+
+	for _, val := range []string{
+		`{"engine":"http","colour":"blue"}`,
+		`{"engine":"netscape"}`,
+		`{"format":"pdf"}`,
+		`{"max_content_length":-1}`,
+		`{"xpath":"//div[@id="}`,
+		`{"device":"toaster"}`,
+		`{"summary_sentences":-1}`,
+		`{"chunk_size":-5}`,
+		`{"response_budget":-5}`,
+		`[]`,
+	} {
+		t.Setenv("FETCH_URL_DEFAULTS", val)
+		if _, err := config.LoadConfig(); err == nil {
+			t.Errorf("Expected %s to be rejected", val)
+		}
+	}
+}